@@ -0,0 +1,126 @@
+package graphite
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/protocol"
+)
+
+// timeout bounds connecting to the Graphite endpoint and writing to it.
+const timeout = 5 * time.Second
+
+type lister interface {
+	ListMetrics(ctx context.Context) ([]model.Metrics, error)
+}
+
+// Forwarder writes all stored metrics to a Graphite endpoint over a long-lived TCP connection.
+type Forwarder struct {
+	source  lister
+	address string
+	prefix  string
+	logger  *zap.SugaredLogger
+	conn    net.Conn // Current connection, nil until the next forward connects
+}
+
+// NewForwarder creates a Forwarder writing the metrics listed by source to the Graphite endpoint
+// at address, with the metric paths prefixed by prefix.
+func NewForwarder(source lister, address, prefix string, logger *zap.SugaredLogger) *Forwarder {
+	return &Forwarder{source: source, address: address, prefix: prefix, logger: logger}
+}
+
+// Run forwards the metrics every interval until ctx is done. Failures are logged,
+// the connection is established again by the next forward.
+func (f *Forwarder) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer f.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.Forward(ctx); err != nil {
+				f.logger.Errorf("forwarding metrics to Graphite at %v: %v", f.address, err)
+			}
+		}
+	}
+}
+
+// Forward writes all metrics once, stamped with the current time. A connection closed by the
+// endpoint is replaced, and the write is retried once on a new connection if it fails.
+func (f *Forwarder) Forward(ctx context.Context) error {
+	metrics, err := f.source.ListMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+	var payload strings.Builder
+	now := time.Now().Unix()
+	for _, m := range metrics {
+		payload.WriteString(protocol.FormatGraphite(m, f.prefix, now))
+	}
+	if f.conn != nil && !alive(f.conn) {
+		f.Close()
+	}
+	if f.conn != nil {
+		if err = f.write(payload.String()); err == nil {
+			return nil
+		}
+		f.Close()
+	}
+	if err := f.connect(ctx); err != nil {
+		return err
+	}
+	if err := f.write(payload.String()); err != nil {
+		f.Close()
+		return err
+	}
+	return nil
+}
+
+// Close closes the current connection.
+func (f *Forwarder) Close() {
+	if f.conn != nil {
+		f.conn.Close() //nolint:all
+		f.conn = nil
+	}
+}
+
+func (f *Forwarder) connect(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", f.address)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	return nil
+}
+
+func (f *Forwarder) write(payload string) error {
+	if err := f.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	_, err := f.conn.Write([]byte(payload))
+	return err
+}
+
+// alive reports whether the endpoint has not closed the connection. Graphite never writes to
+// its clients, so anything but a timeout from a short read means the connection is gone.
+func alive(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return false
+	}
+	var buf [1]byte
+	_, err := conn.Read(buf[:])
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,179 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/model"
+	service "github.com/mrkovshik/yametrics/internal/service/server"
+	"github.com/mrkovshik/yametrics/internal/storage"
+)
+
+func newService() *service.MetricService {
+	cfg := config.ServerConfig{}
+	return service.NewMetricService(storage.NewInMemoryStorage(), &cfg, zap.NewNop().Sugar())
+}
+
+func TestServer_Serve(t *testing.T) {
+	metricService := newService()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- NewServer(metricService, zap.NewNop().Sugar()).Serve(ctx, l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close() //nolint:all
+	_, err = conn.Write([]byte("servers.web1.load 0.75 1700000000\nbad line\n" + strings.Repeat("x", maxLineSize+10) + " 1\ndisk.used;mount=/;host=web1 42\n"))
+	require.NoError(t, err)
+
+	get := func(name string) *float64 {
+		m, _ := metricService.GetMetric(ctx, model.Metrics{ID: name, MType: model.MetricTypeGauge})
+		return m.Value
+	}
+	require.Eventually(t, func() bool { return get("disk.used;host=web1;mount=/") != nil }, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, 0.75, *get("servers.web1.load"))
+	require.Equal(t, 42.0, *get("disk.used;host=web1;mount=/"))
+
+	// Open connections do not hold up the shutdown.
+	cancel()
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after the context was done")
+	}
+}
+
+func TestServer_connectionLimits(t *testing.T) {
+	metricService := newService()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewServer(metricService, zap.NewNop().Sugar())
+	s.maxConns, s.idleTimeout = 1, 200*time.Millisecond
+	go s.Serve(ctx, l) //nolint:all
+
+	closed := func(conn net.Conn) bool {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		_, err := conn.Read(make([]byte, 1))
+		return err != nil && !errors.Is(err, os.ErrDeadlineExceeded)
+	}
+	first, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer first.Close() //nolint:all
+	_, err = first.Write([]byte("load 1\n"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		m, _ := metricService.GetMetric(ctx, model.Metrics{ID: "load", MType: model.MetricTypeGauge})
+		return m.Value != nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	second, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer second.Close() //nolint:all
+	require.True(t, closed(second), "connections over the limit are closed")
+
+	require.True(t, closed(first), "idle connections are closed")
+	third, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer third.Close() //nolint:all
+	_, err = third.Write([]byte("load 2\n"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		m, _ := metricService.GetMetric(ctx, model.Metrics{ID: "load", MType: model.MetricTypeGauge})
+		return m.Value != nil && *m.Value == 2
+	}, 2*time.Second, 10*time.Millisecond, "the closed connections free their slots")
+}
+
+// stub is a Graphite endpoint passing received lines to a channel. It closes every
+// connection after the first line if hangUp is set.
+func stub(t *testing.T, hangUp bool) (net.Listener, chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() }) //nolint:all
+	lines := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close() //nolint:all
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- scanner.Text()
+					if hangUp {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l, lines
+}
+
+func receive(t *testing.T, lines chan string) string {
+	t.Helper()
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(2 * time.Second):
+		t.Fatal("no line was forwarded")
+		return ""
+	}
+}
+
+func TestForwarder_Forward(t *testing.T) {
+	metricService := newService()
+	ctx := context.Background()
+	value, delta := 1.5, int64(3)
+	require.NoError(t, metricService.UpdateMetrics(ctx, []model.Metrics{
+		{ID: "Alloc", MType: model.MetricTypeGauge, Value: &value},
+		{ID: "PollCount", MType: model.MetricTypeCounter, Delta: &delta},
+	}))
+
+	t.Run("lines", func(t *testing.T) {
+		l, lines := stub(t, false)
+		f := NewForwarder(metricService, l.Addr().String(), "yametrics.", zap.NewNop().Sugar())
+		defer f.Close()
+		require.NoError(t, f.Forward(ctx))
+		require.Regexp(t, `^yametrics\.Alloc 1\.5 \d+$`, receive(t, lines))
+		require.Regexp(t, `^yametrics\.PollCount 3 \d+$`, receive(t, lines))
+	})
+
+	t.Run("reconnects after the endpoint hangs up", func(t *testing.T) {
+		l, lines := stub(t, true)
+		f := NewForwarder(metricService, l.Addr().String(), "", zap.NewNop().Sugar())
+		defer f.Close()
+		require.NoError(t, f.Forward(ctx))
+		require.Contains(t, receive(t, lines), "Alloc")
+		// Wait for the hang-up to reach the forwarder.
+		time.Sleep(50 * time.Millisecond)
+		require.NoError(t, f.Forward(ctx))
+		require.Contains(t, receive(t, lines), "Alloc")
+	})
+
+	t.Run("endpoint down", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		address := l.Addr().String()
+		require.NoError(t, l.Close())
+		f := NewForwarder(metricService, address, "", zap.NewNop().Sugar())
+		require.Error(t, f.Forward(ctx))
+	})
+}
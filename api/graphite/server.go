@@ -0,0 +1,139 @@
+// Package graphite accepts metrics sent over the Graphite plaintext protocol on TCP and forwards
+// stored metrics to a Graphite endpoint, for dashboards built on Graphite.
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mrkovshik/yametrics/api"
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/protocol"
+)
+
+const (
+	maxLineSize  = 64 * 1024       // Longer lines are skipped
+	maxBatchSize = 1000            // Lines updated at once on a busy connection
+	maxConns     = 1000            // Open connections, further ones are closed right away
+	idleTimeout  = 5 * time.Minute // Connections that send nothing for longer are closed
+)
+
+// Server translates Graphite plaintext lines into metric batches.
+type Server struct {
+	service     api.Service
+	logger      *zap.SugaredLogger
+	maxConns    int
+	idleTimeout time.Duration
+}
+
+// NewServer creates a Server updating metrics through service.
+func NewServer(service api.Service, logger *zap.SugaredLogger) *Server {
+	return &Server{service: service, logger: logger, maxConns: maxConns, idleTimeout: idleTimeout}
+}
+
+// Serve accepts connections on l until ctx is done. Every line `path value [timestamp]` is stored
+// as a gauge, see protocol.ParseGraphite. Lines received together are updated as a single batch.
+// Invalid lines are logged and skipped. Connections idle for five minutes are closed, as are those
+// over a thousand open at once. Open connections are closed when ctx is done.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		closed bool
+		conns  = make(map[net.Conn]struct{})
+	)
+	go func() {
+		<-ctx.Done()
+		l.Close() //nolint:all
+		mu.Lock()
+		closed = true
+		for conn := range conns {
+			conn.Close() //nolint:all
+		}
+		mu.Unlock()
+	}()
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		mu.Lock()
+		if closed {
+			conn.Close() //nolint:all
+			mu.Unlock()
+			continue
+		}
+		if len(conns) >= s.maxConns {
+			mu.Unlock()
+			s.logger.Warnf("Graphite %v: %d connections open, closing the connection", conn.RemoteAddr(), s.maxConns)
+			conn.Close() //nolint:all
+			continue
+		}
+		conns[conn] = struct{}{}
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(ctx, conn)
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+			conn.Close() //nolint:all
+		}()
+	}
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	r := bufio.NewReaderSize(conn, maxLineSize)
+	var batch []model.Metrics
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(s.idleTimeout)); err != nil {
+			return
+		}
+		line, err := r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			s.logger.Warnf("Graphite %v: line longer than %d bytes skipped", conn.RemoteAddr(), maxLineSize)
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = r.ReadSlice('\n')
+			}
+			line = nil
+		}
+		if text := strings.TrimSpace(string(line)); text != "" {
+			m, err := protocol.ParseGraphite(text)
+			if err != nil {
+				s.logger.Warnf("Graphite %q: %v", text, err)
+			} else {
+				batch = append(batch, m)
+			}
+		}
+		// Update what has arrived so far once the client pauses, so that metrics are not held back.
+		if len(batch) > 0 && (err != nil || r.Buffered() == 0 || len(batch) >= maxBatchSize) {
+			if err := s.service.UpdateMetrics(ctx, batch); err != nil {
+				s.logger.Errorf("UpdateMetrics: %v", err)
+			}
+			batch = batch[:0]
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, os.ErrDeadlineExceeded):
+				s.logger.Debugf("Graphite %v: closing the idle connection", conn.RemoteAddr())
+			case !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && ctx.Err() == nil:
+				s.logger.Warnf("Graphite %v: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
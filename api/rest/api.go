@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/http"
 	"os"
+	"sync/atomic"
 
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
@@ -12,14 +13,32 @@ import (
 
 	"github.com/mrkovshik/yametrics/api"
 	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/config/settings"
+	"github.com/mrkovshik/yametrics/internal/protocol"
+	"github.com/mrkovshik/yametrics/internal/ratelimit"
+	"github.com/mrkovshik/yametrics/internal/replication"
+	"github.com/mrkovshik/yametrics/internal/signature"
+	"github.com/mrkovshik/yametrics/internal/telemetry"
+	"github.com/mrkovshik/yametrics/internal/tracing"
 )
 
 // Server represents the server configuration and dependencies.
 type Server struct {
 	server  *http.Server
 	service api.Service
-	config  *config.ServerConfig
+	config  atomic.Pointer[config.ServerConfig]
 	logger  *zap.SugaredLogger
+
+	authFailures *failureCounter
+	ipLimiter    atomic.Pointer[ratelimit.Limiter]
+	keyLimiter   atomic.Pointer[ratelimit.Limiter]
+	tracer       *tracing.Exporter
+	telemetry    *telemetry.Registry
+	metrics      serverMetrics
+	otlp         *protocol.OTLPConverter
+	prometheus   *protocol.PrometheusConverter
+	replica      *replication.Replica // Set if the server is a replica
+	configReads  signature.Nonces     // Nonces of the accepted requests for the configuration
 }
 
 // NewServer creates a new Server instance.
@@ -30,97 +49,141 @@ type Server struct {
 // Returns:
 // - a pointer to the new Server instance.
 func NewServer(service api.Service, config *config.ServerConfig, logger *zap.SugaredLogger) *Server {
-	return &Server{
+	reg := telemetry.NewRegistry()
+	s := &Server{
 		server: &http.Server{
 			Addr: config.Address,
 		},
-		service: service,
-		config:  config,
-		logger:  logger,
+		service:      service,
+		logger:       logger,
+		authFailures: newFailureCounter(),
+		telemetry:    reg,
+		metrics:      newServerMetrics(reg),
+		otlp:         protocol.NewOTLPConverter(),
+		prometheus:   protocol.NewPrometheusConverter(),
+	}
+	s.UpdateConfig(config)
+	return s
+}
+
+// UpdateConfig atomically replaces the configuration used to serve requests.
+// Rate limiters are recreated if their settings have changed. The listen address is
+// only read by NewServer and is not affected.
+func (s *Server) UpdateConfig(cfg *config.ServerConfig) {
+	old := s.config.Swap(cfg)
+	if old != nil && old.IPRateLimit == cfg.IPRateLimit && old.KeyRateLimit == cfg.KeyRateLimit && old.RateLimitBurst == cfg.RateLimitBurst {
+		return
+	}
+	var ipLimiter, keyLimiter *ratelimit.Limiter
+	if cfg.IPRateLimit > 0 {
+		ipLimiter = ratelimit.NewLimiter(float64(cfg.IPRateLimit), cfg.RateLimitBurst)
 	}
+	if cfg.KeyRateLimit > 0 {
+		keyLimiter = ratelimit.NewLimiter(float64(cfg.KeyRateLimit), cfg.RateLimitBurst)
+	}
+	s.ipLimiter.Store(ipLimiter)
+	s.keyLimiter.Store(keyLimiter)
+}
+
+// Config returns the configuration currently in use.
+func (s *Server) Config() *config.ServerConfig {
+	return s.config.Load()
+}
+
+// WithTracer sets the exporter receiving a span for every handled request.
+func (s *Server) WithTracer(tracer *tracing.Exporter) *Server {
+	s.tracer = tracer
+	return s
 }
 
 // RunServer starts the HTTP server with the configured router.
+// On a stop signal it stops accepting new connections and waits for in-flight requests
+// to finish for up to the configured shutdown timeout.
 func (s *Server) RunServer(stop chan os.Signal) error {
 	g, ctx := errgroup.WithContext(context.Background())
 
 	g.Go(func() error {
-		if err := s.server.ListenAndServe(); err != nil {
+		// ErrServerClosed only means Shutdown has begun, its result is reported by the other goroutine.
+		if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			return err
 		}
 		return nil
 	})
 	g.Go(func() error {
-		<-stop
-		if err := s.server.Shutdown(ctx); err != nil {
+		select {
+		case <-stop:
+		case <-ctx.Done():
+			// The listener failed, nothing to drain.
+			return nil
+		}
+		timeout := s.Config().ShutdownTimeout
+		s.logger.Infof("draining in-flight requests for up to %v", timeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := s.server.Shutdown(shutdownCtx); err != nil {
 			return err
 		}
 		return nil
 	})
 
-	if err := g.Wait(); err != nil {
-		if errors.Is(err, http.ErrServerClosed) {
-			return nil
-		}
-		return err
-	}
-	return nil
+	return g.Wait()
 }
 
 // ConfigureRouter configures routes and middleware.
 func (s *Server) ConfigureRouter() *Server {
 	router := chi.NewRouter()
-	router.Use(s.WithLogging, s.GzipHandle, s.SignResponse, s.DecryptRequest, s.Authenticate)
-	router.Route("/update", func(r chi.Router) {
-		r.Post("/", s.HandleUpdateMetricFromJSON)
-		r.Post("/{type}/{name}/{value}", s.HandleUpdateMetricFromURL)
+	router.Use(s.Trace, s.WithLogging)
+	router.Get("/internal/metrics", s.telemetry.Handler().ServeHTTP)
+	router.Get("/healthz", s.HandleLiveness)
+	router.Get("/readyz", s.HandleReadiness)
+	router.Get("/health", s.HandleHealth)
+	router.Get("/ping", s.HandlePing)
+	router.Get(replication.Path, s.HandleReplicationStatus)
+	router.Group(func(router chi.Router) {
+		router.Use(s.Instrument, s.LimitRate, s.LimitBody, s.CompressHandle, s.SignResponse, s.DecryptRequest)
+		router.Group(func(router chi.Router) {
+			router.Use(s.AuthenticateWrites, s.LimitClientRate)
+			router.Route("/update", func(r chi.Router) {
+				r.Post("/", s.HandleUpdateMetricFromJSON)
+				r.Post("/{type}/{name}/{value}", s.HandleUpdateMetricFromURL)
+			})
+			router.Post("/updates/", s.HandleUpdateMetricsFromJSON)
+		})
+		router.Group(func(router chi.Router) {
+			router.Use(s.Authenticate, s.LimitClientRate)
+			router.Route("/value", func(r chi.Router) {
+				r.Post("/", s.HandleGetMetricFromJSON)
+				r.Get("/{type}/{name}", s.HandleGetMetricFromURL)
+			})
+
+			router.Get("/", s.HandleGetMetrics)
+		})
 	})
-	router.Post("/updates/", s.HandleUpdateMetricsFromJSON)
-	router.Route("/value", func(r chi.Router) {
-		r.Post("/", s.HandleGetMetricFromJSON)
-		r.Get("/{type}/{name}", s.HandleGetMetricFromURL)
+	// InfluxDB and OpenTelemetry clients neither sign nor encrypt their requests.
+	router.Group(func(router chi.Router) {
+		router.Use(s.Instrument, s.LimitRate, s.LimitBody, s.CompressHandle, s.AuthenticateWrites, s.LimitClientRate)
+		router.Post("/write", s.HandleWriteInflux)
+		router.Post("/v1/metrics", s.HandleOTLPMetrics)
+	})
+	// Remote write bodies are snappy-compressed regardless of Content-Encoding, the handler decodes them.
+	router.Group(func(router chi.Router) {
+		router.Use(s.Instrument, s.LimitRate, s.LimitBody, s.AuthenticateWrites, s.LimitClientRate)
+		router.Post("/api/v1/write", s.HandlePrometheusRemoteWrite)
+	})
+	// The configuration is only served if enabled, and then only to signed and stamped requests if a key is configured.
+	router.Group(func(router chi.Router) {
+		router.Use(s.Instrument, s.LimitRate, s.LimitBody, s.RequireSignature, s.Authenticate, s.LimitClientRate)
+		router.Post("/config", s.HandleGetConfig)
+	})
+	// Replication is limited like ingestion, so max_body_size has to fit the snapshots of all metrics.
+	router.Group(func(router chi.Router) {
+		router.Use(s.Instrument, s.LimitRate, s.LimitBody, s.CompressHandle, s.RequireSignature, s.AuthenticateWrites, s.LimitClientRate)
+		router.Post(replication.Path, s.HandleReplicate)
+		router.Post(replication.Path+"promote", s.HandlePromote)
 	})
 
-	router.Get("/ping", s.HandlePing)
-	router.Get("/", s.HandleGetMetrics)
-
-	s.logger.Infof(
-		"Starting server on %v\n "+
-			"StoreInterval: %v\n"+
-			"StoreIntervalIsSet: %v\n"+
-			"SyncStoreEnable: %v\n"+
-			"StoreFilePath: %v\n"+
-			"StoreFilePathSet: %v\n"+
-			"StoreEnable: %v\n"+
-			"RestoreEnable: %v\n"+
-			"RestoreEnvSet: %v\n"+
-			"DBAddress: %v\n"+
-			"DBAddressIsSet: %v\n"+
-			"DBEnable: %v\n"+
-			"CryptoKey: %v\n"+
-			"CryptoKeyIsSet: %v\n"+
-			"Key: %v\n"+
-			"KeyIsSet: %v\n"+
-			"ConfigFilePath: %v\n"+
-			"ConfigFilePathIsSet: %v\n",
-		s.config.Address,
-		s.config.StoreInterval,
-		s.config.StoreIntervalIsSet,
-		s.config.SyncStoreEnable,
-		s.config.StoreFilePath,
-		s.config.StoreFilePathIsSet,
-		s.config.StoreEnable,
-		s.config.RestoreEnable,
-		s.config.RestoreEnvIsSet,
-		s.config.DBAddress,
-		s.config.DBAddressIsSet,
-		s.config.DBEnable,
-		s.config.CryptoKey,
-		s.config.CryptoKeyIsSet,
-		s.config.Key,
-		s.config.KeyIsSet,
-		s.config.ConfigFilePath,
-		s.config.ConfigFilePathIsSet)
+	cfg := s.Config()
+	s.logger.Infof("Starting server on %v with configuration:\n%s", cfg.Address, settings.Format(cfg.Settings()))
 	s.server.Handler = router
 	return s
 }
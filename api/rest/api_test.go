@@ -0,0 +1,370 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/stretchr/testify/require"
+	metricsv1 "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/config/settings"
+	"github.com/mrkovshik/yametrics/internal/model"
+	service "github.com/mrkovshik/yametrics/internal/service/server"
+	"github.com/mrkovshik/yametrics/internal/signature"
+	"github.com/mrkovshik/yametrics/internal/storage"
+)
+
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	return addr
+}
+
+func waitListening(t *testing.T, addr string) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close() //nolint:all
+		return true
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestServer_RunServerShutdown(t *testing.T) {
+	tests := []struct {
+		name            string
+		shutdownTimeout time.Duration
+		handlerDelay    time.Duration
+		wantErr         error
+		wantStatus      int
+	}{
+		{
+			name:            "in-flight request is drained",
+			shutdownTimeout: 5 * time.Second,
+			handlerDelay:    300 * time.Millisecond,
+			wantStatus:      http.StatusOK,
+		},
+		{
+			name:            "drain deadline exceeded",
+			shutdownTimeout: 0,
+			handlerDelay:    300 * time.Millisecond,
+			wantErr:         context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.ServerConfig{Address: freeAddress(t), ShutdownTimeout: tt.shutdownTimeout}
+			s := NewServer(nil, &cfg, zap.NewNop().Sugar())
+			started := make(chan struct{})
+			s.server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				time.Sleep(tt.handlerDelay)
+				w.WriteHeader(http.StatusOK)
+			})
+
+			stop := make(chan os.Signal, 1)
+			runErr := make(chan error, 1)
+			go func() { runErr <- s.RunServer(stop) }()
+			waitListening(t, cfg.Address)
+
+			status := make(chan int, 1)
+			go func() {
+				response, err := http.Get("http://" + cfg.Address + "/")
+				if err != nil {
+					status <- 0
+					return
+				}
+				response.Body.Close() //nolint:all
+				status <- response.StatusCode
+			}()
+			<-started
+			stop <- syscall.SIGTERM
+
+			err := <-runErr
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantStatus, <-status)
+		})
+	}
+}
+
+func TestServer_RunServerListenError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close() //nolint:all
+
+	cfg := config.ServerConfig{Address: l.Addr().String(), ShutdownTimeout: time.Second}
+	s := NewServer(nil, &cfg, zap.NewNop().Sugar())
+	runErr := make(chan error, 1)
+	go func() { runErr <- s.RunServer(make(chan os.Signal)) }()
+
+	select {
+	case err := <-runErr:
+		require.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("RunServer did not return after the listener failed")
+	}
+}
+
+func TestServer_UpdateConfig(t *testing.T) {
+	cfg := config.ServerConfig{}
+	s := NewServer(nil, &cfg, zap.NewNop().Sugar())
+	h := s.LimitRate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	do := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusOK, do(), "no limit is configured")
+	}
+
+	limited := config.ServerConfig{IPRateLimit: 1, RateLimitBurst: 1, Key: "secret"}
+	s.UpdateConfig(&limited)
+	require.Equal(t, &limited, s.Config())
+	require.Equal(t, http.StatusOK, do())
+	require.Equal(t, http.StatusTooManyRequests, do(), "reloaded limit applies to the running server")
+}
+
+func TestServer_HandleGetConfig(t *testing.T) {
+	cfg := config.ServerConfig{
+		Address:   "localhost:8080",
+		Key:       "secret",
+		DBAddress: "host=localhost password=secret",
+		CryptoKey: "./missing_key.pem",
+		Sources:   settings.Sources{"key": settings.Env, "database_dsn": settings.Flag},
+	}
+	s := NewServer(nil, &cfg, zap.NewNop().Sugar()).ConfigureRouter()
+	get := func(stamp signature.Stamp, signed bool) *httptest.ResponseRecorder {
+		body, err := json.Marshal(stamp)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/config", bytes.NewReader(body))
+		if signed {
+			sig, err := signature.NewSha256Sig(cfg.Key, body).Generate()
+			require.NoError(t, err)
+			req.Header.Set("HashSHA256", sig)
+		}
+		rr := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusNotFound, get(signature.NewStamp(), true).Code, "the endpoint is opt-in")
+	enabled := cfg
+	enabled.ConfigEndpoint = true
+	s.UpdateConfig(&enabled)
+	require.Equal(t, http.StatusUnauthorized, get(signature.NewStamp(), false).Code)
+	require.Equal(t, http.StatusForbidden, get(signature.Stamp{Time: time.Now().Add(-time.Hour), Nonce: "old"}, true).Code, "stamps expire")
+	stamp := signature.NewStamp()
+	rr := get(stamp, true)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	require.NotContains(t, rr.Body.String(), "secret")
+	require.Equal(t, http.StatusForbidden, get(stamp, true).Code, "a captured request cannot be replayed")
+
+	var got []settings.Setting
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+	require.Contains(t, got, settings.Setting{Name: "address", Value: "localhost:8080", Source: settings.Default})
+	require.Contains(t, got, settings.Setting{Name: "key", Value: settings.Redacted, Source: settings.Env})
+	require.Contains(t, got, settings.Setting{Name: "database_dsn", Value: "host=localhost password=REDACTED", Source: settings.Flag})
+}
+
+func TestServer_HandleGetMetrics(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	// Reads have no body to decrypt, so a configured crypto key must not get in the way.
+	cfg := config.ServerConfig{Key: "secret", CryptoKey: "./missing_key.pem"}
+	metricService := service.NewMetricService(storage.NewInMemoryStorage(), &cfg, logger)
+	delta, value := int64(3), 1.5
+	require.NoError(t, metricService.UpdateMetrics(context.Background(), []model.Metrics{
+		{ID: "PollCount", MType: model.MetricTypeCounter, Delta: &delta},
+		{ID: "Alloc", MType: model.MetricTypeGauge, Value: &value},
+	}))
+	s := NewServer(metricService, &cfg, logger).ConfigureRouter()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html, application/json;q=0.9")
+	rr := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	sig, err := signature.NewSha256Sig(cfg.Key, rr.Body.Bytes()).Generate()
+	require.NoError(t, err)
+	require.Equal(t, sig, rr.Header().Get("HashSHA256"))
+	var got []model.Metrics
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+	require.Equal(t, []model.Metrics{
+		{ID: "Alloc", MType: model.MetricTypeGauge, Value: &value},
+		{ID: "PollCount", MType: model.MetricTypeCounter, Delta: &delta},
+	}, got)
+
+	rr = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, "text/html", rr.Header().Get("Content-Type"))
+}
+
+func TestServer_HandleWriteInflux(t *testing.T) {
+	tests := []struct {
+		name     string
+		disabled bool
+		body     string
+		wantCode int
+	}{
+		{name: "disabled", disabled: true, body: "cpu usage=1", wantCode: http.StatusNotFound},
+		{name: "lines", body: "# from telegraf\ncpu,host=web1 usage=12.5,cores=4i 1700000000000000000\n\ntemperature value=21.5\n", wantCode: http.StatusNoContent},
+		{name: "invalid line rejects the request", body: "cpu,host=web1 usage=99\ncpu usage", wantCode: http.StatusBadRequest},
+		{name: "batch too long", body: "cpu a=1,b=2,c=3,d=4", wantCode: http.StatusRequestEntityTooLarge},
+	}
+	logger := zap.NewNop().Sugar()
+	// Influx clients do not encrypt bodies, so a configured crypto key must not get in the way.
+	cfg := config.ServerConfig{InfluxWriteEnable: true, MaxBatchSize: 3, CryptoKey: "./missing_key.pem"}
+	metricService := service.NewMetricService(storage.NewInMemoryStorage(), &cfg, logger)
+	s := NewServer(metricService, &cfg, logger).ConfigureRouter()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := cfg
+			next.InfluxWriteEnable = !tt.disabled
+			s.UpdateConfig(&next)
+			rr := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/write?db=telegraf", strings.NewReader(tt.body)))
+			require.Equal(t, tt.wantCode, rr.Code, rr.Body.String())
+		})
+	}
+
+	get := func(name string) float64 {
+		m, err := metricService.GetMetric(context.Background(), model.Metrics{ID: name, MType: model.MetricTypeGauge})
+		require.NoError(t, err)
+		return *m.Value
+	}
+	require.Equal(t, 12.5, get("cpu.usage;host=web1"))
+	require.Equal(t, 4.0, get("cpu.cores;host=web1"))
+	require.Equal(t, 21.5, get("temperature"))
+}
+
+func TestServer_HandleOTLPMetrics(t *testing.T) {
+	data := &metricsv1.MetricsData{ResourceMetrics: []*metricsv1.ResourceMetrics{{ScopeMetrics: []*metricsv1.ScopeMetrics{{Metrics: []*metricsv1.Metric{
+		{Name: "queue.size", Data: &metricsv1.Metric_Gauge{Gauge: &metricsv1.Gauge{
+			DataPoints: []*metricsv1.NumberDataPoint{{Value: &metricsv1.NumberDataPoint_AsInt{AsInt: 7}}},
+		}}},
+		{Name: "orders", Data: &metricsv1.Metric_Sum{Sum: &metricsv1.Sum{
+			IsMonotonic:            true,
+			AggregationTemporality: metricsv1.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+			DataPoints:             []*metricsv1.NumberDataPoint{{Value: &metricsv1.NumberDataPoint_AsInt{AsInt: 2}}},
+		}}},
+	}}}}}}
+	protobuf, err := proto.Marshal(data)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		wantCode    int
+		wantBody    string
+	}{
+		{name: "protobuf", contentType: "application/x-protobuf", body: protobuf, wantCode: http.StatusOK},
+		{
+			name:        "json",
+			contentType: "application/json; charset=utf-8",
+			body:        []byte(`{"resourceMetrics":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},"scopeMetrics":[{"metrics":[{"name":"orders","sum":{"isMonotonic":true,"aggregationTemporality":1,"dataPoints":[{"asInt":"3"}]}}]}]}]}`),
+			wantCode:    http.StatusOK,
+			wantBody:    `{}`,
+		},
+		{
+			name:        "partial success",
+			contentType: "application/json",
+			body:        []byte(`{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"sizes","summary":{"dataPoints":[{"count":"1"}]}}]}]}]}`),
+			wantCode:    http.StatusOK,
+			wantBody:    `{"partialSuccess":{"rejectedDataPoints":"1","errorMessage":"1 data points of unsupported types or with invalid values were rejected"}}`,
+		},
+		{name: "malformed", contentType: "application/x-protobuf", body: []byte("not protobuf"), wantCode: http.StatusBadRequest},
+		{name: "unsupported content type", contentType: "text/plain", body: []byte("orders 1"), wantCode: http.StatusUnsupportedMediaType},
+	}
+	logger := zap.NewNop().Sugar()
+	cfg := config.ServerConfig{}
+	metricService := service.NewMetricService(storage.NewInMemoryStorage(), &cfg, logger)
+	s := NewServer(metricService, &cfg, logger).ConfigureRouter()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rr := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(rr, req)
+			require.Equal(t, tt.wantCode, rr.Code, rr.Body.String())
+			if tt.wantBody != "" {
+				require.JSONEq(t, tt.wantBody, rr.Body.String())
+			}
+		})
+	}
+
+	ctx := context.Background()
+	gauge, err := metricService.GetMetric(ctx, model.Metrics{ID: "queue.size", MType: model.MetricTypeGauge})
+	require.NoError(t, err)
+	require.Equal(t, 7.0, *gauge.Value)
+	counter, err := metricService.GetMetric(ctx, model.Metrics{ID: "orders", MType: model.MetricTypeCounter})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), *counter.Delta)
+	counter, err = metricService.GetMetric(ctx, model.Metrics{ID: "orders;service.name=checkout", MType: model.MetricTypeCounter})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), *counter.Delta)
+}
+
+func TestServer_HandlePrometheusRemoteWrite(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	cfg := config.ServerConfig{MaxDecompressedSize: 1000}
+	metricService := service.NewMetricService(storage.NewInMemoryStorage(), &cfg, logger)
+	s := NewServer(metricService, &cfg, logger).ConfigureRouter()
+	post := func(body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+		rr := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Requests recorded for the protocol package tests.
+	for _, name := range []string{"metadata", "samples-1", "samples-2"} {
+		body, err := os.ReadFile(filepath.Join("..", "..", "internal", "protocol", "testdata", "remote_write_"+name+".snappy"))
+		require.NoError(t, err)
+		rr := post(body)
+		require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+	ctx := context.Background()
+	counter, err := metricService.GetMetric(ctx, model.Metrics{ID: "http_requests_total;code=200;instance=edge-1:9100;job=node", MType: model.MetricTypeCounter})
+	require.NoError(t, err)
+	require.Equal(t, int64(12), *counter.Delta)
+	gauge, err := metricService.GetMetric(ctx, model.Metrics{ID: "go_goroutines;instance=edge-1:9100;job=node", MType: model.MetricTypeGauge})
+	require.NoError(t, err)
+	require.Equal(t, 42.0, *gauge.Value)
+
+	require.Equal(t, http.StatusBadRequest, post([]byte("not snappy")).Code)
+	require.Equal(t, http.StatusRequestEntityTooLarge, post(snappy.Encode(nil, make([]byte, 2000))).Code)
+}
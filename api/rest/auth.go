@@ -0,0 +1,103 @@
+package rest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxFailureIPs bounds the number of source IPs whose authentication failures are counted.
+const maxFailureIPs = 10000
+
+// failureCounter counts authentication failures per source IP. Once maxFailureIPs are counted,
+// the IP whose last failure is the oldest is forgotten to make room for a new one.
+type failureCounter struct {
+	mu     sync.Mutex
+	counts map[string]failures
+}
+
+// failures are the authentication failures of a source IP.
+type failures struct {
+	count int64
+	last  time.Time // Time of the last failure
+}
+
+func newFailureCounter() *failureCounter {
+	return &failureCounter{
+		counts: make(map[string]failures),
+	}
+}
+
+// inc increments the failure count for the given IP and returns the new value.
+func (c *failureCounter) inc(ip string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.counts[ip]
+	if !ok && len(c.counts) >= maxFailureIPs {
+		c.evictOldest()
+	}
+	f.count++
+	f.last = time.Now()
+	c.counts[ip] = f
+	return f.count
+}
+
+// evictOldest forgets the IP whose last failure is the oldest, the caller holds c.mu.
+func (c *failureCounter) evictOldest() {
+	var oldest string
+	var oldestAt time.Time
+	for ip, f := range c.counts {
+		if oldest == "" || f.last.Before(oldestAt) {
+			oldest, oldestAt = ip, f.last
+		}
+	}
+	delete(c.counts, oldest)
+}
+
+// snapshot returns a copy of the current failure counts.
+func (c *failureCounter) snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make(map[string]int64, len(c.counts))
+	for ip, f := range c.counts {
+		res[ip] = f.count
+	}
+	return res
+}
+
+// AuthFailures returns the number of failed authentication attempts per source IP.
+func (s *Server) AuthFailures() map[string]int64 {
+	return s.authFailures.snapshot()
+}
+
+// sourceIP extracts the client IP from the request remote address.
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientKey is the context key of the identity of a client whose request signature was verified.
+type clientKey struct{}
+
+// withVerifiedKey returns a copy of ctx identifying the client by the key that verified its signature.
+// The identity is a fingerprint, so the key itself does not end up in rate limiter state.
+func withVerifiedKey(ctx context.Context, key string) context.Context {
+	sum := sha256.Sum256([]byte(key))
+	return context.WithValue(ctx, clientKey{}, "key:"+hex.EncodeToString(sum[:8]))
+}
+
+// clientIdentity returns the identity of the client: the verified signing key, or the source IP
+// of unsigned requests. Client-supplied headers are not trusted.
+func clientIdentity(r *http.Request) string {
+	if id, ok := r.Context().Value(clientKey{}).(string); ok {
+		return id
+	}
+	return sourceIP(r)
+}
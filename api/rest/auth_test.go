@@ -0,0 +1,102 @@
+package rest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/signature"
+)
+
+func TestServer_Authenticate(t *testing.T) {
+	const (
+		testKey  = "test_key"
+		testIP   = "10.0.0.1"
+		testBody = `{"id":"test","type":"gauge","value":1}`
+	)
+	validSig, err := signature.NewSha256Sig(testKey, []byte(testBody)).Generate()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		key          string
+		strict       bool
+		writes       bool
+		sig          string
+		wantCode     int
+		wantFailures int64
+	}{
+		{"no key, no header", "", false, true, "", http.StatusOK, 0},
+		{"no key, header", "", false, true, "garbage", http.StatusOK, 0},
+		{"key, no header", testKey, false, true, "", http.StatusOK, 0},
+		{"key, valid header", testKey, false, true, validSig, http.StatusOK, 0},
+		{"key, invalid header", testKey, false, true, "garbage", http.StatusUnauthorized, 1},
+		{"key, no header, strict", testKey, true, true, "", http.StatusUnauthorized, 1},
+		{"key, no header, strict, read", testKey, true, false, "", http.StatusOK, 0},
+		{"key, invalid header, strict, read", testKey, true, false, "garbage", http.StatusUnauthorized, 1},
+		{"key, valid header, strict", testKey, true, true, validSig, http.StatusOK, 0},
+		{"key, invalid header, strict", testKey, true, true, "garbage", http.StatusUnauthorized, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.ServerConfig{Key: tt.key, StrictAuth: tt.strict}
+			s := NewServer(nil, &cfg, zap.NewNop().Sugar())
+			var gotBody []byte
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				buf := new(bytes.Buffer)
+				_, err := buf.ReadFrom(r.Body)
+				require.NoError(t, err)
+				gotBody = buf.Bytes()
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(testBody))
+			req.RemoteAddr = testIP + ":12345"
+			if tt.sig != "" {
+				req.Header.Set("HashSHA256", tt.sig)
+			}
+			rr := httptest.NewRecorder()
+			authenticate := s.Authenticate
+			if tt.writes {
+				authenticate = s.AuthenticateWrites
+			}
+			authenticate(next).ServeHTTP(rr, req)
+
+			require.Equal(t, tt.wantCode, rr.Code)
+			require.Equal(t, tt.wantFailures, s.AuthFailures()[testIP])
+			if tt.wantCode == http.StatusOK {
+				require.Equal(t, testBody, string(gotBody))
+			}
+		})
+	}
+}
+
+func TestServer_strictAuthRoutes(t *testing.T) {
+	cfg := config.ServerConfig{Key: "test_key", StrictAuth: true, InfluxWriteEnable: true, ShutdownTimeout: time.Second}
+	s := NewServer(nil, &cfg, zap.NewNop().Sugar()).ConfigureRouter()
+	for _, path := range []string{"/update/", "/updates/", "/update/gauge/a/1", "/write", "/v1/metrics", "/api/v1/write", "/replication/", "/replication/promote"} {
+		t.Run(path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString("{}")))
+			require.Equal(t, http.StatusUnauthorized, rr.Code)
+		})
+	}
+}
+
+func Test_failureCounter_bound(t *testing.T) {
+	c := newFailureCounter()
+	c.inc("first")
+	for i := 0; i < maxFailureIPs; i++ {
+		c.inc(strconv.Itoa(i))
+	}
+	failures := c.snapshot()
+	require.Len(t, failures, maxFailureIPs)
+	require.NotContains(t, failures, "first", "the IP whose last failure is the oldest is forgotten")
+}
@@ -1,11 +1,12 @@
 // Package rest provides the implementation for the HTTP server handling the yametrics service.
 // It includes various HTTP handlers and middleware for managing metrics, including updating metrics,
-// retrieving metrics, and performing server/database health checks.
+// retrieving metrics, and performing server health checks.
 //
 // This package uses the go-chi/chi router for routing and provides middleware functionalities for:
 //
 // - Logging: Logs incoming HTTP requests and their corresponding responses.
-// - Gzip Compression: Handles gzip compression for request and response bodies.
+// - Compression: Handles gzip, deflate and zstd compression for request and response bodies,
+// negotiated through Accept-Encoding, capping the decompressed size.
 // - Authentication: Authenticates incoming requests using HMAC-SHA256 signatures.
 // - Response Signing: Signs outgoing response bodies using HMAC-SHA256 signatures if a signing key is configured.
 //
@@ -25,17 +26,46 @@
 // - POST /update/: Updates a single metric from JSON data.
 // - POST /update/{type}/{name}/{value}: Updates a single metric from URL parameters.
 // - POST /updates/: Updates multiple metrics from JSON data.
+// - POST /write: Updates gauges from the InfluxDB line protocol if enabled, tags are appended to the names.
+// - POST /v1/metrics: Updates metrics from OTLP/HTTP export requests in protobuf or JSON, attributes are appended to the names.
+// - POST /api/v1/write: Updates metrics from the latest samples of Prometheus remote write requests, labels are appended to the names.
 // - POST /value/: Retrieves a single metric using JSON data.
 // - GET /value/{type}/{name}: Retrieves a single metric using URL parameters.
-// - GET /ping: Checks the health of the server/database.
-// - GET /: Retrieves all metrics.
+// - GET /ping: Checks the storage connectivity, kept for compatibility.
+// - GET /healthz: Checks that the server process is alive.
+// - GET /readyz: Checks that the storage is reachable, the restore has finished and snapshots succeed.
+// - GET /health: Reports the per-component health with check latencies in JSON.
+// - POST /config: Reports the effective configuration with the source of each value in JSON, secrets are redacted.
+// Only served if enabled. If a signing key is configured, the body is a signature.Stamp signed with the key,
+// valid once and for a minute.
+// - GET /: Retrieves all metrics as an HTML page, or as a JSON array if the client accepts application/json.
+// - GET /internal/metrics: Exposes the server's own metrics in the Prometheus text format.
+// - POST /replication/: Applies the updates streamed from the primary on a replica, see package replication.
+// - GET /replication/: Reports the replication status of a replica in JSON.
+// - POST /replication/promote: Promotes a replica, which then accepts updates from clients. The body is a
+// replication.PromoteRequest signed with the key, valid once and for a minute; without a key
+// promotion is refused with 403.
+//
+// Until it is promoted, a replica answers updates from clients with 403. Replication requests are
+// rate and size limited like ingestion, so the max body size has to fit a snapshot of all metrics.
 //
 // ## Middleware
 //
 // Middleware functionalities include:
 //
+// - Trace: Propagates the X-Request-ID and traceparent headers, attaches the request ID to the
+// request-scoped logger and optionally exports a server span over OTLP/HTTP.
 // - WithLogging: Logs incoming HTTP requests and their responses.
-// - GzipHandle: Manages gzip compression for request and response bodies.
-// - Authenticate: Verifies the integrity of incoming requests using HMAC-SHA256 signatures.
+// - Instrument: Records request counts and latencies by route, method and status.
+// - LimitRate: Applies the per-IP token-bucket rate limit, answering 429 with Retry-After.
+// - LimitClientRate: Applies the per-client token-bucket rate limit after authentication. Clients are
+// identified by the key that verified their signature, or by source IP if they do not sign.
+// - LimitBody: Rejects request bodies over the configured size with 413.
+// - CompressHandle: Manages compression for request and response bodies.
+// - Authenticate: Verifies the integrity of incoming requests using HMAC-SHA256 signatures;
+// failures are answered with 401 and counted per source IP.
+// - AuthenticateWrites: Authenticate for every route group changing the stored metrics. In strict
+// auth mode unsigned requests are rejected; the StatsD and Graphite listeners cannot be enabled then.
+// - RequireSignature: Rejects unsigned replication and configuration requests if a signing key is configured.
 // - SignResponse: Signs outgoing response bodies using HMAC-SHA256 signatures if a signing key is configured.
 package rest
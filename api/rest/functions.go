@@ -1,15 +1,62 @@
 package rest
 
 import (
+	"context"
+	"errors"
 	"net/http"
 
 	"go.uber.org/zap"
+
+	"github.com/mrkovshik/yametrics/internal/apperrors"
+	"github.com/mrkovshik/yametrics/internal/logger"
+	"github.com/mrkovshik/yametrics/internal/model"
 )
 
-func (s *Server) writeStatusWithMessage(w http.ResponseWriter, status int, msg string) {
+// log returns the request-scoped logger stored in ctx, falling back to the server logger.
+func (s *Server) log(ctx context.Context) *zap.SugaredLogger {
+	return logger.FromContext(ctx, s.logger)
+}
+
+func (s *Server) writeStatusWithMessage(ctx context.Context, w http.ResponseWriter, status int, msg string) {
 	w.WriteHeader(status)
 	if _, err := w.Write([]byte(msg)); err != nil {
-		s.logger.Error("w.Write:", zap.Error(err))
+		s.log(ctx).Error("w.Write:", zap.Error(err))
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// updateFailed answers a failed update with 500 and msg, or with 403 if the server is a read-only replica.
+// errBatchTooLarge is returned by the store of storeConverted for batches over max_batch_size.
+var errBatchTooLarge = errors.New("batch too large")
+
+// storeConverted returns a store for the metrics converted from a request in another protocol,
+// rejecting batches over max_batch_size with errBatchTooLarge.
+func (s *Server) storeConverted(ctx context.Context) func([]model.Metrics) error {
+	return func(batch []model.Metrics) error {
+		if maxBatchSize := s.Config().MaxBatchSize; maxBatchSize > 0 && len(batch) > maxBatchSize {
+			return errBatchTooLarge
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		return s.service.UpdateMetrics(ctx, batch)
+	}
+}
+
+// convertedFailed responds to a request whose converted metrics could not be stored.
+func (s *Server) convertedFailed(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, errBatchTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	s.updateFailed(ctx, w, err, "UpdateMetrics")
+}
+
+func (s *Server) updateFailed(ctx context.Context, w http.ResponseWriter, err error, msg string) {
+	if errors.Is(err, apperrors.ErrReadOnly) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	s.log(ctx).Error("UpdateMetrics", zap.Error(err))
+	http.Error(w, msg, http.StatusInternalServerError)
+}
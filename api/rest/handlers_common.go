@@ -2,24 +2,96 @@ package rest
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/signature"
 )
 
-// HandlePing handles HTTP requests to ping the server/database.
+// healthCheckTimeout limits the time spent on storage checks by the health endpoints.
+const healthCheckTimeout = 5 * time.Second
+
+// HandlePing handles HTTP requests to ping the storage. It is kept for compatibility, see HandleReadiness.
 func (s *Server) HandlePing(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	if s.config.DBEnable {
-		newCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-		defer cancel()
-		if err := s.service.Ping(newCtx); err != nil {
-			s.logger.Error("PingContext", zap.Error(err))
-			http.Error(w, "data base is not responding", http.StatusInternalServerError)
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+	if err := s.service.Ping(ctx); err != nil {
+		s.log(ctx).Error("PingContext", zap.Error(err))
+		http.Error(w, "storage is not responding", http.StatusInternalServerError)
+		return
+	}
+	s.writeStatusWithMessage(ctx, w, http.StatusOK, "storage is alive")
+}
+
+// HandleLiveness handles HTTP requests checking that the server process is alive.
+func (s *Server) HandleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	s.writeStatusWithMessage(r.Context(), w, http.StatusOK, "ok")
+}
+
+// HandleReadiness handles HTTP requests checking that the server is ready to serve traffic:
+// the storage is reachable, the restore has finished and the snapshot writer is healthy.
+func (s *Server) HandleReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+	health := s.service.Health(ctx)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if health.Status != model.HealthStatusOK {
+		var failed []string
+		for _, c := range health.Components {
+			if c.Status != model.HealthStatusOK {
+				failed = append(failed, c.Name+": "+c.Error)
+			}
+		}
+		s.writeStatusWithMessage(ctx, w, http.StatusServiceUnavailable, strings.Join(failed, "\n"))
+		return
+	}
+	s.writeStatusWithMessage(ctx, w, http.StatusOK, "ok")
+}
+
+// HandleHealth handles HTTP requests for the detailed per-component health in JSON.
+func (s *Server) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+	health := s.service.Health(ctx)
+	status := http.StatusOK
+	if health.Status != model.HealthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		s.log(ctx).Error("Encode", zap.Error(err))
+	}
+}
+
+// HandleGetConfig handles HTTP requests for the effective configuration in JSON,
+// with the source of each value and secrets redacted. Answers 404 unless the endpoint is enabled.
+// If a key is configured, the body is a signed signature.Stamp, which is accepted once and only
+// shortly after it was made; answers 403 for an expired or replayed request.
+func (s *Server) HandleGetConfig(w http.ResponseWriter, r *http.Request) {
+	if !s.Config().ConfigEndpoint {
+		http.NotFound(w, r)
+		return
+	}
+	if s.Config().Key != "" {
+		var stamp signature.Stamp
+		if err := json.NewDecoder(r.Body).Decode(&stamp); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.configReads.Accept(stamp, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
 			return
 		}
-		s.writeStatusWithMessage(w, http.StatusOK, "database is alive")
 	}
-	s.writeStatusWithMessage(w, http.StatusInternalServerError, "DB is unable")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.Config().Settings()); err != nil {
+		s.log(r.Context()).Error("Encode", zap.Error(err))
+	}
 }
@@ -10,6 +10,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/remote"
 )
 
 // HandleGetMetricFromJSON handles HTTP requests to retrieve a metric using JSON data.
@@ -17,20 +18,20 @@ func (s *Server) HandleGetMetricFromJSON(w http.ResponseWriter, r *http.Request)
 	ctx := r.Context()
 	var newMetrics model.Metrics
 	if err1 := json.NewDecoder(r.Body).Decode(&newMetrics); err1 != nil {
-		s.logger.Error("Decode", zap.Error(err1))
+		s.log(ctx).Error("Decode", zap.Error(err1))
 		http.Error(w, err1.Error(), http.StatusBadRequest)
 		return
 	}
 	metric, err2 := s.service.GetMetric(ctx, newMetrics)
 	if err2 != nil {
-		s.logger.Error("GetMetric", zap.Error(err2))
+		s.log(ctx).Error("GetMetric", zap.Error(err2))
 		http.Error(w, "GetMetric", http.StatusNotFound)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err3 := json.NewEncoder(w).Encode(metric); err3 != nil {
-		s.logger.Error("Encode", zap.Error(err3))
+		s.log(ctx).Error("Encode", zap.Error(err3))
 		http.Error(w, err3.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -41,14 +42,14 @@ func (s *Server) HandleGetMetricFromURL(w http.ResponseWriter, r *http.Request)
 	ctx := r.Context()
 	var newMetrics model.Metrics
 	if err := newMetrics.MapMetricsFromReqURL(r); err != nil {
-		s.logger.Error("MapMetricsFromReq", zap.Error(err))
+		s.log(ctx).Error("MapMetricsFromReq", zap.Error(err))
 		http.Error(w, apperrors.ErrInvalidRequestData.Error(), http.StatusBadRequest)
 		return
 	}
 
 	metric, err2 := s.service.GetMetric(ctx, newMetrics)
 	if err2 != nil {
-		s.logger.Error("s.storage.GetMetricByModel", zap.Error(err2))
+		s.log(ctx).Error("s.storage.GetMetricByModel", zap.Error(err2))
 		http.Error(w, "error getting value from server", http.StatusNotFound)
 		return
 	}
@@ -60,23 +61,37 @@ func (s *Server) HandleGetMetricFromURL(w http.ResponseWriter, r *http.Request)
 	case model.MetricTypeGauge:
 		stringValue = fmt.Sprint(*metric.Value)
 	default:
-		s.logger.Error("invalid metric type", zap.Error(errors.New("ErrInvalidMetricType")))
+		s.log(ctx).Error("invalid metric type", zap.Error(errors.New("ErrInvalidMetricType")))
 		http.Error(w, "error w.Write", http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	s.writeStatusWithMessage(w, http.StatusOK, stringValue)
+	s.writeStatusWithMessage(ctx, w, http.StatusOK, stringValue)
 }
 
-// HandleGetMetrics handles HTTP requests to retrieve all metrics.
+// HandleGetMetrics handles HTTP requests to retrieve all metrics. Clients accepting
+// application/json get a JSON array of metrics ordered by name, others an HTML page.
 func (s *Server) HandleGetMetrics(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	if remote.AcceptsJSON(r) {
+		list, err := s.service.ListMetrics(ctx)
+		if err != nil {
+			s.log(ctx).Error("ListMetrics", zap.Error(err))
+			http.Error(w, "ListMetrics", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			s.log(ctx).Error("Encode", zap.Error(err))
+		}
+		return
+	}
 	w.Header().Set("Content-Type", "text/html")
 	body, err := s.service.GetAllMetrics(ctx)
 	if err != nil {
-		s.logger.Error("s.storage.GetAllMetrics", zap.Error(err))
+		s.log(ctx).Error("s.storage.GetAllMetrics", zap.Error(err))
 		http.Error(w, "s.storage.GetAllMetrics", http.StatusInternalServerError)
 		return
 	}
-	s.writeStatusWithMessage(w, http.StatusOK, body)
+	s.writeStatusWithMessage(ctx, w, http.StatusOK, body)
 }
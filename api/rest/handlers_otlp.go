@@ -0,0 +1,93 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	metricsv1 "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Content types of OTLP/HTTP requests.
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// HandleOTLPMetrics handles OTLP/HTTP metrics export requests encoded in protobuf or JSON.
+// Metrics are mapped as described by protocol.OTLPConverter; unsupported data points are
+// reported to the client as a partial success.
+func (s *Server) HandleOTLPMetrics(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != contentTypeProtobuf && contentType != contentTypeJSON {
+		http.Error(w, "unsupported content type, use application/x-protobuf or application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.log(ctx).Error("ReadAll", zap.Error(err))
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	// MetricsData has the same encoding as ExportMetricsServiceRequest.
+	var data metricsv1.MetricsData
+	if contentType == contentTypeProtobuf {
+		err = proto.Unmarshal(body, &data)
+	} else {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, &data)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The changes of cumulative series are only remembered once stored, a retry of a failed request sends them again.
+	rejected, err := s.otlp.Convert(&data, s.storeConverted(ctx))
+	if err != nil {
+		s.convertedFailed(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(otlpResponse(contentType, rejected)); err != nil {
+		s.log(ctx).Error("Write", zap.Error(err))
+	}
+}
+
+// otlpResponse encodes an ExportMetricsServiceResponse, with a partial success if data points were rejected.
+func otlpResponse(contentType string, rejected int) []byte {
+	message := ""
+	if rejected > 0 {
+		message = fmt.Sprintf("%d data points of unsupported types or with invalid values were rejected", rejected)
+	}
+	if contentType == contentTypeJSON {
+		type partialSuccess struct {
+			RejectedDataPoints string `json:"rejectedDataPoints"`
+			ErrorMessage       string `json:"errorMessage"`
+		}
+		var resp struct {
+			PartialSuccess *partialSuccess `json:"partialSuccess,omitempty"`
+		}
+		if rejected > 0 {
+			resp.PartialSuccess = &partialSuccess{RejectedDataPoints: fmt.Sprint(rejected), ErrorMessage: message}
+		}
+		body, _ := json.Marshal(resp)
+		return body
+	}
+	if rejected == 0 {
+		return nil
+	}
+	var partial []byte
+	partial = protowire.AppendTag(partial, 1, protowire.VarintType)
+	partial = protowire.AppendVarint(partial, uint64(rejected))
+	partial = protowire.AppendTag(partial, 2, protowire.BytesType)
+	partial = protowire.AppendString(partial, message)
+	resp := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendBytes(resp, partial)
+}
@@ -0,0 +1,50 @@
+package rest
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/klauspost/compress/snappy"
+	"go.uber.org/zap"
+
+	"github.com/mrkovshik/yametrics/internal/protocol"
+)
+
+// HandlePrometheusRemoteWrite handles requests of the Prometheus remote write protocol 1.0:
+// snappy-compressed WriteRequest protobuf messages. The latest sample of every series is stored
+// as described by protocol.PrometheusConverter.
+func (s *Server) HandlePrometheusRemoteWrite(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cfg := s.Config()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.log(ctx).Error("ReadAll", zap.Error(err))
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	size, err := snappy.DecodedLen(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cfg.MaxDecompressedSize > 0 && size > cfg.MaxDecompressedSize {
+		http.Error(w, "decompressed body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req, err := protocol.ParseRemoteWrite(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The changes of cumulative series are only remembered once stored, a retry of a failed request sends them again.
+	if err := s.prometheus.Convert(req, s.storeConverted(ctx)); err != nil {
+		s.convertedFailed(ctx, w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
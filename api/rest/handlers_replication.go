@@ -0,0 +1,100 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mrkovshik/yametrics/internal/replication"
+)
+
+// WithReplica makes the server a replica receiving updates from the primary on POST /replication/.
+func (s *Server) WithReplica(replica *replication.Replica) *Server {
+	s.replica = replica
+	return s
+}
+
+// RequireSignature returns an http.Handler rejecting unsigned requests with 401 if a signing key is configured.
+// Authenticate verifies the signatures.
+func (s *Server) RequireSignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Config().Key != "" && r.Header.Get("HashSHA256") == "" {
+			s.rejectUnauthorized(w, r, "missing signature")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HandleReplicate handles the updates streamed from the primary to a replica. Answers 409 with the
+// replication status if the replica needs a snapshot, 403 once it has been promoted and 404 unless
+// the server is a replica.
+func (s *Server) HandleReplicate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if s.replica == nil {
+		http.NotFound(w, r)
+		return
+	}
+	var msg replication.Message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err := s.replica.Receive(ctx, msg)
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, replication.ErrOutOfSync):
+		s.writeReplicationStatus(w, r, http.StatusConflict)
+	case errors.Is(err, replication.ErrPromoted):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		s.log(ctx).Error("Receive", zap.Error(err))
+		http.Error(w, "Receive", http.StatusInternalServerError)
+	}
+}
+
+// HandleReplicationStatus reports the replication status of a replica in JSON.
+func (s *Server) HandleReplicationStatus(w http.ResponseWriter, r *http.Request) {
+	if s.replica == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.writeReplicationStatus(w, r, http.StatusOK)
+}
+
+// HandlePromote promotes a replica, which then accepts updates from clients and stops following the primary.
+// The body is a signed replication.PromoteRequest, which is accepted once and only shortly after it was made.
+// Answers with the replication status, 403 for an expired or replayed request or without a key.
+func (s *Server) HandlePromote(w http.ResponseWriter, r *http.Request) {
+	if s.replica == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if s.Config().Key == "" {
+		http.Error(w, "promotion needs a key", http.StatusForbidden)
+		return
+	}
+	var req replication.PromoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.replica.AcceptPromotion(req, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	s.replica.Promote()
+	s.writeReplicationStatus(w, r, http.StatusOK)
+}
+
+func (s *Server) writeReplicationStatus(w http.ResponseWriter, r *http.Request, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(s.replica.Status()); err != nil {
+		s.log(r.Context()).Error("Encode", zap.Error(err))
+	}
+}
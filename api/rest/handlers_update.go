@@ -2,12 +2,16 @@ package rest
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
 
 	"github.com/mrkovshik/yametrics/internal/apperrors"
 	"go.uber.org/zap"
 
 	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/protocol"
 )
 
 // HandleUpdateMetricFromJSON handles HTTP requests to update a metric from JSON data.
@@ -15,20 +19,19 @@ func (s *Server) HandleUpdateMetricFromJSON(w http.ResponseWriter, r *http.Reque
 	var newMetrics model.Metrics
 	ctx := r.Context()
 	if err := newMetrics.MapMetricsFromReqJSON(r); err != nil {
-		s.logger.Error("MapMetricsFromReqJSON", zap.Error(err))
+		s.log(ctx).Error("MapMetricsFromReqJSON", zap.Error(err))
 		http.Error(w, apperrors.ErrInvalidRequestData.Error(), http.StatusBadRequest)
 		return
 
 	}
 
 	if err := s.service.UpdateMetrics(ctx, []model.Metrics{newMetrics}); err != nil {
-		s.logger.Error("UpdateMetrics", zap.Error(err))
-		http.Error(w, "error w.Write", http.StatusInternalServerError)
+		s.updateFailed(ctx, w, err, "error w.Write")
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	s.writeStatusWithMessage(w, http.StatusOK, "Gauge successfully updated")
+	s.writeStatusWithMessage(ctx, w, http.StatusOK, "Gauge successfully updated")
 
 }
 
@@ -37,17 +40,20 @@ func (s *Server) HandleUpdateMetricsFromJSON(w http.ResponseWriter, r *http.Requ
 	ctx := r.Context()
 	var batch []model.Metrics
 	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
-		s.logger.Error("Decode", zap.Error(err))
+		s.log(ctx).Error("Decode", zap.Error(err))
 		http.Error(w, "Decode", http.StatusInternalServerError)
 		return
 	}
+	if maxBatchSize := s.Config().MaxBatchSize; maxBatchSize > 0 && len(batch) > maxBatchSize {
+		http.Error(w, "batch too large", http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err := s.service.UpdateMetrics(ctx, batch); err != nil {
-		s.logger.Error("UpdateMetrics", zap.Error(err))
-		http.Error(w, "UpdateMetrics", http.StatusInternalServerError)
+		s.updateFailed(ctx, w, err, "UpdateMetrics")
 		return
 	}
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	s.writeStatusWithMessage(w, http.StatusOK, "Gauge successfully updated")
+	s.writeStatusWithMessage(ctx, w, http.StatusOK, "Gauge successfully updated")
 }
 
 // HandleUpdateMetricFromURL handles HTTP requests to update a metric from URL parameters.
@@ -55,15 +61,52 @@ func (s *Server) HandleUpdateMetricFromURL(w http.ResponseWriter, r *http.Reques
 	ctx := r.Context()
 	var newMetrics model.Metrics
 	if err := newMetrics.MapMetricsFromReqURL(r); err != nil {
-		s.logger.Error("MapMetricsFromReq", zap.Error(err))
+		s.log(ctx).Error("MapMetricsFromReq", zap.Error(err))
 		http.Error(w, apperrors.ErrInvalidRequestData.Error(), http.StatusBadRequest)
 		return
 	}
 	if err := s.service.UpdateMetrics(ctx, []model.Metrics{newMetrics}); err != nil {
-		s.logger.Error("UpdateMetrics", zap.Error(err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.updateFailed(ctx, w, err, err.Error())
 		return
 	}
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	s.writeStatusWithMessage(w, http.StatusOK, "Gauge successfully updated")
+	s.writeStatusWithMessage(ctx, w, http.StatusOK, "Gauge successfully updated")
+}
+
+// HandleWriteInflux handles HTTP requests to update metrics from the InfluxDB line protocol.
+// Every numeric or boolean field becomes a gauge, see protocol.ParseInflux. The request is
+// rejected in full if any line is invalid. Answers 404 unless the endpoint is enabled.
+func (s *Server) HandleWriteInflux(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cfg := s.Config()
+	if !cfg.InfluxWriteEnable {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.log(ctx).Error("ReadAll", zap.Error(err))
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	var batch []model.Metrics
+	for i, line := range strings.Split(string(body), "\n") {
+		metrics, err := protocol.ParseInflux(line)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("line %d: %v", i+1, err), http.StatusBadRequest)
+			return
+		}
+		batch = append(batch, metrics...)
+	}
+	if cfg.MaxBatchSize > 0 && len(batch) > cfg.MaxBatchSize {
+		http.Error(w, "batch too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(batch) > 0 {
+		if err := s.service.UpdateMetrics(ctx, batch); err != nil {
+			s.updateFailed(ctx, w, err, "UpdateMetrics")
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
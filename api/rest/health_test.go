@@ -0,0 +1,60 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/service/server/mock_server"
+)
+
+func TestServer_HealthEndpoints(t *testing.T) {
+	healthy := model.Health{Status: model.HealthStatusOK, Components: []model.ComponentHealth{
+		{Name: "storage", Status: model.HealthStatusOK, Latency: "1ms"},
+	}}
+	unhealthy := model.Health{Status: model.HealthStatusFail, Components: []model.ComponentHealth{
+		{Name: "storage", Status: model.HealthStatusFail, Latency: "5s", Error: "timeout"},
+	}}
+	tests := []struct {
+		name     string
+		path     string
+		health   model.Health
+		pingErr  error
+		wantCode int
+	}{
+		{"liveness", "/healthz", unhealthy, errors.New("down"), http.StatusOK},
+		{"readiness ok", "/readyz", healthy, nil, http.StatusOK},
+		{"readiness fail", "/readyz", unhealthy, nil, http.StatusServiceUnavailable},
+		{"health ok", "/health", healthy, nil, http.StatusOK},
+		{"health fail", "/health", unhealthy, nil, http.StatusServiceUnavailable},
+		{"ping ok", "/ping", healthy, nil, http.StatusOK},
+		{"ping fail", "/ping", healthy, errors.New("down"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			svc := mock_server.NewMockService(ctrl)
+			svc.EXPECT().Health(gomock.Any()).Return(tt.health).AnyTimes()
+			svc.EXPECT().Ping(gomock.Any()).Return(tt.pingErr).AnyTimes()
+			cfg := config.ServerConfig{CryptoKey: "./missing_key.pem"}
+			s := NewServer(svc, &cfg, zap.NewNop().Sugar()).ConfigureRouter()
+
+			rr := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			require.Equal(t, tt.wantCode, rr.Code, rr.Body.String())
+			if tt.path == "/health" {
+				var got model.Health
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+				require.Equal(t, tt.health, got)
+			}
+		})
+	}
+}
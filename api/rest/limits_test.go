@@ -0,0 +1,106 @@
+package rest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/replication"
+	service "github.com/mrkovshik/yametrics/internal/service/server"
+	"github.com/mrkovshik/yametrics/internal/signature"
+	"github.com/mrkovshik/yametrics/internal/storage"
+)
+
+func TestServer_LimitRate(t *testing.T) {
+	const testKey = "test_key"
+	cfg := config.ServerConfig{Key: testKey, IPRateLimit: 1, KeyRateLimit: 1, RateLimitBurst: 2}
+	s := NewServer(nil, &cfg, zap.NewNop().Sugar())
+	h := s.LimitRate(s.Authenticate(s.LimitClientRate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))))
+	sig, err := signature.NewSha256Sig(testKey, []byte("{}")).Generate()
+	require.NoError(t, err)
+	do := func(ip string, signed bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString("{}"))
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("X-Client-ID", ip)
+		if signed {
+			req.Header.Set("HashSHA256", sig)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusOK, do("10.0.0.1", false).Code)
+	require.Equal(t, http.StatusOK, do("10.0.0.1", false).Code)
+	rr := do("10.0.0.1", false)
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Equal(t, "1", rr.Header().Get("Retry-After"))
+
+	require.Equal(t, http.StatusOK, do("10.0.0.2", true).Code)
+	require.Equal(t, http.StatusOK, do("10.0.0.3", true).Code)
+	require.Equal(t, http.StatusTooManyRequests, do("10.0.0.4", true).Code, "key limit applies across IPs")
+	require.Equal(t, http.StatusOK, do("10.0.0.5", false).Code, "unsigned requests are limited by IP, whatever client ID they claim")
+}
+
+func TestServer_BodyLimits(t *testing.T) {
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+	batch := `[{"id":"a","type":"gauge","value":1},{"id":"b","type":"gauge","value":2}]`
+
+	tests := []struct {
+		name     string
+		body     []byte
+		gzip     bool
+		wantCode int
+	}{
+		{"within limits", []byte(batch), false, http.StatusOK},
+		{"within limits gzip", gzipped([]byte(batch)), true, http.StatusOK},
+		{"too large", []byte(strings.Repeat(" ", 300) + batch), false, http.StatusRequestEntityTooLarge},
+		{"gzip bomb", gzipped([]byte(strings.Repeat(" ", 10000) + batch)), true, http.StatusRequestEntityTooLarge},
+		{"batch too long", []byte(`[` + strings.Repeat(`{"id":"a","type":"gauge","value":1},`, 3) + `{"id":"a","type":"gauge","value":1}]`), false, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.ServerConfig{MaxBodySize: 200, MaxDecompressedSize: 500, MaxBatchSize: 3}
+			logger := zap.NewNop().Sugar()
+			s := NewServer(service.NewMetricService(storage.NewInMemoryStorage(), &cfg, logger), &cfg, logger).ConfigureRouter()
+			req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			if tt.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			rr := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(rr, req)
+			require.Equal(t, tt.wantCode, rr.Code)
+		})
+	}
+}
+
+func TestServer_replicationLimits(t *testing.T) {
+	cfg := config.ServerConfig{MaxBodySize: 200, IPRateLimit: 1, RateLimitBurst: 1}
+	logger := zap.NewNop().Sugar()
+	svc := service.NewMetricService(storage.NewInMemoryStorage(), &cfg, logger)
+	s := NewServer(svc, &cfg, logger).WithReplica(replication.NewReplica(svc, logger)).ConfigureRouter()
+	do := func(body string) int {
+		rr := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, replication.Path, strings.NewReader(body)))
+		return rr.Code
+	}
+
+	require.Equal(t, http.StatusRequestEntityTooLarge, do(strings.Repeat(" ", 300)+"{}"))
+	require.Equal(t, http.StatusTooManyRequests, do("{}"))
+}
@@ -3,21 +3,79 @@ package rest
 import (
 	"bytes"
 	"crypto/hmac"
+	"errors"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"go.uber.org/zap"
+
 	"github.com/mrkovshik/yametrics/internal/compress"
 	"github.com/mrkovshik/yametrics/internal/logger"
 	rsa2 "github.com/mrkovshik/yametrics/internal/rsa"
 	"github.com/mrkovshik/yametrics/internal/signature"
+	"github.com/mrkovshik/yametrics/internal/tracing"
 )
 
-// WithLogging wraps an http.Handler with logging functionality.
-// It logs incoming HTTP requests and their corresponding responses.
+// maxRequestIDLength limits the length of client-provided request IDs.
+const maxRequestIDLength = 128
+
+// Trace returns an http.Handler that continues the trace from the traceparent header or starts
+// a new one, and takes the request ID from the X-Request-ID header or the trace ID. The request ID
+// is echoed in the response and attached to every line logged through the request context.
+// If a span exporter is configured, a server span is exported for every request.
+func (s *Server) Trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		tc := tracing.NewTraceContext()
+		if parent, err := tracing.ParseTraceparent(r.Header.Get(tracing.TraceparentHeader)); err == nil {
+			tc = tracing.ChildOf(parent)
+		}
+		if requestID := r.Header.Get(tracing.RequestIDHeader); requestID != "" && len(requestID) <= maxRequestIDLength {
+			tc.RequestID = requestID
+		}
+		w.Header().Set(tracing.RequestIDHeader, tc.RequestID)
+
+		reqLogger := s.logger.With("request_id", tc.RequestID, "trace_id", tc.TraceID)
+		ctx := logger.WithLogger(tracing.WithTraceContext(r.Context(), tc), reqLogger)
+		responseData := &logger.ResponseData{}
+		lw := logger.LoggingResponseWriter{
+			ResponseWriter: w,
+			ResponseData:   responseData,
+		}
+		next.ServeHTTP(&lw, r.WithContext(ctx))
+
+		status := responseData.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		s.tracer.Export(tracing.Span{
+			Trace: tc,
+			Name:  r.Method + " " + r.URL.Path,
+			Kind:  tracing.SpanKindServer,
+			Start: start,
+			End:   time.Now(),
+			Attributes: map[string]string{
+				"http.method":      r.Method,
+				"http.target":      r.RequestURI,
+				"http.status_code": strconv.Itoa(status),
+			},
+			Error: status >= http.StatusInternalServerError,
+		})
+	})
+}
+
+// WithLogging returns an http.Handler that logs every request with the status, size and duration of the response.
+// Requests to the paths listed in the log_skip_paths setting, such as health checks, are not logged.
 func (s *Server) WithLogging(h http.Handler) http.Handler {
 	logFn := func(w http.ResponseWriter, r *http.Request) {
+		if skipLogging(s.Config().LogSkipPaths, r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
 		start := time.Now()
 		responseData := &logger.ResponseData{
 			Status: 0,
@@ -29,47 +87,119 @@ func (s *Server) WithLogging(h http.Handler) http.Handler {
 		}
 		h.ServeHTTP(&lw, r)
 		duration := time.Since(start)
-		s.logger.Infoln(
-			"uri", r.RequestURI,
-			"method", r.Method,
-			"status", responseData.Status,
-			"duration", duration,
-			"size", responseData.Size,
+		status := responseData.Status
+		if status == 0 {
+			// The handler wrote the body without calling WriteHeader.
+			status = http.StatusOK
+		}
+		s.log(r.Context()).Desugar().Info("request",
+			zap.String("uri", r.RequestURI),
+			zap.String("method", r.Method),
+			zap.Int("status", status),
+			zap.Duration("duration", duration),
+			zap.Int("size", responseData.Size),
 		)
 	}
 	return http.HandlerFunc(logFn)
 }
 
-// GzipHandle returns an http.Handler that handles gzip compression for request and response bodies.
-// It checks the request headers for gzip encoding and wraps the response writer with gzip compression if supported.
-func (s *Server) GzipHandle(next http.Handler) http.Handler {
+// skipLogging reports whether path is one of the comma-separated paths.
+func skipLogging(paths, path string) bool {
+	for _, p := range strings.Split(paths, ",") {
+		if strings.TrimSpace(p) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// LimitRate returns an http.Handler that applies the per-IP token-bucket rate limit.
+// Requests over the limit are answered with 429 and a Retry-After header.
+func (s *Server) LimitRate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var isEncodingSupported = false
+		if ipLimiter := s.ipLimiter.Load(); ipLimiter != nil {
+			if ok, wait := ipLimiter.Allow(sourceIP(r)); !ok {
+				s.rejectTooManyRequests(w, wait)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		if r.Header.Get(`Content-Encoding`) == `gzip` {
-			gz, err := compress.NewGzipReader(r.Body)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+// LimitClientRate returns an http.Handler that applies the per-client token-bucket rate limit.
+// Clients are identified by the key that verified their signature, so it follows Authenticate;
+// unsigned requests are limited by source IP.
+func (s *Server) LimitClientRate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if keyLimiter := s.keyLimiter.Load(); keyLimiter != nil {
+			if ok, wait := keyLimiter.Allow(clientIdentity(r)); !ok {
+				s.rejectTooManyRequests(w, wait)
 				return
 			}
-			r.Body = gz
-			defer gz.Close() //nolint:all
 		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LimitBody returns an http.Handler that rejects request bodies larger than the configured size
+// with 413. The size is checked before decompression; CompressHandle checks it after.
+func (s *Server) LimitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		maxBodySize := s.Config().MaxBodySize
+		if maxBodySize <= 0 || r.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > int64(maxBodySize) {
+			s.writeBodyError(w, errBodyTooLarge)
+			return
+		}
+		body, err := readLimited(r.Body, int64(maxBodySize))
+		r.Body.Close() //nolint:all
+		if err != nil {
+			s.writeBodyError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
+		next.ServeHTTP(w, r)
+	})
+}
 
-		acceptValues := r.Header.Values("Accept-Encoding")
-		for _, value := range acceptValues {
-			if strings.Contains(value, "gzip") {
-				isEncodingSupported = true
-				break
+// CompressHandle returns an http.Handler that handles compression of request and response bodies.
+// Request bodies are decoded with the codec named in Content-Encoding, capping the decompressed size.
+// Responses are encoded with the most preferred codec from Accept-Encoding if they reach the
+// configured minimum size.
+func (s *Server) CompressHandle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if encoding := r.Header.Get(`Content-Encoding`); encoding != "" && encoding != compress.EncodingIdentity {
+			codec, ok := compress.Lookup(encoding)
+			if !ok {
+				http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+				return
 			}
+			cr, err := codec.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer cr.Close() //nolint:all
+			body, err := readLimited(cr, int64(s.Config().MaxDecompressedSize))
+			if err != nil {
+				s.writeBodyError(w, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewBuffer(body))
+			r.Header.Del(`Content-Encoding`)
 		}
 
-		if !isEncodingSupported {
+		codec, ok := compress.Negotiate(r.Header.Values("Accept-Encoding"))
+		if !ok {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		cw := compress.NewGzipWriter(w)
+		cw := compress.NewWriter(w, codec, s.Config().CompressMinSize)
 
 		defer cw.Close() //nolint:all
 
@@ -79,44 +209,86 @@ func (s *Server) GzipHandle(next http.Handler) http.Handler {
 
 // Authenticate returns an http.Handler that authenticates incoming requests using HMAC-SHA256 signatures.
 // It verifies the integrity of the request body against the provided signature.
+// If no key is configured the check is skipped. Unsigned requests are passed through.
+// Failed checks are answered with 401 and counted per source IP.
 func (s *Server) Authenticate(next http.Handler) http.Handler {
+	return s.authenticate(next, false)
+}
+
+// AuthenticateWrites is Authenticate for the routes changing the stored metrics, every route group
+// accepting writes uses it. In strict auth mode unsigned requests are rejected.
+func (s *Server) AuthenticateWrites(next http.Handler) http.Handler {
+	return s.authenticate(next, true)
+}
+
+func (s *Server) authenticate(next http.Handler, writes bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := s.Config()
+		if cfg.Key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
 		clientSig := r.Header.Get(`HashSHA256`)
-		if clientSig != "" && r.Body != nil {
-			body, err := io.ReadAll(r.Body)
-			defer r.Body.Close() //nolint:all
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+		if clientSig == "" {
+			if cfg.StrictAuth && writes {
+				s.rejectUnauthorized(w, r, "missing signature")
 				return
 			}
-			r.Body = io.NopCloser(bytes.NewBuffer(body))
-			sigSrv := signature.NewSha256Sig(s.config.Key, body)
-			sig, err := sigSrv.Generate()
+			next.ServeHTTP(w, r)
+			return
+		}
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = io.ReadAll(r.Body)
+			defer r.Body.Close() //nolint:all
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			if !hmac.Equal([]byte(clientSig), []byte(sig)) {
-				http.Error(w, "invalid signature", http.StatusBadRequest)
+				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 		}
-		next.ServeHTTP(w, r)
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
+		sigSrv := signature.NewSha256Sig(cfg.Key, body)
+		sig, err := sigSrv.Generate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !hmac.Equal([]byte(clientSig), []byte(sig)) {
+			s.rejectUnauthorized(w, r, "invalid signature")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withVerifiedKey(r.Context(), cfg.Key)))
 	})
 }
 
+// rejectUnauthorized counts the failed attempt for the source IP and responds with 401.
+func (s *Server) rejectUnauthorized(w http.ResponseWriter, r *http.Request, reason string) {
+	ip := sourceIP(r)
+	failures := s.authFailures.inc(ip)
+	s.metrics.authFailures.Inc(reason)
+	s.log(r.Context()).Warnw("authentication failed",
+		"reason", reason,
+		"ip", ip,
+		"uri", r.RequestURI,
+		"failures", failures,
+	)
+	http.Error(w, reason, http.StatusUnauthorized)
+}
+
 // SignResponse returns an http.Handler that signs outgoing response bodies using HMAC-SHA256 signatures.
 // If a signing key is configured, it computes the signature of the response body and sets the HashSHA256 header.
 func (s *Server) SignResponse(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if s.config.Key == "" {
+		key := s.Config().Key
+		if key == "" {
 			next.ServeHTTP(w, r)
 			return
 		}
 		rw := signature.NewCapturingResponseWriter(w)
 		next.ServeHTTP(rw, r)
 		if len(rw.Body()) != 0 {
-			sigSrv := signature.NewSha256Sig(s.config.Key, rw.Body())
+			sigSrv := signature.NewSha256Sig(key, rw.Body())
 			sig, err := sigSrv.Generate()
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -135,7 +307,8 @@ func (s *Server) SignResponse(next http.Handler) http.Handler {
 
 func (s *Server) DecryptRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if s.config.CryptoKey == "" {
+		cryptoKey := s.Config().CryptoKey
+		if cryptoKey == "" {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -147,9 +320,14 @@ func (s *Server) DecryptRequest(next http.Handler) http.Handler {
 			return
 		}
 		r.Body = io.NopCloser(bytes.NewBuffer(body))
+		// Requests without a body, such as reads, have nothing to decrypt.
+		if len(body) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		// Read the PEM file
-		privateKeyPem, err := rsa2.ReadPEMFile(s.config.CryptoKey)
+		privateKeyPem, err := rsa2.ReadPEMFile(cryptoKey)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -158,6 +336,7 @@ func (s *Server) DecryptRequest(next http.Handler) http.Handler {
 		//// Decode the base64-encoded ciphertext
 		plaintext, err := rsa2.Decrypt(privateKeyPem, body)
 		if err != nil {
+			s.metrics.decryptFailures.Inc()
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -167,3 +346,34 @@ func (s *Server) DecryptRequest(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// readLimited reads r to the end, failing with errBodyTooLarge if more than limit bytes are read.
+// A non-positive limit disables the check.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	body, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errBodyTooLarge
+	}
+	return body, nil
+}
+
+func (s *Server) writeBodyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errBodyTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+func (s *Server) rejectTooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+}
@@ -0,0 +1,130 @@
+package rest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/mrkovshik/yametrics/internal/compress"
+	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/model"
+	service2 "github.com/mrkovshik/yametrics/internal/service/agent"
+	service "github.com/mrkovshik/yametrics/internal/service/server"
+	"github.com/mrkovshik/yametrics/internal/storage"
+	"github.com/mrkovshik/yametrics/internal/telemetry"
+	"github.com/mrkovshik/yametrics/internal/tracing"
+)
+
+func TestServer_CompressHandle(t *testing.T) {
+	const body = `[{"id":"a","type":"gauge","value":1}]`
+	tests := []struct {
+		name     string
+		encoding string
+		accept   string
+		wantCode int
+		wantEnc  string
+	}{
+		{"gzip request", compress.EncodingGzip, "", http.StatusOK, ""},
+		{"deflate request", compress.EncodingDeflate, "", http.StatusOK, ""},
+		{"zstd request", compress.EncodingZstd, "", http.StatusOK, ""},
+		{"identity request", compress.EncodingIdentity, "", http.StatusOK, ""},
+		{"zstd response", compress.EncodingIdentity, "gzip;q=0.5, zstd", http.StatusOK, compress.EncodingZstd},
+		{"unsupported request", "br", "", http.StatusUnsupportedMediaType, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.ServerConfig{}
+			logger := zap.NewNop().Sugar()
+			s := NewServer(service.NewMetricService(storage.NewInMemoryStorage(), &cfg, logger), &cfg, logger).ConfigureRouter()
+
+			rb := service2.NewRequestBuilder().SetURL("/updates/").SetMethod(http.MethodPost)
+			rb.R.Body = io.NopCloser(bytes.NewBufferString(body))
+			if tt.encoding == "br" {
+				rb.WithHeader("Content-Encoding", "br")
+			} else {
+				rb.Compress(tt.encoding)
+			}
+			require.NoError(t, rb.Err)
+			if tt.accept != "" {
+				rb.WithHeader("Accept-Encoding", tt.accept)
+			}
+			rr := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(rr, &rb.R)
+			require.Equal(t, tt.wantCode, rr.Code)
+			require.Equal(t, tt.wantEnc, rr.Header().Get("Content-Encoding"))
+		})
+	}
+}
+
+func TestServer_Trace(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core).Sugar()
+	cfg := config.ServerConfig{}
+	s := NewServer(service.NewMetricService(storage.NewInMemoryStorage(), &cfg, logger), &cfg, logger).ConfigureRouter()
+	logs.TakeAll()
+
+	rb := service2.NewRequestBuilder().WithTrace().SetURL("/value/").SetMethod(http.MethodPost).
+		AddJSONBody(model.Metrics{ID: "missing", MType: model.MetricTypeGauge})
+	require.NoError(t, rb.Err)
+	rr := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rr, &rb.R)
+
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	require.Equal(t, rb.Trace.RequestID, rr.Header().Get(tracing.RequestIDHeader))
+	require.NotZero(t, logs.Len())
+	for _, entry := range logs.All() {
+		require.Equal(t, rb.Trace.RequestID, entry.ContextMap()["request_id"], entry.Message)
+		require.Equal(t, rb.Trace.TraceID, entry.ContextMap()["trace_id"], entry.Message)
+	}
+}
+
+func TestServer_WithLogging(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core).Sugar()
+	cfg := config.ServerConfig{LogSkipPaths: "/healthz, /readyz"}
+	s := NewServer(nil, &cfg, logger)
+	h := s.WithLogging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok")) //nolint:all
+	}))
+
+	for _, path := range []string{"/healthz", "/readyz", "/value/gauge/a"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1, "requests to skipped paths are not logged")
+	fields := entries[0].ContextMap()
+	require.Equal(t, "request", entries[0].Message)
+	require.Equal(t, "/value/gauge/a", fields["uri"])
+	require.Equal(t, http.MethodGet, fields["method"])
+	require.Equal(t, int64(http.StatusOK), fields["status"])
+	require.Equal(t, int64(2), fields["size"])
+	require.Contains(t, fields, "duration")
+}
+
+func TestServer_InternalMetrics(t *testing.T) {
+	reg := telemetry.NewRegistry()
+	cfg := config.ServerConfig{}
+	logger := zap.NewNop().Sugar()
+	strg := storage.NewInstrumentedStorage(storage.NewInMemoryStorage(), "memory", reg)
+	s := NewServer(service.NewMetricService(strg, &cfg, logger).WithTelemetry(reg), &cfg, logger).WithTelemetry(reg).ConfigureRouter()
+
+	for _, path := range []string{"/update/gauge/a/1", "/update/counter/b/2", "/update/counter/b/x"} {
+		rr := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, nil))
+	}
+	rr := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/internal/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	require.Contains(t, body, `yametrics_http_requests_total{route="/update/{type}/{name}/{value}",method="POST",status="200"} 2`)
+	require.Contains(t, body, `yametrics_http_requests_total{route="/update/{type}/{name}/{value}",method="POST",status="400"} 1`)
+	require.Contains(t, body, `yametrics_metric_updates_total{type="counter"} 1`)
+	require.Contains(t, body, `yametrics_storage_operation_duration_seconds_count{backend="memory",operation="update_metrics"} 2`)
+}
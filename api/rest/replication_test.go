@@ -0,0 +1,138 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/replication"
+	service "github.com/mrkovshik/yametrics/internal/service/server"
+	"github.com/mrkovshik/yametrics/internal/signature"
+	"github.com/mrkovshik/yametrics/internal/storage"
+)
+
+func TestServer_Replication(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := zap.NewNop().Sugar()
+	cfg := config.ServerConfig{Key: "secret"}
+
+	replicaService := service.NewMetricService(storage.NewInMemoryStorage(), &cfg, logger)
+	replica := replication.NewReplica(replicaService, logger)
+	replicaService.WithReplication(replica)
+	replicaServer := httptest.NewServer(NewServer(replicaService, &cfg, logger).WithReplica(replica).ConfigureRouter().server.Handler)
+	defer replicaServer.Close()
+
+	primaryService := service.NewMetricService(storage.NewInMemoryStorage(), &cfg, logger)
+	primary := replication.NewPrimary(primaryService, []string{replicaServer.URL}, func() string { return cfg.Key }, logger)
+	primaryService.WithReplication(primary)
+	primaryServer := httptest.NewServer(NewServer(primaryService, &cfg, logger).ConfigureRouter().server.Handler)
+	defer primaryServer.Close()
+	go primary.Run(ctx)
+
+	post := func(url, body string) int {
+		t.Helper()
+		resp, err := http.Post(url, "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close() //nolint:all
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusOK, post(primaryServer.URL+"/update/counter/PollCount/3", ""))
+	require.Equal(t, http.StatusOK, post(primaryServer.URL+"/updates/", `[{"id":"PollCount","type":"counter","delta":2},{"id":"Alloc","type":"gauge","value":1.5}]`))
+
+	// The replica serves reads of the replicated metrics and rejects updates.
+	value := func() string {
+		resp, err := http.Get(replicaServer.URL + "/value/counter/PollCount")
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:all
+		var buf bytes.Buffer
+		buf.ReadFrom(resp.Body) //nolint:all
+		return buf.String()
+	}
+	require.Eventually(t, func() bool { return value() == "5" }, 2*time.Second, 10*time.Millisecond)
+	m, err := replicaService.GetMetric(ctx, model.Metrics{ID: "Alloc", MType: model.MetricTypeGauge})
+	require.NoError(t, err)
+	require.Equal(t, 1.5, *m.Value)
+	require.Equal(t, http.StatusForbidden, post(replicaServer.URL+"/update/counter/PollCount/1", ""))
+	require.Equal(t, http.StatusUnauthorized, post(replicaServer.URL+"/replication/promote", ""), "promotion needs the key")
+
+	statusOf := func(resp *http.Response) replication.Status {
+		t.Helper()
+		defer resp.Body.Close() //nolint:all
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var status replication.Status
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		return status
+	}
+	resp, err := http.Get(replicaServer.URL + "/replication/")
+	require.NoError(t, err)
+	status := statusOf(resp)
+	require.Equal(t, replication.RoleReplica, status.Role)
+	require.NotEmpty(t, status.Primary)
+	require.Equal(t, uint64(2), status.Seq)
+
+	// Once promoted, the replica takes updates from clients and no longer from the old primary.
+	promote := func(body []byte) *http.Response {
+		t.Helper()
+		sig, err := signature.NewSha256Sig(cfg.Key, body).Generate()
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, replicaServer.URL+"/replication/promote", bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("HashSHA256", sig)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	expired, err := json.Marshal(replication.PromoteRequest{Time: time.Now().Add(-2 * replication.PromoteMaxAge), Nonce: "old"})
+	require.NoError(t, err)
+	resp = promote(expired)
+	resp.Body.Close() //nolint:all
+	require.Equal(t, http.StatusForbidden, resp.StatusCode, "expired requests are rejected")
+	body, err := json.Marshal(replication.NewPromoteRequest())
+	require.NoError(t, err)
+	resp = promote(body)
+	require.Equal(t, replication.RolePrimary, statusOf(resp).Role)
+	resp = promote(body)
+	resp.Body.Close() //nolint:all
+	require.Equal(t, http.StatusForbidden, resp.StatusCode, "a request is accepted once")
+	require.Equal(t, http.StatusOK, post(replicaServer.URL+"/update/counter/PollCount/1", ""))
+	require.Equal(t, http.StatusOK, post(primaryServer.URL+"/update/counter/PollCount/10", ""))
+	require.Never(t, func() bool { return value() != "6" }, 200*time.Millisecond, 20*time.Millisecond)
+}
+
+func TestServer_promoteNeedsKey(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	var cfg config.ServerConfig
+	replicaService := service.NewMetricService(storage.NewInMemoryStorage(), &cfg, logger)
+	replica := replication.NewReplica(replicaService, logger)
+	s := NewServer(replicaService, &cfg, logger).WithReplica(replica).ConfigureRouter()
+
+	body, err := json.Marshal(replication.NewPromoteRequest())
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/replication/promote", bytes.NewReader(body)))
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	require.Equal(t, replication.RoleReplica, replica.Status().Role)
+
+	// A key added by a reload enables promotion.
+	keyed := config.ServerConfig{Key: "secret"}
+	s.UpdateConfig(&keyed)
+	sig, err := signature.NewSha256Sig(keyed.Key, body).Generate()
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/replication/promote", bytes.NewReader(body))
+	req.Header.Set("HashSHA256", sig)
+	rr = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, replication.RolePrimary, replica.Status().Role)
+}
@@ -286,8 +286,8 @@ func Test_server(t *testing.T) {
 			if tt.request.contentType == "application/json" {
 				req.AddJSONBody(tt.request.req)
 			}
-			if tt.request.contentEncode == "gzip" {
-				req.Compress()
+			if tt.request.contentEncode != "" {
+				req.Compress(tt.request.contentEncode)
 			}
 			require.NoError(t, req.Err)
 			response, err4 := client.Do(&req.R)
@@ -0,0 +1,64 @@
+package rest
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/mrkovshik/yametrics/internal/logger"
+	"github.com/mrkovshik/yametrics/internal/telemetry"
+)
+
+// serverMetrics holds the self-observability metrics of the HTTP layer.
+type serverMetrics struct {
+	requests        *telemetry.CounterVec
+	requestDuration *telemetry.HistogramVec
+	authFailures    *telemetry.CounterVec
+	decryptFailures *telemetry.CounterVec
+}
+
+func newServerMetrics(reg *telemetry.Registry) serverMetrics {
+	return serverMetrics{
+		requests: reg.NewCounterVec("yametrics_http_requests_total",
+			"Total number of handled HTTP requests.", "route", "method", "status"),
+		requestDuration: reg.NewHistogramVec("yametrics_http_request_duration_seconds",
+			"Latency of handled HTTP requests.", telemetry.DefBuckets, "route", "method"),
+		authFailures: reg.NewCounterVec("yametrics_auth_failures_total",
+			"Total number of requests rejected by signature checks.", "reason"),
+		decryptFailures: reg.NewCounterVec("yametrics_decrypt_failures_total",
+			"Total number of requests which could not be decrypted."),
+	}
+}
+
+// WithTelemetry sets the registry the server records its metrics to and exposes at /internal/metrics.
+func (s *Server) WithTelemetry(reg *telemetry.Registry) *Server {
+	s.telemetry = reg
+	s.metrics = newServerMetrics(reg)
+	return s
+}
+
+// Instrument returns an http.Handler that records request counts and latencies by route and status.
+func (s *Server) Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		responseData := &logger.ResponseData{}
+		lw := logger.LoggingResponseWriter{
+			ResponseWriter: w,
+			ResponseData:   responseData,
+		}
+		next.ServeHTTP(&lw, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := responseData.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		s.metrics.requests.Inc(route, r.Method, strconv.Itoa(status))
+		s.metrics.requestDuration.ObserveSince(start, route, r.Method)
+	})
+}
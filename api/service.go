@@ -33,10 +33,25 @@ type Service interface {
 	// - an error if the retrieval operation fails.
 	GetAllMetrics(ctx context.Context) (string, error)
 
+	// ListMetrics retrieves all available metrics.
+	// Parameters:
+	// - ctx: the context to control the retrieval operation.
+	// Returns:
+	// - the metrics ordered by name and type.
+	// - an error if the retrieval operation fails.
+	ListMetrics(ctx context.Context) ([]model.Metrics, error)
+
 	// Ping checks the availability of the service.
 	// Parameters:
 	// - ctx: the context to control the ping operation.
 	// Returns:
 	// - an error if the service is not available.
 	Ping(ctx context.Context) error
+
+	// Health checks the readiness of the service components: storage, restore and snapshots.
+	// Parameters:
+	// - ctx: the context to control the checks.
+	// Returns:
+	// - the overall and per-component health.
+	Health(ctx context.Context) model.Health
 }
@@ -0,0 +1,75 @@
+// Package statsd accepts metrics sent over the StatsD protocol on UDP and updates them
+// through the metrics service, for applications that cannot use the REST API.
+package statsd
+
+import (
+	"context"
+	"net"
+
+	"go.uber.org/zap"
+
+	"github.com/mrkovshik/yametrics/api"
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/protocol"
+)
+
+// Server translates StatsD packets into metric batches.
+type Server struct {
+	service api.Service
+	logger  *zap.SugaredLogger
+}
+
+// NewServer creates a Server updating metrics through service.
+func NewServer(service api.Service, logger *zap.SugaredLogger) *Server {
+	return &Server{service: service, logger: logger}
+}
+
+// Serve reads StatsD packets from conn until ctx is done. The lines of every packet are mapped onto
+// metrics as described by protocol.StatsDSample.Metrics and updated as a single batch.
+// DogStatsD tags are appended to the metric names. Invalid lines are logged and skipped.
+func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	return protocol.ServePackets(ctx, conn, func(lines []string) {
+		s.handle(ctx, lines)
+	})
+}
+
+func (s *Server) handle(ctx context.Context, lines []string) {
+	var batch []model.Metrics
+	// Gauges set earlier in the packet are the base of relative changes that follow them.
+	gauges := make(map[string]float64)
+	for _, line := range lines {
+		sample, err := protocol.ParseStatsD(line)
+		if err != nil {
+			s.logger.Warnf("StatsD %q: %v", line, err)
+			continue
+		}
+		var current float64
+		if sample.Relative {
+			current = s.gauge(ctx, gauges, sample.Name)
+		}
+		for _, m := range sample.Metrics(current) {
+			if m.MType == model.MetricTypeGauge {
+				gauges[m.ID] = *m.Value
+			}
+			batch = append(batch, m)
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+	if err := s.service.UpdateMetrics(ctx, batch); err != nil {
+		s.logger.Errorf("UpdateMetrics: %v", err)
+	}
+}
+
+// gauge returns the current value of the gauge, 0 if it is not set.
+func (s *Server) gauge(ctx context.Context, gauges map[string]float64, name string) float64 {
+	if value, ok := gauges[name]; ok {
+		return value
+	}
+	m, err := s.service.GetMetric(ctx, model.Metrics{ID: name, MType: model.MetricTypeGauge})
+	if err != nil || m.Value == nil {
+		return 0
+	}
+	return *m.Value
+}
@@ -0,0 +1,54 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/model"
+	service "github.com/mrkovshik/yametrics/internal/service/server"
+	"github.com/mrkovshik/yametrics/internal/storage"
+)
+
+func TestServer_Serve(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	cfg := config.ServerConfig{}
+	metricService := service.NewMetricService(storage.NewInMemoryStorage(), &cfg, logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	value := 10.0
+	require.NoError(t, metricService.UpdateMetrics(ctx, []model.Metrics{{ID: "queue", MType: model.MetricTypeGauge, Value: &value}}))
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- NewServer(metricService, logger).Serve(ctx, conn) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer client.Close() //nolint:all
+	_, err = client.Write([]byte("orders:1|c\norders:2|c|@0.5\nqueue:-3|g\nqueue:+1|g\nbad line\nlatency:250|ms|#route:/api\n"))
+	require.NoError(t, err)
+
+	get := func(name, mType string) model.Metrics {
+		m, _ := metricService.GetMetric(ctx, model.Metrics{ID: name, MType: mType})
+		return m
+	}
+	require.Eventually(t, func() bool { return get("latency.count;route=/api", model.MetricTypeCounter).Delta != nil }, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(5), *get("orders", model.MetricTypeCounter).Delta, "sampled increments are scaled")
+	require.Equal(t, 8.0, *get("queue", model.MetricTypeGauge).Value, "relative changes add up within a packet")
+	require.Equal(t, 250.0, *get("latency;route=/api", model.MetricTypeGauge).Value)
+	require.Equal(t, int64(1), *get("latency.count;route=/api", model.MetricTypeCounter).Delta)
+
+	cancel()
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after the context was done")
+	}
+}
@@ -8,12 +8,54 @@
 // The configuration is loaded using the config package. It fetches configurations from environment variables and
 // command-line flags. The agent's address, polling interval, reporting interval, authentication key, and rate limit
 // are configured through these mechanisms.
+// With -print-config the effective configuration is printed with the source of each value
+// (default, file, env or flag) and secrets redacted, and the agent exits.
+// Applications can push their own metrics through the agent: with ingest_http_address set the agent accepts
+// the update routes of the server over HTTP, with ingest_statsd_address set it accepts StatsD packets over UDP.
+// Both listeners are meant to be bound to localhost. Pushed metrics are shipped with every report.
+// Hosts that cannot reach the server are scraped by it instead: with mode set to pull or both the agent
+// serves its metrics on GET /metrics at expose_address, in the Prometheus text format or in JSON.
+// In the pull mode nothing is sent to the server.
+// During migrations the agent sends to several servers at once: destinations lists them as name=host:port
+// and replaces the address. The key, crypto key, compression and rate limit of the agent apply to every
+// destination unless overridden in destination_keys, destination_crypto_keys, destination_compressions or
+// destination_rate_limits, given as name=value. Every destination has its own workers and retries,
+// so a server that is down does not hold up the others.
+// Requests failing with a network error, 5xx or 429 are retried retry_attempts times with exponential backoff
+// and full jitter between retry_initial_interval and retry_max_interval; workers send other metrics while a
+// retry waits. After breaker_threshold consecutive failed requests a circuit breaker pauses sending to the
+// destination for breaker_cooldown; the metrics held back are sent once a probe request succeeds. The breaker
+// state of every destination is shipped as the agent_breaker_state gauge: 0 closed, 1 half-open, 2 open.
+// The agent ships metrics about itself along with the collected ones, so that a failing agent shows on the
+// server: per destination the send attempts, retries, successes and failures by status, the queue depth and
+// the bytes sent before and after compression, the poll duration per collector, and the build version,
+// commit and date as the tags of the agent_build_info gauge.
+// Key daemons are watched with processes, a list of name=name:regexp, name=cmdline:regexp or name=pidfile:path
+// entries selecting processes by name, command line or PID file. Every poll reports the number of processes
+// selected under each name and their total CPU usage, RSS, open file descriptors and threads, tagged with the
+// name only, so that restarts do not create new series; processes are looked up again on every poll, so those
+// that exit stop counting.
+// Scripts and checks are run with commands, a list of name=command entries run by the shell. A command runs
+// every command_interval and is killed after command_timeout; command_intervals and command_timeouts set them
+// per command as name=duration. Its output holds a metric per line as name type value, or a JSON metric or
+// array of metrics in the form the server accepts; counters are increments. Only the first MiB of the output is
+// parsed, and the start of the standard error is logged. Every run reports the exit code, the number of invalid
+// output lines and the duration tagged with the command name, and runs that exit with a non-zero code, fail
+// to start or time out count in exec_failures_total.
+// Flags and environment variables give these lists comma-separated, so entries cannot contain commas there.
+// A configuration file gives them structured instead: destinations as a list of tables with name and address,
+// processes with name, by and pattern, commands with name and command, and the settings of individual
+// destinations and commands as tables of values by name, e.g. command_intervals = {queue = "30s"} in TOML.
+// Logging is configured by the log_* settings: the level, console or json format, an optional log file
+// rotated by size and sampling of repeated entries.
 //
 // Running the Agent:
 // The agent starts by initializing a map storage and runtime metrics source. It configures logging using zap, a
 // structured logging library, and retrieves configuration settings using the GetConfigs function from the config package.
 // Polling for metrics and sending reports are handled by separate goroutines started with Go's built-in concurrency support.
 // The main function runs indefinitely, ensuring the agent continues to operate until interrupted.
+// On SIGHUP the configuration is loaded again and applied without a restart. On SIGTERM or SIGINT polling stops
+// and the collected metrics are sent one last time before the agent exits.
 //
 // Example Usage:
 // To run the metrics agent, configure environment variables or use command-line flags to set necessary parameters,
@@ -4,17 +4,23 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"go.uber.org/zap"
 
 	config "github.com/mrkovshik/yametrics/internal/config/agent"
+	"github.com/mrkovshik/yametrics/internal/config/settings"
+	"github.com/mrkovshik/yametrics/internal/ingest"
+	logging "github.com/mrkovshik/yametrics/internal/logger"
 	"github.com/mrkovshik/yametrics/internal/metrics"
 	service "github.com/mrkovshik/yametrics/internal/service/agent"
 	"github.com/mrkovshik/yametrics/internal/storage"
+	"github.com/mrkovshik/yametrics/internal/tracing"
 )
 
 var (
@@ -36,86 +42,250 @@ func main() {
 	strg := storage.NewInMemoryStorage()
 	src := metrics.NewRuntimeMetrics()
 
+	// Get configuration settings
+	cfg, err := config.GetConfigs()
+	if err != nil {
+		log.Fatalf("config.GetConfigs: %v", err)
+	}
+	if cfg.PrintConfig {
+		fmt.Print(settings.Format(cfg.Settings()))
+		return
+	}
+
 	// Initialize logging with zap
-	logger, err := zap.NewDevelopment()
+	logger, level, err := logging.New(logOptions(cfg))
 	if err != nil {
-		log.Fatal("zap.NewDevelopment", zap.Error(err))
+		log.Fatalf("logging.New: %v", err)
 	}
 
 	// Flushes buffered log entries before program exits
 	defer logger.Sync() //nolint:all
 	sugar := logger.Sugar()
 
-	// Get configuration settings
-	cfg, err := config.GetConfigs()
-	if err != nil {
-		logger.Fatal("config.GetConfigs", zap.Error(err))
-	}
-
 	ctx, stopServices := context.WithCancel(context.Background())
 	defer stopServices()
 
 	// Create agent instance with dependencies
-	agent := service.NewAgent(src, &cfg, strg, sugar)
+	agent := service.NewAgent(src, &cfg, strg, sugar).WithBuildInfo(buildVersion, buildCommit, buildDate)
+	if cfg.OTLPEndpoint != "" {
+		tracer := tracing.NewExporter(cfg.OTLPEndpoint, "yametrics-agent", sugar)
+		defer tracer.Shutdown(context.Background()) //nolint:all
+		agent.WithTracer(tracer)
+	}
+
+	// Collect metrics of the selected processes along with the runtime ones
+	if processes := cfg.ProcessList(); len(processes) > 0 {
+		agent.WithCollector("process", metrics.NewProcessCollector(processes))
+	}
+
+	// Run the configured commands on their own intervals, which are checked on every poll
+	var commands *metrics.ExecCollector
+	if list := cfg.CommandList(); len(list) > 0 {
+		commands = metrics.NewExecCollector(list, sugar)
+		agent.WithCollector("exec", commands)
+	}
+
+	// Receive metrics pushed by local applications, they are stopped before the final send on shutdown
+	ingestCtx, stopIngesting := context.WithCancel(context.Background())
+	defer stopIngesting()
+	ingestStopped := make(chan struct{})
+	if cfg.IngestHTTPAddress != "" || cfg.IngestStatsDAddress != "" {
+		receiver := ingest.NewReceiver(strg, sugar)
+		agent.WithReceiver(receiver)
+		if err := startIngestion(ingestCtx, cfg, receiver, sugar, ingestStopped); err != nil {
+			sugar.Fatal("startIngestion", err)
+		}
+	} else {
+		close(ingestStopped)
+	}
+
+	// Expose metrics for the server to scrape, the endpoint is stopped along with the ingestion
+	exposeStopped := make(chan struct{})
+	if cfg.ExposeAddress != "" {
+		if err := startExposing(ingestCtx, cfg.ExposeAddress, agent, sugar, exposeStopped); err != nil {
+			sugar.Fatal("startExposing", err)
+		}
+	} else {
+		close(exposeStopped)
+	}
 
 	// Log agent configuration
-	sugar.Infof(
-		"Running agent on %v\n"+
-			"key = %v\n"+
-			"key is set = %v\n"+
-			"poll interval = %v\n"+
-			"poll interval is set = %v\n"+
-			"report interval = %v\n"+
-			"report interval is set = %v\n"+
-			"crypto key = %v\n"+
-			"crypto key is set = %v\n"+
-			"config file path = %v\n"+
-			"config file path is set = %v\n"+
-			"rate limit = %v\n"+
-			"rate limit is set = %v\n",
-		&cfg.Address,
-		cfg.Key,
-		cfg.KeyIsSet,
-		cfg.PollInterval,
-		cfg.PollIntervalIsSet,
-		cfg.ReportInterval,
-		cfg.ReportIntervalIsSet,
-		cfg.CryptoKey,
-		cfg.CryptoKeyIsSet,
-		cfg.ConfigFilePath,
-		cfg.ConfigFilePathIsSet,
-		cfg.RateLimit,
-		cfg.RateLimitIsSet,
-	)
-
-	// Create tickers for polling and sending metrics
-	pollTicker := time.NewTicker(time.Duration(cfg.PollInterval) * time.Second)
-	defer pollTicker.Stop()
-	pollUtilTicker := time.NewTicker(time.Duration(cfg.PollInterval) * time.Second)
-	defer pollUtilTicker.Stop()
-	sendTicker := time.NewTicker(time.Duration(cfg.ReportInterval) * time.Second)
-	defer sendTicker.Stop()
+	sugar.Infof("Running agent with configuration:\n%s", settings.Format(cfg.Settings()))
+
+	// Tick channels are closed on shutdown so that the loops consuming them can finish
+	pollCtx, stopPolling := context.WithCancel(context.Background())
+	defer stopPolling()
+	sendCtx, stopSending := context.WithCancel(context.Background())
+	defer stopSending()
+	pollInterval := func() time.Duration { return agent.Config().PollInterval }
+	reportInterval := func() time.Duration { return agent.Config().ReportInterval }
+	pollTicks := tick(pollCtx, pollInterval)
+	pollUtilTicks := tick(pollCtx, pollInterval)
+	pollCollectorsTicks := tick(pollCtx, pollInterval)
+	sendTicks := tick(sendCtx, reportInterval)
 
 	pollMetricsStopped := make(chan struct{})
 	pollUtilMetricsStopped := make(chan struct{})
+	pollCollectorsStopped := make(chan struct{})
 	sendMetricsStopped := make(chan struct{})
 
 	// Start goroutines for polling and sending metrics
-	go agent.PollMetrics(pollTicker.C, pollMetricsStopped)
-	go agent.PollUtilMetrics(pollUtilTicker.C, pollUtilMetricsStopped)
-	go agent.SendMetrics(ctx, sendTicker.C, sendMetricsStopped)
+	go agent.PollMetrics(pollTicks, pollMetricsStopped)
+	go agent.PollUtilMetrics(pollUtilTicks, pollUtilMetricsStopped)
+	go agent.PollCollectors(pollCollectorsTicks, pollCollectorsStopped)
+	if cfg.Mode == config.ModePull {
+		close(sendMetricsStopped)
+	} else {
+		go agent.SendMetrics(ctx, sendTicks, sendMetricsStopped)
+	}
 
 	sigs := make(chan os.Signal, 1)
-
 	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
-	<-sigs
+	for reloading := true; reloading; {
+		select {
+		case <-hup:
+			reload(agent, level, sugar)
+		case <-sigs:
+			reloading = false
+		}
+	}
 	sugar.Info("Received shutdown signal")
-	pollTicker.Stop()
-	pollUtilTicker.Stop()
-	sendTicker.Stop()
-
+	stopIngesting()
+	<-ingestStopped
+	<-exposeStopped
+	stopPolling()
 	<-pollMetricsStopped
 	<-pollUtilMetricsStopped
+	<-pollCollectorsStopped
+	if commands != nil {
+		// Commands still running end within their timeouts, their metrics go with the final send
+		commands.Wait()
+	}
+
+	// Give the final send cycle up to the shutdown timeout before abandoning in-flight requests
+	drainTimer := time.AfterFunc(agent.Config().ShutdownTimeout, stopServices)
+	defer drainTimer.Stop()
+	stopSending()
 	<-sendMetricsStopped
+	sugar.Info("Agent stopped")
+}
+
+// reload loads the configuration again and applies it to the running agent.
+// The current configuration is kept if the new one is invalid.
+func reload(agent *service.Agent, level zap.AtomicLevel, logger *zap.SugaredLogger) {
+	logger.Info("Received SIGHUP, reloading configuration")
+	next, err := config.GetConfigs()
+	if err != nil {
+		logger.Errorf("configuration reload failed, keeping the current configuration: %v", err)
+		return
+	}
+	applied, ignored := agent.Config().Reload(next)
+	for _, name := range ignored {
+		logger.Warnf("%v cannot be changed without a restart, keeping the current value", name)
+	}
+	if err := level.UnmarshalText([]byte(applied.LogLevel)); err != nil {
+		logger.Errorf("log level: %v", err)
+	}
+	agent.UpdateConfig(&applied)
+	logger.Info("Configuration reloaded")
+}
+
+// startIngestion starts the configured listeners for metrics pushed by local applications.
+// The listeners are bound before it returns; done is closed once they have stopped after ctx is done.
+func startIngestion(ctx context.Context, cfg config.AgentConfig, receiver *ingest.Receiver, logger *zap.SugaredLogger, done chan struct{}) error {
+	var wg sync.WaitGroup
+	if cfg.IngestHTTPAddress != "" {
+		l, err := net.Listen("tcp", cfg.IngestHTTPAddress)
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := receiver.Serve(ctx, l); err != nil {
+				logger.Error("ingest HTTP listener", err)
+			}
+		}()
+		logger.Infof("Receiving metrics over HTTP on %v", l.Addr())
+	}
+	if cfg.IngestStatsDAddress != "" {
+		conn, err := net.ListenPacket("udp", cfg.IngestStatsDAddress)
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := receiver.ServeStatsD(ctx, conn); err != nil {
+				logger.Error("ingest StatsD listener", err)
+			}
+		}()
+		logger.Infof("Receiving StatsD metrics over UDP on %v", conn.LocalAddr())
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return nil
+}
+
+// startExposing starts serving the metrics for the server to scrape on address.
+// The listener is bound before it returns; done is closed once it has stopped after ctx is done.
+func startExposing(ctx context.Context, address string, agent *service.Agent, logger *zap.SugaredLogger, done chan struct{}) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer close(done)
+		if err := agent.Expose(ctx, l); err != nil {
+			logger.Error("expose listener", err)
+		}
+	}()
+	logger.Infof("Exposing metrics for scraping on http://%v/metrics", l.Addr())
+	return nil
+}
+
+func logOptions(cfg config.AgentConfig) logging.Options {
+	return logging.Options{
+		Level:      cfg.LogLevel,
+		Format:     cfg.LogFormat,
+		File:       cfg.LogFile,
+		MaxSize:    cfg.LogMaxSize,
+		MaxBackups: cfg.LogMaxBackups,
+		Sampling:   cfg.LogSampling,
+	}
+}
+
+// tick sends the current time to the returned channel every interval until ctx is done,
+// after which the channel is closed. The interval is checked after every tick, so
+// a changed interval takes effect from the next period.
+func tick(ctx context.Context, interval func() time.Duration) <-chan time.Time {
+	ch := make(chan time.Time)
+	go func() {
+		defer close(ch)
+		current := interval()
+		ticker := time.NewTicker(current)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-ticker.C:
+				if next := interval(); next != current {
+					current = next
+					ticker.Reset(current)
+				}
+				select {
+				case ch <- t:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch
 }
@@ -5,19 +5,30 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/mrkovshik/yametrics/api"
+	"github.com/mrkovshik/yametrics/api/graphite"
 	"github.com/mrkovshik/yametrics/api/rest"
+	"github.com/mrkovshik/yametrics/api/statsd"
+	"github.com/mrkovshik/yametrics/internal/federation"
+	logging "github.com/mrkovshik/yametrics/internal/logger"
+	"github.com/mrkovshik/yametrics/internal/replication"
+	"github.com/mrkovshik/yametrics/internal/scrape"
 	"github.com/mrkovshik/yametrics/internal/storage"
+	"github.com/mrkovshik/yametrics/internal/telemetry"
+	"github.com/mrkovshik/yametrics/internal/tracing"
 	"github.com/mrkovshik/yametrics/internal/util/retriable"
 	"go.uber.org/zap"
 
 	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/config/settings"
 	service "github.com/mrkovshik/yametrics/internal/service/server"
 )
 
@@ -36,21 +47,31 @@ func main() {
 		buildDate = "N/A"
 	}
 	fmt.Printf("Build version: %s\nBuild date: %s\nBuild commit: %s\n", buildVersion, buildDate, buildCommit)
+	// Deferred first, so that the exit code is set once the other deferred calls have run.
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
 	var metricService *service.MetricService
-	logger, err := zap.NewDevelopment()
+	cfg, err := config.GetConfigs()
 	if err != nil {
-		logger.Fatal("zap.NewDevelopment",
-			zap.Error(err))
+		log.Fatalf("config.GetConfigs: %v", err)
 	}
-	defer logger.Sync() //nolint:all
-	sugar := logger.Sugar()
-
-	cfg, err := config.GetConfigs()
+	if cfg.PrintConfig {
+		fmt.Print(settings.Format(cfg.Settings()))
+		return
+	}
+	logger, level, err := logging.New(logOptions(cfg))
 	if err != nil {
-		sugar.Fatal("cfg.GetConfigs", err)
+		log.Fatalf("logging.New: %v", err)
 	}
+	defer logger.Sync() //nolint:all
+	sugar := logger.Sugar()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	reg := telemetry.NewRegistry()
 	var db *sql.DB
 	if cfg.DBEnable {
 		db, err = sql.Open("postgres", cfg.DBAddress)
@@ -75,13 +96,24 @@ func main() {
 		}
 
 		defer db.Close() //nolint:all
-		dbStorage := storage.NewPostgresStorage(db)
-		metricService = service.NewMetricService(dbStorage, &cfg, sugar)
+		dbStorage := storage.NewInstrumentedStorage(storage.NewPostgresStorage(db), "postgres", reg)
+		metricService = service.NewMetricService(dbStorage, &cfg, sugar).WithTelemetry(reg)
 	} else {
-		metricStorage := storage.NewInMemoryStorage()
-		metricService = service.NewMetricService(metricStorage, &cfg, sugar)
+		metricStorage := storage.NewInstrumentedStorage(storage.NewInMemoryStorage(), "memory", reg)
+		metricService = service.NewMetricService(metricStorage, &cfg, sugar).WithTelemetry(reg)
+	}
+	apiService := rest.NewServer(metricService, &cfg, sugar).WithTelemetry(reg).ConfigureRouter()
+	if cfg.ReplicaEnable {
+		replica := replication.NewReplica(metricService, sugar)
+		metricService.WithReplication(replica)
+		apiService.WithReplica(replica)
+		sugar.Info("Serving as a read-only replica until promoted")
+	}
+	if cfg.OTLPEndpoint != "" {
+		tracer := tracing.NewExporter(cfg.OTLPEndpoint, "yametrics-server", sugar)
+		defer tracer.Shutdown(context.Background()) //nolint:all
+		apiService.WithTracer(tracer)
 	}
-	apiService := rest.NewServer(metricService, &cfg, sugar).ConfigureRouter()
 
 	if cfg.RestoreEnable {
 		if err := metricService.RestoreMetrics(ctx); err != nil {
@@ -89,25 +121,181 @@ func main() {
 		}
 	}
 
+	listenCtx, stopListening := context.WithCancel(ctx)
+	defer stopListening()
+	listenersStopped := make(chan struct{})
+	if err := startListeners(listenCtx, cfg, apiService, metricService, sugar, listenersStopped); err != nil {
+		sugar.Fatal("startListeners", err)
+	}
+
+	storeCtx, stopStore := context.WithCancel(ctx)
+	defer stopStore()
+	storeDone := make(chan struct{})
 	if cfg.StoreEnable && !cfg.SyncStoreEnable {
-		storeTicker := time.NewTicker(time.Duration(cfg.StoreInterval) * time.Second)
 		go func() {
-			for range storeTicker.C {
-				if err := metricService.StoreMetrics(ctx); err != nil {
-					sugar.Fatal("StoreMetrics", err)
+			defer close(storeDone)
+			storeInterval := cfg.StoreInterval
+			storeTicker := time.NewTicker(storeInterval)
+			defer storeTicker.Stop()
+			for {
+				select {
+				case <-storeCtx.Done():
+					return
+				case <-storeTicker.C:
+					// Pick up an interval changed by a configuration reload
+					if next := apiService.Config().StoreInterval; next != storeInterval {
+						storeInterval = next
+						storeTicker.Reset(storeInterval)
+					}
+					if err := metricService.StoreMetrics(ctx); err != nil {
+						sugar.Error("StoreMetrics", err)
+					}
 				}
 			}
 		}()
+	} else {
+		close(storeDone)
 	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reload(apiService, metricService, level, sugar)
+		}
+	}()
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGINT)
 
-	run(stop, apiService)
-	if err := metricService.StoreMetrics(context.Background()); err != nil {
-		sugar.Fatal("StoreMetrics", err)
+	if err := run(stop, apiService); err != nil {
+		sugar.Error("RunServer", err)
+	}
+	sugar.Info("server stopped, flushing metrics")
+	stopListening()
+	<-listenersStopped
+
+	// Wait for a snapshot in progress before taking the final one.
+	stopStore()
+	<-storeDone
+	if cfg.StoreEnable {
+		if err := metricService.StoreMetrics(context.Background()); err != nil {
+			sugar.Error("StoreMetrics", err)
+			exitCode = 1
+		}
+	}
+}
+
+// startListeners starts the configured listeners for metrics sent in third-party protocols, the Graphite forwarder,
+// the federation, the scraping of agents and the streaming to replicas.
+// The listeners are bound before it returns; done is closed once they have stopped after ctx is done.
+func startListeners(ctx context.Context, cfg config.ServerConfig, srv *rest.Server, metricService *service.MetricService, logger *zap.SugaredLogger, done chan struct{}) error {
+	var wg sync.WaitGroup
+	// The signing key can be reloaded.
+	key := func() string { return srv.Config().Key }
+	// Replication starts first, so that no update is applied before it is streamed.
+	if replicas := cfg.ReplicaURLs(); len(replicas) > 0 {
+		primary := replication.NewPrimary(metricService, replicas, key, logger)
+		metricService.WithReplication(primary)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			primary.Run(ctx)
+		}()
+		logger.Infof("Streaming updates to %d replicas", len(replicas))
+	}
+	if cfg.StatsDAddress != "" {
+		conn, err := net.ListenPacket("udp", cfg.StatsDAddress)
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := statsd.NewServer(metricService, logger).Serve(ctx, conn); err != nil {
+				logger.Error("StatsD listener", err)
+			}
+		}()
+		logger.Infof("Receiving StatsD metrics over UDP on %v", conn.LocalAddr())
+	}
+	if cfg.GraphiteAddress != "" {
+		l, err := net.Listen("tcp", cfg.GraphiteAddress)
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := graphite.NewServer(metricService, logger).Serve(ctx, l); err != nil {
+				logger.Error("Graphite listener", err)
+			}
+		}()
+		logger.Infof("Receiving Graphite metrics over TCP on %v", l.Addr())
+	}
+	if cfg.GraphiteForwardAddress != "" {
+		forwarder := graphite.NewForwarder(metricService, cfg.GraphiteForwardAddress, cfg.GraphiteForwardPrefix, logger)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			forwarder.Run(ctx, cfg.GraphiteForwardInterval)
+		}()
+		logger.Infof("Forwarding metrics to Graphite at %v every %v", cfg.GraphiteForwardAddress, cfg.GraphiteForwardInterval)
+	}
+	if upstreams := cfg.Upstreams(); len(upstreams) > 0 {
+		federator := federation.NewFederator(metricService, upstreams, logger)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			federator.Run(ctx, cfg.FederationInterval)
+		}()
+		logger.Infof("Pulling metrics from %d upstream servers every %v", len(upstreams), cfg.FederationInterval)
+	}
+	if targets := cfg.Targets(); len(targets) > 0 {
+		scraper := scrape.NewScraper(metricService, targets, key, logger)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scraper.Run(ctx)
+		}()
+		logger.Infof("Scraping metrics from %d agents", len(targets))
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return nil
+}
+
+// reload loads the configuration again and applies it to the running server and service.
+// The current configuration is kept if the new one is invalid.
+func reload(srv *rest.Server, metricService *service.MetricService, level zap.AtomicLevel, logger *zap.SugaredLogger) {
+	logger.Info("Received SIGHUP, reloading configuration")
+	next, err := config.GetConfigs()
+	if err != nil {
+		logger.Errorf("configuration reload failed, keeping the current configuration: %v", err)
+		return
+	}
+	applied, ignored := srv.Config().Reload(next)
+	for _, name := range ignored {
+		logger.Warnf("%v cannot be changed without a restart, keeping the current value", name)
+	}
+	if err := level.UnmarshalText([]byte(applied.LogLevel)); err != nil {
+		logger.Errorf("log level: %v", err)
+	}
+	srv.UpdateConfig(&applied)
+	metricService.UpdateConfig(&applied)
+	logger.Info("Configuration reloaded")
+}
+
+func logOptions(cfg config.ServerConfig) logging.Options {
+	return logging.Options{
+		Level:      cfg.LogLevel,
+		Format:     cfg.LogFormat,
+		File:       cfg.LogFile,
+		MaxSize:    cfg.LogMaxSize,
+		MaxBackups: cfg.LogMaxBackups,
+		Sampling:   cfg.LogSampling,
 	}
 }
 
-func run(stop chan os.Signal, srv api.Server) {
-	log.Fatal(srv.RunServer(stop))
+func run(stop chan os.Signal, srv api.Server) error {
+	return srv.RunServer(stop)
 }
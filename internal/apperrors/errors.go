@@ -5,3 +5,6 @@ import "errors"
 
 // ErrInvalidRequestData is an error that indicates that the request data is invalid.
 var ErrInvalidRequestData = errors.New("invalid request data")
+
+// ErrReadOnly is an error that indicates that the server is a replica and does not accept updates.
+var ErrReadOnly = errors.New("read-only replica, send updates to the primary")
@@ -0,0 +1,175 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported content codings.
+const (
+	EncodingGzip     = "gzip"
+	EncodingDeflate  = "deflate"
+	EncodingZstd     = "zstd"
+	EncodingIdentity = "identity"
+)
+
+// Codec compresses and decompresses data in a single content coding.
+type Codec interface {
+	// Name returns the content coding name used in Content-Encoding and Accept-Encoding headers.
+	Name() string
+	// NewWriter returns a writer compressing data written to it into w.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	// NewReader returns a reader decompressing data read from r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Codec{}
+)
+
+func init() {
+	Register(gzipCodec{})
+	Register(deflateCodec{})
+	Register(zstdCodec{})
+}
+
+// Register adds a codec to the registry, replacing any codec with the same name.
+func Register(c Codec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[c.Name()] = c
+}
+
+// Lookup returns the registered codec with the given name.
+func Lookup(name string) (Codec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	c, ok := registry[strings.ToLower(strings.TrimSpace(name))]
+	return c, ok
+}
+
+// acceptedEncoding is a single entry of the Accept-Encoding header.
+type acceptedEncoding struct {
+	name string
+	q    float64
+}
+
+// ParseAcceptEncoding parses Accept-Encoding header values and returns the accepted
+// codings ordered by preference. Codings with q=0 are omitted.
+func ParseAcceptEncoding(values []string) []string {
+	accepted, _ := parseAcceptEncoding(values)
+	return accepted
+}
+
+// parseAcceptEncoding returns the accepted codings ordered by preference and the codings refused with q=0.
+func parseAcceptEncoding(values []string) ([]string, map[string]bool) {
+	var accepted []acceptedEncoding
+	refused := make(map[string]bool)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			params := strings.Split(part, ";")
+			name := strings.ToLower(strings.TrimSpace(params[0]))
+			if name == "" {
+				continue
+			}
+			q := 1.0
+			for _, param := range params[1:] {
+				key, val, found := strings.Cut(strings.TrimSpace(param), "=")
+				if !found || strings.TrimSpace(key) != "q" {
+					continue
+				}
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+				if err != nil {
+					parsed = 0
+				}
+				q = parsed
+			}
+			if q <= 0 {
+				refused[name] = true
+				continue
+			}
+			accepted = append(accepted, acceptedEncoding{name: name, q: q})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].q > accepted[j].q
+	})
+	names := make([]string, 0, len(accepted))
+	for _, a := range accepted {
+		names = append(names, a.name)
+	}
+	return names, refused
+}
+
+// wildcardEncodings are the codings a wildcard picks from, in order.
+var wildcardEncodings = []string{EncodingGzip, EncodingDeflate, EncodingZstd}
+
+// Negotiate picks the most preferred registered codec accepted by the client.
+// A wildcard picks gzip, or deflate or zstd if gzip is refused with q=0.
+// It returns false if the response should not be compressed.
+func Negotiate(acceptValues []string) (Codec, bool) {
+	accepted, refused := parseAcceptEncoding(acceptValues)
+	for _, name := range accepted {
+		if name != "*" {
+			if c, ok := Lookup(name); ok {
+				return c, true
+			}
+			continue
+		}
+		for _, name := range wildcardEncodings {
+			if c, ok := Lookup(name); ok && !refused[name] {
+				return c, true
+			}
+		}
+	}
+	return nil, false
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return EncodingGzip }
+
+func (gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// deflateCodec implements the HTTP "deflate" coding, which is the zlib format.
+type deflateCodec struct{}
+
+func (deflateCodec) Name() string { return EncodingDeflate }
+
+func (deflateCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriter(w), nil
+}
+
+func (deflateCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string { return EncodingZstd }
+
+func (zstdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
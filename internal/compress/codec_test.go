@@ -0,0 +1,112 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string
+	}{
+		{"empty", nil, []string{}},
+		{"single", []string{"gzip"}, []string{"gzip"}},
+		{"order kept for equal q", []string{"gzip, deflate"}, []string{"gzip", "deflate"}},
+		{"q values", []string{"gzip;q=0.5, zstd, deflate;q=0.8"}, []string{"zstd", "deflate", "gzip"}},
+		{"q zero excluded", []string{"gzip;q=0", "deflate"}, []string{"deflate"}},
+		{"spaces and case", []string{" GZIP ; q=0.3 ,br; q=0.9"}, []string{"br", "gzip"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, ParseAcceptEncoding(tt.values))
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	c, ok := Negotiate([]string{"br, zstd;q=0.9, gzip;q=0.1"})
+	require.True(t, ok)
+	require.Equal(t, EncodingZstd, c.Name())
+
+	c, ok = Negotiate([]string{"*"})
+	require.True(t, ok)
+	require.Equal(t, EncodingGzip, c.Name())
+
+	c, ok = Negotiate([]string{"*, gzip;q=0"})
+	require.True(t, ok)
+	require.Equal(t, EncodingDeflate, c.Name(), "a wildcard does not pick a refused coding")
+
+	_, ok = Negotiate([]string{"*, gzip;q=0, deflate;q=0, zstd;q=0"})
+	require.False(t, ok)
+
+	_, ok = Negotiate([]string{"br"})
+	require.False(t, ok)
+}
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("yametrics ", 100))
+	for _, name := range []string{EncodingGzip, EncodingDeflate, EncodingZstd} {
+		t.Run(name, func(t *testing.T) {
+			c, ok := Lookup(name)
+			require.True(t, ok)
+			var buf bytes.Buffer
+			w, err := c.NewWriter(&buf)
+			require.NoError(t, err)
+			_, err = w.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			require.Less(t, buf.Len(), len(data))
+
+			r, err := c.NewReader(&buf)
+			require.NoError(t, err)
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			require.Equal(t, data, got)
+		})
+	}
+}
+
+func TestWriter_MinSize(t *testing.T) {
+	codec, _ := Lookup(EncodingGzip)
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantEnc  string
+		wantCode int
+	}{
+		{"small", http.StatusOK, "tiny", "", http.StatusOK},
+		{"large", http.StatusOK, strings.Repeat("a", 100), EncodingGzip, http.StatusOK},
+		{"large error", http.StatusBadRequest, strings.Repeat("a", 100), "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			w := NewWriter(rr, codec, 10)
+			w.WriteHeader(tt.status)
+			_, err := w.Write([]byte(tt.body))
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			require.Equal(t, tt.wantCode, rr.Code)
+			require.Equal(t, tt.wantEnc, rr.Header().Get("Content-Encoding"))
+			body := rr.Body.Bytes()
+			if tt.wantEnc != "" {
+				r, err := codec.NewReader(bytes.NewReader(body))
+				require.NoError(t, err)
+				body, err = io.ReadAll(r)
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.body, string(body))
+		})
+	}
+}
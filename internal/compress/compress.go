@@ -2,80 +2,91 @@
 package compress
 
 import (
-	"compress/gzip"
 	"io"
 	"net/http"
 )
 
-// GzipWriter wraps an http.ResponseWriter to provide gzip compression.
-type GzipWriter struct {
-	w  http.ResponseWriter
-	zw *gzip.Writer
+// Writer wraps an http.ResponseWriter to compress successful responses with the given codec.
+// Responses smaller than the minimum size are sent uncompressed.
+type Writer struct {
+	w       http.ResponseWriter
+	codec   Codec
+	minSize int
+	status  int
+	buf     []byte
+	cw      io.WriteCloser
+	flushed bool
 }
 
-// NewGzipWriter creates a new GzipWriter that wraps the provided http.ResponseWriter.
-func NewGzipWriter(w http.ResponseWriter) *GzipWriter {
-	return &GzipWriter{
-		w:  w,
-		zw: gzip.NewWriter(w),
+// NewWriter creates a new Writer compressing responses of at least minSize bytes with codec.
+func NewWriter(w http.ResponseWriter, codec Codec, minSize int) *Writer {
+	return &Writer{
+		w:       w,
+		codec:   codec,
+		minSize: minSize,
+		status:  http.StatusOK,
 	}
 }
 
 // Header returns the header map that will be sent by WriteHeader.
-func (c *GzipWriter) Header() http.Header {
+func (c *Writer) Header() http.Header {
 	return c.w.Header()
 }
 
-// Write compresses the data and writes it to the wrapped http.ResponseWriter.
-func (c *GzipWriter) Write(p []byte) (int, error) {
-	return c.zw.Write(p)
-}
-
-// WriteHeader sends an HTTP response header with the provided status code.
-// If the status code indicates a successful response (< 300), it sets the
-// "Content-Encoding" header to "gzip".
-func (c *GzipWriter) WriteHeader(statusCode int) {
-	if statusCode < 300 {
-		c.w.Header().Set("Content-Encoding", "gzip")
+// WriteHeader records the status code. It is sent once it is known whether the body is compressed.
+func (c *Writer) WriteHeader(statusCode int) {
+	if !c.flushed {
+		c.status = statusCode
 	}
-	c.w.WriteHeader(statusCode)
-}
-
-// Close closes the gzip.Writer, flushing any unwritten data to the underlying
-// http.ResponseWriter.
-func (c *GzipWriter) Close() error {
-	return c.zw.Close()
 }
 
-// GzipReader wraps an io.ReadCloser to provide gzip decompression.
-type GzipReader struct {
-	r  io.ReadCloser
-	zr *gzip.Reader
-}
-
-// NewGzipReader creates a new GzipReader that wraps the provided io.ReadCloser.
-// It returns an error if the gzip reader could not be created.
-func NewGzipReader(r io.ReadCloser) (*GzipReader, error) {
-	zr, err := gzip.NewReader(r)
-	if err != nil {
-		return nil, err
+// Write buffers the data until the minimum size is reached, then compresses it
+// and writes it to the wrapped http.ResponseWriter.
+func (c *Writer) Write(p []byte) (int, error) {
+	if c.flushed {
+		if c.cw != nil {
+			return c.cw.Write(p)
+		}
+		return c.w.Write(p)
 	}
-
-	return &GzipReader{
-		r:  r,
-		zr: zr,
-	}, nil
+	c.buf = append(c.buf, p...)
+	if len(c.buf) < c.minSize {
+		return len(p), nil
+	}
+	if err := c.flush(c.status < 300); err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
 
-// Read reads and decompresses data from the wrapped io.ReadCloser.
-func (c GzipReader) Read(p []byte) (n int, err error) {
-	return c.zr.Read(p)
+// Close sends the buffered response uncompressed if it never reached the minimum size
+// and flushes any unwritten compressed data.
+func (c *Writer) Close() error {
+	if !c.flushed {
+		return c.flush(false)
+	}
+	if c.cw != nil {
+		return c.cw.Close()
+	}
+	return nil
 }
 
-// Close closes both the underlying io.ReadCloser and the gzip.Reader.
-func (c *GzipReader) Close() error {
-	if err := c.r.Close(); err != nil {
+func (c *Writer) flush(compress bool) error {
+	c.flushed = true
+	c.w.Header().Add("Vary", "Accept-Encoding")
+	if !compress {
+		c.w.WriteHeader(c.status)
+		_, err := c.w.Write(c.buf)
+		return err
+	}
+	cw, err := c.codec.NewWriter(c.w)
+	if err != nil {
 		return err
 	}
-	return c.zr.Close()
+	c.cw = cw
+	c.w.Header().Set("Content-Encoding", c.codec.Name())
+	c.w.Header().Del("Content-Length")
+	c.w.WriteHeader(c.status)
+	_, err = c.cw.Write(c.buf)
+	return err
 }
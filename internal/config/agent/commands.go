@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mrkovshik/yametrics/internal/config/source"
+)
+
+// Command is a command the exec collector runs to collect metrics from its output.
+type Command struct {
+	Name     string        // Value of the command label of the metrics about the runs and name used in the settings of individual commands
+	Command  string        // Command line run by the shell
+	Interval time.Duration // Interval between runs
+	Timeout  time.Duration // Time the command may run before it is killed
+}
+
+// CommandList returns the commands the exec collector runs. Commands without a setting of their
+// own use the interval and timeout of the agent.
+func (c AgentConfig) CommandList() []Command {
+	commands, _ := parseCommands(c)
+	return commands
+}
+
+// commandEntry is a command in a structured list.
+type commandEntry struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+}
+
+func parseCommands(c AgentConfig) ([]Command, error) {
+	var commands []Command
+	index := make(map[string]int)
+	entries, err := source.Entries(c.Commands, func(cmd commandEntry) string { return cmd.Name + "=" + cmd.Command })
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name, command, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || strings.TrimSpace(command) == "" {
+			return nil, fmt.Errorf("invalid command %q, use name=command", entry)
+		}
+		if _, ok := index[name]; ok {
+			return nil, fmt.Errorf("duplicate command %q", name)
+		}
+		index[name] = len(commands)
+		commands = append(commands, Command{Name: name, Command: command, Interval: c.CommandInterval, Timeout: c.CommandTimeout})
+	}
+	for _, override := range []struct {
+		list string
+		set  func(cmd *Command, d time.Duration)
+	}{
+		{c.CommandIntervals, func(cmd *Command, d time.Duration) { cmd.Interval = d }},
+		{c.CommandTimeouts, func(cmd *Command, d time.Duration) { cmd.Timeout = d }},
+	} {
+		entries, err := source.Pairs(override.list)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name, value, ok := strings.Cut(entry, "=")
+			if !ok || name == "" {
+				return nil, fmt.Errorf("invalid setting of a command %q, use name=duration", entry)
+			}
+			i, ok := index[name]
+			if !ok {
+				return nil, fmt.Errorf("setting for unknown command %q", name)
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("command %q: invalid duration %q, need a positive duration", name, value)
+			}
+			override.set(&commands[i], d)
+		}
+	}
+	return commands, nil
+}
+
+func validateCommands(list string) error {
+	_, err := parseCommands(AgentConfig{Commands: list})
+	return err
+}
+
+// validateCommandSetting checks the settings of individual commands in one of the lists
+// against valid commands, invalid commands are reported on their own.
+func validateCommandSetting(c *AgentConfig, list *string) error {
+	if validateCommands(c.Commands) != nil {
+		return nil
+	}
+	only := AgentConfig{Commands: c.Commands}
+	switch list {
+	case &c.CommandIntervals:
+		only.CommandIntervals = *list
+	case &c.CommandTimeouts:
+		only.CommandTimeouts = *list
+	}
+	_, err := parseCommands(only)
+	return err
+}
@@ -1,57 +1,146 @@
 // Package config provides configuration handling for the agent, allowing
-// configurations to be set via environment variables or command-line flags.
+// configurations to be set via environment variables, command-line flags or a JSON, YAML or TOML file.
 package config
 
 import (
 	"errors"
-	"flag"
-	"log"
 	"os"
+	"time"
 
 	"github.com/caarlos0/env/v6"
-	"github.com/eschao/config"
-	"github.com/knadh/koanf/parsers/json"
-	"github.com/knadh/koanf/providers/file"
-	"github.com/knadh/koanf/v2"
 	"github.com/mrkovshik/yametrics/internal/config/flags"
-	"github.com/mrkovshik/yametrics/internal/util"
+	"github.com/mrkovshik/yametrics/internal/config/settings"
+	"github.com/mrkovshik/yametrics/internal/config/source"
 )
 
 const (
-	defaultKey            = ""
-	defaultConfigFilePath = ""
-	defaultAddress        = "localhost:8080"
-	defaultPollInterval   = 2
-	defaultReportInterval = 10
-	defaultRateLimit      = 1
-	defaultCryptoKey      = "./public_key.pem"
+	defaultKey                     = ""
+	defaultConfigFilePath          = ""
+	defaultAddress                 = "localhost:8080"
+	defaultPollInterval            = 2 * time.Second
+	defaultReportInterval          = 10 * time.Second
+	defaultRateLimit               = 1
+	defaultCryptoKey               = "./public_key.pem"
+	defaultCompression             = "gzip"
+	defaultOTLPEndpoint            = ""
+	defaultShutdownTimeout         = 10 * time.Second
+	defaultLogLevel                = "debug"
+	defaultLogFormat               = "console"
+	defaultLogFile                 = ""
+	defaultLogMaxSize              = 100
+	defaultLogMaxBackups           = 3
+	defaultLogSampling             = 0
+	defaultIngestHTTPAddress       = ""
+	defaultIngestStatsDAddress     = ""
+	defaultMode                    = ModePush
+	defaultExposeAddress           = ""
+	defaultDestinations            = ""
+	defaultDestinationKeys         = ""
+	defaultDestinationCryptoKeys   = ""
+	defaultDestinationCompressions = ""
+	defaultDestinationRateLimits   = ""
+	defaultRetryAttempts           = 3
+	defaultRetryInitialInterval    = time.Second
+	defaultRetryMaxInterval        = 30 * time.Second
+	defaultBreakerThreshold        = 5
+	defaultBreakerCooldown         = 30 * time.Second
+	defaultProcesses               = ""
+	defaultCommands                = ""
+	defaultCommandInterval         = time.Minute
+	defaultCommandTimeout          = 10 * time.Second
+	defaultCommandIntervals        = ""
+	defaultCommandTimeouts         = ""
 )
 
-var k = koanf.New(".")
+// Modes of sending metrics to the server.
+const (
+	ModePush = "push" // The agent sends metrics to the server
+	ModePull = "pull" // The server scrapes metrics from the agent
+	ModeBoth = "both"
+)
 
 // AgentConfig holds the configuration settings for the agent.
 type AgentConfig struct {
-	Key                  string `env:"KEY" json:"key"`
-	KeyIsSet             bool   `json:"-"`
-	Address              string `env:"ADDRESS" json:"address"`
-	AddressIsSet         bool   `json:"-"`
-	ReportInterval       int    `env:"REPORT_INTERVAL"`
-	ReportIntervalString string `json:"report_interval"`
-	ReportIntervalIsSet  bool   `json:"-"`
-	PollInterval         int    `env:"POLL_INTERVAL"`
-	PollIntervalString   string `json:"poll_interval"`
-	PollIntervalIsSet    bool   `json:"-"`
-	RateLimit            int    `env:"RATE_LIMIT" json:"rate_limit"`
-	RateLimitIsSet       bool   `json:"-"`
-	CryptoKey            string `env:"CRYPTO_KEY" json:"crypto_key"`
-	CryptoKeyIsSet       bool   `json:"-"`
-	ConfigFilePath       string `env:"CONFIG" json:"config_file_path"`
-	ConfigFilePathIsSet  bool   `json:"-"`
+	Key                          string           `env:"KEY" json:"key" secret:"true"`
+	KeyIsSet                     bool             `json:"-"`
+	Address                      string           `env:"ADDRESS" json:"address"`
+	AddressIsSet                 bool             `json:"-"`
+	ReportInterval               time.Duration    `env:"REPORT_INTERVAL" json:"report_interval"`
+	ReportIntervalIsSet          bool             `json:"-"`
+	PollInterval                 time.Duration    `env:"POLL_INTERVAL" json:"poll_interval"`
+	PollIntervalIsSet            bool             `json:"-"`
+	RateLimit                    int              `env:"RATE_LIMIT" json:"rate_limit"`
+	RateLimitIsSet               bool             `json:"-"`
+	CryptoKey                    string           `env:"CRYPTO_KEY" json:"crypto_key"`
+	CryptoKeyIsSet               bool             `json:"-"`
+	ConfigFilePath               string           `env:"CONFIG" json:"config"`
+	ConfigFilePathIsSet          bool             `json:"-"`
+	Compression                  string           `env:"COMPRESSION" json:"compression"`
+	CompressionIsSet             bool             `json:"-"`
+	OTLPEndpoint                 string           `env:"OTLP_ENDPOINT" json:"otlp_endpoint"`
+	OTLPEndpointIsSet            bool             `json:"-"`
+	ShutdownTimeout              time.Duration    `env:"SHUTDOWN_TIMEOUT" json:"shutdown_timeout"`
+	ShutdownTimeoutIsSet         bool             `json:"-"`
+	LogLevel                     string           `env:"LOG_LEVEL" json:"log_level"`
+	LogLevelIsSet                bool             `json:"-"`
+	LogFormat                    string           `env:"LOG_FORMAT" json:"log_format"`
+	LogFormatIsSet               bool             `json:"-"`
+	LogFile                      string           `env:"LOG_FILE" json:"log_file"`
+	LogFileIsSet                 bool             `json:"-"`
+	LogMaxSize                   int              `env:"LOG_MAX_SIZE" json:"log_max_size"`
+	LogMaxSizeIsSet              bool             `json:"-"`
+	LogMaxBackups                int              `env:"LOG_MAX_BACKUPS" json:"log_max_backups"`
+	LogMaxBackupsIsSet           bool             `json:"-"`
+	LogSampling                  int              `env:"LOG_SAMPLING" json:"log_sampling"`
+	LogSamplingIsSet             bool             `json:"-"`
+	IngestHTTPAddress            string           `env:"INGEST_HTTP_ADDRESS" json:"ingest_http_address"`
+	IngestHTTPAddressIsSet       bool             `json:"-"`
+	IngestStatsDAddress          string           `env:"INGEST_STATSD_ADDRESS" json:"ingest_statsd_address"`
+	IngestStatsDAddressIsSet     bool             `json:"-"`
+	Mode                         string           `env:"MODE" json:"mode"`
+	ModeIsSet                    bool             `json:"-"`
+	ExposeAddress                string           `env:"EXPOSE_ADDRESS" json:"expose_address"`
+	ExposeAddressIsSet           bool             `json:"-"`
+	Destinations                 string           `env:"DESTINATIONS" json:"destinations"`
+	DestinationsIsSet            bool             `json:"-"`
+	DestinationKeys              string           `env:"DESTINATION_KEYS" json:"destination_keys" secret:"true"`
+	DestinationKeysIsSet         bool             `json:"-"`
+	DestinationCryptoKeys        string           `env:"DESTINATION_CRYPTO_KEYS" json:"destination_crypto_keys"`
+	DestinationCryptoKeysIsSet   bool             `json:"-"`
+	DestinationCompressions      string           `env:"DESTINATION_COMPRESSIONS" json:"destination_compressions"`
+	DestinationCompressionsIsSet bool             `json:"-"`
+	DestinationRateLimits        string           `env:"DESTINATION_RATE_LIMITS" json:"destination_rate_limits"`
+	DestinationRateLimitsIsSet   bool             `json:"-"`
+	RetryAttempts                int              `env:"RETRY_ATTEMPTS" json:"retry_attempts"`
+	RetryAttemptsIsSet           bool             `json:"-"`
+	RetryInitialInterval         time.Duration    `env:"RETRY_INITIAL_INTERVAL" json:"retry_initial_interval"`
+	RetryInitialIntervalIsSet    bool             `json:"-"`
+	RetryMaxInterval             time.Duration    `env:"RETRY_MAX_INTERVAL" json:"retry_max_interval"`
+	RetryMaxIntervalIsSet        bool             `json:"-"`
+	BreakerThreshold             int              `env:"BREAKER_THRESHOLD" json:"breaker_threshold"`
+	BreakerThresholdIsSet        bool             `json:"-"`
+	BreakerCooldown              time.Duration    `env:"BREAKER_COOLDOWN" json:"breaker_cooldown"`
+	BreakerCooldownIsSet         bool             `json:"-"`
+	Processes                    string           `env:"PROCESSES" json:"processes"`
+	ProcessesIsSet               bool             `json:"-"`
+	Commands                     string           `env:"COMMANDS" json:"commands"`
+	CommandsIsSet                bool             `json:"-"`
+	CommandInterval              time.Duration    `env:"COMMAND_INTERVAL" json:"command_interval"`
+	CommandIntervalIsSet         bool             `json:"-"`
+	CommandTimeout               time.Duration    `env:"COMMAND_TIMEOUT" json:"command_timeout"`
+	CommandTimeoutIsSet          bool             `json:"-"`
+	CommandIntervals             string           `env:"COMMAND_INTERVALS" json:"command_intervals"`
+	CommandIntervalsIsSet        bool             `json:"-"`
+	CommandTimeouts              string           `env:"COMMAND_TIMEOUTS" json:"command_timeouts"`
+	CommandTimeoutsIsSet         bool             `json:"-"`
+	PrintConfig                  bool             `json:"-"` // Print the effective configuration and exit
+	Sources                      settings.Sources `json:"-"` // Where the values of the settings come from
 }
 
 // AgentConfigBuilder is a builder for constructing an AgentConfig instance.
 type AgentConfigBuilder struct {
 	Config AgentConfig
+	Err    error // The first error encountered while building, the remaining steps are skipped
 }
 
 func (c *AgentConfig) SetDefaults() {
@@ -62,6 +151,35 @@ func (c *AgentConfig) SetDefaults() {
 	c.ReportInterval = defaultReportInterval
 	c.PollInterval = defaultPollInterval
 	c.ConfigFilePath = defaultConfigFilePath
+	c.Compression = defaultCompression
+	c.OTLPEndpoint = defaultOTLPEndpoint
+	c.ShutdownTimeout = defaultShutdownTimeout
+	c.LogLevel = defaultLogLevel
+	c.LogFormat = defaultLogFormat
+	c.LogFile = defaultLogFile
+	c.LogMaxSize = defaultLogMaxSize
+	c.LogMaxBackups = defaultLogMaxBackups
+	c.LogSampling = defaultLogSampling
+	c.IngestHTTPAddress = defaultIngestHTTPAddress
+	c.IngestStatsDAddress = defaultIngestStatsDAddress
+	c.Mode = defaultMode
+	c.ExposeAddress = defaultExposeAddress
+	c.Destinations = defaultDestinations
+	c.DestinationKeys = defaultDestinationKeys
+	c.DestinationCryptoKeys = defaultDestinationCryptoKeys
+	c.DestinationCompressions = defaultDestinationCompressions
+	c.DestinationRateLimits = defaultDestinationRateLimits
+	c.RetryAttempts = defaultRetryAttempts
+	c.RetryInitialInterval = defaultRetryInitialInterval
+	c.RetryMaxInterval = defaultRetryMaxInterval
+	c.BreakerThreshold = defaultBreakerThreshold
+	c.BreakerCooldown = defaultBreakerCooldown
+	c.Processes = defaultProcesses
+	c.Commands = defaultCommands
+	c.CommandInterval = defaultCommandInterval
+	c.CommandTimeout = defaultCommandTimeout
+	c.CommandIntervals = defaultCommandIntervals
+	c.CommandTimeouts = defaultCommandTimeouts
 }
 
 // WithKey sets the key in the AgentConfig.
@@ -86,14 +204,14 @@ func (c *AgentConfigBuilder) WithCryptoKey(path string) *AgentConfigBuilder {
 }
 
 // WithReportInterval sets the report interval in the AgentConfig.
-func (c *AgentConfigBuilder) WithReportInterval(reportInterval int) *AgentConfigBuilder {
+func (c *AgentConfigBuilder) WithReportInterval(reportInterval time.Duration) *AgentConfigBuilder {
 	c.Config.ReportInterval = reportInterval
 	c.Config.ReportIntervalIsSet = true
 	return c
 }
 
 // WithPollInterval sets the poll interval in the AgentConfig.
-func (c *AgentConfigBuilder) WithPollInterval(pollInterval int) *AgentConfigBuilder {
+func (c *AgentConfigBuilder) WithPollInterval(pollInterval time.Duration) *AgentConfigBuilder {
 	c.Config.PollInterval = pollInterval
 	c.Config.PollIntervalIsSet = true
 	return c
@@ -106,6 +224,209 @@ func (c *AgentConfigBuilder) WithRateLimit(rateLimit int) *AgentConfigBuilder {
 	return c
 }
 
+// WithCompression sets the request body compression codec in the AgentConfig.
+func (c *AgentConfigBuilder) WithCompression(encoding string) *AgentConfigBuilder {
+	c.Config.Compression = encoding
+	c.Config.CompressionIsSet = true
+	return c
+}
+
+// WithOTLPEndpoint sets the OTLP/HTTP traces endpoint in the AgentConfig.
+func (c *AgentConfigBuilder) WithOTLPEndpoint(endpoint string) *AgentConfigBuilder {
+	c.Config.OTLPEndpoint = endpoint
+	c.Config.OTLPEndpointIsSet = true
+	return c
+}
+
+// WithShutdownTimeout sets the time to finish in-flight work on shutdown in the AgentConfig.
+func (c *AgentConfigBuilder) WithShutdownTimeout(timeout time.Duration) *AgentConfigBuilder {
+	c.Config.ShutdownTimeout = timeout
+	c.Config.ShutdownTimeoutIsSet = true
+	return c
+}
+
+// WithLogLevel sets the minimal level of logged messages in the AgentConfig.
+func (c *AgentConfigBuilder) WithLogLevel(level string) *AgentConfigBuilder {
+	c.Config.LogLevel = level
+	c.Config.LogLevelIsSet = true
+	return c
+}
+
+// WithLogFormat sets the format of log entries in the AgentConfig.
+func (c *AgentConfigBuilder) WithLogFormat(format string) *AgentConfigBuilder {
+	c.Config.LogFormat = format
+	c.Config.LogFormatIsSet = true
+	return c
+}
+
+// WithLogFile sets the path to the log file in the AgentConfig.
+func (c *AgentConfigBuilder) WithLogFile(path string) *AgentConfigBuilder {
+	c.Config.LogFile = path
+	c.Config.LogFileIsSet = true
+	return c
+}
+
+// WithLogMaxSize sets the size at which the log file is rotated in the AgentConfig.
+func (c *AgentConfigBuilder) WithLogMaxSize(size int) *AgentConfigBuilder {
+	c.Config.LogMaxSize = size
+	c.Config.LogMaxSizeIsSet = true
+	return c
+}
+
+// WithLogMaxBackups sets the number of rotated log files to keep in the AgentConfig.
+func (c *AgentConfigBuilder) WithLogMaxBackups(backups int) *AgentConfigBuilder {
+	c.Config.LogMaxBackups = backups
+	c.Config.LogMaxBackupsIsSet = true
+	return c
+}
+
+// WithLogSampling sets the number of identical log entries per second logged before sampling starts in the AgentConfig.
+func (c *AgentConfigBuilder) WithLogSampling(n int) *AgentConfigBuilder {
+	c.Config.LogSampling = n
+	c.Config.LogSamplingIsSet = true
+	return c
+}
+
+// WithIngestHTTPAddress sets the address of the HTTP listener for pushed metrics in the AgentConfig.
+func (c *AgentConfigBuilder) WithIngestHTTPAddress(address string) *AgentConfigBuilder {
+	c.Config.IngestHTTPAddress = address
+	c.Config.IngestHTTPAddressIsSet = true
+	return c
+}
+
+// WithIngestStatsDAddress sets the address of the StatsD UDP listener for pushed metrics in the AgentConfig.
+func (c *AgentConfigBuilder) WithIngestStatsDAddress(address string) *AgentConfigBuilder {
+	c.Config.IngestStatsDAddress = address
+	c.Config.IngestStatsDAddressIsSet = true
+	return c
+}
+
+// WithMode sets whether metrics are pushed to the server, exposed for scraping or both in the AgentConfig.
+func (c *AgentConfigBuilder) WithMode(mode string) *AgentConfigBuilder {
+	c.Config.Mode = mode
+	c.Config.ModeIsSet = true
+	return c
+}
+
+// WithExposeAddress sets the address metrics are exposed on for scraping in the AgentConfig.
+func (c *AgentConfigBuilder) WithExposeAddress(address string) *AgentConfigBuilder {
+	c.Config.ExposeAddress = address
+	c.Config.ExposeAddressIsSet = true
+	return c
+}
+
+// WithDestinations sets the servers metrics are sent to in the AgentConfig.
+func (c *AgentConfigBuilder) WithDestinations(destinations string) *AgentConfigBuilder {
+	c.Config.Destinations = destinations
+	c.Config.DestinationsIsSet = true
+	return c
+}
+
+// WithDestinationKeys sets the signing keys of individual destinations in the AgentConfig.
+func (c *AgentConfigBuilder) WithDestinationKeys(keys string) *AgentConfigBuilder {
+	c.Config.DestinationKeys = keys
+	c.Config.DestinationKeysIsSet = true
+	return c
+}
+
+// WithDestinationCryptoKeys sets the public keys of individual destinations in the AgentConfig.
+func (c *AgentConfigBuilder) WithDestinationCryptoKeys(paths string) *AgentConfigBuilder {
+	c.Config.DestinationCryptoKeys = paths
+	c.Config.DestinationCryptoKeysIsSet = true
+	return c
+}
+
+// WithDestinationCompressions sets the compressions of individual destinations in the AgentConfig.
+func (c *AgentConfigBuilder) WithDestinationCompressions(encodings string) *AgentConfigBuilder {
+	c.Config.DestinationCompressions = encodings
+	c.Config.DestinationCompressionsIsSet = true
+	return c
+}
+
+// WithDestinationRateLimits sets the numbers of workers of individual destinations in the AgentConfig.
+func (c *AgentConfigBuilder) WithDestinationRateLimits(limits string) *AgentConfigBuilder {
+	c.Config.DestinationRateLimits = limits
+	c.Config.DestinationRateLimitsIsSet = true
+	return c
+}
+
+// WithRetryAttempts sets the number of retries of a failed request in the AgentConfig.
+func (c *AgentConfigBuilder) WithRetryAttempts(attempts int) *AgentConfigBuilder {
+	c.Config.RetryAttempts = attempts
+	c.Config.RetryAttemptsIsSet = true
+	return c
+}
+
+// WithRetryInitialInterval sets the upper bound of the wait before the first retry in the AgentConfig.
+func (c *AgentConfigBuilder) WithRetryInitialInterval(interval time.Duration) *AgentConfigBuilder {
+	c.Config.RetryInitialInterval = interval
+	c.Config.RetryInitialIntervalIsSet = true
+	return c
+}
+
+// WithRetryMaxInterval sets the cap of the wait between retries in the AgentConfig.
+func (c *AgentConfigBuilder) WithRetryMaxInterval(interval time.Duration) *AgentConfigBuilder {
+	c.Config.RetryMaxInterval = interval
+	c.Config.RetryMaxIntervalIsSet = true
+	return c
+}
+
+// WithBreakerThreshold sets the number of consecutive failed requests that opens the circuit breaker in the AgentConfig.
+func (c *AgentConfigBuilder) WithBreakerThreshold(threshold int) *AgentConfigBuilder {
+	c.Config.BreakerThreshold = threshold
+	c.Config.BreakerThresholdIsSet = true
+	return c
+}
+
+// WithBreakerCooldown sets the time sending is paused for once the circuit breaker opens in the AgentConfig.
+func (c *AgentConfigBuilder) WithBreakerCooldown(cooldown time.Duration) *AgentConfigBuilder {
+	c.Config.BreakerCooldown = cooldown
+	c.Config.BreakerCooldownIsSet = true
+	return c
+}
+
+// WithProcesses sets the processes metrics are collected of in the AgentConfig.
+func (c *AgentConfigBuilder) WithProcesses(processes string) *AgentConfigBuilder {
+	c.Config.Processes = processes
+	c.Config.ProcessesIsSet = true
+	return c
+}
+
+// WithCommands sets the commands run by the exec collector in the AgentConfig.
+func (c *AgentConfigBuilder) WithCommands(commands string) *AgentConfigBuilder {
+	c.Config.Commands = commands
+	c.Config.CommandsIsSet = true
+	return c
+}
+
+// WithCommandInterval sets the interval between runs of a command in the AgentConfig.
+func (c *AgentConfigBuilder) WithCommandInterval(interval time.Duration) *AgentConfigBuilder {
+	c.Config.CommandInterval = interval
+	c.Config.CommandIntervalIsSet = true
+	return c
+}
+
+// WithCommandTimeout sets the time a command may run before it is killed in the AgentConfig.
+func (c *AgentConfigBuilder) WithCommandTimeout(timeout time.Duration) *AgentConfigBuilder {
+	c.Config.CommandTimeout = timeout
+	c.Config.CommandTimeoutIsSet = true
+	return c
+}
+
+// WithCommandIntervals sets the intervals of individual commands in the AgentConfig.
+func (c *AgentConfigBuilder) WithCommandIntervals(intervals string) *AgentConfigBuilder {
+	c.Config.CommandIntervals = intervals
+	c.Config.CommandIntervalsIsSet = true
+	return c
+}
+
+// WithCommandTimeouts sets the timeouts of individual commands in the AgentConfig.
+func (c *AgentConfigBuilder) WithCommandTimeouts(timeouts string) *AgentConfigBuilder {
+	c.Config.CommandTimeouts = timeouts
+	c.Config.CommandTimeoutsIsSet = true
+	return c
+}
+
 // WithConfigFile sets the path to JSON configuration file
 func (c *AgentConfigBuilder) WithConfigFile(configFilePath string) *AgentConfigBuilder {
 	c.Config.ConfigFilePath = configFilePath
@@ -114,36 +435,134 @@ func (c *AgentConfigBuilder) WithConfigFile(configFilePath string) *AgentConfigB
 }
 
 // FromFlags populates the AgentConfig from command-line flags.
+// It is safe to call repeatedly.
 func (c *AgentConfigBuilder) FromFlags() *AgentConfigBuilder {
+	if c.Err != nil {
+		return c
+	}
+	// A new flag set is used so that flags can be parsed again on config reload.
+	fs := flags.NewFlagSet()
+
 	addr := flags.CustomString{}
-	flag.Var(&addr, "a", "server host and port")
+	fs.Var(&addr, "a", "server host and port")
 
-	pollInterval := flags.CustomInt{}
-	flag.Var(&pollInterval, "p", "metrics polling interval")
+	pollInterval := flags.CustomDuration{}
+	fs.Var(&pollInterval, "p", "metrics polling interval, e.g. 2s or 500ms")
 
-	reportInterval := flags.CustomInt{}
-	flag.Var(&reportInterval, "r", "metrics sending to server interval")
+	reportInterval := flags.CustomDuration{}
+	fs.Var(&reportInterval, "r", "metrics sending to server interval, e.g. 10s or 1m")
 
 	key := flags.CustomString{}
-	flag.Var(&key, "k", "secret auth key")
+	fs.Var(&key, "k", "secret auth key")
 
 	rateLimit := flags.CustomInt{}
-	flag.Var(&rateLimit, "l", "number of agent workers")
+	fs.Var(&rateLimit, "l", "number of agent workers")
 
 	cryptoKey := flags.CustomString{}
-	flag.Var(&cryptoKey, "crypto-key", "path to the file with public key")
+	fs.Var(&cryptoKey, "crypto-key", "path to the file with public key")
+
+	compression := flags.CustomString{}
+	fs.Var(&compression, "compression", "request body compression: gzip, deflate, zstd or identity")
+
+	otlpEndpoint := flags.CustomString{}
+	fs.Var(&otlpEndpoint, "otlp-endpoint", "OTLP/HTTP traces endpoint to export spans to, empty disables export")
+
+	shutdownTimeout := flags.CustomDuration{}
+	fs.Var(&shutdownTimeout, "shutdown-timeout", "time to finish in-flight work on shutdown, e.g. 10s")
+
+	logLevel := flags.CustomString{}
+	fs.Var(&logLevel, "log-level", "log level: debug, info, warn or error")
+
+	logFormat := flags.CustomString{}
+	fs.Var(&logFormat, "log-format", "log format: console or json")
+
+	logFile := flags.CustomString{}
+	fs.Var(&logFile, "log-file", "path to the log file, empty logs to stderr")
+
+	logMaxSize := flags.CustomInt{}
+	fs.Var(&logMaxSize, "log-max-size", "size in megabytes at which the log file is rotated")
+
+	logMaxBackups := flags.CustomInt{}
+	fs.Var(&logMaxBackups, "log-max-backups", "number of rotated log files to keep, 0 keeps all")
+
+	logSampling := flags.CustomInt{}
+	fs.Var(&logSampling, "log-sampling", "identical log entries per second logged before only every 100th of them is, 0 disables sampling")
+
+	ingestHTTPAddress := flags.CustomString{}
+	fs.Var(&ingestHTTPAddress, "ingest-http-address", "host and port to receive metrics from local applications over HTTP, e.g. localhost:8090, empty disables the listener")
+
+	ingestStatsDAddress := flags.CustomString{}
+	fs.Var(&ingestStatsDAddress, "ingest-statsd-address", "host and port to receive StatsD metrics over UDP, e.g. localhost:8125, empty disables the listener")
+
+	mode := flags.CustomString{}
+	fs.Var(&mode, "mode", "push to send metrics to the server, pull to expose them for the server to scrape, or both")
+
+	exposeAddress := flags.CustomString{}
+	fs.Var(&exposeAddress, "expose-address", "host and port to expose metrics for scraping on GET /metrics in the pull mode, e.g. :9101")
+
+	destinations := flags.CustomString{}
+	fs.Var(&destinations, "destinations", "comma-separated servers to send metrics to as name=host:port, overriding -a")
+
+	destinationKeys := flags.CustomString{}
+	fs.Var(&destinationKeys, "destination-keys", "comma-separated signing keys of destinations as name=key")
+
+	destinationCryptoKeys := flags.CustomString{}
+	fs.Var(&destinationCryptoKeys, "destination-crypto-keys", "comma-separated public key paths of destinations as name=path")
+
+	destinationCompressions := flags.CustomString{}
+	fs.Var(&destinationCompressions, "destination-compressions", "comma-separated compressions of destinations as name=encoding")
+
+	destinationRateLimits := flags.CustomString{}
+	fs.Var(&destinationRateLimits, "destination-rate-limits", "comma-separated numbers of workers of destinations as name=count")
+
+	retryAttempts := flags.CustomInt{}
+	fs.Var(&retryAttempts, "retry-attempts", "number of retries of a request failing with a network error, 5xx or 429, 0 disables retries")
+
+	retryInitialInterval := flags.CustomDuration{}
+	fs.Var(&retryInitialInterval, "retry-initial-interval", "upper bound of the random wait before the first retry, doubled with every retry, e.g. 1s")
+
+	retryMaxInterval := flags.CustomDuration{}
+	fs.Var(&retryMaxInterval, "retry-max-interval", "cap of the upper bound of the random wait between retries, e.g. 30s")
+
+	breakerThreshold := flags.CustomInt{}
+	fs.Var(&breakerThreshold, "breaker-threshold", "consecutive failed requests to a destination that pause sending to it, 0 disables the circuit breaker")
+
+	breakerCooldown := flags.CustomDuration{}
+	fs.Var(&breakerCooldown, "breaker-cooldown", "time sending to a destination is paused for before a request probes it again, e.g. 30s")
+
+	processes := flags.CustomString{}
+	fs.Var(&processes, "processes", "comma-separated processes to collect CPU, memory, file descriptor and thread metrics of as name=name:regexp, name=cmdline:regexp or name=pidfile:path")
+
+	commands := flags.CustomString{}
+	fs.Var(&commands, "commands", "comma-separated commands run by the exec collector as name=command, their output holds metrics as name type value lines or JSON")
+
+	commandInterval := flags.CustomDuration{}
+	fs.Var(&commandInterval, "command-interval", "interval between runs of a command")
+
+	commandTimeout := flags.CustomDuration{}
+	fs.Var(&commandTimeout, "command-timeout", "time a command may run before it is killed")
+
+	commandIntervals := flags.CustomString{}
+	fs.Var(&commandIntervals, "command-intervals", "comma-separated intervals of commands as name=duration")
+
+	commandTimeouts := flags.CustomString{}
+	fs.Var(&commandTimeouts, "command-timeouts", "comma-separated timeouts of commands as name=duration")
+
+	printConfig := fs.Bool("print-config", false, "print the effective configuration with the source of each value and exit")
 
 	configFilePath := flags.CustomString{}
-	flag.Var(&configFilePath, "c", "path to config file (shorthand)")
+	fs.Var(&configFilePath, "c", "path to config file (shorthand)")
 
 	configFilePathAlias := flags.CustomString{}
-	flag.Var(&configFilePathAlias, "config", "path to config file")
+	fs.Var(&configFilePathAlias, "config", "path to config file")
 
-	flag.Parse()
+	fs.Parse(os.Args[1:]) //nolint:all // errors exit the process
+	c.Config.PrintConfig = *printConfig
 
 	//Verifying if the flags were set properly
 	if configFilePath.IsSet && configFilePathAlias.IsSet {
-		log.Fatalf("usage of both shorthand and full flag (-c and --config)")
+		c.Err = errors.New("usage of both shorthand and full flag (-c and --config)")
+		return c
 	}
 
 	if !c.Config.ConfigFilePathIsSet {
@@ -179,61 +598,289 @@ func (c *AgentConfigBuilder) FromFlags() *AgentConfigBuilder {
 		c.WithRateLimit(rateLimit.Value)
 	}
 
+	if !c.Config.CompressionIsSet && compression.IsSet {
+		c.WithCompression(compression.Value)
+	}
+
+	if !c.Config.OTLPEndpointIsSet && otlpEndpoint.IsSet {
+		c.WithOTLPEndpoint(otlpEndpoint.Value)
+	}
+
+	if !c.Config.ShutdownTimeoutIsSet && shutdownTimeout.IsSet {
+		c.WithShutdownTimeout(shutdownTimeout.Value)
+	}
+
+	if !c.Config.LogLevelIsSet && logLevel.IsSet {
+		c.WithLogLevel(logLevel.Value)
+	}
+
+	if !c.Config.LogFormatIsSet && logFormat.IsSet {
+		c.WithLogFormat(logFormat.Value)
+	}
+
+	if !c.Config.LogFileIsSet && logFile.IsSet {
+		c.WithLogFile(logFile.Value)
+	}
+
+	if !c.Config.LogMaxSizeIsSet && logMaxSize.IsSet {
+		c.WithLogMaxSize(logMaxSize.Value)
+	}
+
+	if !c.Config.LogMaxBackupsIsSet && logMaxBackups.IsSet {
+		c.WithLogMaxBackups(logMaxBackups.Value)
+	}
+
+	if !c.Config.LogSamplingIsSet && logSampling.IsSet {
+		c.WithLogSampling(logSampling.Value)
+	}
+
+	if !c.Config.IngestHTTPAddressIsSet && ingestHTTPAddress.IsSet {
+		c.WithIngestHTTPAddress(ingestHTTPAddress.Value)
+	}
+
+	if !c.Config.IngestStatsDAddressIsSet && ingestStatsDAddress.IsSet {
+		c.WithIngestStatsDAddress(ingestStatsDAddress.Value)
+	}
+
+	if !c.Config.ModeIsSet && mode.IsSet {
+		c.WithMode(mode.Value)
+	}
+
+	if !c.Config.ExposeAddressIsSet && exposeAddress.IsSet {
+		c.WithExposeAddress(exposeAddress.Value)
+	}
+
+	if !c.Config.DestinationsIsSet && destinations.IsSet {
+		c.WithDestinations(destinations.Value)
+	}
+
+	if !c.Config.DestinationKeysIsSet && destinationKeys.IsSet {
+		c.WithDestinationKeys(destinationKeys.Value)
+	}
+
+	if !c.Config.DestinationCryptoKeysIsSet && destinationCryptoKeys.IsSet {
+		c.WithDestinationCryptoKeys(destinationCryptoKeys.Value)
+	}
+
+	if !c.Config.DestinationCompressionsIsSet && destinationCompressions.IsSet {
+		c.WithDestinationCompressions(destinationCompressions.Value)
+	}
+
+	if !c.Config.DestinationRateLimitsIsSet && destinationRateLimits.IsSet {
+		c.WithDestinationRateLimits(destinationRateLimits.Value)
+	}
+
+	if !c.Config.RetryAttemptsIsSet && retryAttempts.IsSet {
+		c.WithRetryAttempts(retryAttempts.Value)
+	}
+
+	if !c.Config.RetryInitialIntervalIsSet && retryInitialInterval.IsSet {
+		c.WithRetryInitialInterval(retryInitialInterval.Value)
+	}
+
+	if !c.Config.RetryMaxIntervalIsSet && retryMaxInterval.IsSet {
+		c.WithRetryMaxInterval(retryMaxInterval.Value)
+	}
+
+	if !c.Config.BreakerThresholdIsSet && breakerThreshold.IsSet {
+		c.WithBreakerThreshold(breakerThreshold.Value)
+	}
+
+	if !c.Config.BreakerCooldownIsSet && breakerCooldown.IsSet {
+		c.WithBreakerCooldown(breakerCooldown.Value)
+	}
+
+	if !c.Config.ProcessesIsSet && processes.IsSet {
+		c.WithProcesses(processes.Value)
+	}
+
+	if !c.Config.CommandsIsSet && commands.IsSet {
+		c.WithCommands(commands.Value)
+	}
+
+	if !c.Config.CommandIntervalIsSet && commandInterval.IsSet {
+		c.WithCommandInterval(commandInterval.Value)
+	}
+
+	if !c.Config.CommandTimeoutIsSet && commandTimeout.IsSet {
+		c.WithCommandTimeout(commandTimeout.Value)
+	}
+
+	if !c.Config.CommandIntervalsIsSet && commandIntervals.IsSet {
+		c.WithCommandIntervals(commandIntervals.Value)
+	}
+
+	if !c.Config.CommandTimeoutsIsSet && commandTimeouts.IsSet {
+		c.WithCommandTimeouts(commandTimeouts.Value)
+	}
 	return c
 }
 
-// FromFile populates the AgentConfig from config JSON file.
+// FromFile populates the AgentConfig from the JSON, YAML or TOML config file.
+// Settings present in the file are applied unless they were set by environment variables or flags.
+// All invalid settings are reported together in Err.
 func (c *AgentConfigBuilder) FromFile() *AgentConfigBuilder {
-	if c.Config.ConfigFilePath == "" {
+	if c.Err != nil || c.Config.ConfigFilePath == "" {
 		return c
 	}
-	if err := k.Load(file.Provider(c.Config.ConfigFilePath), json.Parser()); err != nil {
-		log.Fatalf("error loading config: %v", err)
+	src, err := source.Load(c.Config.ConfigFilePath)
+	if err != nil {
+		c.Err = err
+		return c
 	}
-	JSONConfig := AgentConfig{}
-	JSONConfig.SetDefaults()
 
-	if err := config.ParseConfigFile(&JSONConfig, c.Config.ConfigFilePath); err != nil {
-		log.Fatalf("error parsing config: %v", err)
+	if key, ok := src.String("key"); ok && !c.Config.KeyIsSet {
+		c.WithKey(key)
 	}
-	if JSONConfig.Key != defaultKey && !c.Config.KeyIsSet {
-		c.WithKey(JSONConfig.Key)
+
+	if address, ok := src.String("address"); ok && src.Check("address", validateAddress(address)) && !c.Config.AddressIsSet {
+		c.WithAddress(address)
 	}
 
-	if JSONConfig.Address != defaultAddress && !c.Config.AddressIsSet {
-		c.WithAddress(JSONConfig.Address)
+	if path, ok := src.String("crypto_key"); ok && !c.Config.CryptoKeyIsSet {
+		c.WithCryptoKey(path)
 	}
 
-	if JSONConfig.CryptoKey != defaultCryptoKey && !c.Config.CryptoKeyIsSet {
-		c.WithCryptoKey(JSONConfig.CryptoKey)
+	if interval, ok := src.Duration("report_interval"); ok && src.Check("report_interval", positive(interval)) && !c.Config.ReportIntervalIsSet {
+		c.WithReportInterval(interval)
 	}
 
-	if JSONConfig.ReportIntervalString != "" && !c.Config.ReportIntervalIsSet {
-		intReportInterval, err := util.CutSeconds(JSONConfig.ReportIntervalString)
-		if err != nil {
-			log.Fatal(err)
-		}
-		c.WithReportInterval(intReportInterval)
+	if interval, ok := src.Duration("poll_interval"); ok && src.Check("poll_interval", positive(interval)) && !c.Config.PollIntervalIsSet {
+		c.WithPollInterval(interval)
 	}
 
-	if JSONConfig.PollIntervalString != "" && !c.Config.PollIntervalIsSet {
-		intPollInterval, err := util.CutSeconds(JSONConfig.PollIntervalString)
-		if err != nil {
-			log.Fatal(err)
-		}
-		c.WithPollInterval(intPollInterval)
+	if limit, ok := src.Int("rate_limit"); ok && src.Check("rate_limit", positive(limit)) && !c.Config.RateLimitIsSet {
+		c.WithRateLimit(limit)
+	}
+
+	if encoding, ok := src.String("compression"); ok && src.Check("compression", validateCompression(encoding)) && !c.Config.CompressionIsSet {
+		c.WithCompression(encoding)
+	}
+
+	if endpoint, ok := src.String("otlp_endpoint"); ok && !c.Config.OTLPEndpointIsSet {
+		c.WithOTLPEndpoint(endpoint)
+	}
+
+	if timeout, ok := src.Duration("shutdown_timeout"); ok && src.Check("shutdown_timeout", nonNegative(timeout)) && !c.Config.ShutdownTimeoutIsSet {
+		c.WithShutdownTimeout(timeout)
+	}
+
+	if level, ok := src.String("log_level"); ok && src.Check("log_level", validateLogLevel(level)) && !c.Config.LogLevelIsSet {
+		c.WithLogLevel(level)
+	}
+
+	if format, ok := src.String("log_format"); ok && src.Check("log_format", validateLogFormat(format)) && !c.Config.LogFormatIsSet {
+		c.WithLogFormat(format)
+	}
+
+	if path, ok := src.String("log_file"); ok && !c.Config.LogFileIsSet {
+		c.WithLogFile(path)
+	}
+
+	if size, ok := src.Int("log_max_size"); ok && src.Check("log_max_size", positive(size)) && !c.Config.LogMaxSizeIsSet {
+		c.WithLogMaxSize(size)
+	}
+
+	if backups, ok := src.Int("log_max_backups"); ok && src.Check("log_max_backups", nonNegative(backups)) && !c.Config.LogMaxBackupsIsSet {
+		c.WithLogMaxBackups(backups)
+	}
+
+	if n, ok := src.Int("log_sampling"); ok && src.Check("log_sampling", nonNegative(n)) && !c.Config.LogSamplingIsSet {
+		c.WithLogSampling(n)
+	}
+
+	if address, ok := src.String("ingest_http_address"); ok && src.Check("ingest_http_address", validateOptionalAddress(address)) && !c.Config.IngestHTTPAddressIsSet {
+		c.WithIngestHTTPAddress(address)
+	}
+
+	if address, ok := src.String("ingest_statsd_address"); ok && src.Check("ingest_statsd_address", validateOptionalAddress(address)) && !c.Config.IngestStatsDAddressIsSet {
+		c.WithIngestStatsDAddress(address)
+	}
+
+	if mode, ok := src.String("mode"); ok && src.Check("mode", validateMode(mode)) && !c.Config.ModeIsSet {
+		c.WithMode(mode)
+	}
+
+	if address, ok := src.String("expose_address"); ok && src.Check("expose_address", validateOptionalAddress(address)) && !c.Config.ExposeAddressIsSet {
+		c.WithExposeAddress(address)
+	}
+
+	if destinations, ok := src.List("destinations"); ok && !c.Config.DestinationsIsSet {
+		c.WithDestinations(destinations)
 	}
 
-	if JSONConfig.RateLimit != defaultRateLimit && !c.Config.RateLimitIsSet {
-		c.WithRateLimit(JSONConfig.RateLimit)
+	if keys, ok := src.List("destination_keys"); ok && !c.Config.DestinationKeysIsSet {
+		c.WithDestinationKeys(keys)
 	}
+
+	if paths, ok := src.List("destination_crypto_keys"); ok && !c.Config.DestinationCryptoKeysIsSet {
+		c.WithDestinationCryptoKeys(paths)
+	}
+
+	if encodings, ok := src.List("destination_compressions"); ok && !c.Config.DestinationCompressionsIsSet {
+		c.WithDestinationCompressions(encodings)
+	}
+
+	if limits, ok := src.List("destination_rate_limits"); ok && !c.Config.DestinationRateLimitsIsSet {
+		c.WithDestinationRateLimits(limits)
+	}
+
+	if attempts, ok := src.Int("retry_attempts"); ok && !c.Config.RetryAttemptsIsSet {
+		c.WithRetryAttempts(attempts)
+	}
+
+	if interval, ok := src.Duration("retry_initial_interval"); ok && !c.Config.RetryInitialIntervalIsSet {
+		c.WithRetryInitialInterval(interval)
+	}
+
+	if interval, ok := src.Duration("retry_max_interval"); ok && !c.Config.RetryMaxIntervalIsSet {
+		c.WithRetryMaxInterval(interval)
+	}
+
+	if threshold, ok := src.Int("breaker_threshold"); ok && !c.Config.BreakerThresholdIsSet {
+		c.WithBreakerThreshold(threshold)
+	}
+
+	if cooldown, ok := src.Duration("breaker_cooldown"); ok && !c.Config.BreakerCooldownIsSet {
+		c.WithBreakerCooldown(cooldown)
+	}
+
+	if processes, ok := src.List("processes"); ok && !c.Config.ProcessesIsSet {
+		c.WithProcesses(processes)
+	}
+
+	if commands, ok := src.List("commands"); ok && !c.Config.CommandsIsSet {
+		c.WithCommands(commands)
+	}
+
+	if interval, ok := src.Duration("command_interval"); ok && !c.Config.CommandIntervalIsSet {
+		c.WithCommandInterval(interval)
+	}
+
+	if timeout, ok := src.Duration("command_timeout"); ok && !c.Config.CommandTimeoutIsSet {
+		c.WithCommandTimeout(timeout)
+	}
+
+	if intervals, ok := src.List("command_intervals"); ok && !c.Config.CommandIntervalsIsSet {
+		c.WithCommandIntervals(intervals)
+	}
+
+	if timeouts, ok := src.List("command_timeouts"); ok && !c.Config.CommandTimeoutsIsSet {
+		c.WithCommandTimeouts(timeouts)
+	}
+
+	c.Err = src.Err()
 	return c
 }
 
 // FromEnv populates the AgentConfig from environment variables.
 func (c *AgentConfigBuilder) FromEnv() *AgentConfigBuilder {
-	if err := env.Parse(&c.Config); err != nil {
-		log.Fatal(err)
+	if c.Err != nil {
+		return c
+	}
+	if err := env.ParseWithFuncs(&c.Config, flags.EnvParsers()); err != nil {
+		c.Err = err
+		return c
 	}
 
 	_, addressIsSet := os.LookupEnv("ADDRESS")
@@ -268,23 +915,156 @@ func (c *AgentConfigBuilder) FromEnv() *AgentConfigBuilder {
 		c.Config.RateLimitIsSet = true
 	}
 
+	_, compressionSet := os.LookupEnv("COMPRESSION")
+	if compressionSet {
+		c.Config.CompressionIsSet = true
+	}
+	_, otlpEndpointSet := os.LookupEnv("OTLP_ENDPOINT")
+	if otlpEndpointSet {
+		c.Config.OTLPEndpointIsSet = true
+	}
+	_, shutdownTimeoutSet := os.LookupEnv("SHUTDOWN_TIMEOUT")
+	if shutdownTimeoutSet {
+		c.Config.ShutdownTimeoutIsSet = true
+	}
+	_, logLevelSet := os.LookupEnv("LOG_LEVEL")
+	if logLevelSet {
+		c.Config.LogLevelIsSet = true
+	}
+	_, logFormatSet := os.LookupEnv("LOG_FORMAT")
+	if logFormatSet {
+		c.Config.LogFormatIsSet = true
+	}
+	_, logFileSet := os.LookupEnv("LOG_FILE")
+	if logFileSet {
+		c.Config.LogFileIsSet = true
+	}
+	_, logMaxSizeSet := os.LookupEnv("LOG_MAX_SIZE")
+	if logMaxSizeSet {
+		c.Config.LogMaxSizeIsSet = true
+	}
+	_, logMaxBackupsSet := os.LookupEnv("LOG_MAX_BACKUPS")
+	if logMaxBackupsSet {
+		c.Config.LogMaxBackupsIsSet = true
+	}
+	_, logSamplingSet := os.LookupEnv("LOG_SAMPLING")
+	if logSamplingSet {
+		c.Config.LogSamplingIsSet = true
+	}
+	_, ingestHTTPAddressSet := os.LookupEnv("INGEST_HTTP_ADDRESS")
+	if ingestHTTPAddressSet {
+		c.Config.IngestHTTPAddressIsSet = true
+	}
+	_, ingestStatsDAddressSet := os.LookupEnv("INGEST_STATSD_ADDRESS")
+	if ingestStatsDAddressSet {
+		c.Config.IngestStatsDAddressIsSet = true
+	}
+	_, modeSet := os.LookupEnv("MODE")
+	if modeSet {
+		c.Config.ModeIsSet = true
+	}
+	_, exposeAddressSet := os.LookupEnv("EXPOSE_ADDRESS")
+	if exposeAddressSet {
+		c.Config.ExposeAddressIsSet = true
+	}
+	_, destinationsSet := os.LookupEnv("DESTINATIONS")
+	if destinationsSet {
+		c.Config.DestinationsIsSet = true
+	}
+	_, destinationKeysSet := os.LookupEnv("DESTINATION_KEYS")
+	if destinationKeysSet {
+		c.Config.DestinationKeysIsSet = true
+	}
+	_, destinationCryptoKeysSet := os.LookupEnv("DESTINATION_CRYPTO_KEYS")
+	if destinationCryptoKeysSet {
+		c.Config.DestinationCryptoKeysIsSet = true
+	}
+	_, destinationCompressionsSet := os.LookupEnv("DESTINATION_COMPRESSIONS")
+	if destinationCompressionsSet {
+		c.Config.DestinationCompressionsIsSet = true
+	}
+	_, destinationRateLimitsSet := os.LookupEnv("DESTINATION_RATE_LIMITS")
+	if destinationRateLimitsSet {
+		c.Config.DestinationRateLimitsIsSet = true
+	}
+	_, retryAttemptsSet := os.LookupEnv("RETRY_ATTEMPTS")
+	if retryAttemptsSet {
+		c.Config.RetryAttemptsIsSet = true
+	}
+	_, retryInitialIntervalSet := os.LookupEnv("RETRY_INITIAL_INTERVAL")
+	if retryInitialIntervalSet {
+		c.Config.RetryInitialIntervalIsSet = true
+	}
+	_, retryMaxIntervalSet := os.LookupEnv("RETRY_MAX_INTERVAL")
+	if retryMaxIntervalSet {
+		c.Config.RetryMaxIntervalIsSet = true
+	}
+	_, breakerThresholdSet := os.LookupEnv("BREAKER_THRESHOLD")
+	if breakerThresholdSet {
+		c.Config.BreakerThresholdIsSet = true
+	}
+	_, breakerCooldownSet := os.LookupEnv("BREAKER_COOLDOWN")
+	if breakerCooldownSet {
+		c.Config.BreakerCooldownIsSet = true
+	}
+	_, processesSet := os.LookupEnv("PROCESSES")
+	if processesSet {
+		c.Config.ProcessesIsSet = true
+	}
+	_, commandsSet := os.LookupEnv("COMMANDS")
+	if commandsSet {
+		c.Config.CommandsIsSet = true
+	}
+	_, commandIntervalSet := os.LookupEnv("COMMAND_INTERVAL")
+	if commandIntervalSet {
+		c.Config.CommandIntervalIsSet = true
+	}
+	_, commandTimeoutSet := os.LookupEnv("COMMAND_TIMEOUT")
+	if commandTimeoutSet {
+		c.Config.CommandTimeoutIsSet = true
+	}
+	_, commandIntervalsSet := os.LookupEnv("COMMAND_INTERVALS")
+	if commandIntervalsSet {
+		c.Config.CommandIntervalsIsSet = true
+	}
+	_, commandTimeoutsSet := os.LookupEnv("COMMAND_TIMEOUTS")
+	if commandTimeoutsSet {
+		c.Config.CommandTimeoutsIsSet = true
+	}
+	return c
+}
+
+// track records source for the settings set by the last step.
+func (c *AgentConfigBuilder) track(source string) *AgentConfigBuilder {
+	if c.Err != nil {
+		return c
+	}
+	if c.Config.Sources == nil {
+		c.Config.Sources = make(settings.Sources)
+	}
+	c.Config.Sources.Track(&c.Config, source)
 	return c
 }
 
 // GetConfigs returns the fully constructed AgentConfig by combining
-// configurations from environment variables, command-line flags and JSON file.
-// It validates the address and rate limit to ensure they are properly set.
+// configurations from environment variables, command-line flags and config file,
+// in that order of precedence, and validates the result.
+// It can be called again to reload the configuration, e.g. on SIGHUP.
 func GetConfigs() (AgentConfig, error) {
 	var c AgentConfigBuilder
 	c.Config.SetDefaults()
 
-	c.FromEnv().FromFlags().FromFile()
-
-	if !util.ValidateAddress(c.Config.Address) {
-		return AgentConfig{}, errors.New("need address in a form host:port")
+	c.FromEnv().track(settings.Env).FromFlags().track(settings.Flag).FromFile().track(settings.File)
+	if c.Err != nil {
+		return AgentConfig{}, c.Err
 	}
-	if c.Config.RateLimit == 0 {
-		return AgentConfig{}, errors.New("rate limit must be larger than 0")
+	if err := c.Config.Validate(); err != nil {
+		return AgentConfig{}, err
 	}
 	return c.Config, nil
 }
+
+// Settings returns the effective settings with their sources, secrets are redacted.
+func (c AgentConfig) Settings() []settings.Setting {
+	return settings.List(c, c.Sources)
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestGetConfigs_File(t *testing.T) {
+	t.Run("settings are applied below environment", func(t *testing.T) {
+		t.Setenv("CONFIG", writeConfigFile(t, "agent.yaml", "poll_interval: 500ms\nreport_interval: 4\nrate_limit: 3\ncompression: zstd\n"))
+		t.Setenv("RATE_LIMIT", "6")
+		cfg, err := GetConfigs()
+		require.NoError(t, err)
+		require.Equal(t, 500*time.Millisecond, cfg.PollInterval)
+		require.Equal(t, 4*time.Second, cfg.ReportInterval, "plain integers are seconds")
+		require.Equal(t, 6, cfg.RateLimit)
+		require.Equal(t, "zstd", cfg.Compression)
+	})
+
+	t.Run("invalid settings are reported with their lines", func(t *testing.T) {
+		path := writeConfigFile(t, "agent.json", "{\n  \"rate_limit\": 0,\n  \"compression\": \"brotli\",\n  \"pollinterval\": \"1s\"\n}")
+		t.Setenv("CONFIG", path)
+		_, err := GetConfigs()
+		require.EqualError(t, err, path+`:2: rate_limit: must be positive, got 0
+`+path+`:3: compression: unsupported compression "brotli"
+`+path+`:4: pollinterval: unknown setting`)
+	})
+}
+
+func TestGetConfigs_Mode(t *testing.T) {
+	t.Setenv("MODE", "pull")
+	_, err := GetConfigs()
+	require.EqualError(t, err, "expose_address: need an address in the pull mode")
+
+	t.Setenv("EXPOSE_ADDRESS", "localhost:9101")
+	cfg, err := GetConfigs()
+	require.NoError(t, err)
+	require.Equal(t, ModePull, cfg.Mode)
+	require.Equal(t, "localhost:9101", cfg.ExposeAddress)
+
+	t.Setenv("MODE", "poll")
+	_, err = GetConfigs()
+	require.ErrorContains(t, err, `mode: invalid mode "poll", use push, pull or both`)
+}
+
+func TestAgentConfig_DestinationList(t *testing.T) {
+	var cfg AgentConfig
+	cfg.SetDefaults()
+	cfg.Address, cfg.Key, cfg.RateLimit = "127.0.0.1:8080", "key", 2
+	require.Equal(t, []Destination{
+		{Name: "127.0.0.1:8080", Address: "127.0.0.1:8080", Key: "key", CryptoKey: "./public_key.pem", Compression: "gzip", RateLimit: 2},
+	}, cfg.DestinationList(), "without destinations metrics are sent to the address")
+
+	cfg.Destinations = "old=127.0.0.1:8080, new=127.0.0.1:9090"
+	cfg.DestinationKeys = "new=next"
+	cfg.DestinationCryptoKeys = "new="
+	cfg.DestinationCompressions = "old=identity"
+	cfg.DestinationRateLimits = "new=4"
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, []Destination{
+		{Name: "old", Address: "127.0.0.1:8080", Key: "key", CryptoKey: "./public_key.pem", Compression: "identity", RateLimit: 2},
+		{Name: "new", Address: "127.0.0.1:9090", Key: "next", Compression: "gzip", RateLimit: 4},
+	}, cfg.DestinationList())
+
+	tests := []struct {
+		name    string
+		set     func(c *AgentConfig)
+		wantErr string
+	}{
+		{name: "invalid destination", set: func(c *AgentConfig) { c.Destinations = "old" }, wantErr: `destinations: invalid destination "old", use name=host:port`},
+		{name: "duplicate destination", set: func(c *AgentConfig) { c.Destinations = "old=127.0.0.1:1,old=127.0.0.1:2" }, wantErr: `destinations: duplicate destination "old"`},
+		{name: "unknown destination", set: func(c *AgentConfig) { c.DestinationKeys = "dc3=key" }, wantErr: `destination_keys: setting for unknown destination "dc3"`},
+		{name: "invalid compression", set: func(c *AgentConfig) { c.DestinationCompressions = "old=brotli" }, wantErr: `destination_compressions: destination "old": unsupported compression "brotli"`},
+		{name: "invalid rate limit", set: func(c *AgentConfig) { c.DestinationRateLimits = "new=0" }, wantErr: `destination_rate_limits: destination "new": invalid rate limit "0", need a positive number`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cfg
+			tt.set(&c)
+			require.EqualError(t, c.Validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestGetConfigs_Retry(t *testing.T) {
+	t.Setenv("RETRY_INITIAL_INTERVAL", "2s")
+	t.Setenv("RETRY_MAX_INTERVAL", "1s")
+	t.Setenv("BREAKER_THRESHOLD", "-1")
+	_, err := GetConfigs()
+	require.EqualError(t, err, "retry_max_interval: must not be shorter than retry_initial_interval 2s, got 1s\nbreaker_threshold: must not be negative, got -1")
+
+	t.Setenv("RETRY_MAX_INTERVAL", "1m")
+	t.Setenv("BREAKER_THRESHOLD", "0")
+	cfg, err := GetConfigs()
+	require.NoError(t, err)
+	require.Equal(t, 3, cfg.RetryAttempts)
+	require.Equal(t, time.Minute, cfg.RetryMaxInterval)
+	require.Equal(t, 0, cfg.BreakerThreshold, "a zero threshold disables the circuit breaker")
+}
+
+func TestAgentConfig_ProcessList(t *testing.T) {
+	cfg := AgentConfig{Processes: "web=name:^nginx$, db=pidfile:/run/postgresql/postmaster.pid,worker=cmdline:queue-worker --pool=\\w+"}
+	require.NoError(t, validateProcesses(cfg.Processes))
+	require.Equal(t, []Process{
+		{Name: "web", By: ProcessByName, Pattern: "^nginx$"},
+		{Name: "db", By: ProcessByPIDFile, Pattern: "/run/postgresql/postmaster.pid"},
+		{Name: "worker", By: ProcessByCmdline, Pattern: `queue-worker --pool=\w+`},
+	}, cfg.ProcessList())
+
+	require.EqualError(t, validateProcesses("web"), `invalid process "web", use name=name:regexp, name=cmdline:regexp or name=pidfile:path`)
+	require.EqualError(t, validateProcesses("web=exe:nginx"), `invalid selector of process "web", use name:regexp, cmdline:regexp or pidfile:path`)
+	require.EqualError(t, validateProcesses("web=name:(nginx"), "invalid regular expression of process \"web\": error parsing regexp: missing closing ): `(nginx`")
+	require.EqualError(t, validateProcesses("db=pidfile:"), `need a path to the PID file of process "db"`)
+	require.EqualError(t, validateProcesses("web=name:a,web=name:b"), `duplicate process "web"`)
+}
+
+func TestAgentConfig_CommandList(t *testing.T) {
+	cfg := AgentConfig{
+		Commands:         "queue=/usr/local/bin/queue-depth --format=json, disk=df -P / | awk 'NR==2 {print \"disk_used gauge \" $5+0}'",
+		CommandInterval:  time.Minute,
+		CommandTimeout:   10 * time.Second,
+		CommandIntervals: "disk=5m",
+		CommandTimeouts:  "queue=2s",
+	}
+	require.NoError(t, validateCommands(cfg.Commands))
+	require.NoError(t, validateCommandSetting(&cfg, &cfg.CommandIntervals))
+	require.NoError(t, validateCommandSetting(&cfg, &cfg.CommandTimeouts))
+	require.Equal(t, []Command{
+		{Name: "queue", Command: "/usr/local/bin/queue-depth --format=json", Interval: time.Minute, Timeout: 2 * time.Second},
+		{Name: "disk", Command: `df -P / | awk 'NR==2 {print "disk_used gauge " $5+0}'`, Interval: 5 * time.Minute, Timeout: 10 * time.Second},
+	}, cfg.CommandList())
+
+	require.EqualError(t, validateCommands("queue"), `invalid command "queue", use name=command`)
+	require.EqualError(t, validateCommands("queue=a,queue=b"), `duplicate command "queue"`)
+	cfg.CommandIntervals = "web=1m"
+	require.EqualError(t, validateCommandSetting(&cfg, &cfg.CommandIntervals), `setting for unknown command "web"`)
+	cfg.CommandTimeouts = "queue=0s"
+	require.EqualError(t, validateCommandSetting(&cfg, &cfg.CommandTimeouts), `command "queue": invalid duration "0s", need a positive duration`)
+}
+
+func TestGetConfigs_FileLists(t *testing.T) {
+	t.Setenv("CONFIG", writeConfigFile(t, "agent.toml", `processes = [
+  {name = "web", by = "cmdline", pattern = 'nginx -g daemon\s{1,2}off'},
+]
+commands = [
+  {name = "disk", command = "df -P / | awk -F, '{print $1}'"},
+]
+command_intervals = {disk = "5m"}
+`))
+	cfg, err := GetConfigs()
+	require.NoError(t, err)
+	require.Equal(t, []Process{{Name: "web", By: ProcessByCmdline, Pattern: `nginx -g daemon\s{1,2}off`}}, cfg.ProcessList())
+	commands := cfg.CommandList()
+	require.Len(t, commands, 1)
+	require.Equal(t, "df -P / | awk -F, '{print $1}'", commands[0].Command)
+	require.Equal(t, 5*time.Minute, commands[0].Interval)
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mrkovshik/yametrics/internal/config/source"
+	"github.com/mrkovshik/yametrics/internal/util"
+)
+
+// Destination is a server the agent sends metrics to.
+type Destination struct {
+	Name        string // Name of the destination used in the settings of individual destinations and in logs
+	Address     string // Address of the server in a form host:port
+	Key         string // Key signing the requests, empty if they are not signed
+	CryptoKey   string // Path to the public key encrypting the requests, empty if they are not encrypted
+	Compression string // Compression of the request bodies
+	RateLimit   int    // Number of workers sending requests to the server
+}
+
+// DestinationList returns the servers the agent sends metrics to. Destinations without a setting
+// of their own use the key, crypto key, compression and rate limit of the agent. Without
+// destinations the metrics are sent to the address of the agent only.
+func (c AgentConfig) DestinationList() []Destination {
+	destinations, _ := parseDestinations(c)
+	return destinations
+}
+
+// destinationEntry is a destination in a structured list.
+type destinationEntry struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+func parseDestinations(c AgentConfig) ([]Destination, error) {
+	base := Destination{Name: c.Address, Address: c.Address, Key: c.Key, CryptoKey: c.CryptoKey, Compression: c.Compression, RateLimit: c.RateLimit}
+	var destinations []Destination
+	index := make(map[string]int)
+	entries, err := source.Entries(c.Destinations, func(d destinationEntry) string { return d.Name + "=" + d.Address })
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name, address, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid destination %q, use name=host:port", entry)
+		}
+		if !util.ValidateAddress(address) {
+			return nil, fmt.Errorf("invalid address of destination %q, need host:port", name)
+		}
+		if _, ok := index[name]; ok {
+			return nil, fmt.Errorf("duplicate destination %q", name)
+		}
+		index[name] = len(destinations)
+		d := base
+		d.Name, d.Address = name, address
+		destinations = append(destinations, d)
+	}
+	for _, override := range []struct {
+		list string
+		set  func(d *Destination, value string) error
+	}{
+		{c.DestinationKeys, func(d *Destination, key string) error {
+			d.Key = key
+			return nil
+		}},
+		{c.DestinationCryptoKeys, func(d *Destination, path string) error {
+			d.CryptoKey = path
+			return nil
+		}},
+		{c.DestinationCompressions, func(d *Destination, encoding string) error {
+			if err := validateCompression(encoding); err != nil {
+				return err
+			}
+			d.Compression = encoding
+			return nil
+		}},
+		{c.DestinationRateLimits, func(d *Destination, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid rate limit %q, need a positive number", value)
+			}
+			d.RateLimit = n
+			return nil
+		}},
+	} {
+		entries, err := source.Pairs(override.list)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name, value, ok := strings.Cut(entry, "=")
+			if !ok || name == "" {
+				// The entry is not quoted as it may hold a key.
+				return nil, fmt.Errorf("invalid setting of a destination, use name=value")
+			}
+			i, ok := index[name]
+			if !ok {
+				return nil, fmt.Errorf("setting for unknown destination %q", name)
+			}
+			if err := override.set(&destinations[i], value); err != nil {
+				return nil, fmt.Errorf("destination %q: %w", name, err)
+			}
+		}
+	}
+	if len(destinations) == 0 {
+		return []Destination{base}, nil
+	}
+	return destinations, nil
+}
+
+func validateDestinations(list string) error {
+	_, err := parseDestinations(AgentConfig{Destinations: list})
+	return err
+}
+
+// validateDestinationSetting checks the settings of individual destinations in one of the lists
+// against valid destinations, invalid destinations are reported on their own.
+func validateDestinationSetting(c *AgentConfig, list *string) error {
+	if validateDestinations(c.Destinations) != nil {
+		return nil
+	}
+	only := AgentConfig{Destinations: c.Destinations}
+	switch list {
+	case &c.DestinationKeys:
+		only.DestinationKeys = *list
+	case &c.DestinationCryptoKeys:
+		only.DestinationCryptoKeys = *list
+	case &c.DestinationCompressions:
+		only.DestinationCompressions = *list
+	case &c.DestinationRateLimits:
+		only.DestinationRateLimits = *list
+	}
+	_, err := parseDestinations(only)
+	return err
+}
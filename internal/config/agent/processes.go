@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mrkovshik/yametrics/internal/config/source"
+)
+
+// Ways of selecting the processes metrics are collected of.
+const (
+	ProcessByName    = "name"    // The process name matches a regular expression
+	ProcessByCmdline = "cmdline" // The command line matches a regular expression
+	ProcessByPIDFile = "pidfile" // The PID is read from a file
+)
+
+// Process selects the processes metrics are collected of.
+type Process struct {
+	Name    string `json:"name"`    // Value of the process label of the metrics
+	By      string `json:"by"`      // How the processes are selected: ProcessByName, ProcessByCmdline or ProcessByPIDFile
+	Pattern string `json:"pattern"` // Regular expression or path to the PID file
+}
+
+// ProcessList returns the processes metrics are collected of.
+func (c AgentConfig) ProcessList() []Process {
+	processes, _ := parseProcesses(c.Processes)
+	return processes
+}
+
+func parseProcesses(list string) ([]Process, error) {
+	var processes []Process
+	seen := make(map[string]bool)
+	entries, err := source.Entries(list, func(p Process) string { return p.Name + "=" + p.By + ":" + p.Pattern })
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name, selector, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid process %q, use name=name:regexp, name=cmdline:regexp or name=pidfile:path", entry)
+		}
+		by, pattern, _ := strings.Cut(selector, ":")
+		switch by {
+		case ProcessByName, ProcessByCmdline:
+			if _, err := regexp.Compile(pattern); err != nil {
+				return nil, fmt.Errorf("invalid regular expression of process %q: %w", name, err)
+			}
+		case ProcessByPIDFile:
+			if pattern == "" {
+				return nil, fmt.Errorf("need a path to the PID file of process %q", name)
+			}
+		default:
+			return nil, fmt.Errorf("invalid selector of process %q, use name:regexp, cmdline:regexp or pidfile:path", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate process %q", name)
+		}
+		seen[name] = true
+		processes = append(processes, Process{Name: name, By: by, Pattern: pattern})
+	}
+	return processes, nil
+}
+
+func validateProcesses(list string) error {
+	_, err := parseProcesses(list)
+	return err
+}
@@ -0,0 +1,71 @@
+package config
+
+// Reload returns the configuration to put in use when next has been loaded while c is running.
+// Settings that only take effect at startup keep their current values; the names of those
+// that differ in next are returned so that the caller can report them.
+func (c AgentConfig) Reload(next AgentConfig) (AgentConfig, []string) {
+	applied := next
+	var ignored []string
+	keep := func(name string, changed bool, restore func()) {
+		if changed {
+			ignored = append(ignored, name)
+			restore()
+			applied.Sources = applied.Sources.With(name, c.Sources[name])
+		}
+	}
+
+	keep("config", next.ConfigFilePath != c.ConfigFilePath, func() {
+		applied.ConfigFilePath, applied.ConfigFilePathIsSet = c.ConfigFilePath, c.ConfigFilePathIsSet
+	})
+	keep("otlp_endpoint", next.OTLPEndpoint != c.OTLPEndpoint, func() {
+		applied.OTLPEndpoint, applied.OTLPEndpointIsSet = c.OTLPEndpoint, c.OTLPEndpointIsSet
+	})
+	keep("ingest_http_address", next.IngestHTTPAddress != c.IngestHTTPAddress, func() {
+		applied.IngestHTTPAddress, applied.IngestHTTPAddressIsSet = c.IngestHTTPAddress, c.IngestHTTPAddressIsSet
+	})
+	keep("ingest_statsd_address", next.IngestStatsDAddress != c.IngestStatsDAddress, func() {
+		applied.IngestStatsDAddress, applied.IngestStatsDAddressIsSet = c.IngestStatsDAddress, c.IngestStatsDAddressIsSet
+	})
+	keep("mode", next.Mode != c.Mode, func() {
+		applied.Mode, applied.ModeIsSet = c.Mode, c.ModeIsSet
+	})
+	keep("expose_address", next.ExposeAddress != c.ExposeAddress, func() {
+		applied.ExposeAddress, applied.ExposeAddressIsSet = c.ExposeAddress, c.ExposeAddressIsSet
+	})
+	keep("processes", next.Processes != c.Processes, func() {
+		applied.Processes, applied.ProcessesIsSet = c.Processes, c.ProcessesIsSet
+	})
+	// Commands, their intervals and timeouts are kept as the exec collector is created at startup.
+	keep("commands", next.Commands != c.Commands, func() {
+		applied.Commands, applied.CommandsIsSet = c.Commands, c.CommandsIsSet
+	})
+	keep("command_interval", next.CommandInterval != c.CommandInterval, func() {
+		applied.CommandInterval, applied.CommandIntervalIsSet = c.CommandInterval, c.CommandIntervalIsSet
+	})
+	keep("command_timeout", next.CommandTimeout != c.CommandTimeout, func() {
+		applied.CommandTimeout, applied.CommandTimeoutIsSet = c.CommandTimeout, c.CommandTimeoutIsSet
+	})
+	keep("command_intervals", next.CommandIntervals != c.CommandIntervals, func() {
+		applied.CommandIntervals, applied.CommandIntervalsIsSet = c.CommandIntervals, c.CommandIntervalsIsSet
+	})
+	keep("command_timeouts", next.CommandTimeouts != c.CommandTimeouts, func() {
+		applied.CommandTimeouts, applied.CommandTimeoutsIsSet = c.CommandTimeouts, c.CommandTimeoutsIsSet
+	})
+	// The log level is applied on reload, the rest of the log settings require building a new logger.
+	keep("log_format", next.LogFormat != c.LogFormat, func() {
+		applied.LogFormat, applied.LogFormatIsSet = c.LogFormat, c.LogFormatIsSet
+	})
+	keep("log_file", next.LogFile != c.LogFile, func() {
+		applied.LogFile, applied.LogFileIsSet = c.LogFile, c.LogFileIsSet
+	})
+	keep("log_max_size", next.LogMaxSize != c.LogMaxSize, func() {
+		applied.LogMaxSize, applied.LogMaxSizeIsSet = c.LogMaxSize, c.LogMaxSizeIsSet
+	})
+	keep("log_max_backups", next.LogMaxBackups != c.LogMaxBackups, func() {
+		applied.LogMaxBackups, applied.LogMaxBackupsIsSet = c.LogMaxBackups, c.LogMaxBackupsIsSet
+	})
+	keep("log_sampling", next.LogSampling != c.LogSampling, func() {
+		applied.LogSampling, applied.LogSamplingIsSet = c.LogSampling, c.LogSamplingIsSet
+	})
+	return applied, ignored
+}
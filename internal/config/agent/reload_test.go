@@ -0,0 +1,40 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetConfigs_Rerun(t *testing.T) {
+	t.Setenv("POLL_INTERVAL", "5")
+	first, err := GetConfigs()
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Second, first.PollInterval, "plain integers are seconds")
+
+	t.Setenv("POLL_INTERVAL", "1m30s")
+	t.Setenv("RATE_LIMIT", "4")
+	second, err := GetConfigs()
+	require.NoError(t, err)
+	require.Equal(t, 90*time.Second, second.PollInterval)
+	require.Equal(t, 4, second.RateLimit)
+}
+
+func TestAgentConfig_Reload(t *testing.T) {
+	var current AgentConfig
+	current.SetDefaults()
+
+	next := current
+	next.PollInterval = time.Second
+	next.RateLimit = 8
+	next.Key = "secret"
+	next.OTLPEndpoint = "http://collector:4318/v1/traces"
+
+	applied, ignored := current.Reload(next)
+	require.Equal(t, []string{"otlp_endpoint"}, ignored)
+	require.Equal(t, current.OTLPEndpoint, applied.OTLPEndpoint)
+	require.Equal(t, time.Second, applied.PollInterval)
+	require.Equal(t, 8, applied.RateLimit)
+	require.Equal(t, "secret", applied.Key)
+}
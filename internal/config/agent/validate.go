@@ -0,0 +1,129 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/mrkovshik/yametrics/internal/compress"
+	"github.com/mrkovshik/yametrics/internal/util"
+)
+
+// Validate checks all settings of the AgentConfig and returns every problem found.
+func (c *AgentConfig) Validate() error {
+	return errors.Join(
+		field("address", validateAddress(c.Address)),
+		field("poll_interval", positive(c.PollInterval)),
+		field("report_interval", positive(c.ReportInterval)),
+		field("rate_limit", positive(c.RateLimit)),
+		field("compression", validateCompression(c.Compression)),
+		field("shutdown_timeout", nonNegative(c.ShutdownTimeout)),
+		field("log_level", validateLogLevel(c.LogLevel)),
+		field("log_format", validateLogFormat(c.LogFormat)),
+		field("log_max_size", positive(c.LogMaxSize)),
+		field("log_max_backups", nonNegative(c.LogMaxBackups)),
+		field("log_sampling", nonNegative(c.LogSampling)),
+		field("ingest_http_address", validateOptionalAddress(c.IngestHTTPAddress)),
+		field("ingest_statsd_address", validateOptionalAddress(c.IngestStatsDAddress)),
+		field("mode", validateMode(c.Mode)),
+		field("expose_address", validateExposeAddress(c.Mode, c.ExposeAddress)),
+		field("destinations", validateDestinations(c.Destinations)),
+		field("destination_keys", validateDestinationSetting(c, &c.DestinationKeys)),
+		field("destination_crypto_keys", validateDestinationSetting(c, &c.DestinationCryptoKeys)),
+		field("destination_compressions", validateDestinationSetting(c, &c.DestinationCompressions)),
+		field("destination_rate_limits", validateDestinationSetting(c, &c.DestinationRateLimits)),
+		field("retry_attempts", nonNegative(c.RetryAttempts)),
+		field("retry_initial_interval", positive(c.RetryInitialInterval)),
+		field("retry_max_interval", validateRetryMaxInterval(c.RetryInitialInterval, c.RetryMaxInterval)),
+		field("breaker_threshold", nonNegative(c.BreakerThreshold)),
+		field("breaker_cooldown", positive(c.BreakerCooldown)),
+		field("processes", validateProcesses(c.Processes)),
+		field("commands", validateCommands(c.Commands)),
+		field("command_interval", positive(c.CommandInterval)),
+		field("command_timeout", positive(c.CommandTimeout)),
+		field("command_intervals", validateCommandSetting(c, &c.CommandIntervals)),
+		field("command_timeouts", validateCommandSetting(c, &c.CommandTimeouts)),
+	)
+}
+
+// field prefixes a validation error with the name of the setting.
+func field(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+func validateAddress(address string) error {
+	if !util.ValidateAddress(address) {
+		return errors.New("need address in a form host:port")
+	}
+	return nil
+}
+
+func validateCompression(encoding string) error {
+	if _, ok := compress.Lookup(encoding); !ok && encoding != compress.EncodingIdentity {
+		return fmt.Errorf("unsupported compression %q", encoding)
+	}
+	return nil
+}
+
+// validateOptionalAddress accepts an empty address, which disables the listener.
+func validateOptionalAddress(address string) error {
+	if address == "" {
+		return nil
+	}
+	return validateAddress(address)
+}
+
+func validateMode(mode string) error {
+	if mode != ModePush && mode != ModePull && mode != ModeBoth {
+		return fmt.Errorf("invalid mode %q, use push, pull or both", mode)
+	}
+	return nil
+}
+
+// validateExposeAddress requires an address to expose metrics on in the pull mode.
+func validateExposeAddress(mode, address string) error {
+	if address == "" && (mode == ModePull || mode == ModeBoth) {
+		return fmt.Errorf("need an address in the %v mode", mode)
+	}
+	return validateOptionalAddress(address)
+}
+
+func validateRetryMaxInterval(initial, maxInterval time.Duration) error {
+	if maxInterval < initial {
+		return fmt.Errorf("must not be shorter than retry_initial_interval %v, got %v", initial, maxInterval)
+	}
+	return nil
+}
+
+func validateLogLevel(level string) error {
+	if _, err := zapcore.ParseLevel(level); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	return nil
+}
+
+func positive[T int | time.Duration](n T) error {
+	if n <= 0 {
+		return fmt.Errorf("must be positive, got %v", n)
+	}
+	return nil
+}
+
+func validateLogFormat(format string) error {
+	if format != "console" && format != "json" {
+		return fmt.Errorf("invalid log format %q, use console or json", format)
+	}
+	return nil
+}
+
+func nonNegative[T int | time.Duration](n T) error {
+	if n < 0 {
+		return fmt.Errorf("must not be negative, got %v", n)
+	}
+	return nil
+}
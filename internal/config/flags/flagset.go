@@ -0,0 +1,18 @@
+package flags
+
+import (
+	"flag"
+	"os"
+)
+
+// NewFlagSet returns an empty flag set for the program's command line.
+// Flags registered on flag.CommandLine by other packages (e.g. by the testing package)
+// are copied into it so that parsing os.Args does not fail on them.
+// Unlike flag.CommandLine, a new flag set can be populated and parsed any number of times.
+func NewFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	return fs
+}
@@ -3,7 +3,13 @@ package flags
 import (
 	"errors"
 	"fmt"
+	"reflect"
 	"strconv"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+
+	"github.com/mrkovshik/yametrics/internal/util"
 )
 
 // CustomInt is a custom flag type that tracks whether it was set.
@@ -65,3 +71,33 @@ func (c *CustomBool) Set(s string) error {
 func (c *CustomBool) String() string {
 	return fmt.Sprintf("%v", c.Value)
 }
+
+// CustomDuration is a custom flag type that tracks whether it was set.
+// It accepts values like "500ms" or "1m30s" as well as a plain number of seconds.
+type CustomDuration struct {
+	Value time.Duration
+	IsSet bool
+}
+
+func (c *CustomDuration) Set(s string) error {
+	var err error
+	c.Value, err = util.ParseDuration(s)
+	if err == nil {
+		c.IsSet = true
+	}
+	return err
+}
+
+func (c *CustomDuration) String() string {
+	return c.Value.String()
+}
+
+// EnvParsers returns the parsers of environment variables with custom formats,
+// to be passed to env.ParseWithFuncs. Durations accept the same values as CustomDuration.
+func EnvParsers() map[reflect.Type]env.ParserFunc {
+	return map[reflect.Type]env.ParserFunc{
+		reflect.TypeOf(time.Duration(0)): func(v string) (interface{}, error) {
+			return util.ParseDuration(v)
+		},
+	}
+}
@@ -1,64 +1,163 @@
 // Package config provides configuration handling for the server, allowing
-// configurations to be set via environment variables or command-line flags.
+// configurations to be set via environment variables, command-line flags or a JSON, YAML or TOML file.
 package config
 
 import (
 	"errors"
-	"flag"
-	"log"
 	"os"
+	"time"
 
 	"github.com/caarlos0/env/v6"
-	"github.com/eschao/config"
-	"github.com/knadh/koanf/parsers/json"
-	"github.com/knadh/koanf/providers/file"
-	"github.com/knadh/koanf/v2"
 	"github.com/mrkovshik/yametrics/internal/config/flags"
+	"github.com/mrkovshik/yametrics/internal/config/settings"
+	"github.com/mrkovshik/yametrics/internal/config/source"
 
 	"github.com/mrkovshik/yametrics/internal/util"
 )
 
 const (
-	defaultKey            = ""
-	defaultConfigFilePath = ""
-	defaultAddress        = "localhost:8080"
-	defaultStoreInterval  = 300
-	defaultStoreFilePath  = "./tmp/metrics-db.json"
-	defaultCryptoKey      = "./public_key.pem"
-	defaultDBAddress      = ""
-	defaultRestoreEnable  = true
-	defaultStoreEnable    = true
+	defaultKey                     = ""
+	defaultConfigFilePath          = ""
+	defaultAddress                 = "localhost:8080"
+	defaultStoreInterval           = 300 * time.Second
+	defaultStoreFilePath           = "./tmp/metrics-db.json"
+	defaultCryptoKey               = "./public_key.pem"
+	defaultDBAddress               = ""
+	defaultRestoreEnable           = true
+	defaultStoreEnable             = true
+	defaultStrictAuth              = false
+	defaultIPRateLimit             = 0
+	defaultKeyRateLimit            = 0
+	defaultRateLimitBurst          = 0
+	defaultMaxBodySize             = 10 << 20
+	defaultMaxDecompressedSize     = 32 << 20
+	defaultMaxBatchSize            = 10000
+	defaultCompressMinSize         = 1024
+	defaultOTLPEndpoint            = ""
+	defaultShutdownTimeout         = 10 * time.Second
+	defaultLogLevel                = "debug"
+	defaultLogFormat               = "console"
+	defaultLogFile                 = ""
+	defaultLogMaxSize              = 100
+	defaultLogMaxBackups           = 3
+	defaultLogSampling             = 0
+	defaultLogSkipPaths            = "/healthz,/readyz"
+	defaultStatsDAddress           = ""
+	defaultInfluxWriteEnable       = false
+	defaultGraphiteAddress         = ""
+	defaultGraphiteForwardAddress  = ""
+	defaultGraphiteForwardInterval = 10 * time.Second
+	defaultGraphiteForwardPrefix   = ""
+	defaultFederationUpstreams     = ""
+	defaultFederationKeys          = ""
+	defaultFederationInterval      = 30 * time.Second
+	defaultReplicas                = ""
+	defaultReplicaEnable           = false
+	defaultScrapeTargets           = ""
+	defaultScrapeInterval          = 15 * time.Second
+	defaultScrapeTimeout           = 5 * time.Second
+	defaultScrapeIntervals         = ""
+	defaultScrapeTimeouts          = ""
+	defaultConfigEndpoint          = false
 )
 
-var k = koanf.New(".")
-
 // ServerConfig holds the configuration settings for the server.
 type ServerConfig struct {
-	Address             string `env:"ADDRESS" json:"address"`
-	AddressIsSet        bool   `json:"-"`
-	Key                 string `env:"KEY" json:"key"`
-	KeyIsSet            bool   `json:"-"`
-	StoreInterval       int    `env:"STORE_INTERVAL" json:"-"`
-	StoreIntervalString string `json:"store_interval"`
-	StoreIntervalIsSet  bool   `json:"-"`
-	SyncStoreEnable     bool   `json:"-"`
-	StoreFilePath       string `env:"FILE_STORAGE_PATH" json:"store_file"`
-	StoreFilePathIsSet  bool   `json:"-"`
-	StoreEnable         bool   `json:"-"`
-	RestoreEnable       bool   `env:"RESTORE" json:"restore"`
-	RestoreEnvIsSet     bool   `json:"-"`
-	DBAddress           string `env:"DATABASE_DSN" json:"database_dsn"`
-	DBAddressIsSet      bool   `json:"-"`
-	DBEnable            bool   `json:"-"`
-	CryptoKey           string `env:"CRYPTO_KEY" json:"crypto_key"`
-	CryptoKeyIsSet      bool   `json:"-"`
-	ConfigFilePath      string `env:"CONFIG" json:"-"`
-	ConfigFilePathIsSet bool   `json:"-"`
+	Address                      string           `env:"ADDRESS" json:"address"`
+	AddressIsSet                 bool             `json:"-"`
+	Key                          string           `env:"KEY" json:"key" secret:"true"`
+	KeyIsSet                     bool             `json:"-"`
+	StoreInterval                time.Duration    `env:"STORE_INTERVAL" json:"store_interval"`
+	StoreIntervalIsSet           bool             `json:"-"`
+	SyncStoreEnable              bool             `json:"-"`
+	StoreFilePath                string           `env:"FILE_STORAGE_PATH" json:"store_file"`
+	StoreFilePathIsSet           bool             `json:"-"`
+	StoreEnable                  bool             `json:"-"`
+	RestoreEnable                bool             `env:"RESTORE" json:"restore"`
+	RestoreEnableIsSet           bool             `json:"-"`
+	DBAddress                    string           `env:"DATABASE_DSN" json:"database_dsn" secret:"dsn"`
+	DBAddressIsSet               bool             `json:"-"`
+	DBEnable                     bool             `json:"-"`
+	CryptoKey                    string           `env:"CRYPTO_KEY" json:"crypto_key"`
+	CryptoKeyIsSet               bool             `json:"-"`
+	ConfigFilePath               string           `env:"CONFIG" json:"config"`
+	ConfigFilePathIsSet          bool             `json:"-"`
+	StrictAuth                   bool             `env:"STRICT_AUTH" json:"strict_auth"`
+	StrictAuthIsSet              bool             `json:"-"`
+	IPRateLimit                  int              `env:"IP_RATE_LIMIT" json:"ip_rate_limit"`
+	IPRateLimitIsSet             bool             `json:"-"`
+	KeyRateLimit                 int              `env:"KEY_RATE_LIMIT" json:"key_rate_limit"`
+	KeyRateLimitIsSet            bool             `json:"-"`
+	RateLimitBurst               int              `env:"RATE_LIMIT_BURST" json:"rate_limit_burst"`
+	RateLimitBurstIsSet          bool             `json:"-"`
+	MaxBodySize                  int              `env:"MAX_BODY_SIZE" json:"max_body_size"`
+	MaxBodySizeIsSet             bool             `json:"-"`
+	MaxDecompressedSize          int              `env:"MAX_DECOMPRESSED_SIZE" json:"max_decompressed_size"`
+	MaxDecompressedSizeIsSet     bool             `json:"-"`
+	MaxBatchSize                 int              `env:"MAX_BATCH_SIZE" json:"max_batch_size"`
+	MaxBatchSizeIsSet            bool             `json:"-"`
+	CompressMinSize              int              `env:"COMPRESS_MIN_SIZE" json:"compress_min_size"`
+	CompressMinSizeIsSet         bool             `json:"-"`
+	OTLPEndpoint                 string           `env:"OTLP_ENDPOINT" json:"otlp_endpoint"`
+	OTLPEndpointIsSet            bool             `json:"-"`
+	ShutdownTimeout              time.Duration    `env:"SHUTDOWN_TIMEOUT" json:"shutdown_timeout"`
+	ShutdownTimeoutIsSet         bool             `json:"-"`
+	LogLevel                     string           `env:"LOG_LEVEL" json:"log_level"`
+	LogLevelIsSet                bool             `json:"-"`
+	LogFormat                    string           `env:"LOG_FORMAT" json:"log_format"`
+	LogFormatIsSet               bool             `json:"-"`
+	LogFile                      string           `env:"LOG_FILE" json:"log_file"`
+	LogFileIsSet                 bool             `json:"-"`
+	LogMaxSize                   int              `env:"LOG_MAX_SIZE" json:"log_max_size"`
+	LogMaxSizeIsSet              bool             `json:"-"`
+	LogMaxBackups                int              `env:"LOG_MAX_BACKUPS" json:"log_max_backups"`
+	LogMaxBackupsIsSet           bool             `json:"-"`
+	LogSampling                  int              `env:"LOG_SAMPLING" json:"log_sampling"`
+	LogSamplingIsSet             bool             `json:"-"`
+	LogSkipPaths                 string           `env:"LOG_SKIP_PATHS" json:"log_skip_paths"`
+	LogSkipPathsIsSet            bool             `json:"-"`
+	StatsDAddress                string           `env:"STATSD_ADDRESS" json:"statsd_address"`
+	StatsDAddressIsSet           bool             `json:"-"`
+	InfluxWriteEnable            bool             `env:"INFLUX_WRITE" json:"influx_write"`
+	InfluxWriteEnableIsSet       bool             `json:"-"`
+	GraphiteAddress              string           `env:"GRAPHITE_ADDRESS" json:"graphite_address"`
+	GraphiteAddressIsSet         bool             `json:"-"`
+	GraphiteForwardAddress       string           `env:"GRAPHITE_FORWARD_ADDRESS" json:"graphite_forward_address"`
+	GraphiteForwardAddressIsSet  bool             `json:"-"`
+	GraphiteForwardInterval      time.Duration    `env:"GRAPHITE_FORWARD_INTERVAL" json:"graphite_forward_interval"`
+	GraphiteForwardIntervalIsSet bool             `json:"-"`
+	GraphiteForwardPrefix        string           `env:"GRAPHITE_FORWARD_PREFIX" json:"graphite_forward_prefix"`
+	GraphiteForwardPrefixIsSet   bool             `json:"-"`
+	FederationUpstreams          string           `env:"FEDERATION_UPSTREAMS" json:"federation_upstreams"`
+	FederationUpstreamsIsSet     bool             `json:"-"`
+	FederationKeys               string           `env:"FEDERATION_KEYS" json:"federation_keys" secret:"true"`
+	FederationKeysIsSet          bool             `json:"-"`
+	FederationInterval           time.Duration    `env:"FEDERATION_INTERVAL" json:"federation_interval"`
+	FederationIntervalIsSet      bool             `json:"-"`
+	Replicas                     string           `env:"REPLICAS" json:"replicas"`
+	ReplicasIsSet                bool             `json:"-"`
+	ReplicaEnable                bool             `env:"REPLICA" json:"replica"`
+	ReplicaEnableIsSet           bool             `json:"-"`
+	ScrapeTargets                string           `env:"SCRAPE_TARGETS" json:"scrape_targets"`
+	ScrapeTargetsIsSet           bool             `json:"-"`
+	ScrapeInterval               time.Duration    `env:"SCRAPE_INTERVAL" json:"scrape_interval"`
+	ScrapeIntervalIsSet          bool             `json:"-"`
+	ScrapeTimeout                time.Duration    `env:"SCRAPE_TIMEOUT" json:"scrape_timeout"`
+	ScrapeTimeoutIsSet           bool             `json:"-"`
+	ScrapeIntervals              string           `env:"SCRAPE_INTERVALS" json:"scrape_intervals"`
+	ScrapeIntervalsIsSet         bool             `json:"-"`
+	ScrapeTimeouts               string           `env:"SCRAPE_TIMEOUTS" json:"scrape_timeouts"`
+	ScrapeTimeoutsIsSet          bool             `json:"-"`
+	ConfigEndpoint               bool             `env:"CONFIG_ENDPOINT" json:"config_endpoint"`
+	ConfigEndpointIsSet          bool             `json:"-"`
+	PrintConfig                  bool             `json:"-"` // Print the effective configuration and exit
+	Sources                      settings.Sources `json:"-"` // Where the values of the settings come from
 }
 
 // ServerConfigBuilder is a builder for constructing a ServerConfig instance.
 type ServerConfigBuilder struct {
 	Config ServerConfig
+	Err    error // The first error encountered while building, the remaining steps are skipped
 }
 
 func (c *ServerConfig) SetDefaults() {
@@ -71,6 +170,40 @@ func (c *ServerConfig) SetDefaults() {
 	c.StoreFilePath = defaultStoreFilePath
 	c.ConfigFilePath = defaultConfigFilePath
 	c.StoreEnable = defaultStoreEnable
+	c.StrictAuth = defaultStrictAuth
+	c.IPRateLimit = defaultIPRateLimit
+	c.KeyRateLimit = defaultKeyRateLimit
+	c.RateLimitBurst = defaultRateLimitBurst
+	c.MaxBodySize = defaultMaxBodySize
+	c.MaxDecompressedSize = defaultMaxDecompressedSize
+	c.MaxBatchSize = defaultMaxBatchSize
+	c.CompressMinSize = defaultCompressMinSize
+	c.OTLPEndpoint = defaultOTLPEndpoint
+	c.ShutdownTimeout = defaultShutdownTimeout
+	c.LogLevel = defaultLogLevel
+	c.LogFormat = defaultLogFormat
+	c.LogFile = defaultLogFile
+	c.LogMaxSize = defaultLogMaxSize
+	c.LogMaxBackups = defaultLogMaxBackups
+	c.LogSampling = defaultLogSampling
+	c.LogSkipPaths = defaultLogSkipPaths
+	c.StatsDAddress = defaultStatsDAddress
+	c.InfluxWriteEnable = defaultInfluxWriteEnable
+	c.GraphiteAddress = defaultGraphiteAddress
+	c.GraphiteForwardAddress = defaultGraphiteForwardAddress
+	c.GraphiteForwardInterval = defaultGraphiteForwardInterval
+	c.GraphiteForwardPrefix = defaultGraphiteForwardPrefix
+	c.FederationUpstreams = defaultFederationUpstreams
+	c.FederationKeys = defaultFederationKeys
+	c.FederationInterval = defaultFederationInterval
+	c.Replicas = defaultReplicas
+	c.ReplicaEnable = defaultReplicaEnable
+	c.ScrapeTargets = defaultScrapeTargets
+	c.ScrapeInterval = defaultScrapeInterval
+	c.ScrapeTimeout = defaultScrapeTimeout
+	c.ScrapeIntervals = defaultScrapeIntervals
+	c.ScrapeTimeouts = defaultScrapeTimeouts
+	c.ConfigEndpoint = defaultConfigEndpoint
 }
 
 // WithKey sets the key in the ServerConfig.
@@ -100,7 +233,7 @@ func (c *ServerConfigBuilder) WithDSN(dsn string) *ServerConfigBuilder {
 }
 
 // WithStoreInterval sets the store interval in the ServerConfig.
-func (c *ServerConfigBuilder) WithStoreInterval(interval int) *ServerConfigBuilder {
+func (c *ServerConfigBuilder) WithStoreInterval(interval time.Duration) *ServerConfigBuilder {
 	c.Config.StoreInterval = interval
 	c.Config.StoreIntervalIsSet = true
 	if interval == 0 {
@@ -126,7 +259,7 @@ func (c *ServerConfigBuilder) WithStoreFilePath(path string) *ServerConfigBuilde
 // WithRestoreEnable sets the restore enable flag in the ServerConfig.
 func (c *ServerConfigBuilder) WithRestoreEnable(restore bool) *ServerConfigBuilder {
 	c.Config.RestoreEnable = restore
-	c.Config.RestoreEnvIsSet = true
+	c.Config.RestoreEnableIsSet = true
 	return c
 }
 
@@ -137,6 +270,244 @@ func (c *ServerConfigBuilder) WithCryptoKey(path string) *ServerConfigBuilder {
 	return c
 }
 
+// WithStrictAuth sets the flag requiring signatures on all mutating requests in the ServerConfig.
+func (c *ServerConfigBuilder) WithStrictAuth(strict bool) *ServerConfigBuilder {
+	c.Config.StrictAuth = strict
+	c.Config.StrictAuthIsSet = true
+	return c
+}
+
+// WithIPRateLimit sets the per-IP request rate limit in the ServerConfig.
+func (c *ServerConfigBuilder) WithIPRateLimit(rateLimit int) *ServerConfigBuilder {
+	c.Config.IPRateLimit = rateLimit
+	c.Config.IPRateLimitIsSet = true
+	return c
+}
+
+// WithKeyRateLimit sets the per-client request rate limit in the ServerConfig.
+func (c *ServerConfigBuilder) WithKeyRateLimit(rateLimit int) *ServerConfigBuilder {
+	c.Config.KeyRateLimit = rateLimit
+	c.Config.KeyRateLimitIsSet = true
+	return c
+}
+
+// WithRateLimitBurst sets the burst size of the rate limiters in the ServerConfig.
+func (c *ServerConfigBuilder) WithRateLimitBurst(burst int) *ServerConfigBuilder {
+	c.Config.RateLimitBurst = burst
+	c.Config.RateLimitBurstIsSet = true
+	return c
+}
+
+// WithMaxBodySize sets the max request body size before decompression in the ServerConfig.
+func (c *ServerConfigBuilder) WithMaxBodySize(size int) *ServerConfigBuilder {
+	c.Config.MaxBodySize = size
+	c.Config.MaxBodySizeIsSet = true
+	return c
+}
+
+// WithMaxDecompressedSize sets the max request body size after decompression in the ServerConfig.
+func (c *ServerConfigBuilder) WithMaxDecompressedSize(size int) *ServerConfigBuilder {
+	c.Config.MaxDecompressedSize = size
+	c.Config.MaxDecompressedSizeIsSet = true
+	return c
+}
+
+// WithMaxBatchSize sets the max number of metrics in a batch update in the ServerConfig.
+func (c *ServerConfigBuilder) WithMaxBatchSize(size int) *ServerConfigBuilder {
+	c.Config.MaxBatchSize = size
+	c.Config.MaxBatchSizeIsSet = true
+	return c
+}
+
+// WithCompressMinSize sets the min size of a compressed response in the ServerConfig.
+func (c *ServerConfigBuilder) WithCompressMinSize(size int) *ServerConfigBuilder {
+	c.Config.CompressMinSize = size
+	c.Config.CompressMinSizeIsSet = true
+	return c
+}
+
+// WithOTLPEndpoint sets the OTLP/HTTP traces endpoint in the ServerConfig.
+func (c *ServerConfigBuilder) WithOTLPEndpoint(endpoint string) *ServerConfigBuilder {
+	c.Config.OTLPEndpoint = endpoint
+	c.Config.OTLPEndpointIsSet = true
+	return c
+}
+
+// WithShutdownTimeout sets the time to finish in-flight work on shutdown in the ServerConfig.
+func (c *ServerConfigBuilder) WithShutdownTimeout(timeout time.Duration) *ServerConfigBuilder {
+	c.Config.ShutdownTimeout = timeout
+	c.Config.ShutdownTimeoutIsSet = true
+	return c
+}
+
+// WithLogLevel sets the minimal level of logged messages in the ServerConfig.
+func (c *ServerConfigBuilder) WithLogLevel(level string) *ServerConfigBuilder {
+	c.Config.LogLevel = level
+	c.Config.LogLevelIsSet = true
+	return c
+}
+
+// WithLogFormat sets the format of log entries in the ServerConfig.
+func (c *ServerConfigBuilder) WithLogFormat(format string) *ServerConfigBuilder {
+	c.Config.LogFormat = format
+	c.Config.LogFormatIsSet = true
+	return c
+}
+
+// WithLogFile sets the path to the log file in the ServerConfig.
+func (c *ServerConfigBuilder) WithLogFile(path string) *ServerConfigBuilder {
+	c.Config.LogFile = path
+	c.Config.LogFileIsSet = true
+	return c
+}
+
+// WithLogMaxSize sets the size at which the log file is rotated in the ServerConfig.
+func (c *ServerConfigBuilder) WithLogMaxSize(size int) *ServerConfigBuilder {
+	c.Config.LogMaxSize = size
+	c.Config.LogMaxSizeIsSet = true
+	return c
+}
+
+// WithLogMaxBackups sets the number of rotated log files to keep in the ServerConfig.
+func (c *ServerConfigBuilder) WithLogMaxBackups(backups int) *ServerConfigBuilder {
+	c.Config.LogMaxBackups = backups
+	c.Config.LogMaxBackupsIsSet = true
+	return c
+}
+
+// WithLogSampling sets the number of identical log entries per second logged before sampling starts in the ServerConfig.
+func (c *ServerConfigBuilder) WithLogSampling(n int) *ServerConfigBuilder {
+	c.Config.LogSampling = n
+	c.Config.LogSamplingIsSet = true
+	return c
+}
+
+// WithLogSkipPaths sets the request paths that are not logged in the ServerConfig.
+func (c *ServerConfigBuilder) WithLogSkipPaths(paths string) *ServerConfigBuilder {
+	c.Config.LogSkipPaths = paths
+	c.Config.LogSkipPathsIsSet = true
+	return c
+}
+
+// WithStatsDAddress sets the address of the StatsD UDP listener in the ServerConfig.
+func (c *ServerConfigBuilder) WithStatsDAddress(address string) *ServerConfigBuilder {
+	c.Config.StatsDAddress = address
+	c.Config.StatsDAddressIsSet = true
+	return c
+}
+
+// WithInfluxWriteEnable sets whether the InfluxDB line protocol is accepted on POST /write in the ServerConfig.
+func (c *ServerConfigBuilder) WithInfluxWriteEnable(enable bool) *ServerConfigBuilder {
+	c.Config.InfluxWriteEnable = enable
+	c.Config.InfluxWriteEnableIsSet = true
+	return c
+}
+
+// WithGraphiteAddress sets the address of the Graphite plaintext TCP listener in the ServerConfig.
+func (c *ServerConfigBuilder) WithGraphiteAddress(address string) *ServerConfigBuilder {
+	c.Config.GraphiteAddress = address
+	c.Config.GraphiteAddressIsSet = true
+	return c
+}
+
+// WithGraphiteForwardAddress sets the address of the Graphite endpoint all metrics are forwarded to in the ServerConfig.
+func (c *ServerConfigBuilder) WithGraphiteForwardAddress(address string) *ServerConfigBuilder {
+	c.Config.GraphiteForwardAddress = address
+	c.Config.GraphiteForwardAddressIsSet = true
+	return c
+}
+
+// WithGraphiteForwardInterval sets the time interval between forwarding metrics to Graphite in the ServerConfig.
+func (c *ServerConfigBuilder) WithGraphiteForwardInterval(interval time.Duration) *ServerConfigBuilder {
+	c.Config.GraphiteForwardInterval = interval
+	c.Config.GraphiteForwardIntervalIsSet = true
+	return c
+}
+
+// WithGraphiteForwardPrefix sets the prefix of the metric paths forwarded to Graphite in the ServerConfig.
+func (c *ServerConfigBuilder) WithGraphiteForwardPrefix(prefix string) *ServerConfigBuilder {
+	c.Config.GraphiteForwardPrefix = prefix
+	c.Config.GraphiteForwardPrefixIsSet = true
+	return c
+}
+
+// WithFederationUpstreams sets the upstream servers metrics are pulled from in the ServerConfig.
+func (c *ServerConfigBuilder) WithFederationUpstreams(upstreams string) *ServerConfigBuilder {
+	c.Config.FederationUpstreams = upstreams
+	c.Config.FederationUpstreamsIsSet = true
+	return c
+}
+
+// WithFederationKeys sets the signing keys of upstream servers in the ServerConfig.
+func (c *ServerConfigBuilder) WithFederationKeys(keys string) *ServerConfigBuilder {
+	c.Config.FederationKeys = keys
+	c.Config.FederationKeysIsSet = true
+	return c
+}
+
+// WithFederationInterval sets the time interval between pulling metrics from upstream servers in the ServerConfig.
+func (c *ServerConfigBuilder) WithFederationInterval(interval time.Duration) *ServerConfigBuilder {
+	c.Config.FederationInterval = interval
+	c.Config.FederationIntervalIsSet = true
+	return c
+}
+
+// WithReplicas sets the base URLs of the replicas updates are streamed to in the ServerConfig.
+func (c *ServerConfigBuilder) WithReplicas(replicas string) *ServerConfigBuilder {
+	c.Config.Replicas = replicas
+	c.Config.ReplicasIsSet = true
+	return c
+}
+
+// WithReplicaEnable sets whether the server is a read-only replica of a primary in the ServerConfig.
+func (c *ServerConfigBuilder) WithReplicaEnable(enable bool) *ServerConfigBuilder {
+	c.Config.ReplicaEnable = enable
+	c.Config.ReplicaEnableIsSet = true
+	return c
+}
+
+// WithScrapeTargets sets the agents metrics are scraped from in the ServerConfig.
+func (c *ServerConfigBuilder) WithScrapeTargets(targets string) *ServerConfigBuilder {
+	c.Config.ScrapeTargets = targets
+	c.Config.ScrapeTargetsIsSet = true
+	return c
+}
+
+// WithScrapeInterval sets the time interval between scrapes of a target in the ServerConfig.
+func (c *ServerConfigBuilder) WithScrapeInterval(interval time.Duration) *ServerConfigBuilder {
+	c.Config.ScrapeInterval = interval
+	c.Config.ScrapeIntervalIsSet = true
+	return c
+}
+
+// WithScrapeTimeout sets the timeout of a scrape in the ServerConfig.
+func (c *ServerConfigBuilder) WithScrapeTimeout(timeout time.Duration) *ServerConfigBuilder {
+	c.Config.ScrapeTimeout = timeout
+	c.Config.ScrapeTimeoutIsSet = true
+	return c
+}
+
+// WithScrapeIntervals sets the scrape intervals of individual targets in the ServerConfig.
+func (c *ServerConfigBuilder) WithScrapeIntervals(intervals string) *ServerConfigBuilder {
+	c.Config.ScrapeIntervals = intervals
+	c.Config.ScrapeIntervalsIsSet = true
+	return c
+}
+
+// WithScrapeTimeouts sets the scrape timeouts of individual targets in the ServerConfig.
+func (c *ServerConfigBuilder) WithScrapeTimeouts(timeouts string) *ServerConfigBuilder {
+	c.Config.ScrapeTimeouts = timeouts
+	c.Config.ScrapeTimeoutsIsSet = true
+	return c
+}
+
+// WithConfigEndpoint sets the flag serving the effective configuration on POST /config in the ServerConfig.
+func (c *ServerConfigBuilder) WithConfigEndpoint(enable bool) *ServerConfigBuilder {
+	c.Config.ConfigEndpoint = enable
+	c.Config.ConfigEndpointIsSet = true
+	return c
+}
+
 // WithConfigFile sets the path to JSON configuration file
 func (c *ServerConfigBuilder) WithConfigFile(configFilePath string) *ServerConfigBuilder {
 	c.Config.ConfigFilePath = configFilePath
@@ -145,39 +516,152 @@ func (c *ServerConfigBuilder) WithConfigFile(configFilePath string) *ServerConfi
 }
 
 // FromFlags populates the ServerConfig from command-line flags.
+// It is safe to call repeatedly.
 func (c *ServerConfigBuilder) FromFlags() *ServerConfigBuilder {
-	storeInterval := flags.CustomInt{}
-	flag.Var(&storeInterval, "i", "time interval between storing data to file in seconds")
+	if c.Err != nil {
+		return c
+	}
+	// A new flag set is used so that flags can be parsed again on config reload.
+	fs := flags.NewFlagSet()
+
+	storeInterval := flags.CustomDuration{}
+	fs.Var(&storeInterval, "i", "time interval between storing data to file, e.g. 300s or 5m, 0 stores synchronously")
 
 	addr := flags.CustomString{}
-	flag.Var(&addr, "a", "server host and port")
+	fs.Var(&addr, "a", "server host and port")
 
 	storeFilePath := flags.CustomString{}
-	flag.Var(&storeFilePath, "f", "path to storing data file")
+	fs.Var(&storeFilePath, "f", "path to storing data file")
 
 	restoreEnable := flags.CustomBool{}
-	flag.Var(&restoreEnable, "r", "is data restore from file enabled")
+	fs.Var(&restoreEnable, "r", "is data restore from file enabled")
 
 	dbAddress := flags.CustomString{}
-	flag.Var(&dbAddress, "d", "db address") //
+	fs.Var(&dbAddress, "d", "db address") //
 
 	key := flags.CustomString{}
-	flag.Var(&key, "k", "secret auth key")
+	fs.Var(&key, "k", "secret auth key")
 
 	cryptoKey := flags.CustomString{}
-	flag.Var(&cryptoKey, "crypto-key", "path to the file with private key")
+	fs.Var(&cryptoKey, "crypto-key", "path to the file with private key")
+
+	strictAuth := flags.CustomBool{}
+	fs.Var(&strictAuth, "strict-auth", "require signature on all mutating requests, cannot be combined with the StatsD and Graphite listeners")
+
+	ipRateLimit := flags.CustomInt{}
+	fs.Var(&ipRateLimit, "ip-rate-limit", "max requests per second from a single IP, 0 disables the limit")
+
+	keyRateLimit := flags.CustomInt{}
+	fs.Var(&keyRateLimit, "key-rate-limit", "max requests per second from a single client, identified by its verified signing key or its IP, 0 disables the limit")
+
+	rateLimitBurst := flags.CustomInt{}
+	fs.Var(&rateLimitBurst, "rate-limit-burst", "max burst of requests allowed by the rate limiters")
+
+	maxBodySize := flags.CustomInt{}
+	fs.Var(&maxBodySize, "max-body-size", "max request body size in bytes before decompression")
+
+	maxDecompressedSize := flags.CustomInt{}
+	fs.Var(&maxDecompressedSize, "max-decompressed-size", "max request body size in bytes after decompression")
+
+	maxBatchSize := flags.CustomInt{}
+	fs.Var(&maxBatchSize, "max-batch-size", "max number of metrics in a single batch update")
+
+	compressMinSize := flags.CustomInt{}
+	fs.Var(&compressMinSize, "compress-min-size", "min response size in bytes to be compressed")
+
+	otlpEndpoint := flags.CustomString{}
+	fs.Var(&otlpEndpoint, "otlp-endpoint", "OTLP/HTTP traces endpoint to export spans to, empty disables export")
+
+	shutdownTimeout := flags.CustomDuration{}
+	fs.Var(&shutdownTimeout, "shutdown-timeout", "time to finish in-flight work on shutdown, e.g. 10s")
+
+	logLevel := flags.CustomString{}
+	fs.Var(&logLevel, "log-level", "log level: debug, info, warn or error")
+
+	logFormat := flags.CustomString{}
+	fs.Var(&logFormat, "log-format", "log format: console or json")
+
+	logFile := flags.CustomString{}
+	fs.Var(&logFile, "log-file", "path to the log file, empty logs to stderr")
+
+	logMaxSize := flags.CustomInt{}
+	fs.Var(&logMaxSize, "log-max-size", "size in megabytes at which the log file is rotated")
+
+	logMaxBackups := flags.CustomInt{}
+	fs.Var(&logMaxBackups, "log-max-backups", "number of rotated log files to keep, 0 keeps all")
+
+	logSampling := flags.CustomInt{}
+	fs.Var(&logSampling, "log-sampling", "identical log entries per second logged before only every 100th of them is, 0 disables sampling")
+
+	logSkipPaths := flags.CustomString{}
+	fs.Var(&logSkipPaths, "log-skip-paths", "comma-separated request paths that are not logged")
+
+	statsDAddress := flags.CustomString{}
+	fs.Var(&statsDAddress, "statsd-address", "address of the StatsD UDP listener, empty disables it")
+
+	influxWriteEnable := flags.CustomBool{}
+	fs.Var(&influxWriteEnable, "influx-write", "accept the InfluxDB line protocol on POST /write")
+
+	graphiteAddress := flags.CustomString{}
+	fs.Var(&graphiteAddress, "graphite-address", "address of the Graphite plaintext TCP listener, empty disables it")
+
+	graphiteForwardAddress := flags.CustomString{}
+	fs.Var(&graphiteForwardAddress, "graphite-forward-address", "address of the Graphite endpoint all metrics are forwarded to, empty disables forwarding")
+
+	graphiteForwardInterval := flags.CustomDuration{}
+	fs.Var(&graphiteForwardInterval, "graphite-forward-interval", "time interval between forwarding metrics to Graphite, e.g. 10s or 1m")
+
+	graphiteForwardPrefix := flags.CustomString{}
+	fs.Var(&graphiteForwardPrefix, "graphite-forward-prefix", "prefix of the metric paths forwarded to Graphite, e.g. yametrics.")
+
+	federationUpstreams := flags.CustomString{}
+	fs.Var(&federationUpstreams, "federation-upstreams", "comma-separated upstream servers to pull metrics from as origin=URL, e.g. dc1=http://dc1:8080")
+
+	federationKeys := flags.CustomString{}
+	fs.Var(&federationKeys, "federation-keys", "comma-separated signing keys of upstream servers as origin=key, the server key is used for the others")
+
+	federationInterval := flags.CustomDuration{}
+	fs.Var(&federationInterval, "federation-interval", "time interval between pulling metrics from upstream servers, e.g. 30s or 1m")
+
+	replicas := flags.CustomString{}
+	fs.Var(&replicas, "replicas", "comma-separated base URLs of replicas to stream updates to, e.g. http://replica1:8080")
+
+	replicaEnable := flags.CustomBool{}
+	fs.Var(&replicaEnable, "replica", "serve as a read-only replica receiving updates from a primary until promoted")
+
+	scrapeTargets := flags.CustomString{}
+	fs.Var(&scrapeTargets, "scrape-targets", "comma-separated agents to scrape metrics from as name=URL, e.g. web1=http://web1:9101/metrics")
+
+	scrapeInterval := flags.CustomDuration{}
+	fs.Var(&scrapeInterval, "scrape-interval", "time interval between scrapes of a target, e.g. 15s")
+
+	scrapeTimeout := flags.CustomDuration{}
+	fs.Var(&scrapeTimeout, "scrape-timeout", "timeout of a scrape, e.g. 5s")
+
+	scrapeIntervals := flags.CustomString{}
+	fs.Var(&scrapeIntervals, "scrape-intervals", "comma-separated scrape intervals of individual targets as name=interval, e.g. web1=1m")
+
+	scrapeTimeouts := flags.CustomString{}
+	fs.Var(&scrapeTimeouts, "scrape-timeouts", "comma-separated scrape timeouts of individual targets as name=timeout, e.g. web1=10s")
+
+	configEndpoint := flags.CustomBool{}
+	fs.Var(&configEndpoint, "config-endpoint", "serve the effective configuration on POST /config to signed and stamped requests")
+
+	printConfig := fs.Bool("print-config", false, "print the effective configuration with the source of each value and exit")
 
 	configFilePath := flags.CustomString{}
-	flag.Var(&configFilePath, "c", "path to config file (shorthand)")
+	fs.Var(&configFilePath, "c", "path to config file (shorthand)")
 
 	configFilePathAlias := flags.CustomString{}
-	flag.Var(&configFilePathAlias, "config", "path to config file")
+	fs.Var(&configFilePathAlias, "config", "path to config file")
 
-	flag.Parse()
+	fs.Parse(os.Args[1:]) //nolint:all // errors exit the process
+	c.Config.PrintConfig = *printConfig
 
 	//Verifying if the flags were set properly
 	if configFilePath.IsSet && configFilePathAlias.IsSet {
-		log.Fatalf("usage of both shorthand and full flag (-c and --config)")
+		c.Err = errors.New("usage of both shorthand and full flag (-c and --config)")
+		return c
 	}
 
 	if !c.Config.ConfigFilePathIsSet {
@@ -213,66 +697,337 @@ func (c *ServerConfigBuilder) FromFlags() *ServerConfigBuilder {
 		c.WithStoreInterval(storeInterval.Value)
 	}
 
-	if !c.Config.RestoreEnvIsSet && restoreEnable.IsSet {
+	if !c.Config.RestoreEnableIsSet && restoreEnable.IsSet {
 		c.WithRestoreEnable(restoreEnable.Value)
 	}
+
+	if !c.Config.StrictAuthIsSet && strictAuth.IsSet {
+		c.WithStrictAuth(strictAuth.Value)
+	}
+
+	if !c.Config.IPRateLimitIsSet && ipRateLimit.IsSet {
+		c.WithIPRateLimit(ipRateLimit.Value)
+	}
+
+	if !c.Config.KeyRateLimitIsSet && keyRateLimit.IsSet {
+		c.WithKeyRateLimit(keyRateLimit.Value)
+	}
+
+	if !c.Config.RateLimitBurstIsSet && rateLimitBurst.IsSet {
+		c.WithRateLimitBurst(rateLimitBurst.Value)
+	}
+
+	if !c.Config.MaxBodySizeIsSet && maxBodySize.IsSet {
+		c.WithMaxBodySize(maxBodySize.Value)
+	}
+
+	if !c.Config.MaxDecompressedSizeIsSet && maxDecompressedSize.IsSet {
+		c.WithMaxDecompressedSize(maxDecompressedSize.Value)
+	}
+
+	if !c.Config.MaxBatchSizeIsSet && maxBatchSize.IsSet {
+		c.WithMaxBatchSize(maxBatchSize.Value)
+	}
+
+	if !c.Config.CompressMinSizeIsSet && compressMinSize.IsSet {
+		c.WithCompressMinSize(compressMinSize.Value)
+	}
+
+	if !c.Config.OTLPEndpointIsSet && otlpEndpoint.IsSet {
+		c.WithOTLPEndpoint(otlpEndpoint.Value)
+	}
+
+	if !c.Config.ShutdownTimeoutIsSet && shutdownTimeout.IsSet {
+		c.WithShutdownTimeout(shutdownTimeout.Value)
+	}
+
+	if !c.Config.LogLevelIsSet && logLevel.IsSet {
+		c.WithLogLevel(logLevel.Value)
+	}
+
+	if !c.Config.LogFormatIsSet && logFormat.IsSet {
+		c.WithLogFormat(logFormat.Value)
+	}
+
+	if !c.Config.LogFileIsSet && logFile.IsSet {
+		c.WithLogFile(logFile.Value)
+	}
+
+	if !c.Config.LogMaxSizeIsSet && logMaxSize.IsSet {
+		c.WithLogMaxSize(logMaxSize.Value)
+	}
+
+	if !c.Config.LogMaxBackupsIsSet && logMaxBackups.IsSet {
+		c.WithLogMaxBackups(logMaxBackups.Value)
+	}
+
+	if !c.Config.LogSamplingIsSet && logSampling.IsSet {
+		c.WithLogSampling(logSampling.Value)
+	}
+
+	if !c.Config.LogSkipPathsIsSet && logSkipPaths.IsSet {
+		c.WithLogSkipPaths(logSkipPaths.Value)
+	}
+
+	if !c.Config.StatsDAddressIsSet && statsDAddress.IsSet {
+		c.WithStatsDAddress(statsDAddress.Value)
+	}
+
+	if !c.Config.InfluxWriteEnableIsSet && influxWriteEnable.IsSet {
+		c.WithInfluxWriteEnable(influxWriteEnable.Value)
+	}
+
+	if !c.Config.GraphiteAddressIsSet && graphiteAddress.IsSet {
+		c.WithGraphiteAddress(graphiteAddress.Value)
+	}
+
+	if !c.Config.GraphiteForwardAddressIsSet && graphiteForwardAddress.IsSet {
+		c.WithGraphiteForwardAddress(graphiteForwardAddress.Value)
+	}
+
+	if !c.Config.GraphiteForwardIntervalIsSet && graphiteForwardInterval.IsSet {
+		c.WithGraphiteForwardInterval(graphiteForwardInterval.Value)
+	}
+
+	if !c.Config.GraphiteForwardPrefixIsSet && graphiteForwardPrefix.IsSet {
+		c.WithGraphiteForwardPrefix(graphiteForwardPrefix.Value)
+	}
+
+	if !c.Config.FederationUpstreamsIsSet && federationUpstreams.IsSet {
+		c.WithFederationUpstreams(federationUpstreams.Value)
+	}
+
+	if !c.Config.FederationKeysIsSet && federationKeys.IsSet {
+		c.WithFederationKeys(federationKeys.Value)
+	}
+
+	if !c.Config.FederationIntervalIsSet && federationInterval.IsSet {
+		c.WithFederationInterval(federationInterval.Value)
+	}
+
+	if !c.Config.ReplicasIsSet && replicas.IsSet {
+		c.WithReplicas(replicas.Value)
+	}
+
+	if !c.Config.ReplicaEnableIsSet && replicaEnable.IsSet {
+		c.WithReplicaEnable(replicaEnable.Value)
+	}
+
+	if !c.Config.ScrapeTargetsIsSet && scrapeTargets.IsSet {
+		c.WithScrapeTargets(scrapeTargets.Value)
+	}
+
+	if !c.Config.ScrapeIntervalIsSet && scrapeInterval.IsSet {
+		c.WithScrapeInterval(scrapeInterval.Value)
+	}
+
+	if !c.Config.ScrapeTimeoutIsSet && scrapeTimeout.IsSet {
+		c.WithScrapeTimeout(scrapeTimeout.Value)
+	}
+
+	if !c.Config.ScrapeIntervalsIsSet && scrapeIntervals.IsSet {
+		c.WithScrapeIntervals(scrapeIntervals.Value)
+	}
+
+	if !c.Config.ScrapeTimeoutsIsSet && scrapeTimeouts.IsSet {
+		c.WithScrapeTimeouts(scrapeTimeouts.Value)
+	}
+
+	if !c.Config.ConfigEndpointIsSet && configEndpoint.IsSet {
+		c.WithConfigEndpoint(configEndpoint.Value)
+	}
 	return c
 }
 
-// FromFile populates the ServerConfig from JSON .
+// FromFile populates the ServerConfig from the JSON, YAML or TOML config file.
+// Settings present in the file are applied unless they were set by environment variables or flags.
+// All invalid settings are reported together in Err.
 func (c *ServerConfigBuilder) FromFile() *ServerConfigBuilder {
-
-	if c.Config.ConfigFilePath == "" {
+	if c.Err != nil || c.Config.ConfigFilePath == "" {
 		return c
 	}
-	if err := k.Load(file.Provider(c.Config.ConfigFilePath), json.Parser()); err != nil {
-		log.Fatalf("error loading config: %v", err)
+	src, err := source.Load(c.Config.ConfigFilePath)
+	if err != nil {
+		c.Err = err
+		return c
 	}
-	JSONConfig := ServerConfig{}
-	JSONConfig.SetDefaults()
 
-	if err := config.ParseConfigFile(&JSONConfig, c.Config.ConfigFilePath); err != nil {
-		log.Fatalf("error parsing config: %v", err)
+	if key, ok := src.String("key"); ok && !c.Config.KeyIsSet {
+		c.WithKey(key)
 	}
 
-	if JSONConfig.Key != defaultKey && !c.Config.KeyIsSet {
-		c.WithKey(JSONConfig.Key)
+	if address, ok := src.String("address"); ok && src.Check("address", validateAddress(address)) && !c.Config.AddressIsSet {
+		c.WithAddress(address)
 	}
 
-	if JSONConfig.Address != defaultAddress && !c.Config.AddressIsSet {
-		c.WithAddress(JSONConfig.Address)
+	if dsn, ok := src.String("database_dsn"); ok && !c.Config.DBAddressIsSet {
+		c.WithDSN(dsn)
 	}
 
-	if JSONConfig.DBAddress != defaultDBAddress && !c.Config.DBAddressIsSet {
-		c.WithDSN(JSONConfig.DBAddress)
+	if path, ok := src.String("store_file"); ok && !c.Config.StoreFilePathIsSet {
+		c.WithStoreFilePath(path)
 	}
 
-	if JSONConfig.StoreFilePath != defaultStoreFilePath && !c.Config.StoreFilePathIsSet {
-		c.WithStoreFilePath(JSONConfig.StoreFilePath)
+	if interval, ok := src.Duration("store_interval"); ok && src.Check("store_interval", nonNegative(interval)) && !c.Config.StoreIntervalIsSet {
+		c.WithStoreInterval(interval)
 	}
 
-	if JSONConfig.StoreIntervalString != "" && !c.Config.StoreIntervalIsSet {
-		storeInterval, err := util.CutSeconds(JSONConfig.StoreIntervalString)
-		if err != nil {
-			log.Fatal(err)
-		}
-		c.WithStoreInterval(storeInterval)
+	if path, ok := src.String("crypto_key"); ok && !c.Config.CryptoKeyIsSet {
+		c.WithCryptoKey(path)
+	}
+
+	if restore, ok := src.Bool("restore"); ok && !c.Config.RestoreEnableIsSet {
+		c.WithRestoreEnable(restore)
+	}
+
+	if strict, ok := src.Bool("strict_auth"); ok && !c.Config.StrictAuthIsSet {
+		c.WithStrictAuth(strict)
+	}
+
+	if limit, ok := src.Int("ip_rate_limit"); ok && src.Check("ip_rate_limit", nonNegative(limit)) && !c.Config.IPRateLimitIsSet {
+		c.WithIPRateLimit(limit)
+	}
+
+	if limit, ok := src.Int("key_rate_limit"); ok && src.Check("key_rate_limit", nonNegative(limit)) && !c.Config.KeyRateLimitIsSet {
+		c.WithKeyRateLimit(limit)
+	}
+
+	if burst, ok := src.Int("rate_limit_burst"); ok && src.Check("rate_limit_burst", nonNegative(burst)) && !c.Config.RateLimitBurstIsSet {
+		c.WithRateLimitBurst(burst)
+	}
+
+	if size, ok := src.Int("max_body_size"); ok && src.Check("max_body_size", nonNegative(size)) && !c.Config.MaxBodySizeIsSet {
+		c.WithMaxBodySize(size)
+	}
+
+	if size, ok := src.Int("max_decompressed_size"); ok && src.Check("max_decompressed_size", nonNegative(size)) && !c.Config.MaxDecompressedSizeIsSet {
+		c.WithMaxDecompressedSize(size)
+	}
+
+	if size, ok := src.Int("max_batch_size"); ok && src.Check("max_batch_size", nonNegative(size)) && !c.Config.MaxBatchSizeIsSet {
+		c.WithMaxBatchSize(size)
+	}
+
+	if size, ok := src.Int("compress_min_size"); ok && src.Check("compress_min_size", nonNegative(size)) && !c.Config.CompressMinSizeIsSet {
+		c.WithCompressMinSize(size)
+	}
+
+	if endpoint, ok := src.String("otlp_endpoint"); ok && !c.Config.OTLPEndpointIsSet {
+		c.WithOTLPEndpoint(endpoint)
+	}
+
+	if timeout, ok := src.Duration("shutdown_timeout"); ok && src.Check("shutdown_timeout", nonNegative(timeout)) && !c.Config.ShutdownTimeoutIsSet {
+		c.WithShutdownTimeout(timeout)
+	}
+
+	if level, ok := src.String("log_level"); ok && src.Check("log_level", validateLogLevel(level)) && !c.Config.LogLevelIsSet {
+		c.WithLogLevel(level)
+	}
+
+	if format, ok := src.String("log_format"); ok && src.Check("log_format", validateLogFormat(format)) && !c.Config.LogFormatIsSet {
+		c.WithLogFormat(format)
+	}
+
+	if path, ok := src.String("log_file"); ok && !c.Config.LogFileIsSet {
+		c.WithLogFile(path)
+	}
+
+	if size, ok := src.Int("log_max_size"); ok && src.Check("log_max_size", positive(size)) && !c.Config.LogMaxSizeIsSet {
+		c.WithLogMaxSize(size)
+	}
+
+	if backups, ok := src.Int("log_max_backups"); ok && src.Check("log_max_backups", nonNegative(backups)) && !c.Config.LogMaxBackupsIsSet {
+		c.WithLogMaxBackups(backups)
+	}
+
+	if n, ok := src.Int("log_sampling"); ok && src.Check("log_sampling", nonNegative(n)) && !c.Config.LogSamplingIsSet {
+		c.WithLogSampling(n)
+	}
+
+	if paths, ok := src.String("log_skip_paths"); ok && !c.Config.LogSkipPathsIsSet {
+		c.WithLogSkipPaths(paths)
+	}
+
+	if address, ok := src.String("statsd_address"); ok && src.Check("statsd_address", validateOptionalAddress(address)) && !c.Config.StatsDAddressIsSet {
+		c.WithStatsDAddress(address)
+	}
+
+	if enable, ok := src.Bool("influx_write"); ok && !c.Config.InfluxWriteEnableIsSet {
+		c.WithInfluxWriteEnable(enable)
+	}
+
+	if address, ok := src.String("graphite_address"); ok && src.Check("graphite_address", validateOptionalAddress(address)) && !c.Config.GraphiteAddressIsSet {
+		c.WithGraphiteAddress(address)
+	}
+
+	if address, ok := src.String("graphite_forward_address"); ok && src.Check("graphite_forward_address", validateOptionalAddress(address)) && !c.Config.GraphiteForwardAddressIsSet {
+		c.WithGraphiteForwardAddress(address)
+	}
+
+	if interval, ok := src.Duration("graphite_forward_interval"); ok && src.Check("graphite_forward_interval", positive(interval)) && !c.Config.GraphiteForwardIntervalIsSet {
+		c.WithGraphiteForwardInterval(interval)
+	}
+
+	if prefix, ok := src.String("graphite_forward_prefix"); ok && !c.Config.GraphiteForwardPrefixIsSet {
+		c.WithGraphiteForwardPrefix(prefix)
+	}
+
+	if upstreams, ok := src.List("federation_upstreams"); ok && src.Check("federation_upstreams", validateUpstreams(upstreams)) && !c.Config.FederationUpstreamsIsSet {
+		c.WithFederationUpstreams(upstreams)
+	}
+
+	if keys, ok := src.List("federation_keys"); ok && !c.Config.FederationKeysIsSet {
+		c.WithFederationKeys(keys)
+	}
+
+	if interval, ok := src.Duration("federation_interval"); ok && src.Check("federation_interval", positive(interval)) && !c.Config.FederationIntervalIsSet {
+		c.WithFederationInterval(interval)
 	}
 
-	if JSONConfig.CryptoKey != defaultCryptoKey && !c.Config.CryptoKeyIsSet {
-		c.WithCryptoKey(JSONConfig.CryptoKey)
+	if replicas, ok := src.List("replicas"); ok && src.Check("replicas", validateReplicas(replicas)) && !c.Config.ReplicasIsSet {
+		c.WithReplicas(replicas)
 	}
 
-	if !JSONConfig.RestoreEnable && defaultRestoreEnable && !c.Config.RestoreEnvIsSet { //nolint:all
-		c.WithRestoreEnable(JSONConfig.RestoreEnable)
+	if enable, ok := src.Bool("replica"); ok && !c.Config.ReplicaEnableIsSet {
+		c.WithReplicaEnable(enable)
 	}
+
+	if targets, ok := src.List("scrape_targets"); ok && src.Check("scrape_targets", validateTargets(targets)) && !c.Config.ScrapeTargetsIsSet {
+		c.WithScrapeTargets(targets)
+	}
+
+	if interval, ok := src.Duration("scrape_interval"); ok && src.Check("scrape_interval", positive(interval)) && !c.Config.ScrapeIntervalIsSet {
+		c.WithScrapeInterval(interval)
+	}
+
+	if timeout, ok := src.Duration("scrape_timeout"); ok && src.Check("scrape_timeout", positive(timeout)) && !c.Config.ScrapeTimeoutIsSet {
+		c.WithScrapeTimeout(timeout)
+	}
+
+	if intervals, ok := src.List("scrape_intervals"); ok && src.Check("scrape_intervals", validateOverrides(intervals)) && !c.Config.ScrapeIntervalsIsSet {
+		c.WithScrapeIntervals(intervals)
+	}
+
+	if timeouts, ok := src.List("scrape_timeouts"); ok && src.Check("scrape_timeouts", validateOverrides(timeouts)) && !c.Config.ScrapeTimeoutsIsSet {
+		c.WithScrapeTimeouts(timeouts)
+	}
+
+	if enable, ok := src.Bool("config_endpoint"); ok && !c.Config.ConfigEndpointIsSet {
+		c.WithConfigEndpoint(enable)
+	}
+
+	c.Err = src.Err()
 	return c
 }
 
 // FromEnv populates the ServerConfig from environment variables.
 func (c *ServerConfigBuilder) FromEnv() *ServerConfigBuilder {
-	if err := env.Parse(&c.Config); err != nil {
-		log.Fatal(err)
+	if c.Err != nil {
+		return c
+	}
+	if err := env.ParseWithFuncs(&c.Config, flags.EnvParsers()); err != nil {
+		c.Err = err
+		return c
 	}
 	_, addressIsSet := os.LookupEnv("ADDRESS")
 	if addressIsSet {
@@ -300,24 +1055,176 @@ func (c *ServerConfigBuilder) FromEnv() *ServerConfigBuilder {
 	}
 	_, restoreSet := os.LookupEnv("RESTORE")
 	if restoreSet {
-		c.Config.RestoreEnvIsSet = true
+		c.Config.RestoreEnableIsSet = true
 	}
 	_, DSNSet := os.LookupEnv("DATABASE_DSN")
 	if DSNSet {
 		c.Config.DBAddressIsSet = true
 	}
+	_, strictAuthSet := os.LookupEnv("STRICT_AUTH")
+	if strictAuthSet {
+		c.Config.StrictAuthIsSet = true
+	}
+	_, ipRateLimitSet := os.LookupEnv("IP_RATE_LIMIT")
+	if ipRateLimitSet {
+		c.Config.IPRateLimitIsSet = true
+	}
+	_, keyRateLimitSet := os.LookupEnv("KEY_RATE_LIMIT")
+	if keyRateLimitSet {
+		c.Config.KeyRateLimitIsSet = true
+	}
+	_, rateLimitBurstSet := os.LookupEnv("RATE_LIMIT_BURST")
+	if rateLimitBurstSet {
+		c.Config.RateLimitBurstIsSet = true
+	}
+	_, maxBodySizeSet := os.LookupEnv("MAX_BODY_SIZE")
+	if maxBodySizeSet {
+		c.Config.MaxBodySizeIsSet = true
+	}
+	_, maxDecompressedSizeSet := os.LookupEnv("MAX_DECOMPRESSED_SIZE")
+	if maxDecompressedSizeSet {
+		c.Config.MaxDecompressedSizeIsSet = true
+	}
+	_, maxBatchSizeSet := os.LookupEnv("MAX_BATCH_SIZE")
+	if maxBatchSizeSet {
+		c.Config.MaxBatchSizeIsSet = true
+	}
+	_, compressMinSizeSet := os.LookupEnv("COMPRESS_MIN_SIZE")
+	if compressMinSizeSet {
+		c.Config.CompressMinSizeIsSet = true
+	}
+	_, otlpEndpointSet := os.LookupEnv("OTLP_ENDPOINT")
+	if otlpEndpointSet {
+		c.Config.OTLPEndpointIsSet = true
+	}
+	_, shutdownTimeoutSet := os.LookupEnv("SHUTDOWN_TIMEOUT")
+	if shutdownTimeoutSet {
+		c.Config.ShutdownTimeoutIsSet = true
+	}
+	_, logLevelSet := os.LookupEnv("LOG_LEVEL")
+	if logLevelSet {
+		c.Config.LogLevelIsSet = true
+	}
+	_, logFormatSet := os.LookupEnv("LOG_FORMAT")
+	if logFormatSet {
+		c.Config.LogFormatIsSet = true
+	}
+	_, logFileSet := os.LookupEnv("LOG_FILE")
+	if logFileSet {
+		c.Config.LogFileIsSet = true
+	}
+	_, logMaxSizeSet := os.LookupEnv("LOG_MAX_SIZE")
+	if logMaxSizeSet {
+		c.Config.LogMaxSizeIsSet = true
+	}
+	_, logMaxBackupsSet := os.LookupEnv("LOG_MAX_BACKUPS")
+	if logMaxBackupsSet {
+		c.Config.LogMaxBackupsIsSet = true
+	}
+	_, logSamplingSet := os.LookupEnv("LOG_SAMPLING")
+	if logSamplingSet {
+		c.Config.LogSamplingIsSet = true
+	}
+	_, logSkipPathsSet := os.LookupEnv("LOG_SKIP_PATHS")
+	if logSkipPathsSet {
+		c.Config.LogSkipPathsIsSet = true
+	}
+	_, statsDAddressSet := os.LookupEnv("STATSD_ADDRESS")
+	if statsDAddressSet {
+		c.Config.StatsDAddressIsSet = true
+	}
+	_, influxWriteEnableSet := os.LookupEnv("INFLUX_WRITE")
+	if influxWriteEnableSet {
+		c.Config.InfluxWriteEnableIsSet = true
+	}
+	_, graphiteAddressSet := os.LookupEnv("GRAPHITE_ADDRESS")
+	if graphiteAddressSet {
+		c.Config.GraphiteAddressIsSet = true
+	}
+	_, graphiteForwardAddressSet := os.LookupEnv("GRAPHITE_FORWARD_ADDRESS")
+	if graphiteForwardAddressSet {
+		c.Config.GraphiteForwardAddressIsSet = true
+	}
+	_, graphiteForwardIntervalSet := os.LookupEnv("GRAPHITE_FORWARD_INTERVAL")
+	if graphiteForwardIntervalSet {
+		c.Config.GraphiteForwardIntervalIsSet = true
+	}
+	_, graphiteForwardPrefixSet := os.LookupEnv("GRAPHITE_FORWARD_PREFIX")
+	if graphiteForwardPrefixSet {
+		c.Config.GraphiteForwardPrefixIsSet = true
+	}
+	_, federationUpstreamsSet := os.LookupEnv("FEDERATION_UPSTREAMS")
+	if federationUpstreamsSet {
+		c.Config.FederationUpstreamsIsSet = true
+	}
+	_, federationKeysSet := os.LookupEnv("FEDERATION_KEYS")
+	if federationKeysSet {
+		c.Config.FederationKeysIsSet = true
+	}
+	_, federationIntervalSet := os.LookupEnv("FEDERATION_INTERVAL")
+	if federationIntervalSet {
+		c.Config.FederationIntervalIsSet = true
+	}
+	_, replicasSet := os.LookupEnv("REPLICAS")
+	if replicasSet {
+		c.Config.ReplicasIsSet = true
+	}
+	_, replicaEnableSet := os.LookupEnv("REPLICA")
+	if replicaEnableSet {
+		c.Config.ReplicaEnableIsSet = true
+	}
+	_, scrapeTargetsSet := os.LookupEnv("SCRAPE_TARGETS")
+	if scrapeTargetsSet {
+		c.Config.ScrapeTargetsIsSet = true
+	}
+	_, scrapeIntervalSet := os.LookupEnv("SCRAPE_INTERVAL")
+	if scrapeIntervalSet {
+		c.Config.ScrapeIntervalIsSet = true
+	}
+	_, scrapeTimeoutSet := os.LookupEnv("SCRAPE_TIMEOUT")
+	if scrapeTimeoutSet {
+		c.Config.ScrapeTimeoutIsSet = true
+	}
+	_, scrapeIntervalsSet := os.LookupEnv("SCRAPE_INTERVALS")
+	if scrapeIntervalsSet {
+		c.Config.ScrapeIntervalsIsSet = true
+	}
+	_, scrapeTimeoutsSet := os.LookupEnv("SCRAPE_TIMEOUTS")
+	if scrapeTimeoutsSet {
+		c.Config.ScrapeTimeoutsIsSet = true
+	}
+	_, configEndpointSet := os.LookupEnv("CONFIG_ENDPOINT")
+	if configEndpointSet {
+		c.Config.ConfigEndpointIsSet = true
+	}
+	return c
+}
+
+// track records source for the settings set by the last step.
+func (c *ServerConfigBuilder) track(source string) *ServerConfigBuilder {
+	if c.Err != nil {
+		return c
+	}
+	if c.Config.Sources == nil {
+		c.Config.Sources = make(settings.Sources)
+	}
+	c.Config.Sources.Track(&c.Config, source)
 	return c
 }
 
 // GetConfigs returns the fully constructed ServerConfig by combining
-// configurations from environment variables and command-line flags.
-// It validates the address to ensure it is properly set.
+// configurations from environment variables, command-line flags and config file,
+// in that order of precedence, and validates the result.
+// It can be called again to reload the configuration, e.g. on SIGHUP.
 func GetConfigs() (ServerConfig, error) {
 	var c ServerConfigBuilder
 	c.Config.SetDefaults()
-	c.FromEnv().FromFlags().FromFile()
-	if !util.ValidateAddress(c.Config.Address) {
-		return ServerConfig{}, errors.New("need address in a form host:port")
+	c.FromEnv().track(settings.Env).FromFlags().track(settings.Flag).FromFile().track(settings.File)
+	if c.Err != nil {
+		return ServerConfig{}, c.Err
+	}
+	if err := c.Config.Validate(); err != nil {
+		return ServerConfig{}, err
 	}
 	return c.Config, nil
 }
@@ -330,3 +1237,8 @@ func GetTestConfig() (ServerConfig, error) {
 	}
 	return c.Config, nil
 }
+
+// Settings returns the effective settings with their sources, secrets are redacted.
+func (c ServerConfig) Settings() []settings.Setting {
+	return settings.List(c, c.Sources)
+}
@@ -0,0 +1,101 @@
+package config
+
+// Reload returns the configuration to put in use when next has been loaded while c is running.
+// Settings that only take effect at startup keep their current values; the names of those
+// that differ in next are returned so that the caller can report them.
+func (c ServerConfig) Reload(next ServerConfig) (ServerConfig, []string) {
+	applied := next
+	var ignored []string
+	keep := func(name string, changed bool, restore func()) {
+		if changed {
+			ignored = append(ignored, name)
+			restore()
+			applied.Sources = applied.Sources.With(name, c.Sources[name])
+		}
+	}
+
+	keep("address", next.Address != c.Address, func() {
+		applied.Address, applied.AddressIsSet = c.Address, c.AddressIsSet
+	})
+	keep("database_dsn", next.DBAddress != c.DBAddress, func() {
+		applied.DBAddress, applied.DBAddressIsSet, applied.DBEnable = c.DBAddress, c.DBAddressIsSet, c.DBEnable
+	})
+	keep("store_file", next.StoreFilePath != c.StoreFilePath, func() {
+		applied.StoreFilePath, applied.StoreFilePathIsSet, applied.StoreEnable = c.StoreFilePath, c.StoreFilePathIsSet, c.StoreEnable
+	})
+	// Switching between synchronous and periodic storing requires a restart, changing the period does not.
+	keep("store_interval", next.SyncStoreEnable != c.SyncStoreEnable, func() {
+		applied.StoreInterval, applied.StoreIntervalIsSet, applied.SyncStoreEnable = c.StoreInterval, c.StoreIntervalIsSet, c.SyncStoreEnable
+	})
+	keep("restore", next.RestoreEnable != c.RestoreEnable, func() {
+		applied.RestoreEnable, applied.RestoreEnableIsSet = c.RestoreEnable, c.RestoreEnableIsSet
+	})
+	keep("config", next.ConfigFilePath != c.ConfigFilePath, func() {
+		applied.ConfigFilePath, applied.ConfigFilePathIsSet = c.ConfigFilePath, c.ConfigFilePathIsSet
+	})
+	keep("otlp_endpoint", next.OTLPEndpoint != c.OTLPEndpoint, func() {
+		applied.OTLPEndpoint, applied.OTLPEndpointIsSet = c.OTLPEndpoint, c.OTLPEndpointIsSet
+	})
+	keep("statsd_address", next.StatsDAddress != c.StatsDAddress, func() {
+		applied.StatsDAddress, applied.StatsDAddressIsSet = c.StatsDAddress, c.StatsDAddressIsSet
+	})
+	keep("graphite_address", next.GraphiteAddress != c.GraphiteAddress, func() {
+		applied.GraphiteAddress, applied.GraphiteAddressIsSet = c.GraphiteAddress, c.GraphiteAddressIsSet
+	})
+	keep("graphite_forward_address", next.GraphiteForwardAddress != c.GraphiteForwardAddress, func() {
+		applied.GraphiteForwardAddress, applied.GraphiteForwardAddressIsSet = c.GraphiteForwardAddress, c.GraphiteForwardAddressIsSet
+	})
+	keep("graphite_forward_interval", next.GraphiteForwardInterval != c.GraphiteForwardInterval, func() {
+		applied.GraphiteForwardInterval, applied.GraphiteForwardIntervalIsSet = c.GraphiteForwardInterval, c.GraphiteForwardIntervalIsSet
+	})
+	keep("graphite_forward_prefix", next.GraphiteForwardPrefix != c.GraphiteForwardPrefix, func() {
+		applied.GraphiteForwardPrefix, applied.GraphiteForwardPrefixIsSet = c.GraphiteForwardPrefix, c.GraphiteForwardPrefixIsSet
+	})
+	keep("federation_upstreams", next.FederationUpstreams != c.FederationUpstreams, func() {
+		applied.FederationUpstreams, applied.FederationUpstreamsIsSet = c.FederationUpstreams, c.FederationUpstreamsIsSet
+	})
+	keep("federation_keys", next.FederationKeys != c.FederationKeys, func() {
+		applied.FederationKeys, applied.FederationKeysIsSet = c.FederationKeys, c.FederationKeysIsSet
+	})
+	keep("federation_interval", next.FederationInterval != c.FederationInterval, func() {
+		applied.FederationInterval, applied.FederationIntervalIsSet = c.FederationInterval, c.FederationIntervalIsSet
+	})
+	keep("replicas", next.Replicas != c.Replicas, func() {
+		applied.Replicas, applied.ReplicasIsSet = c.Replicas, c.ReplicasIsSet
+	})
+	keep("replica", next.ReplicaEnable != c.ReplicaEnable, func() {
+		applied.ReplicaEnable, applied.ReplicaEnableIsSet = c.ReplicaEnable, c.ReplicaEnableIsSet
+	})
+	keep("scrape_targets", next.ScrapeTargets != c.ScrapeTargets, func() {
+		applied.ScrapeTargets, applied.ScrapeTargetsIsSet = c.ScrapeTargets, c.ScrapeTargetsIsSet
+	})
+	keep("scrape_interval", next.ScrapeInterval != c.ScrapeInterval, func() {
+		applied.ScrapeInterval, applied.ScrapeIntervalIsSet = c.ScrapeInterval, c.ScrapeIntervalIsSet
+	})
+	keep("scrape_timeout", next.ScrapeTimeout != c.ScrapeTimeout, func() {
+		applied.ScrapeTimeout, applied.ScrapeTimeoutIsSet = c.ScrapeTimeout, c.ScrapeTimeoutIsSet
+	})
+	keep("scrape_intervals", next.ScrapeIntervals != c.ScrapeIntervals, func() {
+		applied.ScrapeIntervals, applied.ScrapeIntervalsIsSet = c.ScrapeIntervals, c.ScrapeIntervalsIsSet
+	})
+	keep("scrape_timeouts", next.ScrapeTimeouts != c.ScrapeTimeouts, func() {
+		applied.ScrapeTimeouts, applied.ScrapeTimeoutsIsSet = c.ScrapeTimeouts, c.ScrapeTimeoutsIsSet
+	})
+	// The log level is applied on reload, the rest of the log settings require building a new logger.
+	keep("log_format", next.LogFormat != c.LogFormat, func() {
+		applied.LogFormat, applied.LogFormatIsSet = c.LogFormat, c.LogFormatIsSet
+	})
+	keep("log_file", next.LogFile != c.LogFile, func() {
+		applied.LogFile, applied.LogFileIsSet = c.LogFile, c.LogFileIsSet
+	})
+	keep("log_max_size", next.LogMaxSize != c.LogMaxSize, func() {
+		applied.LogMaxSize, applied.LogMaxSizeIsSet = c.LogMaxSize, c.LogMaxSizeIsSet
+	})
+	keep("log_max_backups", next.LogMaxBackups != c.LogMaxBackups, func() {
+		applied.LogMaxBackups, applied.LogMaxBackupsIsSet = c.LogMaxBackups, c.LogMaxBackupsIsSet
+	})
+	keep("log_sampling", next.LogSampling != c.LogSampling, func() {
+		applied.LogSampling, applied.LogSamplingIsSet = c.LogSampling, c.LogSamplingIsSet
+	})
+	return applied, ignored
+}
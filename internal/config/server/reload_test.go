@@ -0,0 +1,73 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mrkovshik/yametrics/internal/config/settings"
+)
+
+func TestGetConfigs_Rerun(t *testing.T) {
+	t.Setenv("KEY", "first")
+	first, err := GetConfigs()
+	require.NoError(t, err)
+	require.Equal(t, "first", first.Key)
+
+	t.Setenv("KEY", "second")
+	t.Setenv("LOG_LEVEL", "warn")
+	second, err := GetConfigs()
+	require.NoError(t, err)
+	require.Equal(t, "second", second.Key)
+	require.Equal(t, "warn", second.LogLevel)
+
+	t.Setenv("LOG_LEVEL", "loud")
+	_, err = GetConfigs()
+	require.Error(t, err)
+}
+
+func TestServerConfig_Reload(t *testing.T) {
+	var current ServerConfig
+	current.SetDefaults()
+	current.StoreInterval = 5 * time.Minute
+
+	t.Run("reloadable settings are applied", func(t *testing.T) {
+		next := current
+		next.Key = "secret"
+		next.IPRateLimit = 10
+		next.StoreInterval = 500 * time.Millisecond
+		next.LogLevel = "error"
+		next.InfluxWriteEnable = true
+
+		applied, ignored := current.Reload(next)
+		require.Empty(t, ignored)
+		require.Equal(t, next, applied)
+	})
+
+	t.Run("startup settings are kept and reported", func(t *testing.T) {
+		next := current
+		next.Address = "localhost:9090"
+		next.DBAddress = "postgres://localhost/metrics"
+		next.DBEnable = true
+		next.StoreInterval = 0
+		next.SyncStoreEnable = true
+		next.Key = "secret"
+		next.LogFormat = "json"
+		next.StatsDAddress = "localhost:8125"
+		next.Sources = settings.Sources{"address": settings.Flag, "key": settings.Env}
+
+		applied, ignored := current.Reload(next)
+		require.ElementsMatch(t, []string{"address", "database_dsn", "store_interval", "log_format", "statsd_address"}, ignored)
+		require.Equal(t, current.Address, applied.Address)
+		require.Equal(t, current.DBAddress, applied.DBAddress)
+		require.False(t, applied.DBEnable)
+		require.Equal(t, 5*time.Minute, applied.StoreInterval)
+		require.False(t, applied.SyncStoreEnable)
+		require.Equal(t, "secret", applied.Key)
+		require.Equal(t, "console", applied.LogFormat)
+		require.Empty(t, applied.StatsDAddress)
+		require.Equal(t, settings.Sources{"key": settings.Env}, applied.Sources, "kept settings keep their sources")
+		require.Equal(t, settings.Flag, next.Sources["address"])
+	})
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mrkovshik/yametrics/internal/config/source"
+)
+
+// ReplicaURLs returns the base URLs of the replicas updates are streamed to.
+func (c ServerConfig) ReplicaURLs() []string {
+	urls, _ := parseReplicas(c.Replicas)
+	return urls
+}
+
+func parseReplicas(list string) ([]string, error) {
+	var urls []string
+	seen := make(map[string]bool)
+	entries, err := source.Entries(list, func(rawURL string) string { return rawURL })
+	if err != nil {
+		return nil, err
+	}
+	for _, rawURL := range entries {
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid replica URL %q, need http(s)://host:port", rawURL)
+		}
+		rawURL = strings.TrimSuffix(rawURL, "/")
+		if seen[rawURL] {
+			return nil, fmt.Errorf("duplicate replica %q", rawURL)
+		}
+		seen[rawURL] = true
+		urls = append(urls, rawURL)
+	}
+	return urls, nil
+}
+
+func validateReplicas(list string) error {
+	_, err := parseReplicas(list)
+	return err
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mrkovshik/yametrics/internal/config/source"
+)
+
+// ScrapeTarget is an agent metrics are scraped from in the pull mode.
+type ScrapeTarget struct {
+	Name     string        // Value of the instance label of the metrics scraped from the agent
+	URL      string        // URL of the metrics endpoint of the agent
+	Interval time.Duration // Time between scrapes
+	Timeout  time.Duration // Timeout of a scrape
+}
+
+// Targets returns the configured scrape targets. Targets without an interval or a timeout of
+// their own use scrape_interval and scrape_timeout.
+func (c ServerConfig) Targets() []ScrapeTarget {
+	targets, _ := parseTargets(c.ScrapeTargets, c.ScrapeIntervals, c.ScrapeTimeouts, c.ScrapeInterval, c.ScrapeTimeout)
+	return targets
+}
+
+// targetEntry is a scrape target in a structured list.
+type targetEntry struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+func parseTargets(list, intervals, timeouts string, interval, timeout time.Duration) ([]ScrapeTarget, error) {
+	var targets []ScrapeTarget
+	index := make(map[string]int)
+	entries, err := source.Entries(list, func(t targetEntry) string { return t.Name + "=" + t.URL })
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name, rawURL, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid target %q, use name=URL", entry)
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid URL of target %q, need http(s)://host:port/path", name)
+		}
+		if _, ok := index[name]; ok {
+			return nil, fmt.Errorf("duplicate target %q", name)
+		}
+		index[name] = len(targets)
+		targets = append(targets, ScrapeTarget{Name: name, URL: rawURL, Interval: interval, Timeout: timeout})
+	}
+	for _, override := range []struct {
+		list string
+		set  func(t *ScrapeTarget, d time.Duration)
+	}{
+		{intervals, func(t *ScrapeTarget, d time.Duration) { t.Interval = d }},
+		{timeouts, func(t *ScrapeTarget, d time.Duration) { t.Timeout = d }},
+	} {
+		durations, err := parseOverrides(override.list)
+		if err != nil {
+			return nil, err
+		}
+		for name, d := range durations {
+			i, ok := index[name]
+			if !ok {
+				return nil, fmt.Errorf("setting for unknown target %q", name)
+			}
+			override.set(&targets[i], d)
+		}
+	}
+	return targets, nil
+}
+
+// parseOverrides parses the durations of individual targets given as name=duration.
+func parseOverrides(list string) (map[string]time.Duration, error) {
+	durations := make(map[string]time.Duration)
+	entries, err := source.Pairs(list)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid setting %q, use name=duration", entry)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid duration %q of target %q", value, name)
+		}
+		durations[name] = d
+	}
+	return durations, nil
+}
+
+func validateTargets(list string) error {
+	_, err := parseTargets(list, "", "", 0, 0)
+	return err
+}
+
+func validateOverrides(list string) error {
+	_, err := parseOverrides(list)
+	return err
+}
+
+// validateTargetOverrides checks the settings of individual targets against valid targets,
+// invalid targets are reported on their own.
+func validateTargetOverrides(targets, list string) error {
+	if err := validateOverrides(list); err != nil || validateTargets(targets) != nil {
+		return err
+	}
+	_, err := parseTargets(targets, list, "", 0, 0)
+	return err
+}
+
+// validateScrapeTimeouts checks that no scrape may take longer than the interval between scrapes.
+func validateScrapeTimeouts(c *ServerConfig) error {
+	targets, err := parseTargets(c.ScrapeTargets, c.ScrapeIntervals, c.ScrapeTimeouts, c.ScrapeInterval, c.ScrapeTimeout)
+	if err != nil {
+		// Reported with the settings
+		return nil
+	}
+	for _, t := range targets {
+		if t.Timeout > t.Interval {
+			return fmt.Errorf("timeout %v of target %q exceeds its interval %v", t.Timeout, t.Name, t.Interval)
+		}
+	}
+	return nil
+}
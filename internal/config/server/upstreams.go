@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mrkovshik/yametrics/internal/config/source"
+)
+
+// Upstream is a server metrics are pulled from in the federation mode.
+type Upstream struct {
+	Origin string // Value of the origin label of the metrics pulled from the server
+	URL    string // Base URL of the server
+	Key    string // Key verifying the signatures of the responses, empty if they are not signed
+}
+
+// Upstreams returns the configured upstream servers. Servers without a key of their own
+// use the key of this server.
+func (c ServerConfig) Upstreams() []Upstream {
+	upstreams, _ := parseUpstreams(c.FederationUpstreams, c.FederationKeys, c.Key)
+	return upstreams
+}
+
+// upstreamEntry is an upstream server in a structured list.
+type upstreamEntry struct {
+	Origin string `json:"origin"`
+	URL    string `json:"url"`
+}
+
+func parseUpstreams(list, keys, defaultKey string) ([]Upstream, error) {
+	var upstreams []Upstream
+	index := make(map[string]int)
+	entries, err := source.Entries(list, func(u upstreamEntry) string { return u.Origin + "=" + u.URL })
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		origin, rawURL, ok := strings.Cut(entry, "=")
+		if !ok || origin == "" {
+			return nil, fmt.Errorf("invalid upstream %q, use origin=URL", entry)
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid URL of upstream %q, need http(s)://host:port", origin)
+		}
+		if _, ok := index[origin]; ok {
+			return nil, fmt.Errorf("duplicate upstream %q", origin)
+		}
+		index[origin] = len(upstreams)
+		upstreams = append(upstreams, Upstream{Origin: origin, URL: strings.TrimSuffix(rawURL, "/"), Key: defaultKey})
+	}
+	keyEntries, err := source.Pairs(keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range keyEntries {
+		origin, key, ok := strings.Cut(entry, "=")
+		if !ok || origin == "" {
+			return nil, fmt.Errorf("invalid upstream key, use origin=key")
+		}
+		i, ok := index[origin]
+		if !ok {
+			return nil, fmt.Errorf("key for unknown upstream %q", origin)
+		}
+		upstreams[i].Key = key
+	}
+	return upstreams, nil
+}
+
+func validateUpstreams(list string) error {
+	_, err := parseUpstreams(list, "", "")
+	return err
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/mrkovshik/yametrics/internal/util"
+)
+
+// Validate checks all settings of the ServerConfig and returns every problem found.
+func (c *ServerConfig) Validate() error {
+	return errors.Join(
+		field("address", validateAddress(c.Address)),
+		field("strict_auth", validateStrictAuth(c.StrictAuth, c.Key)),
+		field("strict_auth", validateStrictListeners(c.StrictAuth, c.StatsDAddress, c.GraphiteAddress)),
+		field("store_interval", nonNegative(c.StoreInterval)),
+		field("ip_rate_limit", nonNegative(c.IPRateLimit)),
+		field("key_rate_limit", nonNegative(c.KeyRateLimit)),
+		field("rate_limit_burst", nonNegative(c.RateLimitBurst)),
+		field("max_body_size", nonNegative(c.MaxBodySize)),
+		field("max_decompressed_size", nonNegative(c.MaxDecompressedSize)),
+		field("max_batch_size", nonNegative(c.MaxBatchSize)),
+		field("compress_min_size", nonNegative(c.CompressMinSize)),
+		field("shutdown_timeout", nonNegative(c.ShutdownTimeout)),
+		field("log_level", validateLogLevel(c.LogLevel)),
+		field("log_format", validateLogFormat(c.LogFormat)),
+		field("log_max_size", positive(c.LogMaxSize)),
+		field("log_max_backups", nonNegative(c.LogMaxBackups)),
+		field("log_sampling", nonNegative(c.LogSampling)),
+		field("statsd_address", validateOptionalAddress(c.StatsDAddress)),
+		field("graphite_address", validateOptionalAddress(c.GraphiteAddress)),
+		field("graphite_forward_address", validateOptionalAddress(c.GraphiteForwardAddress)),
+		field("graphite_forward_interval", positive(c.GraphiteForwardInterval)),
+		field("federation_upstreams", validateUpstreams(c.FederationUpstreams)),
+		field("federation_keys", validateFederationKeys(c.FederationUpstreams, c.FederationKeys)),
+		field("federation_interval", positive(c.FederationInterval)),
+		field("replicas", validateReplicas(c.Replicas)),
+		field("replica", validateReplica(c.ReplicaEnable, c.Replicas)),
+		field("scrape_targets", validateTargets(c.ScrapeTargets)),
+		field("scrape_interval", positive(c.ScrapeInterval)),
+		field("scrape_timeout", positive(c.ScrapeTimeout)),
+		field("scrape_intervals", validateTargetOverrides(c.ScrapeTargets, c.ScrapeIntervals)),
+		field("scrape_timeouts", validateTargetOverrides(c.ScrapeTargets, c.ScrapeTimeouts)),
+		field("scrape_timeout", validateScrapeTimeouts(c)),
+	)
+}
+
+// field prefixes a validation error with the name of the setting.
+func field(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+func validateAddress(address string) error {
+	if !util.ValidateAddress(address) {
+		return errors.New("need address in a form host:port")
+	}
+	return nil
+}
+
+// validateOptionalAddress accepts an empty address, which disables the listener.
+func validateOptionalAddress(address string) error {
+	if address == "" {
+		return nil
+	}
+	return validateAddress(address)
+}
+
+// validateStrictAuth rejects strict auth without a key, as nothing could be signed.
+func validateStrictAuth(strict bool, key string) error {
+	if strict && key == "" {
+		return errors.New("needs a key to verify the signatures")
+	}
+	return nil
+}
+
+// validateStrictListeners rejects strict auth mode together with the StatsD and Graphite listeners,
+// whose protocols cannot carry signatures.
+func validateStrictListeners(strict bool, statsDAddress, graphiteAddress string) error {
+	switch {
+	case strict && statsDAddress != "":
+		return errors.New("cannot be combined with statsd_address, as StatsD metrics are not signed")
+	case strict && graphiteAddress != "":
+		return errors.New("cannot be combined with graphite_address, as Graphite metrics are not signed")
+	}
+	return nil
+}
+
+// validateFederationKeys checks the keys against valid upstreams, invalid upstreams are reported on their own.
+func validateFederationKeys(upstreams, keys string) error {
+	if validateUpstreams(upstreams) != nil {
+		return nil
+	}
+	_, err := parseUpstreams(upstreams, keys, "")
+	return err
+}
+
+// validateReplica rejects a replica with replicas of its own, as streaming to them would replace
+// its replica role.
+func validateReplica(enable bool, replicas string) error {
+	if urls, _ := parseReplicas(replicas); enable && len(urls) > 0 {
+		return errors.New("a replica cannot stream updates to replicas")
+	}
+	return nil
+}
+
+func validateLogLevel(level string) error {
+	if _, err := zapcore.ParseLevel(level); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	return nil
+}
+
+func validateLogFormat(format string) error {
+	if format != "console" && format != "json" {
+		return fmt.Errorf("invalid log format %q, use console or json", format)
+	}
+	return nil
+}
+
+func nonNegative[T int | time.Duration](n T) error {
+	if n < 0 {
+		return fmt.Errorf("must not be negative, got %v", n)
+	}
+	return nil
+}
+
+func positive[T int | time.Duration](n T) error {
+	if n <= 0 {
+		return fmt.Errorf("must be positive, got %v", n)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package source
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Split splits a comma-separated list, dropping blank entries.
+func Split(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Entries returns the entries of a list setting. A comma-separated list is split; a structured list,
+// as returned by File.List, has its tables decoded into T, which must know all their fields, and
+// formatted by format in the comma-separated form of an entry.
+func Entries[T any](list string, format func(T) string) ([]string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(list), "[") {
+		return Split(list), nil
+	}
+	dec := json.NewDecoder(strings.NewReader(list))
+	dec.DisallowUnknownFields()
+	var tables []T
+	if err := dec.Decode(&tables); err != nil {
+		return nil, fmt.Errorf("invalid list: %w", err)
+	}
+	entries := make([]string, 0, len(tables))
+	for _, t := range tables {
+		entries = append(entries, format(t))
+	}
+	return entries, nil
+}
+
+// Pairs returns the name=value entries of a list setting. A comma-separated list is split; a table
+// of values by name, as returned by File.List, has its entries ordered by name.
+func Pairs(list string) ([]string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(list), "{") {
+		return Split(list), nil
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(list)))
+	dec.UseNumber()
+	var table map[string]interface{}
+	if err := dec.Decode(&table); err != nil {
+		return nil, fmt.Errorf("invalid table: %w", err)
+	}
+	entries := make([]string, 0, len(table))
+	for name, value := range table {
+		switch value.(type) {
+		case string, json.Number, bool:
+		default:
+			// The value is not quoted as it may hold a key.
+			return nil, fmt.Errorf("value of %q must be a string or a number", name)
+		}
+		entries = append(entries, fmt.Sprintf("%v=%v", name, value))
+	}
+	sort.Strings(entries)
+	return entries, nil
+}
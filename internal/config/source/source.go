@@ -0,0 +1,246 @@
+// Package source loads configuration files in JSON, YAML or TOML format and
+// reports invalid settings together with their position in the file.
+package source
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	kjson "github.com/knadh/koanf/parsers/json"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/v2"
+
+	"github.com/mrkovshik/yametrics/internal/util"
+)
+
+// FieldError describes an invalid setting in a configuration file.
+type FieldError struct {
+	Path string // Path to the configuration file
+	Line int    // Line of the setting, 0 if it could not be located
+	Key  string // Name of the setting
+	Err  error  // What is wrong with the setting
+}
+
+func (e *FieldError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %v", e.Path, e.Line, e.Key, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Path, e.Key, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// File is a parsed configuration file.
+// Getters record an error for settings of a wrong type, all errors are returned together by Err.
+type File struct {
+	path  string
+	data  []byte
+	k     *koanf.Koanf
+	errs  []*FieldError
+	known map[string]struct{}
+}
+
+// Load reads and parses the configuration file at path.
+// The format is selected by the file extension: .json, .yaml, .yml or .toml.
+func Load(path string) (*File, error) {
+	var parser koanf.Parser
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		parser = kjson.Parser()
+	case ".yaml", ".yml":
+		parser = yaml.Parser()
+	case ".toml":
+		parser = toml.Parser()
+	default:
+		return nil, fmt.Errorf("%s: unsupported config file format, use .json, .yaml, .yml or .toml", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	k := koanf.New(".")
+	if err := k.Load(rawBytes(data), parser); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineAt(data, syntaxErr.Offset), syntaxErr)
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &File{path: path, data: data, k: k, known: make(map[string]struct{})}, nil
+}
+
+// String returns the value of a string setting and whether it is present and valid.
+func (f *File) String(key string) (string, bool) {
+	value, ok := f.lookup(key)
+	if !ok {
+		return "", false
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", f.Check(key, fmt.Errorf("must be a string, got %v", value))
+	}
+	return s, true
+}
+
+// Int returns the value of an integer setting and whether it is present and valid.
+func (f *File) Int(key string) (int, bool) {
+	value, ok := f.lookup(key)
+	if !ok {
+		return 0, false
+	}
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		// JSON numbers are always decoded as floats.
+		if v == math.Trunc(v) {
+			return int(v), true
+		}
+	}
+	return 0, f.Check(key, fmt.Errorf("must be an integer, got %v", value))
+}
+
+// Duration returns the value of a duration setting and whether it is present and valid.
+// Strings like "500ms" or "1m30s" are accepted, as well as integers interpreted as seconds.
+func (f *File) Duration(key string) (time.Duration, bool) {
+	value, ok := f.lookup(key)
+	if !ok {
+		return 0, false
+	}
+	switch v := value.(type) {
+	case string:
+		d, err := util.ParseDuration(v)
+		return d, f.Check(key, err)
+	case int, int64, float64:
+		seconds, ok := f.Int(key)
+		return time.Duration(seconds) * time.Second, ok
+	}
+	return 0, f.Check(key, fmt.Errorf("must be a duration, got %v", value))
+}
+
+// Bool returns the value of a boolean setting and whether it is present and valid.
+func (f *File) Bool(key string) (bool, bool) {
+	value, ok := f.lookup(key)
+	if !ok {
+		return false, false
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, f.Check(key, fmt.Errorf("must be true or false, got %v", value))
+	}
+	return b, true
+}
+
+// List returns the value of a list setting and whether it is present and valid. The list is either a
+// comma-separated string, returned as is, or structured: a list of tables or a table of values by name,
+// returned encoded in JSON to be read by Entries or Pairs. Structured lists may hold entries with commas.
+func (f *File) List(key string) (string, bool) {
+	value, ok := f.lookup(key)
+	if !ok {
+		return "", false
+	}
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case []interface{}:
+		data, err := json.Marshal(v)
+		return string(data), f.Check(key, err)
+	case map[string]interface{}:
+		// Names holding dots are split into nested tables, they are joined back.
+		table := f.k.Cut(key).All()
+		for name := range table {
+			f.known[key+"."+name] = struct{}{}
+		}
+		data, err := json.Marshal(table)
+		return string(data), f.Check(key, err)
+	}
+	return "", f.Check(key, fmt.Errorf("must be a string, a list or a table, got %v", value))
+}
+
+// Check records err as an error of the setting key unless it is nil.
+// It reports whether the setting is valid.
+func (f *File) Check(key string, err error) bool {
+	if err == nil {
+		return true
+	}
+	f.errs = append(f.errs, &FieldError{Path: f.path, Line: f.line(key), Key: key, Err: err})
+	return false
+}
+
+// Err returns the errors of all invalid and unknown settings ordered by their position in the file,
+// or nil if there are none. Settings are known once any of the getters has been called for them.
+func (f *File) Err() error {
+	for _, key := range f.k.Keys() {
+		if _, ok := f.known[key]; !ok {
+			f.Check(key, errors.New("unknown setting"))
+		}
+	}
+	if len(f.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(f.errs, func(i, j int) bool {
+		return f.errs[i].Line < f.errs[j].Line
+	})
+	errs := make([]error, 0, len(f.errs))
+	for _, err := range f.errs {
+		errs = append(errs, err)
+	}
+	f.errs = nil
+	return errors.Join(errs...)
+}
+
+func (f *File) lookup(key string) (interface{}, bool) {
+	f.known[key] = struct{}{}
+	if !f.k.Exists(key) {
+		return nil, false
+	}
+	return f.k.Get(key), true
+}
+
+// line returns the line the setting is defined on, or 0 if it cannot be found.
+// Settings are looked up as `key:` (YAML), `key =` (TOML) or `"key":` (JSON).
+func (f *File) line(key string) int {
+	name := regexp.QuoteMeta(key[strings.LastIndex(key, ".")+1:])
+	patterns := []string{
+		`(?m)^[ \t]*["']?` + name + `["']?[ \t]*[:=]`,
+		`["']` + name + `["'][ \t]*:`,
+	}
+	for _, pattern := range patterns {
+		if loc := regexp.MustCompile(pattern).FindIndex(f.data); loc != nil {
+			return lineAt(f.data, int64(loc[0]))
+		}
+	}
+	return 0
+}
+
+// rawBytes is a koanf provider of an already read file.
+type rawBytes []byte
+
+func (b rawBytes) ReadBytes() ([]byte, error) {
+	return b, nil
+}
+
+func (b rawBytes) Read() (map[string]interface{}, error) {
+	return nil, errors.New("rawBytes provider does not support Read")
+}
+
+// lineAt returns the 1-based line number of the byte offset in data.
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return 1 + strings.Count(string(data[:offset]), "\n")
+}
@@ -0,0 +1,187 @@
+package source
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_Formats(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name:    "json",
+			file:    "config.json",
+			content: `{"address": "localhost:9090", "rate_limit": 4, "restore": false}`,
+		},
+		{
+			name:    "yaml",
+			file:    "config.yaml",
+			content: "address: localhost:9090\nrate_limit: 4\nrestore: false\n",
+		},
+		{
+			name:    "yml",
+			file:    "config.yml",
+			content: "address: localhost:9090\nrate_limit: 4\nrestore: false\n",
+		},
+		{
+			name:    "toml",
+			file:    "config.toml",
+			content: "address = \"localhost:9090\"\nrate_limit = 4\nrestore = false\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Load(writeFile(t, tt.file, tt.content))
+			require.NoError(t, err)
+
+			address, ok := f.String("address")
+			require.True(t, ok)
+			require.Equal(t, "localhost:9090", address)
+
+			limit, ok := f.Int("rate_limit")
+			require.True(t, ok)
+			require.Equal(t, 4, limit)
+
+			restore, ok := f.Bool("restore")
+			require.True(t, ok, "settings equal to the zero value are present")
+			require.False(t, restore)
+
+			_, ok = f.String("key")
+			require.False(t, ok)
+			require.NoError(t, f.Err())
+		})
+	}
+}
+
+func TestFile_Err(t *testing.T) {
+	path := writeFile(t, "config.yaml", "address: localhost:8080\nrate_limit: many\nrestore: 1\nratelimit: 2\n")
+	f, err := Load(path)
+	require.NoError(t, err)
+
+	_, ok := f.String("address")
+	require.True(t, ok)
+	require.False(t, f.Check("address", errors.New("bad address")))
+	_, ok = f.Int("rate_limit")
+	require.False(t, ok)
+	_, ok = f.Bool("restore")
+	require.False(t, ok)
+
+	err = f.Err()
+	require.EqualError(t, err, path+":1: address: bad address\n"+
+		path+":2: rate_limit: must be an integer, got many\n"+
+		path+":3: restore: must be true or false, got 1\n"+
+		path+":4: ratelimit: unknown setting")
+
+	var fieldErr *FieldError
+	require.ErrorAs(t, err, &fieldErr)
+	require.Equal(t, 1, fieldErr.Line)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	_, err := Load(writeFile(t, "config.ini", "address=localhost"))
+	require.ErrorContains(t, err, "unsupported config file format")
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.json"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	path := writeFile(t, "config.json", "{\n  \"address\": \"localhost:8080\",\n  \"rate_limit\": 4,,\n}")
+	_, err = Load(path)
+	require.ErrorContains(t, err, path+":3:")
+}
+
+func TestFile_Duration(t *testing.T) {
+	path := writeFile(t, "config.json", `{"a": "500ms", "b": "1m30s", "c": 10, "d": "soon", "e": true}`)
+	f, err := Load(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		key    string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"a", 500 * time.Millisecond, true},
+		{"b", 90 * time.Second, true},
+		{"c", 10 * time.Second, true},
+		{"d", 0, false},
+		{"e", 0, false},
+		{"missing", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := f.Duration(tt.key)
+		require.Equal(t, tt.wantOK, ok, tt.key)
+		require.Equal(t, tt.want, got, tt.key)
+	}
+	err = f.Err()
+	require.ErrorContains(t, err, `d: invalid duration "soon"`)
+	require.ErrorContains(t, err, "e: must be a duration, got true")
+}
+
+func TestFile_List(t *testing.T) {
+	path := writeFile(t, "config.yaml", `plain: a=1,b=2
+tables:
+  - name: web
+    pattern: '^\d{1,3},x$'
+values:
+  api.example: 5s
+  queue: 30s
+bad: 4
+`)
+	f, err := Load(path)
+	require.NoError(t, err)
+
+	plain, ok := f.List("plain")
+	require.True(t, ok)
+	require.Equal(t, "a=1,b=2", plain)
+
+	tables, ok := f.List("tables")
+	require.True(t, ok)
+	type table struct {
+		Name    string `json:"name"`
+		Pattern string `json:"pattern"`
+	}
+	entries, err := Entries(tables, func(t table) string { return t.Name + "=" + t.Pattern })
+	require.NoError(t, err)
+	require.Equal(t, []string{`web=^\d{1,3},x$`}, entries)
+
+	values, ok := f.List("values")
+	require.True(t, ok)
+	pairs, err := Pairs(values)
+	require.NoError(t, err)
+	require.Equal(t, []string{"api.example=5s", "queue=30s"}, pairs)
+
+	_, ok = f.List("bad")
+	require.False(t, ok)
+	require.EqualError(t, f.Err(), path+":8: bad: must be a string, a list or a table, got 4")
+}
+
+func TestEntries(t *testing.T) {
+	type table struct {
+		Name string `json:"name"`
+	}
+	format := func(t table) string { return t.Name }
+
+	entries, err := Entries(" a, ,b ", format)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, entries)
+
+	_, err = Entries(`[{"name":"a","url":"b"}]`, format)
+	require.EqualError(t, err, `invalid list: json: unknown field "url"`)
+
+	_, err = Pairs(`{"a":{"b":1}}`)
+	require.EqualError(t, err, `value of "a" must be a string or a number`)
+}
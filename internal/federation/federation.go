@@ -0,0 +1,156 @@
+// Package federation pulls the metrics of other yametrics servers into the local storage, so that
+// a server can give a global view of several datacenters. The pulled metrics are labeled with the
+// origin of the upstream server, e.g. Alloc;origin=dc1.
+package federation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/protocol"
+	"github.com/mrkovshik/yametrics/internal/remote"
+)
+
+const (
+	// OriginTag is the tag holding the origin of federated metrics.
+	OriginTag = "origin"
+
+	pullTimeout = 10 * time.Second
+	maxBackoff  = 5 * time.Minute
+)
+
+type service interface {
+	UpdateMetrics(ctx context.Context, batch []model.Metrics) error
+
+	ListMetrics(ctx context.Context) ([]model.Metrics, error)
+}
+
+// Federator pulls metrics from upstream servers.
+type Federator struct {
+	service   service
+	client    *http.Client
+	upstreams []*upstream
+	logger    *zap.SugaredLogger
+}
+
+type upstream struct {
+	config.Upstream
+	failures int           // Pulls failed in a row
+	next     time.Time     // Time of the next pull after a failure
+	totals   remote.Totals // Counter totals of the last stored pull
+}
+
+// NewFederator creates a Federator storing the metrics of upstreams through service.
+func NewFederator(service service, upstreams []config.Upstream, logger *zap.SugaredLogger) *Federator {
+	f := &Federator{
+		service: service,
+		client:  &http.Client{Timeout: pullTimeout},
+		logger:  logger,
+	}
+	for _, u := range upstreams {
+		f.upstreams = append(f.upstreams, &upstream{Upstream: u})
+	}
+	return f
+}
+
+// Run pulls from all upstream servers every interval until ctx is done. An upstream server that
+// fails is skipped for an exponentially growing number of intervals, up to five minutes.
+func (f *Federator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		f.Pull(ctx, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Pull pulls from the upstream servers that are not backing off after a failure, concurrently.
+// interval is the base of the backoff.
+func (f *Federator) Pull(ctx context.Context, interval time.Duration) {
+	var wg sync.WaitGroup
+	now := time.Now()
+	for _, u := range f.upstreams {
+		if now.Before(u.next) {
+			continue
+		}
+		wg.Add(1)
+		go func(u *upstream) {
+			defer wg.Done()
+			err := f.pull(ctx, u)
+			switch {
+			case err != nil && ctx.Err() == nil:
+				u.failures++
+				backoff := interval << min(u.failures-1, 16)
+				if backoff > maxBackoff || backoff <= 0 {
+					backoff = maxBackoff
+				}
+				u.next = time.Now().Add(backoff)
+				f.logger.Warnf("federation: pulling from %v failed %d times in a row, retrying in %v: %v", u.Origin, u.failures, backoff, err)
+			case err == nil && u.failures > 0:
+				f.logger.Infof("federation: pulling from %v recovered after %d failures", u.Origin, u.failures)
+				u.failures, u.next = 0, time.Time{}
+			}
+		}(u)
+	}
+	wg.Wait()
+}
+
+// pull stores the metrics of the upstream server. Gauges take the upstream values, counters are
+// incremented by the change of the upstream totals, see remote.Totals. Metrics that already have
+// an origin, pulled by the upstream server from its own upstreams, keep it.
+func (f *Federator) pull(ctx context.Context, u *upstream) error {
+	metrics, err := remote.Fetch(ctx, f.client, u.URL+"/", u.Key)
+	if err != nil {
+		return err
+	}
+	totals, err := remote.CounterTotals(ctx, f.service)
+	if err != nil {
+		return err
+	}
+	batch := make([]model.Metrics, 0, len(metrics))
+	pulled := make(map[string]int64)
+	for _, m := range metrics {
+		id := m.ID
+		if !hasOrigin(id) {
+			id = protocol.WithTag(id, OriginTag, u.Origin)
+		}
+		switch {
+		case m.MType == model.MetricTypeGauge && m.Value != nil:
+			value := *m.Value
+			batch = append(batch, model.Metrics{ID: id, MType: m.MType, Value: &value})
+		case m.MType == model.MetricTypeCounter && m.Delta != nil:
+			delta := u.totals.Delta(id, *m.Delta, totals)
+			pulled[id] = *m.Delta
+			batch = append(batch, model.Metrics{ID: id, MType: m.MType, Delta: &delta})
+		}
+	}
+	if len(batch) > 0 {
+		if err := f.service.UpdateMetrics(ctx, batch); err != nil {
+			return fmt.Errorf("UpdateMetrics: %w", err)
+		}
+	}
+	u.totals.Commit(pulled)
+	return nil
+}
+
+func hasOrigin(id string) bool {
+	_, list, _ := strings.Cut(id, ";")
+	for _, tag := range strings.Split(list, ";") {
+		if key, _, _ := strings.Cut(tag, "="); key == OriginTag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/model"
+	server "github.com/mrkovshik/yametrics/internal/service/server"
+	"github.com/mrkovshik/yametrics/internal/signature"
+	"github.com/mrkovshik/yametrics/internal/storage"
+)
+
+func newService() *server.MetricService {
+	cfg := config.ServerConfig{}
+	return server.NewMetricService(storage.NewInMemoryStorage(), &cfg, zap.NewNop().Sugar())
+}
+
+// upstreamStub serves the metrics as the JSON listing of a server signing its responses with key.
+// It fails with 500 while failing is set.
+type upstreamStub struct {
+	metrics  atomic.Value
+	failing  atomic.Bool
+	requests atomic.Int32
+}
+
+func newUpstream(t *testing.T, key string, metrics []model.Metrics) (*upstreamStub, *httptest.Server) {
+	t.Helper()
+	u := &upstreamStub{}
+	u.metrics.Store(metrics)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.requests.Add(1)
+		if r.URL.Path != "/" || r.Header.Get("Accept") != "application/json" || u.failing.Load() {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		body, err := json.Marshal(u.metrics.Load())
+		require.NoError(t, err)
+		sig, err := signature.NewSha256Sig(key, body).Generate()
+		require.NoError(t, err)
+		w.Header().Set("HashSHA256", sig)
+		w.Write(body) //nolint:all
+	}))
+	t.Cleanup(srv.Close)
+	return u, srv
+}
+
+func gauge(id string, v float64) model.Metrics {
+	return model.Metrics{ID: id, MType: model.MetricTypeGauge, Value: &v}
+}
+
+func counter(id string, d int64) model.Metrics {
+	return model.Metrics{ID: id, MType: model.MetricTypeCounter, Delta: &d}
+}
+
+func TestFederator_Pull(t *testing.T) {
+	ctx := context.Background()
+	metricService := newService()
+	// A local counter of the same name is kept apart from the federated one.
+	require.NoError(t, metricService.UpdateMetrics(ctx, []model.Metrics{counter("PollCount", 3)}))
+	stub, srv := newUpstream(t, "secret", []model.Metrics{
+		counter("PollCount", 10),
+		gauge("Alloc", 1.5),
+		gauge("Alloc;origin=dc3", 7),
+	})
+	f := NewFederator(metricService, []config.Upstream{{Origin: "dc1", URL: srv.URL, Key: "secret"}}, zap.NewNop().Sugar())
+
+	f.Pull(ctx, time.Second)
+	list, err := metricService.ListMetrics(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []model.Metrics{
+		gauge("Alloc;origin=dc1", 1.5),
+		gauge("Alloc;origin=dc3", 7),
+		counter("PollCount", 3),
+		counter("PollCount;origin=dc1", 10),
+	}, list)
+
+	// Counters follow the upstream totals rather than adding them up on every pull.
+	stub.metrics.Store([]model.Metrics{counter("PollCount", 25), gauge("Alloc", 2)})
+	f.Pull(ctx, time.Second)
+	f.Pull(ctx, time.Second)
+	m, err := metricService.GetMetric(ctx, counter("PollCount;origin=dc1", 0))
+	require.NoError(t, err)
+	require.Equal(t, int64(25), *m.Delta)
+	m, err = metricService.GetMetric(ctx, gauge("Alloc;origin=dc1", 0))
+	require.NoError(t, err)
+	require.Equal(t, 2.0, *m.Value)
+
+	// A restarted upstream counts from zero again, its new total adds up instead of going backwards.
+	stub.metrics.Store([]model.Metrics{counter("PollCount", 4)})
+	f.Pull(ctx, time.Second)
+	stub.metrics.Store([]model.Metrics{counter("PollCount", 6)})
+	f.Pull(ctx, time.Second)
+	m, err = metricService.GetMetric(ctx, counter("PollCount;origin=dc1", 0))
+	require.NoError(t, err)
+	require.Equal(t, int64(31), *m.Delta)
+}
+
+func TestFederator_PullSignature(t *testing.T) {
+	ctx := context.Background()
+	metricService := newService()
+	_, srv := newUpstream(t, "secret", []model.Metrics{gauge("Alloc", 1)})
+	f := NewFederator(metricService, []config.Upstream{{Origin: "dc1", URL: srv.URL, Key: "other"}}, zap.NewNop().Sugar())
+
+	f.Pull(ctx, time.Second)
+	list, err := metricService.ListMetrics(ctx)
+	require.NoError(t, err)
+	require.Empty(t, list, "responses with a wrong signature are dropped")
+	require.Equal(t, 1, f.upstreams[0].failures)
+}
+
+func TestFederator_PullBackoff(t *testing.T) {
+	ctx := context.Background()
+	metricService := newService()
+	stub, srv := newUpstream(t, "", []model.Metrics{gauge("Alloc", 1)})
+	stub.failing.Store(true)
+	f := NewFederator(metricService, []config.Upstream{{Origin: "dc1", URL: srv.URL}}, zap.NewNop().Sugar())
+	u := f.upstreams[0]
+
+	f.Pull(ctx, time.Minute)
+	require.Equal(t, 1, u.failures)
+	require.WithinDuration(t, time.Now().Add(time.Minute), u.next, time.Second)
+
+	// A failed upstream is not asked again until its backoff is over.
+	f.Pull(ctx, time.Minute)
+	require.Equal(t, int32(1), stub.requests.Load())
+
+	u.next = time.Time{}
+	f.Pull(ctx, time.Minute)
+	require.Equal(t, 2, u.failures)
+	require.WithinDuration(t, time.Now().Add(2*time.Minute), u.next, time.Second)
+
+	u.failures = 10
+	u.next = time.Time{}
+	f.Pull(ctx, time.Minute)
+	require.WithinDuration(t, time.Now().Add(maxBackoff), u.next, time.Second, "the backoff is capped")
+
+	stub.failing.Store(false)
+	u.next = time.Time{}
+	f.Pull(ctx, time.Minute)
+	require.Zero(t, u.failures)
+	require.True(t, u.next.IsZero())
+	m, err := metricService.GetMetric(ctx, gauge("Alloc;origin=dc1", 0))
+	require.NoError(t, err)
+	require.Equal(t, 1.0, *m.Value)
+}
@@ -0,0 +1,94 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+)
+
+// Handler returns the HTTP API of the receiver, which mirrors the update routes of the server:
+//
+// - POST /update/: Receives a single metric in JSON.
+// - POST /update/{type}/{name}/{value}: Receives a single metric from URL parameters.
+// - POST /updates/: Receives a JSON array of metrics.
+func (r *Receiver) Handler() http.Handler {
+	router := chi.NewRouter()
+	router.Post("/update/", r.handleUpdateFromJSON)
+	router.Post("/update/{type}/{name}/{value}", r.handleUpdateFromURL)
+	router.Post("/updates/", r.handleUpdatesFromJSON)
+	return router
+}
+
+// Serve serves the HTTP API on l until ctx is done.
+func (r *Receiver) Serve(ctx context.Context, l net.Listener) error {
+	srv := &http.Server{Handler: r.Handler(), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background()) //nolint:all
+	}()
+	if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (r *Receiver) handleUpdateFromJSON(w http.ResponseWriter, req *http.Request) {
+	var m model.Metrics
+	if err := json.NewDecoder(req.Body).Decode(&m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.receive(w, req, m)
+}
+
+func (r *Receiver) handleUpdateFromURL(w http.ResponseWriter, req *http.Request) {
+	var m model.Metrics
+	if err := m.MapMetricsFromReqURL(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.receive(w, req, m)
+}
+
+func (r *Receiver) handleUpdatesFromJSON(w http.ResponseWriter, req *http.Request) {
+	var batch []model.Metrics
+	if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The whole batch is checked first, so that it is either received in full or rejected.
+	for _, m := range batch {
+		if err := validate(m); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, m := range batch {
+		if err := r.Receive(req.Context(), m); err != nil {
+			r.logger.Errorf("Receive %v: %v", m.ID, err)
+			http.Error(w, "failed to store metrics", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *Receiver) receive(w http.ResponseWriter, req *http.Request, m model.Metrics) {
+	if err := validate(m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := r.Receive(req.Context(), m); err != nil {
+		r.logger.Errorf("Receive %v: %v", m.ID, err)
+		http.Error(w, "failed to store metric", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,98 @@
+package ingest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+	storage2 "github.com/mrkovshik/yametrics/internal/storage"
+)
+
+func gauge(t *testing.T, strg *storage2.InMemoryStorage, name string) float64 {
+	t.Helper()
+	m, err := strg.GetMetricByModel(context.Background(), model.Metrics{ID: name, MType: model.MetricTypeGauge})
+	require.NoError(t, err)
+	return *m.Value
+}
+
+func counter(t *testing.T, strg *storage2.InMemoryStorage, name string) int64 {
+	t.Helper()
+	m, err := strg.GetMetricByModel(context.Background(), model.Metrics{ID: name, MType: model.MetricTypeCounter})
+	require.NoError(t, err)
+	return *m.Delta
+}
+
+func TestReceiver_ServeStatsD(t *testing.T) {
+	strg := storage2.NewInMemoryStorage()
+	r := NewReceiver(strg, zap.NewNop().Sugar())
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- r.ServeStatsD(ctx, conn) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer client.Close() //nolint:all
+	_, err = client.Write([]byte("orders:1|c\norders:2|c|@0.5\nqueue:10|g\nqueue:-3|g\nbad line\norders:1|c|#env:prod\nlatency:250|ms|@0.5\n"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool { return len(r.Received()) == 5 }, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(5), counter(t, strg, "orders"), "sampled increments are scaled")
+	require.Equal(t, 7.0, gauge(t, strg, "queue"), "signed gauge values are relative")
+	require.Equal(t, 250.0, gauge(t, strg, "latency"))
+	require.Equal(t, int64(2), counter(t, strg, "latency.count"))
+	require.Equal(t, []model.Metrics{
+		{ID: "latency.count", MType: model.MetricTypeCounter},
+		{ID: "orders", MType: model.MetricTypeCounter},
+		{ID: "orders;env=prod", MType: model.MetricTypeCounter},
+		{ID: "latency", MType: model.MetricTypeGauge},
+		{ID: "queue", MType: model.MetricTypeGauge},
+	}, r.Received())
+
+	cancel()
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServeStatsD did not return after the context was done")
+	}
+}
+
+func TestReceiver_Handler(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		wantCode int
+	}{
+		{name: "json gauge", path: "/update/", body: `{"id":"temperature","type":"gauge","value":21.5}`, wantCode: http.StatusOK},
+		{name: "json counter", path: "/update/", body: `{"id":"orders","type":"counter","delta":2}`, wantCode: http.StatusOK},
+		{name: "url counter", path: "/update/counter/orders/3", wantCode: http.StatusOK},
+		{name: "batch", path: "/updates/", body: `[{"id":"orders","type":"counter","delta":1},{"id":"queue","type":"gauge","value":4}]`, wantCode: http.StatusOK},
+		{name: "gauge without value", path: "/update/", body: `{"id":"temperature","type":"gauge"}`, wantCode: http.StatusBadRequest},
+		{name: "invalid batch is rejected in full", path: "/updates/", body: `[{"id":"orders","type":"counter","delta":100},{"id":"","type":"gauge","value":4}]`, wantCode: http.StatusBadRequest},
+		{name: "unknown type", path: "/update/histogram/latency/3", wantCode: http.StatusBadRequest},
+		{name: "malformed json", path: "/update/", body: `{`, wantCode: http.StatusBadRequest},
+	}
+	strg := storage2.NewInMemoryStorage()
+	h := NewReceiver(strg, zap.NewNop().Sugar()).Handler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+			require.Equal(t, tt.wantCode, rr.Code, rr.Body.String())
+		})
+	}
+	require.Equal(t, int64(6), counter(t, strg, "orders"))
+	require.Equal(t, 21.5, gauge(t, strg, "temperature"))
+	require.Equal(t, 4.0, gauge(t, strg, "queue"))
+}
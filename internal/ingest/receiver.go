@@ -0,0 +1,90 @@
+// Package ingest receives metrics pushed by local applications to the agent over HTTP and StatsD.
+// Received metrics are put into the agent storage and shipped to the server with the next report,
+// so that signing, encryption and retries are handled by the agent.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+)
+
+type storage interface {
+	UpdateMetricValue(ctx context.Context, newMetrics model.Metrics) error
+
+	GetMetricByModel(ctx context.Context, newMetrics model.Metrics) (model.Metrics, error)
+}
+
+// Receiver stores pushed metrics and keeps track of their names.
+type Receiver struct {
+	storage storage
+	logger  *zap.SugaredLogger
+
+	mu       sync.Mutex
+	received map[string]model.Metrics // ID and type of every received metric by type:ID
+}
+
+// NewReceiver creates a Receiver putting metrics into strg.
+func NewReceiver(strg storage, logger *zap.SugaredLogger) *Receiver {
+	return &Receiver{
+		storage:  strg,
+		logger:   logger,
+		received: make(map[string]model.Metrics),
+	}
+}
+
+// Receive validates the metric and stores it: gauges replace the current value, counters add to it.
+func (r *Receiver) Receive(ctx context.Context, m model.Metrics) error {
+	if err := validate(m); err != nil {
+		return err
+	}
+	if err := r.storage.UpdateMetricValue(ctx, m); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.received[m.MType+":"+m.ID] = model.Metrics{ID: m.ID, MType: m.MType}
+	r.mu.Unlock()
+	return nil
+}
+
+// Received returns the ID and type of every metric received so far, ordered by type and ID.
+func (r *Receiver) Received() []model.Metrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]model.Metrics, 0, len(r.received))
+	for _, m := range r.received {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].MType != list[j].MType {
+			return list[i].MType < list[j].MType
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+func validate(m model.Metrics) error {
+	if m.ID == "" {
+		return errors.New("metric name is empty")
+	}
+	switch m.MType {
+	case model.MetricTypeGauge:
+		if m.Value == nil {
+			return fmt.Errorf("gauge %v has no value", m.ID)
+		}
+	case model.MetricTypeCounter:
+		if m.Delta == nil {
+			return fmt.Errorf("counter %v has no delta", m.ID)
+		}
+	default:
+		return fmt.Errorf("metric %v has invalid type %q", m.ID, m.MType)
+	}
+	return nil
+}
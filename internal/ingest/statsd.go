@@ -0,0 +1,43 @@
+package ingest
+
+import (
+	"context"
+	"net"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/protocol"
+)
+
+// ServeStatsD reads StatsD packets from conn until ctx is done. Every packet holds one or more
+// newline-separated lines of the form `name:value|type[|@rate][|#tags]`, mapped onto metrics as
+// described by protocol.StatsDSample.Metrics. Tags are appended to the metric name.
+// Invalid lines are logged and skipped.
+func (r *Receiver) ServeStatsD(ctx context.Context, conn net.PacketConn) error {
+	return protocol.ServePackets(ctx, conn, func(lines []string) {
+		for _, line := range lines {
+			if err := r.receiveStatsD(ctx, line); err != nil {
+				r.logger.Warnf("StatsD %q: %v", line, err)
+			}
+		}
+	})
+}
+
+func (r *Receiver) receiveStatsD(ctx context.Context, line string) error {
+	s, err := protocol.ParseStatsD(line)
+	if err != nil {
+		return err
+	}
+	var current float64
+	if s.Relative {
+		m, err := r.storage.GetMetricByModel(ctx, model.Metrics{ID: s.Name, MType: model.MetricTypeGauge})
+		if err == nil && m.Value != nil {
+			current = *m.Value
+		}
+	}
+	for _, m := range s.Metrics(current) {
+		if err := r.Receive(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
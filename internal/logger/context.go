@@ -0,0 +1,23 @@
+package logger
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type ctxKey struct{}
+
+// WithLogger returns a copy of ctx carrying the logger, so that request-scoped fields
+// such as the request ID are attached to every line logged while handling the request.
+func WithLogger(ctx context.Context, l *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the logger stored in ctx, or fallback if there is none.
+func FromContext(ctx context.Context, fallback *zap.SugaredLogger) *zap.SugaredLogger {
+	if l, ok := ctx.Value(ctxKey{}).(*zap.SugaredLogger); ok {
+		return l
+	}
+	return fallback
+}
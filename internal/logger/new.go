@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Formats of log entries.
+const (
+	FormatConsole = "console" // Human-readable lines, as logged by the zap development logger
+	FormatJSON    = "json"    // One JSON object per entry, for log collectors
+)
+
+// Options configure the logger built by New.
+type Options struct {
+	Level      string // Minimal level of logged entries
+	Format     string // FormatConsole or FormatJSON
+	File       string // Path to the log file, empty logs to stderr
+	MaxSize    int    // Size in megabytes at which the log file is rotated
+	MaxBackups int    // Number of rotated log files to keep, 0 keeps all
+	Sampling   int    // Identical entries per second logged before sampling starts, 0 disables sampling
+}
+
+// sampleThereafter makes every 100th identical entry logged once sampling has started, as in zap's production logger.
+const sampleThereafter = 100
+
+// New builds a logger configured by opts. The returned level can be changed while the logger is in use.
+func New(opts Options) (*zap.Logger, zap.AtomicLevel, error) {
+	level, err := zap.ParseAtomicLevel(opts.Level)
+	if err != nil {
+		return nil, level, err
+	}
+
+	var encoder zapcore.Encoder
+	switch opts.Format {
+	case FormatConsole, "":
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	case FormatJSON:
+		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	default:
+		return nil, level, fmt.Errorf("unknown log format %q", opts.Format)
+	}
+
+	out := zapcore.Lock(os.Stderr)
+	if opts.File != "" {
+		out = zapcore.AddSync(&lumberjack.Logger{
+			Filename:   opts.File,
+			MaxSize:    opts.MaxSize,
+			MaxBackups: opts.MaxBackups,
+		})
+	}
+
+	core := zapcore.NewCore(encoder, out, level)
+	if opts.Sampling > 0 {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, opts.Sampling, sampleThereafter)
+	}
+	return zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel), zap.ErrorOutput(zapcore.Lock(os.Stderr))), level, nil
+}
@@ -0,0 +1,68 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func readEntries(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close() //nolint:all
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry), scanner.Text())
+		entries = append(entries, entry)
+	}
+	require.NoError(t, scanner.Err())
+	return entries
+}
+
+func TestNew_JSONFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	l, level, err := New(Options{Level: "info", Format: FormatJSON, File: path, MaxSize: 1})
+	require.NoError(t, err)
+
+	l.Debug("hidden")
+	l.Info("request", zap.String("uri", "/update/"), zap.Int("status", 200))
+	level.SetLevel(zapcore.DebugLevel)
+	l.Debug("shown")
+	require.NoError(t, l.Sync())
+
+	entries := readEntries(t, path)
+	require.Len(t, entries, 2)
+	require.Equal(t, "request", entries[0]["msg"])
+	require.Equal(t, "info", entries[0]["level"])
+	require.Equal(t, "/update/", entries[0]["uri"])
+	require.Equal(t, float64(200), entries[0]["status"])
+	require.Equal(t, "shown", entries[1]["msg"])
+}
+
+func TestNew_Sampling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	l, _, err := New(Options{Level: "debug", Format: FormatJSON, File: path, MaxSize: 1, Sampling: 3})
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		l.Info("metrics sent")
+	}
+	l.Info("done")
+	require.NoError(t, l.Sync())
+	require.Len(t, readEntries(t, path), 4, "identical entries over the limit are dropped")
+}
+
+func TestNew_Errors(t *testing.T) {
+	_, _, err := New(Options{Level: "loud"})
+	require.Error(t, err)
+	_, _, err = New(Options{Level: "info", Format: "xml"})
+	require.ErrorContains(t, err, `unknown log format "xml"`)
+}
@@ -0,0 +1,293 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	config "github.com/mrkovshik/yametrics/internal/config/agent"
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/protocol"
+	"go.uber.org/zap"
+)
+
+// Names of the metrics about the runs of the commands, each is tagged with the configured name of the command.
+const (
+	ExecExitCodeMetric     = "exec_exit_code"        // Exit code of the last run, -1 if the command could not be started or timed out
+	ExecFailuresMetric     = "exec_failures_total"   // Runs that exited with a non-zero code, could not be started or timed out
+	ExecInvalidLinesMetric = "exec_invalid_lines"    // Lines of the output of the last run that are not valid metrics
+	ExecDurationMetric     = "exec_duration_seconds" // Time the last run took
+
+	CommandTag = "command" // Configured name of the command
+)
+
+// execWaitDelay is how long the output of a killed command is waited for, as processes started by
+// the command may keep it open.
+const execWaitDelay = time.Second
+
+// Limits of the output kept of a run, the rest is discarded.
+const (
+	maxExecOutput = 1 << 20 // Standard output parsed for metrics
+	maxExecStderr = 4 << 10 // Standard error logged
+)
+
+// ExecCollector runs commands on their own intervals and collects the metrics they print. Each line
+// of the output is a metric as name type value, such as "queue_depth gauge 12", blank lines and lines
+// starting with # are skipped. An output starting with { or [ is a JSON metric or array of metrics
+// in the form the server accepts. Counters are increments.
+//
+// A command is run by the shell when its interval is over and is killed once its timeout is over.
+// Only the first MiB of the output is parsed; the start of the standard error is logged.
+// Poll starts the commands and returns without waiting for them, so that a slow command does not
+// hold up other collectors; the metrics of a run are updated in the storage once it ends. A command
+// still running when its next run is due is not started again.
+type ExecCollector struct {
+	commands []config.Command
+	logger   *zap.SugaredLogger
+
+	wg        sync.WaitGroup // Running commands
+	mu        sync.Mutex
+	started   map[string]time.Time       // When the commands were last started by name
+	running   map[string]bool            // Whether the commands are running by name
+	collected map[string][]model.Metrics // Metrics updated by the last run of the commands by name
+}
+
+// NewExecCollector creates an ExecCollector of the given commands.
+func NewExecCollector(commands []config.Command, logger *zap.SugaredLogger) *ExecCollector {
+	return &ExecCollector{
+		commands:  commands,
+		logger:    logger,
+		started:   make(map[string]time.Time),
+		running:   make(map[string]bool),
+		collected: make(map[string][]model.Metrics),
+	}
+}
+
+// Poll starts the commands whose interval is over since they were last started.
+func (c *ExecCollector) Poll(s storage) error {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cmd := range c.commands {
+		if c.running[cmd.Name] {
+			continue
+		}
+		if last, ok := c.started[cmd.Name]; ok && now.Sub(last) < cmd.Interval {
+			continue
+		}
+		c.started[cmd.Name], c.running[cmd.Name] = now, true
+		c.wg.Add(1)
+		go func(cmd config.Command) {
+			defer c.wg.Done()
+			c.run(cmd, s)
+		}(cmd)
+	}
+	return nil
+}
+
+// Collected lists the metrics updated by the last run of every command.
+func (c *ExecCollector) Collected() []model.Metrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var list []model.Metrics
+	for _, cmd := range c.commands {
+		list = append(list, c.collected[cmd.Name]...)
+	}
+	return list
+}
+
+// Wait waits for the running commands to end.
+func (c *ExecCollector) Wait() {
+	c.wg.Wait()
+}
+
+// run runs a command and updates the metrics it printed and those about the run in the storage.
+func (c *ExecCollector) run(cmd config.Command, s storage) {
+	ctx, cancel := context.WithTimeout(context.Background(), cmd.Timeout)
+	defer cancel()
+	start := time.Now()
+	stdout, stderr := &limitedBuffer{limit: maxExecOutput}, &limitedBuffer{limit: maxExecStderr}
+	command := exec.CommandContext(ctx, "sh", "-c", cmd.Command)
+	command.Stdout, command.Stderr = stdout, stderr
+	command.WaitDelay = execWaitDelay
+	err := command.Run()
+	duration := time.Since(start)
+
+	var batch []model.Metrics
+	invalid := 0
+	exitCode := 0
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		// The output of a command that timed out may be cut short.
+		exitCode = -1
+	case errors.As(err, &exitErr):
+		// Checks may report metrics along with a non-zero code, such as the value that failed the check.
+		exitCode = exitErr.ExitCode()
+		batch, invalid = parseExecOutput(stdout.Bytes())
+	case err != nil:
+		exitCode = -1
+	default:
+		batch, invalid = parseExecOutput(stdout.Bytes())
+	}
+	c.log(cmd.Name, exitCode, err, stdout, stderr)
+	tags := map[string]string{CommandTag: cmd.Name}
+	gauge := func(name string, value float64) {
+		batch = append(batch, model.Metrics{ID: protocol.Name(name, tags), MType: model.MetricTypeGauge, Value: &value})
+	}
+	gauge(ExecExitCodeMetric, float64(exitCode))
+	gauge(ExecInvalidLinesMetric, float64(invalid))
+	gauge(ExecDurationMetric, duration.Seconds())
+	if exitCode != 0 {
+		failures := int64(1)
+		batch = append(batch, model.Metrics{ID: protocol.Name(ExecFailuresMetric, tags), MType: model.MetricTypeCounter, Delta: &failures})
+	}
+
+	collected := make([]model.Metrics, 0, len(batch))
+	for _, m := range batch {
+		collected = append(collected, model.Metrics{ID: m.ID, MType: m.MType})
+	}
+	// The storage is updated before the metrics are listed, so that they are never reported before they are stored.
+	err = s.UpdateMetrics(context.Background(), batch)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.collected[cmd.Name] = collected
+	}
+	c.running[cmd.Name] = false
+}
+
+// log logs a failed run and the standard error of the command, if any.
+func (c *ExecCollector) log(name string, exitCode int, err error, stdout, stderr *limitedBuffer) {
+	if stdout.truncated {
+		c.logger.Warnf("command %v: output over %d bytes was discarded", name, maxExecOutput)
+	}
+	message := strings.TrimSpace(string(stderr.Bytes()))
+	if stderr.truncated {
+		message += " (truncated)"
+	}
+	switch {
+	case exitCode != 0:
+		c.logger.Warnf("command %v failed with exit code %d: %v, stderr: %v", name, exitCode, err, message)
+	case message != "":
+		c.logger.Debugf("command %v stderr: %v", name, message)
+	}
+}
+
+// limitedBuffer is a buffer keeping the first limit bytes written to it. Writes never fail, so that
+// a command is not stopped by the limit.
+type limitedBuffer struct {
+	buf       bytes.Buffer // Not embedded, as its ReadFrom would bypass the limit
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.truncated = true
+		b.buf.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// Bytes returns the bytes kept.
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// parseExecOutput parses the output of a command and returns the valid metrics and the number of
+// invalid lines, an invalid JSON output counts as one.
+func parseExecOutput(output []byte) ([]model.Metrics, int) {
+	trimmed := bytes.TrimSpace(output)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		var list []model.Metrics
+		if trimmed[0] == '{' {
+			list = make([]model.Metrics, 1)
+			if err := json.Unmarshal(trimmed, &list[0]); err != nil {
+				return nil, 1
+			}
+		} else if err := json.Unmarshal(trimmed, &list); err != nil {
+			return nil, 1
+		}
+		var valid []model.Metrics
+		invalid := 0
+		for _, m := range list {
+			if validateExecMetric(m) != nil {
+				invalid++
+				continue
+			}
+			valid = append(valid, m)
+		}
+		return valid, invalid
+	}
+	var valid []model.Metrics
+	invalid := 0
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m, err := parseExecLine(line)
+		if err != nil {
+			invalid++
+			continue
+		}
+		valid = append(valid, m)
+	}
+	return valid, invalid
+}
+
+// parseExecLine parses a metric given as name type value.
+func parseExecLine(line string) (model.Metrics, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return model.Metrics{}, fmt.Errorf("invalid metric %q, use name type value", line)
+	}
+	m := model.Metrics{ID: fields[0], MType: fields[1]}
+	switch m.MType {
+	case model.MetricTypeGauge:
+		value, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return model.Metrics{}, fmt.Errorf("invalid value of gauge %v: %w", m.ID, err)
+		}
+		m.Value = &value
+	case model.MetricTypeCounter:
+		delta, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return model.Metrics{}, fmt.Errorf("invalid delta of counter %v: %w", m.ID, err)
+		}
+		m.Delta = &delta
+	default:
+		return model.Metrics{}, fmt.Errorf("metric %v has invalid type %q", m.ID, m.MType)
+	}
+	return m, nil
+}
+
+func validateExecMetric(m model.Metrics) error {
+	if m.ID == "" {
+		return errors.New("metric name is empty")
+	}
+	switch m.MType {
+	case model.MetricTypeGauge:
+		if m.Value == nil {
+			return fmt.Errorf("gauge %v has no value", m.ID)
+		}
+	case model.MetricTypeCounter:
+		if m.Delta == nil {
+			return fmt.Errorf("counter %v has no delta", m.ID)
+		}
+	default:
+		return fmt.Errorf("metric %v has invalid type %q", m.ID, m.MType)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	config "github.com/mrkovshik/yametrics/internal/config/agent"
+	"github.com/mrkovshik/yametrics/internal/model"
+	storage2 "github.com/mrkovshik/yametrics/internal/storage"
+)
+
+func TestExecCollector_Poll(t *testing.T) {
+	c := NewExecCollector([]config.Command{
+		{Name: "text", Command: `printf '# queue\nqueue_depth gauge 12.5\njobs_done counter 3\n\nbroken line\n'`, Interval: time.Hour, Timeout: 5 * time.Second},
+		{Name: "json", Command: `echo '[{"id":"temperature","type":"gauge","value":21.5},{"id":"no_value","type":"gauge"}]'`, Interval: time.Hour, Timeout: 5 * time.Second},
+		{Name: "check", Command: `echo 'cert_days_left gauge 3'; exit 2`, Interval: time.Hour, Timeout: 5 * time.Second},
+		{Name: "slow", Command: `sleep 5; echo 'late gauge 1'`, Interval: time.Hour, Timeout: 100 * time.Millisecond},
+	}, zap.NewNop().Sugar())
+	s := storage2.NewInMemoryStorage()
+	gauge := func(id string) float64 {
+		t.Helper()
+		m, err := s.GetMetricByModel(context.Background(), model.Metrics{ID: id, MType: model.MetricTypeGauge})
+		require.NoError(t, err)
+		return *m.Value
+	}
+	counter := func(id string) int64 {
+		t.Helper()
+		m, err := s.GetMetricByModel(context.Background(), model.Metrics{ID: id, MType: model.MetricTypeCounter})
+		require.NoError(t, err)
+		return *m.Delta
+	}
+
+	require.NoError(t, c.Poll(s))
+	c.Wait()
+	require.Equal(t, 12.5, gauge("queue_depth"))
+	require.Equal(t, int64(3), counter("jobs_done"))
+	require.Equal(t, 1.0, gauge("exec_invalid_lines;command=text"))
+	require.Equal(t, 0.0, gauge("exec_exit_code;command=text"))
+	require.Equal(t, 21.5, gauge("temperature"))
+	require.Equal(t, 1.0, gauge("exec_invalid_lines;command=json"))
+	require.Equal(t, 3.0, gauge("cert_days_left"), "metrics printed by a failed check are kept")
+	require.Equal(t, 2.0, gauge("exec_exit_code;command=check"))
+	require.Equal(t, int64(1), counter("exec_failures_total;command=check"))
+	require.Equal(t, -1.0, gauge("exec_exit_code;command=slow"))
+	require.Equal(t, int64(1), counter("exec_failures_total;command=slow"))
+	_, err := s.GetMetricByModel(context.Background(), model.Metrics{ID: "late", MType: model.MetricTypeGauge})
+	require.Error(t, err, "the command was killed at its timeout")
+	require.Contains(t, c.Collected(), model.Metrics{ID: "jobs_done", MType: model.MetricTypeCounter})
+	require.Contains(t, c.Collected(), model.Metrics{ID: "exec_duration_seconds;command=slow", MType: model.MetricTypeGauge})
+
+	// The interval is not over, so the commands are not run again.
+	require.NoError(t, c.Poll(s))
+	c.Wait()
+	require.Equal(t, int64(3), counter("jobs_done"))
+	require.Equal(t, int64(1), counter("exec_failures_total;command=check"))
+}
+
+func TestExecCollector_outputLimits(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	c := NewExecCollector([]config.Command{
+		{Name: "noisy", Command: `echo 'queue_depth gauge 1'; yes x | head -c 2000000; yes e | tr -d '\n' | head -c 10000 >&2; exit 1`, Interval: time.Hour, Timeout: 5 * time.Second},
+	}, zap.New(core).Sugar())
+	s := storage2.NewInMemoryStorage()
+
+	require.NoError(t, c.Poll(s))
+	c.Wait()
+	m, err := s.GetMetricByModel(context.Background(), model.Metrics{ID: "queue_depth", MType: model.MetricTypeGauge})
+	require.NoError(t, err, "lines before the limit are parsed")
+	require.Equal(t, 1.0, *m.Value)
+
+	entries := logs.All()
+	require.Len(t, entries, 2)
+	require.Equal(t, "command noisy: output over 1048576 bytes was discarded", entries[0].Message)
+	require.Contains(t, entries[1].Message, "command noisy failed with exit code 1")
+	require.Contains(t, entries[1].Message, "stderr: "+strings.Repeat("e", maxExecStderr)+" (truncated)")
+}
+
+func Test_parseExecOutput(t *testing.T) {
+	metrics, invalid := parseExecOutput([]byte(`{"id":"up","type":"counter","delta":1}`))
+	require.Equal(t, 0, invalid)
+	require.Len(t, metrics, 1)
+	require.Equal(t, int64(1), *metrics[0].Delta)
+
+	metrics, invalid = parseExecOutput([]byte(`[{"id":"up"`))
+	require.Empty(t, metrics)
+	require.Equal(t, 1, invalid)
+
+	metrics, invalid = parseExecOutput([]byte("a gauge x\nb histogram 1\nc counter 1.5\nd gauge\nload;host=web gauge 0.7\n"))
+	require.Equal(t, 4, invalid)
+	require.Equal(t, []model.Metrics{{ID: "load;host=web", MType: model.MetricTypeGauge, Value: metrics[0].Value}}, metrics)
+	require.Equal(t, 0.7, *metrics[0].Value)
+}
@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shirou/gopsutil/v3/process"
+
+	config "github.com/mrkovshik/yametrics/internal/config/agent"
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/protocol"
+)
+
+// Names of the process metrics. Each is tagged with the configured name of the process and sums the
+// values of the processes selected under the name, so that restarts do not create new series.
+const (
+	ProcessCountMetric      = "process_count"       // Number of processes selected
+	ProcessCPUPercentMetric = "process_cpu_percent" // CPU usage since the last poll, 100 for a fully used core
+	ProcessRSSMetric        = "process_rss_bytes"   // Resident set size
+	ProcessFDsMetric        = "process_open_fds"    // Open file descriptors
+	ProcessThreadsMetric    = "process_threads"     // Threads
+
+	ProcessTag = "process" // Configured name of the process
+)
+
+// ProcessCollector collects CPU, memory, file descriptor and thread metrics of selected processes.
+// Processes are looked up again on every poll, so processes that have exited no longer count and
+// new ones do. Values that cannot be read, such as the file descriptors of processes of other users,
+// are left out of the sums; a metric none of the selected processes could be read for is skipped.
+type ProcessCollector struct {
+	processes []config.Process
+	patterns  []*regexp.Regexp // Compiled patterns of the processes, nil for PID files
+
+	mu        sync.Mutex
+	tracked   map[int32]*trackedProcess // Processes seen by the last poll by PID, keeping the CPU times
+	collected []model.Metrics           // Metrics updated by the last poll
+}
+
+// trackedProcess is a process seen by a poll.
+type trackedProcess struct {
+	proc    *process.Process
+	created int64 // Creation time, telling a process apart from a later one with the same PID
+}
+
+// NewProcessCollector creates a ProcessCollector of the given processes, whose patterns are valid.
+func NewProcessCollector(processes []config.Process) *ProcessCollector {
+	c := &ProcessCollector{processes: processes, patterns: make([]*regexp.Regexp, len(processes))}
+	for i, p := range processes {
+		if p.By != config.ProcessByPIDFile {
+			c.patterns[i] = regexp.MustCompile(p.Pattern)
+		}
+	}
+	return c
+}
+
+// Poll collects the metrics of the selected processes and updates them in the storage.
+func (c *ProcessCollector) Poll(s storage) error {
+	ctx := context.Background()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	running, err := c.running(ctx)
+	if err != nil {
+		return err
+	}
+	tracked := make(map[int32]*trackedProcess)
+	var batch []model.Metrics
+	gauge := func(name string, value float64, tags map[string]string) {
+		batch = append(batch, model.Metrics{ID: protocol.Name(name, tags), MType: model.MetricTypeGauge, Value: &value})
+	}
+	for i, p := range c.processes {
+		selected := c.selected(ctx, i, running)
+		tags := map[string]string{ProcessTag: p.Name}
+		gauge(ProcessCountMetric, float64(len(selected)), tags)
+		// Sums of the metrics and whether any process could be read for them, by metric name.
+		sums := make(map[string]float64)
+		read := make(map[string]bool)
+		add := func(name string, value float64, err error) {
+			if err == nil {
+				sums[name] += value
+				read[name] = true
+			}
+		}
+		for _, proc := range selected {
+			t, err := c.track(ctx, proc)
+			if err != nil {
+				// The process has exited.
+				continue
+			}
+			tracked[proc.Pid] = t
+			percent, err := t.proc.PercentWithContext(ctx, 0)
+			add(ProcessCPUPercentMetric, percent, err)
+			if mem, err := t.proc.MemoryInfoWithContext(ctx); err == nil {
+				add(ProcessRSSMetric, float64(mem.RSS), nil)
+			}
+			fds, err := t.proc.NumFDsWithContext(ctx)
+			add(ProcessFDsMetric, float64(fds), err)
+			threads, err := t.proc.NumThreadsWithContext(ctx)
+			add(ProcessThreadsMetric, float64(threads), err)
+		}
+		for _, name := range []string{ProcessCPUPercentMetric, ProcessRSSMetric, ProcessFDsMetric, ProcessThreadsMetric} {
+			// Without processes the metrics are zero, rather than keeping the values of those that exited.
+			if read[name] || len(selected) == 0 {
+				gauge(name, sums[name], tags)
+			}
+		}
+	}
+	c.tracked = tracked
+	c.collected = make([]model.Metrics, 0, len(batch))
+	for _, m := range batch {
+		c.collected = append(c.collected, model.Metrics{ID: m.ID, MType: m.MType})
+	}
+	return s.UpdateMetrics(ctx, batch)
+}
+
+// Collected lists the metrics updated by the last poll.
+func (c *ProcessCollector) Collected() []model.Metrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]model.Metrics(nil), c.collected...)
+}
+
+// running lists the running processes if they are needed to select processes by name or command line.
+func (c *ProcessCollector) running(ctx context.Context) ([]*process.Process, error) {
+	for _, p := range c.processes {
+		if p.By != config.ProcessByPIDFile {
+			return process.ProcessesWithContext(ctx)
+		}
+	}
+	return nil, nil
+}
+
+// selected returns the running processes selected by the i-th process of the configuration.
+func (c *ProcessCollector) selected(ctx context.Context, i int, running []*process.Process) []*process.Process {
+	p := c.processes[i]
+	if p.By == config.ProcessByPIDFile {
+		proc, err := readPIDFile(ctx, p.Pattern)
+		if err != nil {
+			return nil
+		}
+		return []*process.Process{proc}
+	}
+	var selected []*process.Process
+	for _, proc := range running {
+		var value string
+		var err error
+		if p.By == config.ProcessByName {
+			value, err = proc.NameWithContext(ctx)
+		} else {
+			value, err = proc.CmdlineWithContext(ctx)
+		}
+		if err == nil && c.patterns[i].MatchString(value) {
+			selected = append(selected, proc)
+		}
+	}
+	return selected
+}
+
+// track returns the process seen by the last poll with the PID of proc, unless the PID has been reused since.
+func (c *ProcessCollector) track(ctx context.Context, proc *process.Process) (*trackedProcess, error) {
+	created, err := proc.CreateTimeWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := c.tracked[proc.Pid]; ok && t.created == created {
+		return t, nil
+	}
+	return &trackedProcess{proc: proc, created: created}, nil
+}
+
+// readPIDFile returns the process whose PID is stored in the file at path.
+func readPIDFile(ctx context.Context, path string) (*process.Process, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PID file %v: %w", path, err)
+	}
+	return process.NewProcessWithContext(ctx, int32(pid))
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	config "github.com/mrkovshik/yametrics/internal/config/agent"
+	"github.com/mrkovshik/yametrics/internal/model"
+	storage2 "github.com/mrkovshik/yametrics/internal/storage"
+)
+
+func TestProcessCollector_Poll(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "test.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o600))
+	sleep := exec.Command("sleep", "300")
+	require.NoError(t, sleep.Start())
+	defer sleep.Process.Kill() //nolint:all
+
+	c := NewProcessCollector([]config.Process{
+		{Name: "self", By: config.ProcessByPIDFile, Pattern: pidFile},
+		{Name: "sleeper", By: config.ProcessByCmdline, Pattern: "^sleep 300$"},
+		{Name: "missing", By: config.ProcessByPIDFile, Pattern: filepath.Join(t.TempDir(), "missing.pid")},
+	})
+	s := storage2.NewInMemoryStorage()
+	gauge := func(id string) float64 {
+		t.Helper()
+		m, err := s.GetMetricByModel(context.Background(), model.Metrics{ID: id, MType: model.MetricTypeGauge})
+		require.NoError(t, err)
+		return *m.Value
+	}
+	collected := func() map[string]bool {
+		ids := make(map[string]bool)
+		for _, m := range c.Collected() {
+			ids[m.ID] = true
+		}
+		return ids
+	}
+	self := ";process=self"
+	sleeper := ";process=sleeper"
+
+	require.NoError(t, c.Poll(s))
+	require.Equal(t, 1.0, gauge("process_count;process=self"))
+	require.Equal(t, 1.0, gauge("process_count;process=sleeper"))
+	require.Equal(t, 0.0, gauge("process_count;process=missing"))
+	require.Positive(t, gauge("process_rss_bytes"+self))
+	require.Positive(t, gauge("process_threads"+self))
+	require.Positive(t, gauge("process_open_fds"+self))
+	require.Contains(t, collected(), "process_cpu_percent"+self)
+	require.Equal(t, 1.0, gauge("process_threads"+sleeper))
+	require.Equal(t, 0.0, gauge("process_threads;process=missing"))
+
+	require.NoError(t, sleep.Process.Kill())
+	_ = sleep.Wait()
+	require.NoError(t, c.Poll(s))
+	require.Equal(t, 0.0, gauge("process_count;process=sleeper"))
+	require.Equal(t, 0.0, gauge("process_threads"+sleeper), "processes that exited no longer count")
+	require.Contains(t, collected(), "process_threads"+self)
+	for _, m := range c.Collected() {
+		require.NotContains(t, m.ID, "pid=", "series do not depend on the PIDs")
+	}
+}
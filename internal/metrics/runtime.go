@@ -1,4 +1,4 @@
-// Package metrics provides functionality to collect runtime and virtual memory metrics.
+// Package metrics provides functionality to collect runtime, virtual memory and process metrics.
 package metrics
 
 import (
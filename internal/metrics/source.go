@@ -19,3 +19,12 @@ type MetricSource interface {
 	// PollVirtMemStats polls virtual memory statistics and updates the storage.
 	PollVirtMemStats(s storage) error
 }
+
+// Collector polls metrics that change from poll to poll, unlike those of a MetricSource.
+type Collector interface {
+	// Poll polls the metrics and updates the storage.
+	Poll(s storage) error
+
+	// Collected lists the metrics updated by the last poll, without their values.
+	Collected() []model.Metrics
+}
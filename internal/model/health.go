@@ -0,0 +1,23 @@
+package model
+
+// Health statuses.
+const (
+	// HealthStatusOK means the component works as expected.
+	HealthStatusOK = "ok"
+	// HealthStatusFail means the component is not able to serve requests.
+	HealthStatusFail = "fail"
+)
+
+// ComponentHealth represents the health of a single server component.
+type ComponentHealth struct {
+	Name    string `json:"name"`            // Component name
+	Status  string `json:"status"`          // Either ok or fail
+	Latency string `json:"latency"`         // Time taken by the check
+	Error   string `json:"error,omitempty"` // Reason of the failure
+}
+
+// Health represents the overall health of the server along with its components.
+type Health struct {
+	Status     string            `json:"status"`     // ok if all components are ok, fail otherwise
+	Components []ComponentHealth `json:"components"` // Per-component health
+}
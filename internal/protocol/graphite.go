@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+)
+
+// ParseGraphite parses a line of the Graphite plaintext protocol `path value [timestamp]` into a gauge.
+// Tags in the path, as in path;tag=value, are sorted. The timestamp is checked but not used,
+// as metrics have no time.
+func ParseGraphite(line string) (model.Metrics, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return model.Metrics{}, errors.New("missing metric value")
+	}
+	if len(fields) > 3 {
+		return model.Metrics{}, errors.New("unexpected text after the timestamp")
+	}
+	name, list, _ := strings.Cut(fields[0], ";")
+	if name == "" {
+		return model.Metrics{}, errors.New("missing metric path")
+	}
+	tags := make(map[string]string)
+	if list != "" {
+		for _, tag := range strings.Split(list, ";") {
+			key, value, ok := strings.Cut(tag, "=")
+			if !ok || key == "" || value == "" {
+				return model.Metrics{}, fmt.Errorf("invalid tag %q", tag)
+			}
+			tags[key] = value
+		}
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return model.Metrics{}, fmt.Errorf("invalid value %q", fields[1])
+	}
+	if len(fields) == 3 {
+		if _, err := strconv.ParseFloat(fields[2], 64); err != nil {
+			return model.Metrics{}, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+	}
+	return model.Metrics{ID: Name(name, tags), MType: model.MetricTypeGauge, Value: &value}, nil
+}
+
+// FormatGraphite formats the metric as a line of the Graphite plaintext protocol with the path
+// prefixed by prefix. Counters are written as their total. Whitespace in the name is replaced
+// with underscores, so that the line stays parsable.
+func FormatGraphite(m model.Metrics, prefix string, timestamp int64) string {
+	var value string
+	switch {
+	case m.Value != nil:
+		value = strconv.FormatFloat(*m.Value, 'f', -1, 64)
+	case m.Delta != nil:
+		value = strconv.FormatInt(*m.Delta, 10)
+	default:
+		value = "0"
+	}
+	path := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			return '_'
+		}
+		return r
+	}, prefix+m.ID)
+	return path + " " + value + " " + strconv.FormatInt(timestamp, 10) + "\n"
+}
@@ -0,0 +1,148 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+)
+
+// ParseInflux parses a line of the InfluxDB line protocol
+// `measurement[,tag=value...] field=value[,field=value...] [timestamp]` into gauges, one per field.
+// A gauge is named measurement.field, or just measurement for the field named value, with the tags appended.
+// Float, integer and unsigned fields are taken as is, booleans become 1 or 0, and string fields are skipped.
+// The timestamp is checked but not used, as metrics have no time. Empty lines and comments yield no metrics.
+func ParseInflux(line string) ([]model.Metrics, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	sections := split(line, ' ')
+	if len(sections) < 2 {
+		return nil, errors.New("missing fields")
+	}
+	if len(sections) > 3 {
+		return nil, errors.New("unexpected text after the timestamp")
+	}
+	if len(sections) == 3 {
+		if _, err := strconv.ParseInt(sections[2], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", sections[2])
+		}
+	}
+
+	series := split(sections[0], ',')
+	measurement := unescape(series[0])
+	if measurement == "" {
+		return nil, errors.New("missing measurement")
+	}
+	tags := make(map[string]string, len(series)-1)
+	for _, tag := range series[1:] {
+		key, value, ok := cut(tag)
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		tags[key] = value
+	}
+
+	var metrics []model.Metrics
+	for _, field := range split(sections[1], ',') {
+		key, raw, ok := cut(field)
+		if !ok || key == "" || raw == "" {
+			return nil, fmt.Errorf("invalid field %q", field)
+		}
+		if strings.HasPrefix(raw, `"`) {
+			if len(raw) < 2 || !strings.HasSuffix(raw, `"`) {
+				return nil, fmt.Errorf("invalid string field %q", field)
+			}
+			continue
+		}
+		value, err := parseFieldValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of field %q: %w", key, err)
+		}
+		name := measurement
+		if key != "value" {
+			name += "." + key
+		}
+		metrics = append(metrics, model.Metrics{ID: Name(name, tags), MType: model.MetricTypeGauge, Value: &value})
+	}
+	return metrics, nil
+}
+
+func parseFieldValue(raw string) (float64, error) {
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, nil
+	}
+	switch raw[len(raw)-1] {
+	case 'i':
+		n, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		return float64(n), err
+	case 'u':
+		n, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		return float64(n), err
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err == nil && (math.IsNaN(value) || math.IsInf(value, 0)) {
+		err = errors.New("not a finite number")
+	}
+	return value, err
+}
+
+// split splits s at every sep that is neither escaped with a backslash nor inside a quoted string.
+func split(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// cut splits a key=value pair at the first unescaped equals sign and unescapes both sides.
+// The value is left as is when it is a quoted string.
+func cut(s string) (key, value string, ok bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '=':
+			value = s[i+1:]
+			if !strings.HasPrefix(value, `"`) {
+				value = unescape(value)
+			}
+			return unescape(s[:i]), value, true
+		}
+	}
+	return "", "", false
+}
+
+// unescape removes the backslashes escaping commas, spaces, equals signs and backslashes.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`, =\`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,54 @@
+// Package protocol parses metrics sent in third-party wire formats, such as StatsD, the InfluxDB
+// line protocol and the Graphite plaintext protocol, into metrics models, and formats metrics for
+// Graphite and the Prometheus text exposition format. Tags are appended to metric names in the
+// Graphite tagged form, e.g. requests;method=GET;status=200, sorted by name.
+package protocol
+
+import (
+	"sort"
+	"strings"
+)
+
+// Name appends the tags to the metric name in the Graphite tagged form, sorted by tag name.
+// Tags without a value are appended as the bare tag name.
+func Name(name string, tags map[string]string) string {
+	if len(tags) == 0 {
+		return name
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range keys {
+		b.WriteByte(';')
+		b.WriteString(k)
+		if v := tags[k]; v != "" {
+			b.WriteByte('=')
+			b.WriteString(v)
+		}
+	}
+	return b.String()
+}
+
+// WithTag adds the tag to the metric name, replacing a tag of the same name.
+func WithTag(name, key, value string) string {
+	base, tags := SplitName(name)
+	tags[key] = value
+	return Name(base, tags)
+}
+
+// SplitName splits a metric name in the Graphite tagged form into the name and the tags, the reverse of Name.
+func SplitName(name string) (string, map[string]string) {
+	base, list, _ := strings.Cut(name, ";")
+	tags := make(map[string]string)
+	if list != "" {
+		for _, tag := range strings.Split(list, ";") {
+			k, v, _ := strings.Cut(tag, "=")
+			tags[k] = v
+		}
+	}
+	return base, tags
+}
@@ -0,0 +1,303 @@
+package protocol
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	metricsv1 "go.opentelemetry.io/proto/otlp/metrics/v1"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+)
+
+// staleSeriesAge is how long the converters remember a cumulative series that receives no new points.
+const staleSeriesAge = time.Hour
+
+// OTLPConverter maps OpenTelemetry metrics onto metrics models. Metrics are named as in OTel,
+// with the resource attributes and data point attributes appended as tags:
+//
+// - Gauge: a gauge per data point.
+// - Sum: a counter incremented by the change for monotonic sums, a gauge otherwise.
+// - Histogram: a counter name.count of observations, counters name.bucket;le=bound of
+// observations up to the bucket bound, gauges name.mean of the observations since the previous
+// data point and name.min and name.max if reported.
+//
+// Cumulative values are turned into changes by remembering the previous data point of every
+// series. A cumulative series seen for the first time is taken as a baseline unless it has started
+// after the converter was created, so that restarts of the server do not count it twice. Series
+// without data points for staleSeriesAge are forgotten.
+// Exponential histograms and summaries are not supported.
+type OTLPConverter struct {
+	mu      sync.Mutex
+	horizon uint64                // Series seen for the first time count whole if they started later, in Unix nanoseconds
+	series  map[string]cumulative // Previous point of every cumulative series by name
+	pending map[string]cumulative // Points of the conversion in progress
+	expired time.Time             // When stale series were last forgotten
+}
+
+type cumulative struct {
+	start uint64
+	value float64
+	seen  time.Time // When the point was stored
+}
+
+// NewOTLPConverter creates an OTLPConverter.
+func NewOTLPConverter() *OTLPConverter {
+	now := time.Now()
+	return &OTLPConverter{
+		horizon: uint64(now.UnixNano()),
+		series:  make(map[string]cumulative),
+		expired: now,
+	}
+}
+
+// Convert converts all data points in data and passes the metrics to store. It returns the number
+// of data points rejected because their type or value is not supported, and the error of store.
+// The converter is held until store returns, so that concurrent requests for the same series
+// convert against the points stored by each other. The points of cumulative series are remembered
+// only if store succeeds; otherwise data converts to the same changes again, so that a failed
+// update can be retried without losing them.
+func (c *OTLPConverter) Convert(data *metricsv1.MetricsData, store func([]model.Metrics) error) (rejected int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = make(map[string]cumulative)
+	defer func() { c.pending = nil }()
+	var metrics []model.Metrics
+	for _, rm := range data.GetResourceMetrics() {
+		resource := rm.GetResource().GetAttributes()
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				var n int
+				metrics, n = c.convert(metrics, m, resource)
+				rejected += n
+			}
+		}
+	}
+	if err := store(metrics); err != nil {
+		return rejected, err
+	}
+	c.commit()
+	return rejected, nil
+}
+
+// commit remembers the points of the conversion in progress and forgets stale series.
+func (c *OTLPConverter) commit() {
+	now := time.Now()
+	for name, point := range c.pending {
+		point.seen = now
+		c.series[name] = point
+	}
+	if now.Sub(c.expired) < staleSeriesAge {
+		return
+	}
+	c.expired = now
+	for name, point := range c.series {
+		if now.Sub(point.seen) < staleSeriesAge {
+			continue
+		}
+		delete(c.series, name)
+		// A forgotten series that comes back started before it was last seen and is taken as a baseline.
+		if seen := uint64(point.seen.UnixNano()); seen > c.horizon {
+			c.horizon = seen
+		}
+	}
+}
+
+// previous returns the previous point of a series, including those of the conversion in progress.
+func (c *OTLPConverter) previous(name string) (cumulative, bool) {
+	if point, ok := c.pending[name]; ok {
+		return point, true
+	}
+	point, ok := c.series[name]
+	return point, ok
+}
+
+func (c *OTLPConverter) convert(metrics []model.Metrics, m *metricsv1.Metric, resource []*commonv1.KeyValue) ([]model.Metrics, int) {
+	rejected := 0
+	switch data := m.GetData().(type) {
+	case *metricsv1.Metric_Gauge:
+		for _, p := range data.Gauge.GetDataPoints() {
+			if noRecordedValue(p.GetFlags()) {
+				continue
+			}
+			value, ok := numberValue(p)
+			if !ok {
+				rejected++
+				continue
+			}
+			metrics = append(metrics, gaugeMetric(Name(m.GetName(), tags(resource, p.GetAttributes())), value))
+		}
+	case *metricsv1.Metric_Sum:
+		delta := data.Sum.GetAggregationTemporality() == metricsv1.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+		for _, p := range data.Sum.GetDataPoints() {
+			if noRecordedValue(p.GetFlags()) {
+				continue
+			}
+			value, ok := numberValue(p)
+			if !ok {
+				rejected++
+				continue
+			}
+			name := Name(m.GetName(), tags(resource, p.GetAttributes()))
+			switch {
+			case data.Sum.GetIsMonotonic():
+				increment := math.Round(value)
+				if !delta {
+					increment = c.change(name, p.GetStartTimeUnixNano(), increment)
+				}
+				metrics = append(metrics, counterMetric(name, int64(increment)))
+			case delta:
+				// Changes of an up-down sum add up to its level, starting from the first one received.
+				prev, _ := c.previous(name)
+				level := prev.value + value
+				c.pending[name] = cumulative{value: level}
+				metrics = append(metrics, gaugeMetric(name, level))
+			default:
+				metrics = append(metrics, gaugeMetric(name, value))
+			}
+		}
+	case *metricsv1.Metric_Histogram:
+		delta := data.Histogram.GetAggregationTemporality() == metricsv1.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+		for _, p := range data.Histogram.GetDataPoints() {
+			if noRecordedValue(p.GetFlags()) {
+				continue
+			}
+			if !validHistogram(p) {
+				rejected++
+				continue
+			}
+			metrics = c.histogram(metrics, m.GetName(), tags(resource, p.GetAttributes()), p, delta)
+		}
+	case *metricsv1.Metric_ExponentialHistogram:
+		rejected += len(data.ExponentialHistogram.GetDataPoints())
+	case *metricsv1.Metric_Summary:
+		rejected += len(data.Summary.GetDataPoints())
+	}
+	return metrics, rejected
+}
+
+func (c *OTLPConverter) histogram(metrics []model.Metrics, name string, labels map[string]string, p *metricsv1.HistogramDataPoint, delta bool) []model.Metrics {
+	start := p.GetStartTimeUnixNano()
+	change := func(name string, value float64) float64 {
+		if delta {
+			return value
+		}
+		return c.change(name, start, value)
+	}
+
+	countName := Name(name+".count", labels)
+	count := change(countName, float64(p.GetCount()))
+	metrics = append(metrics, counterMetric(countName, int64(count)))
+
+	bounds := p.GetExplicitBounds()
+	var observed uint64 // Observations up to the current bucket bound
+	for i, n := range p.GetBucketCounts() {
+		observed += n
+		le := "+Inf"
+		if i < len(bounds) {
+			le = strconv.FormatFloat(bounds[i], 'g', -1, 64)
+		}
+		bucketLabels := make(map[string]string, len(labels)+1)
+		for k, v := range labels {
+			bucketLabels[k] = v
+		}
+		bucketLabels["le"] = le
+		bucketName := Name(name+".bucket", bucketLabels)
+		metrics = append(metrics, counterMetric(bucketName, int64(change(bucketName, float64(observed)))))
+	}
+
+	if p.Sum != nil {
+		sum := change(Name(name+".sum", labels), p.GetSum())
+		if count > 0 {
+			metrics = append(metrics, gaugeMetric(Name(name+".mean", labels), sum/count))
+		}
+	}
+	if p.Min != nil {
+		metrics = append(metrics, gaugeMetric(Name(name+".min", labels), p.GetMin()))
+	}
+	if p.Max != nil {
+		metrics = append(metrics, gaugeMetric(Name(name+".max", labels), p.GetMax()))
+	}
+	return metrics
+}
+
+// change returns the change of a cumulative series since its previous point, which is the whole
+// value if the series has been reset or has started after the horizon.
+func (c *OTLPConverter) change(name string, start uint64, value float64) float64 {
+	prev, ok := c.previous(name)
+	c.pending[name] = cumulative{start: start, value: value}
+	switch {
+	case !ok && start > c.horizon:
+		return value
+	case !ok:
+		return 0
+	case start != prev.start || value < prev.value:
+		return value
+	default:
+		return value - prev.value
+	}
+}
+
+func noRecordedValue(flags uint32) bool {
+	return flags&uint32(metricsv1.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0
+}
+
+func numberValue(p *metricsv1.NumberDataPoint) (float64, bool) {
+	switch v := p.GetValue().(type) {
+	case *metricsv1.NumberDataPoint_AsInt:
+		return float64(v.AsInt), true
+	case *metricsv1.NumberDataPoint_AsDouble:
+		return v.AsDouble, !math.IsNaN(v.AsDouble) && !math.IsInf(v.AsDouble, 0)
+	}
+	return 0, false
+}
+
+func validHistogram(p *metricsv1.HistogramDataPoint) bool {
+	if n := len(p.GetBucketCounts()); n > 0 && n != len(p.GetExplicitBounds())+1 {
+		return false
+	}
+	for _, v := range []*float64{p.Sum, p.Min, p.Max} {
+		if v != nil && (math.IsNaN(*v) || math.IsInf(*v, 0)) {
+			return false
+		}
+	}
+	return true
+}
+
+// tags merges the resource attributes with the data point attributes, which take precedence.
+// Attributes holding arrays, maps or bytes are skipped.
+func tags(resource, attributes []*commonv1.KeyValue) map[string]string {
+	list := make(map[string]string, len(resource)+len(attributes))
+	for _, attrs := range [][]*commonv1.KeyValue{resource, attributes} {
+		for _, kv := range attrs {
+			if value, ok := attributeValue(kv.GetValue()); ok && kv.GetKey() != "" {
+				list[kv.GetKey()] = value
+			}
+		}
+	}
+	return list
+}
+
+func attributeValue(v *commonv1.AnyValue) (string, bool) {
+	switch v := v.GetValue().(type) {
+	case *commonv1.AnyValue_StringValue:
+		return v.StringValue, true
+	case *commonv1.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue), true
+	case *commonv1.AnyValue_IntValue:
+		return strconv.FormatInt(v.IntValue, 10), true
+	case *commonv1.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.DoubleValue, 'g', -1, 64), true
+	}
+	return "", false
+}
+
+func gaugeMetric(name string, value float64) model.Metrics {
+	return model.Metrics{ID: name, MType: model.MetricTypeGauge, Value: &value}
+}
+
+func counterMetric(name string, delta int64) model.Metrics {
+	return model.Metrics{ID: name, MType: model.MetricTypeCounter, Delta: &delta}
+}
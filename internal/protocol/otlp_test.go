@@ -0,0 +1,189 @@
+package protocol
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	metricsv1 "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcev1 "go.opentelemetry.io/proto/otlp/resource/v1"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+)
+
+func attribute(key, value string) *commonv1.KeyValue {
+	return &commonv1.KeyValue{Key: key, Value: &commonv1.AnyValue{Value: &commonv1.AnyValue_StringValue{StringValue: value}}}
+}
+
+func metricsData(metrics ...*metricsv1.Metric) *metricsv1.MetricsData {
+	return &metricsv1.MetricsData{ResourceMetrics: []*metricsv1.ResourceMetrics{{
+		Resource:     &resourcev1.Resource{Attributes: []*commonv1.KeyValue{attribute("service.name", "checkout")}},
+		ScopeMetrics: []*metricsv1.ScopeMetrics{{Metrics: metrics}},
+	}}}
+}
+
+func sum(name string, monotonic bool, temporality metricsv1.AggregationTemporality, start uint64, value float64) *metricsv1.Metric {
+	return &metricsv1.Metric{Name: name, Data: &metricsv1.Metric_Sum{Sum: &metricsv1.Sum{
+		IsMonotonic:            monotonic,
+		AggregationTemporality: temporality,
+		DataPoints: []*metricsv1.NumberDataPoint{{
+			StartTimeUnixNano: start,
+			Value:             &metricsv1.NumberDataPoint_AsDouble{AsDouble: value},
+		}},
+	}}}
+}
+
+// errStore fails the store of a conversion in the tests.
+var errStore = errors.New("store failed")
+
+// convertOTLP converts data with a store that fails unless stored is set, and returns the metrics passed to it.
+func convertOTLP(t *testing.T, c *OTLPConverter, data *metricsv1.MetricsData, stored bool) ([]model.Metrics, int) {
+	t.Helper()
+	var got []model.Metrics
+	rejected, err := c.Convert(data, func(metrics []model.Metrics) error {
+		got = metrics
+		if !stored {
+			return errStore
+		}
+		return nil
+	})
+	if stored {
+		require.NoError(t, err)
+	} else {
+		require.ErrorIs(t, err, errStore)
+	}
+	return got, rejected
+}
+
+func TestOTLPConverter_Convert(t *testing.T) {
+	const (
+		cumulative = metricsv1.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		delta      = metricsv1.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	)
+
+	t.Run("gauges carry resource attributes", func(t *testing.T) {
+		c := NewOTLPConverter()
+		got, rejected := convertOTLP(t, c, metricsData(&metricsv1.Metric{Name: "queue.size", Data: &metricsv1.Metric_Gauge{Gauge: &metricsv1.Gauge{
+			DataPoints: []*metricsv1.NumberDataPoint{
+				{Attributes: []*commonv1.KeyValue{attribute("queue", "orders")}, Value: &metricsv1.NumberDataPoint_AsInt{AsInt: 3}},
+				{Attributes: []*commonv1.KeyValue{attribute("service.name", "worker")}, Value: &metricsv1.NumberDataPoint_AsDouble{AsDouble: 1.5}},
+				{Value: &metricsv1.NumberDataPoint_AsDouble{AsDouble: math.NaN()}},
+				{Flags: uint32(metricsv1.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK)},
+			},
+		}}}), true)
+		require.Equal(t, 1, rejected)
+		require.Equal(t, []model.Metrics{gauge("queue.size;queue=orders;service.name=checkout", 3), gauge("queue.size;service.name=worker", 1.5)}, got)
+	})
+
+	t.Run("cumulative monotonic sums become counter increments", func(t *testing.T) {
+		c := NewOTLPConverter()
+		before, after := c.horizon-1, c.horizon+1
+		convert := func(m *metricsv1.Metric) []model.Metrics {
+			got, rejected := convertOTLP(t, c, metricsData(m), true)
+			require.Zero(t, rejected)
+			return got
+		}
+		require.Equal(t, []model.Metrics{counter("orders;service.name=checkout", 0)}, convert(sum("orders", true, cumulative, before, 10)), "the first point is a baseline")
+		require.Equal(t, []model.Metrics{counter("orders;service.name=checkout", 5)}, convert(sum("orders", true, cumulative, before, 15)))
+		require.Equal(t, []model.Metrics{counter("orders;service.name=checkout", 2)}, convert(sum("orders", true, cumulative, after, 2)), "a reset counts in full")
+		require.Equal(t, []model.Metrics{counter("refunds;service.name=checkout", 4)}, convert(sum("refunds", true, cumulative, after, 4)), "a series started after the server counts in full")
+		require.Equal(t, []model.Metrics{counter("payments;service.name=checkout", 3)}, convert(sum("payments", true, delta, after, 3)))
+	})
+
+	t.Run("non-monotonic sums become gauges", func(t *testing.T) {
+		c := NewOTLPConverter()
+		got, _ := convertOTLP(t, c, metricsData(sum("connections", false, cumulative, 0, 7)), false)
+		require.Equal(t, []model.Metrics{gauge("connections;service.name=checkout", 7)}, got)
+		convertOTLP(t, c, metricsData(sum("inflight", false, delta, 0, 3)), true)
+		got, _ = convertOTLP(t, c, metricsData(sum("inflight", false, delta, 0, -1)), false)
+		require.Equal(t, []model.Metrics{gauge("inflight;service.name=checkout", 2)}, got, "delta changes add up")
+	})
+
+	t.Run("histograms", func(t *testing.T) {
+		c := NewOTLPConverter()
+		total, low, high := 3.0, 0.1, 2.5
+		got, rejected := convertOTLP(t, c, metricsData(
+			&metricsv1.Metric{Name: "latency", Data: &metricsv1.Metric_Histogram{Histogram: &metricsv1.Histogram{
+				AggregationTemporality: delta,
+				DataPoints: []*metricsv1.HistogramDataPoint{
+					{Count: 4, Sum: &total, Min: &low, Max: &high, ExplicitBounds: []float64{0.5, 1}, BucketCounts: []uint64{2, 1, 1}},
+					{Count: 1, ExplicitBounds: []float64{0.5}, BucketCounts: []uint64{1}},
+				},
+			}}},
+			&metricsv1.Metric{Name: "sizes", Data: &metricsv1.Metric_Summary{Summary: &metricsv1.Summary{
+				DataPoints: []*metricsv1.SummaryDataPoint{{Count: 1}},
+			}}},
+		), true)
+		require.Equal(t, 2, rejected, "mismatched buckets and summaries are rejected")
+		require.Equal(t, []model.Metrics{
+			counter("latency.count;service.name=checkout", 4),
+			counter("latency.bucket;le=0.5;service.name=checkout", 2),
+			counter("latency.bucket;le=1;service.name=checkout", 3),
+			counter("latency.bucket;le=+Inf;service.name=checkout", 4),
+			gauge("latency.mean;service.name=checkout", 0.75),
+			gauge("latency.min;service.name=checkout", 0.1),
+			gauge("latency.max;service.name=checkout", 2.5),
+		}, got)
+	})
+
+	t.Run("changes are remembered once committed", func(t *testing.T) {
+		c := NewOTLPConverter()
+		before := c.horizon - 1
+		convertOTLP(t, c, metricsData(sum("orders", true, cumulative, before, 10)), true)
+		got, _ := convertOTLP(t, c, metricsData(sum("orders", true, cumulative, before, 15)), false)
+		require.Equal(t, []model.Metrics{counter("orders;service.name=checkout", 5)}, got)
+		got, _ = convertOTLP(t, c, metricsData(sum("orders", true, cumulative, before, 15)), true)
+		require.Equal(t, []model.Metrics{counter("orders;service.name=checkout", 5)}, got, "a failed update is not remembered")
+		got, _ = convertOTLP(t, c, metricsData(sum("orders", true, cumulative, before, 15)), false)
+		require.Equal(t, []model.Metrics{counter("orders;service.name=checkout", 0)}, got)
+	})
+
+	t.Run("stale series are forgotten", func(t *testing.T) {
+		c := NewOTLPConverter()
+		c.horizon -= uint64(3 * staleSeriesAge)
+		start := c.horizon + 1
+		convertOTLP(t, c, metricsData(sum("orders", true, cumulative, start, 10)), true)
+		point := c.series["orders;service.name=checkout"]
+		point.seen = point.seen.Add(-staleSeriesAge)
+		c.series["orders;service.name=checkout"] = point
+		c.expired = c.expired.Add(-staleSeriesAge)
+		convertOTLP(t, c, metricsData(sum("refunds", true, cumulative, start, 1)), true)
+		require.NotContains(t, c.series, "orders;service.name=checkout")
+		got, _ := convertOTLP(t, c, metricsData(sum("orders", true, cumulative, start, 12)), false)
+		require.Equal(t, []model.Metrics{counter("orders;service.name=checkout", 0)}, got, "a forgotten series that comes back is a baseline")
+	})
+
+	t.Run("concurrent requests count a change once", func(t *testing.T) {
+		c := NewOTLPConverter()
+		before := c.horizon - 1
+		convertOTLP(t, c, metricsData(sum("orders", true, cumulative, before, 10)), true)
+		var (
+			mu    sync.Mutex
+			total int64
+			wg    sync.WaitGroup
+		)
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := c.Convert(metricsData(sum("orders", true, cumulative, before, 15)), func(metrics []model.Metrics) error {
+					// Storing takes a while, other requests convert meanwhile unless they are held.
+					time.Sleep(10 * time.Millisecond)
+					mu.Lock()
+					defer mu.Unlock()
+					for _, m := range metrics {
+						total += *m.Delta
+					}
+					return nil
+				})
+				require.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		require.Equal(t, int64(5), total)
+	})
+}
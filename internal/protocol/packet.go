@@ -0,0 +1,38 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+)
+
+// maxPacket is the largest UDP payload read at once.
+const maxPacket = 65535
+
+// ServePackets reads packets from conn until ctx is done and passes the non-empty lines of every packet to handle.
+func ServePackets(ctx context.Context, conn net.PacketConn, handle func(lines []string)) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close() //nolint:all
+	}()
+	buf := make([]byte, maxPacket)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		var lines []string
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) > 0 {
+			handle(lines)
+		}
+	}
+}
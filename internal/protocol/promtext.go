@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// FormatPrometheusText writes the metrics in the Prometheus text exposition format. Tags become
+// labels, and characters not allowed in metric and label names are replaced with underscores.
+func FormatPrometheusText(w io.Writer, metrics []model.Metrics) error {
+	type sample struct {
+		name, labels, typ, value string
+	}
+	samples := make([]sample, 0, len(metrics))
+	for _, m := range metrics {
+		s := sample{typ: m.MType}
+		switch {
+		case m.MType == model.MetricTypeGauge && m.Value != nil:
+			s.value = formatPrometheusValue(*m.Value)
+		case m.MType == model.MetricTypeCounter && m.Delta != nil:
+			s.value = strconv.FormatInt(*m.Delta, 10)
+		default:
+			continue
+		}
+		name, tags := SplitName(m.ID)
+		s.name = promName(name, true)
+		keys := make([]string, 0, len(tags))
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(keys))
+		for _, k := range keys {
+			pairs = append(pairs, promName(k, false)+`="`+labelValueEscaper.Replace(tags[k])+`"`)
+		}
+		if len(pairs) > 0 {
+			s.labels = "{" + strings.Join(pairs, ",") + "}"
+		}
+		samples = append(samples, s)
+	}
+	// Samples of a metric family follow its TYPE line.
+	sort.SliceStable(samples, func(i, j int) bool {
+		if samples[i].name != samples[j].name {
+			return samples[i].name < samples[j].name
+		}
+		return samples[i].typ < samples[j].typ
+	})
+	bw := bufio.NewWriter(w)
+	for i, s := range samples {
+		if i == 0 || s.name != samples[i-1].name {
+			bw.WriteString("# TYPE " + s.name + " " + s.typ + "\n") //nolint:all
+		}
+		bw.WriteString(s.name + s.labels + " " + s.value + "\n") //nolint:all
+	}
+	return bw.Flush()
+}
+
+// promName replaces the characters not allowed in Prometheus metric names, or label names
+// which may not contain colons.
+func promName(name string, colons bool) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':' && colons || c >= '0' && c <= '9' && i > 0) {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+func formatPrometheusValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,207 @@
+package protocol
+
+import (
+	"context"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+)
+
+func gauge(name string, value float64) model.Metrics {
+	return model.Metrics{ID: name, MType: model.MetricTypeGauge, Value: &value}
+}
+
+func counter(name string, delta int64) model.Metrics {
+	return model.Metrics{ID: name, MType: model.MetricTypeCounter, Delta: &delta}
+}
+
+func TestParseStatsD(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    StatsDSample
+		wantErr string
+	}{
+		{line: "orders:1|c", want: StatsDSample{Name: "orders", Value: 1, Kind: "c", Rate: 1}},
+		{line: "orders:3|c|@0.5", want: StatsDSample{Name: "orders", Value: 3, Kind: "c", Rate: 0.5}},
+		{line: "queue:12.5|g|#env:prod,canary", want: StatsDSample{Name: "queue;canary;env=prod", Value: 12.5, Kind: "g", Rate: 1}},
+		{line: "queue:-2|g", want: StatsDSample{Name: "queue", Value: -2, Kind: "g", Rate: 1, Relative: true}},
+		{line: "latency:320|ms|@0.1", want: StatsDSample{Name: "latency", Value: 320, Kind: "ms", Rate: 0.1}},
+		{line: "orders", wantErr: "missing metric value"},
+		{line: "orders:1", wantErr: "missing metric type"},
+		{line: ":1|c", wantErr: "missing metric name"},
+		{line: "orders:many|c", wantErr: `invalid value "many"`},
+		{line: "orders:1|c|@2", wantErr: `invalid sample rate "2"`},
+		{line: "users:42|s", wantErr: `unsupported metric type "s"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := ParseStatsD(tt.line)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStatsDSample_Metrics(t *testing.T) {
+	tests := []struct {
+		line    string
+		current float64
+		want    []model.Metrics
+	}{
+		{line: "orders:3|c|@0.5", want: []model.Metrics{counter("orders", 6)}},
+		{line: "queue:4|g", current: 10, want: []model.Metrics{gauge("queue", 4)}},
+		{line: "queue:-4|g", current: 10, want: []model.Metrics{gauge("queue", 6)}},
+		{line: "latency:250|ms|@0.5|#route:/api", want: []model.Metrics{gauge("latency;route=/api", 250), counter("latency.count;route=/api", 2)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			s, err := ParseStatsD(tt.line)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, s.Metrics(tt.current))
+		})
+	}
+}
+
+func TestParseInflux(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    []model.Metrics
+		wantErr string
+	}{
+		{name: "value field", line: "temperature value=21.5", want: []model.Metrics{gauge("temperature", 21.5)}},
+		{
+			name: "tags and timestamp",
+			line: "cpu,host=web1,region=eu usage_idle=90.5,usage_user=4i 1700000000000000000",
+			want: []model.Metrics{gauge("cpu.usage_idle;host=web1;region=eu", 90.5), gauge("cpu.usage_user;host=web1;region=eu", 4)},
+		},
+		{name: "unsigned and boolean", line: "disk free=42u,healthy=t,degraded=false", want: []model.Metrics{gauge("disk.free", 42), gauge("disk.healthy", 1), gauge("disk.degraded", 0)}},
+		{name: "strings are skipped", line: `app,env=prod status="ok, fine",uptime=10`, want: []model.Metrics{gauge("app.uptime;env=prod", 10)}},
+		{name: "escaped characters", line: `my\ app,path=C:\\tmp\,x hits=1`, want: []model.Metrics{gauge(`my app.hits;path=C:\tmp,x`, 1)}},
+		{name: "comment", line: "# exported by app"},
+		{name: "empty", line: "  "},
+		{name: "missing fields", line: "temperature", wantErr: "missing fields"},
+		{name: "missing measurement", line: ",host=a value=1", wantErr: "missing measurement"},
+		{name: "invalid tag", line: "cpu,host value=1", wantErr: `invalid tag "host"`},
+		{name: "invalid field", line: "cpu usage", wantErr: `invalid field "usage"`},
+		{name: "invalid value", line: "cpu usage=high", wantErr: `invalid value of field "usage": strconv.ParseFloat: parsing "high": invalid syntax`},
+		{name: "invalid timestamp", line: "cpu usage=1 yesterday", wantErr: `invalid timestamp "yesterday"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseInflux(tt.line)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestWithTag(t *testing.T) {
+	require.Equal(t, "orders;origin=dc1", WithTag("orders", "origin", "dc1"))
+	require.Equal(t, "orders;env=prod;origin=dc1;zone", WithTag("orders;zone;env=prod", "origin", "dc1"))
+	require.Equal(t, "orders;origin=dc2", WithTag("orders;origin=dc1", "origin", "dc2"))
+}
+
+func TestParseGraphite(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    model.Metrics
+		wantErr string
+	}{
+		{line: "servers.web1.load 0.75 1700000000", want: gauge("servers.web1.load", 0.75)},
+		{line: "queue.size 12", want: gauge("queue.size", 12)},
+		{line: "disk.used;mount=/;host=web1 42 1700000000", want: gauge("disk.used;host=web1;mount=/", 42)},
+		{line: "queue.size", wantErr: "missing metric value"},
+		{line: ";host=a 1", wantErr: "missing metric path"},
+		{line: "disk.used;host 1", wantErr: `invalid tag "host"`},
+		{line: "queue.size many", wantErr: `invalid value "many"`},
+		{line: "queue.size 1 now", wantErr: `invalid timestamp "now"`},
+		{line: "queue.size 1 1700000000 extra", wantErr: "unexpected text after the timestamp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := ParseGraphite(tt.line)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFormatGraphite(t *testing.T) {
+	require.Equal(t, "yametrics.Alloc 1.5 1700000000\n", FormatGraphite(gauge("Alloc", 1.5), "yametrics.", 1700000000))
+	require.Equal(t, "PollCount 42 1700000000\n", FormatGraphite(counter("PollCount", 42), "", 1700000000))
+	require.Equal(t, "my_metric;env=prod 1 1700000000\n", FormatGraphite(gauge("my metric;env=prod", 1), "", 1700000000))
+
+	m, err := ParseGraphite(FormatGraphite(gauge("disk.used;host=web1", 3), "", 1700000000))
+	require.NoError(t, err)
+	require.Equal(t, gauge("disk.used;host=web1", 3), m)
+}
+
+func TestFormatPrometheusText(t *testing.T) {
+	var b strings.Builder
+	require.NoError(t, FormatPrometheusText(&b, []model.Metrics{
+		gauge("disk.used;mount=/;host=web1", 42),
+		counter("PollCount", 7),
+		gauge("Alloc", 1.5),
+		gauge("disk.used;mount=/home;host=web1", 0.25),
+		gauge("latency;quote=say \"hi\";zone", math.Inf(1)),
+		{ID: "empty", MType: model.MetricTypeGauge},
+	}))
+	require.Equal(t, `# TYPE Alloc gauge
+Alloc 1.5
+# TYPE PollCount counter
+PollCount 7
+# TYPE disk_used gauge
+disk_used{host="web1",mount="/"} 42
+disk_used{host="web1",mount="/home"} 0.25
+# TYPE latency gauge
+latency{quote="say \"hi\"",zone=""} +Inf
+`, b.String())
+}
+
+func TestServePackets(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	packets := make(chan []string, 1)
+	served := make(chan error, 1)
+	go func() { served <- ServePackets(ctx, conn, func(lines []string) { packets <- lines }) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer client.Close() //nolint:all
+	_, err = client.Write([]byte("orders:1|c\n\n queue:2|g \n"))
+	require.NoError(t, err)
+	select {
+	case lines := <-packets:
+		require.Equal(t, []string{"orders:1|c", "queue:2|g"}, lines)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no packet was handled")
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServePackets did not return after the context was done")
+	}
+}
@@ -0,0 +1,298 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+)
+
+// RemoteWrite is a decoded Prometheus remote write request.
+type RemoteWrite struct {
+	Series   []RemoteWriteSeries
+	Metadata map[string]string // Type of every metric family described in the request, e.g. counter or gauge
+}
+
+// RemoteWriteSeries is a time series of a remote write request, the metric name is the __name__ label.
+type RemoteWriteSeries struct {
+	Labels  map[string]string
+	Samples []RemoteWriteSample
+}
+
+// RemoteWriteSample is a sample of a time series.
+type RemoteWriteSample struct {
+	Value     float64
+	Timestamp int64 // Milliseconds since the Unix epoch
+}
+
+// Metric types of remote write metadata by their protobuf values.
+var metricTypes = []string{"unknown", "counter", "gauge", "histogram", "gaugehistogram", "summary", "info", "stateset"}
+
+// ParseRemoteWrite decodes an uncompressed WriteRequest protobuf message of the Prometheus
+// remote write protocol 1.0. Native histograms and exemplars are skipped.
+func ParseRemoteWrite(data []byte) (RemoteWrite, error) {
+	req := RemoteWrite{Metadata: make(map[string]string)}
+	err := fields(data, func(num protowire.Number, typ protowire.Type, _ uint64, b []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			series, err := parseSeries(b)
+			if err != nil {
+				return fmt.Errorf("timeseries: %w", err)
+			}
+			req.Series = append(req.Series, series)
+		case num == 3 && typ == protowire.BytesType:
+			var family, kind string
+			err := fields(b, func(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+				switch {
+				case num == 1 && typ == protowire.VarintType && v < uint64(len(metricTypes)):
+					kind = metricTypes[v]
+				case num == 2 && typ == protowire.BytesType:
+					family = string(b)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("metadata: %w", err)
+			}
+			if family != "" && kind != "" {
+				req.Metadata[family] = kind
+			}
+		}
+		return nil
+	})
+	return req, err
+}
+
+func parseSeries(data []byte) (RemoteWriteSeries, error) {
+	series := RemoteWriteSeries{Labels: make(map[string]string)}
+	err := fields(data, func(num protowire.Number, typ protowire.Type, _ uint64, b []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			var name, value string
+			err := fields(b, func(num protowire.Number, typ protowire.Type, _ uint64, b []byte) error {
+				switch {
+				case num == 1 && typ == protowire.BytesType:
+					name = string(b)
+				case num == 2 && typ == protowire.BytesType:
+					value = string(b)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("label: %w", err)
+			}
+			series.Labels[name] = value
+		case num == 2 && typ == protowire.BytesType:
+			var sample RemoteWriteSample
+			err := fields(b, func(num protowire.Number, typ protowire.Type, v uint64, _ []byte) error {
+				switch {
+				case num == 1 && typ == protowire.Fixed64Type:
+					sample.Value = math.Float64frombits(v)
+				case num == 2 && typ == protowire.VarintType:
+					sample.Timestamp = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("sample: %w", err)
+			}
+			series.Samples = append(series.Samples, sample)
+		}
+		return nil
+	})
+	return series, err
+}
+
+// fields calls fn for every field of the protobuf message in data, passing varint and fixed-size
+// values as v and the contents of length-delimited fields as b.
+func fields(data []byte, fn func(num protowire.Number, typ protowire.Type, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		var (
+			v uint64
+			b []byte
+		)
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(data)
+			v = uint64(v32)
+		case protowire.BytesType:
+			b, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if err := fn(num, typ, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrometheusConverter maps Prometheus time series onto metrics models, keeping the latest sample
+// of every series. The series are named by their __name__ label with the other labels appended.
+//
+// Counters, and the _bucket and _count series of histograms and summaries, become counters
+// incremented by the change since the previous sample; the first sample of a series is taken as
+// a baseline. Series of metric families without metadata are taken as counters if their name ends
+// with _total. All other series become gauges. Staleness markers and samples older than the
+// previous sample of the series are skipped. Series without samples and metric families without
+// metadata for staleSeriesAge are forgotten.
+type PrometheusConverter struct {
+	mu      sync.Mutex
+	types   map[string]familyType   // Type of every metric family from metadata
+	series  map[string]latestSample // Previous sample of every series by name
+	expired time.Time               // When stale series were last forgotten
+}
+
+// familyType is the type of a metric family from metadata.
+type familyType struct {
+	kind string
+	seen time.Time // When the metadata was last received
+}
+
+// latestSample is the previous sample of a series.
+type latestSample struct {
+	RemoteWriteSample
+	seen time.Time // When the sample was stored
+}
+
+// NewPrometheusConverter creates a PrometheusConverter.
+func NewPrometheusConverter() *PrometheusConverter {
+	return &PrometheusConverter{
+		types:   make(map[string]familyType),
+		series:  make(map[string]latestSample),
+		expired: time.Now(),
+	}
+}
+
+// Convert converts the series of req and passes the metrics to store, returning its error.
+// Metadata is remembered for later requests, as Prometheus sends it separately from the samples.
+// The converter is held until store returns, so that concurrent requests for the same series
+// convert against the samples stored by each other. The samples are remembered only if store
+// succeeds; otherwise req converts to the same changes again, so that a failed update can be
+// retried without losing them.
+func (c *PrometheusConverter) Convert(req RemoteWrite, store func([]model.Metrics) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for family, kind := range req.Metadata {
+		c.types[family] = familyType{kind: kind, seen: now}
+	}
+	var metrics []model.Metrics
+	pending := make(map[string]RemoteWriteSample)
+	for _, series := range req.Series {
+		name := series.Labels["__name__"]
+		if name == "" || len(series.Samples) == 0 {
+			continue
+		}
+		latest := series.Samples[0]
+		for _, sample := range series.Samples[1:] {
+			if sample.Timestamp >= latest.Timestamp {
+				latest = sample
+			}
+		}
+		if math.IsNaN(latest.Value) || math.IsInf(latest.Value, 0) {
+			continue
+		}
+		labels := make(map[string]string, len(series.Labels)-1)
+		for k, v := range series.Labels {
+			if k != "__name__" {
+				labels[k] = v
+			}
+		}
+		id := Name(name, labels)
+		prev, seen := pending[id]
+		if !seen {
+			var stored latestSample
+			stored, seen = c.series[id]
+			prev = stored.RemoteWriteSample
+		}
+		if seen && latest.Timestamp <= prev.Timestamp {
+			continue
+		}
+		pending[id] = latest
+		if !c.counter(name) {
+			metrics = append(metrics, gaugeMetric(id, latest.Value))
+			continue
+		}
+		var delta float64
+		switch {
+		case !seen:
+		case latest.Value < prev.Value:
+			// The counter has been reset.
+			delta = math.Round(latest.Value)
+		default:
+			delta = math.Round(latest.Value) - math.Round(prev.Value)
+		}
+		metrics = append(metrics, counterMetric(id, int64(delta)))
+	}
+	if err := store(metrics); err != nil {
+		return err
+	}
+	c.commit(pending)
+	return nil
+}
+
+// commit remembers the samples of a conversion, and forgets stale series and metric families.
+func (c *PrometheusConverter) commit(pending map[string]RemoteWriteSample) {
+	now := time.Now()
+	for id, sample := range pending {
+		c.series[id] = latestSample{RemoteWriteSample: sample, seen: now}
+	}
+	if now.Sub(c.expired) < staleSeriesAge {
+		return
+	}
+	c.expired = now
+	for id, sample := range c.series {
+		if now.Sub(sample.seen) >= staleSeriesAge {
+			delete(c.series, id)
+		}
+	}
+	for family, t := range c.types {
+		if now.Sub(t.seen) >= staleSeriesAge {
+			delete(c.types, family)
+		}
+	}
+}
+
+// counter reports whether the series named name is cumulative.
+func (c *PrometheusConverter) counter(name string) bool {
+	if t, ok := c.types[name]; ok && t.kind != "unknown" {
+		return t.kind == "counter"
+	}
+	for _, suffix := range []string{"_total", "_bucket", "_count"} {
+		family, ok := strings.CutSuffix(name, suffix)
+		if !ok {
+			continue
+		}
+		t, known := c.types[family]
+		switch {
+		case !known || t.kind == "unknown":
+			return suffix == "_total"
+		case t.kind == "counter":
+			return true
+		case t.kind == "histogram" || t.kind == "summary":
+			return suffix != "_total"
+		}
+		return false
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package protocol
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+)
+
+// readRemoteWrite decodes a recorded request, encoded with the Prometheus prompb package and
+// snappy as remote write clients send it: testdata/remote_write_metadata.snappy holds the metadata,
+// the samples files hold two consecutive sends of the same series 15s apart.
+func readRemoteWrite(t *testing.T, name string) RemoteWrite {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", "remote_write_"+name+".snappy"))
+	require.NoError(t, err)
+	data, err := snappy.Decode(nil, body)
+	require.NoError(t, err)
+	req, err := ParseRemoteWrite(data)
+	require.NoError(t, err)
+	return req
+}
+
+func TestParseRemoteWrite(t *testing.T) {
+	metadata := readRemoteWrite(t, "metadata")
+	require.Empty(t, metadata.Series)
+	require.Equal(t, map[string]string{
+		"http_requests_total":           "counter",
+		"go_goroutines":                 "gauge",
+		"http_request_duration_seconds": "histogram",
+		"queue_jobs_total":              "gauge",
+	}, metadata.Metadata)
+
+	samples := readRemoteWrite(t, "samples-1")
+	require.Len(t, samples.Series, 6)
+	require.Equal(t, RemoteWriteSeries{
+		Labels:  map[string]string{"__name__": "http_requests_total", "code": "200", "instance": "edge-1:9100", "job": "node"},
+		Samples: []RemoteWriteSample{{Value: 100, Timestamp: 1699999985000}, {Value: 110, Timestamp: 1700000000000}},
+	}, samples.Series[1])
+
+	_, err := ParseRemoteWrite([]byte{0x0a, 0x05, 0x0a})
+	require.Error(t, err)
+}
+
+func TestPrometheusConverter_Convert(t *testing.T) {
+	const (
+		goroutines = "go_goroutines;instance=edge-1:9100;job=node"
+		requests   = "http_requests_total;code=200;instance=edge-1:9100;job=node"
+		bucket     = "http_request_duration_seconds_bucket;instance=edge-1:9100;job=node;le=0.5"
+		sum        = "http_request_duration_seconds_sum;instance=edge-1:9100;job=node"
+		queue      = "queue_jobs_total;instance=edge-1:9100;job=node"
+	)
+
+	convert := func(c *PrometheusConverter, req RemoteWrite) []model.Metrics {
+		var got []model.Metrics
+		require.NoError(t, c.Convert(req, func(metrics []model.Metrics) error {
+			got = metrics
+			return nil
+		}))
+		return got
+	}
+
+	t.Run("with metadata", func(t *testing.T) {
+		c := NewPrometheusConverter()
+		require.Empty(t, convert(c, readRemoteWrite(t, "metadata")))
+		require.Equal(t, []model.Metrics{
+			gauge(goroutines, 42),
+			counter(requests, 0),
+			counter(bucket, 0),
+			gauge(sum, 3.25),
+			gauge(queue, 5),
+		}, convert(c, readRemoteWrite(t, "samples-1")), "stale series are skipped and counters start from a baseline")
+		require.Equal(t, []model.Metrics{
+			gauge(goroutines, 42),
+			counter(requests, 12),
+			counter(bucket, 12),
+			gauge(sum, 15.25),
+			gauge(queue, 5),
+		}, convert(c, readRemoteWrite(t, "samples-2")))
+		require.Empty(t, convert(c, readRemoteWrite(t, "samples-2")), "samples already received are skipped")
+	})
+
+	t.Run("without metadata", func(t *testing.T) {
+		c := NewPrometheusConverter()
+		got := convert(c, readRemoteWrite(t, "samples-1"))
+		require.Contains(t, got, counter(queue, 0), "_total series are counters")
+		require.Contains(t, got, gauge(bucket, 7))
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		c := NewPrometheusConverter()
+		series := func(value float64, timestamp int64) RemoteWrite {
+			return RemoteWrite{Series: []RemoteWriteSeries{{
+				Labels:  map[string]string{"__name__": "jobs_total"},
+				Samples: []RemoteWriteSample{{Value: value, Timestamp: timestamp}},
+			}}}
+		}
+		convert(c, series(50, 1))
+		require.Equal(t, []model.Metrics{counter("jobs_total", 3)}, convert(c, series(3, 2)))
+	})
+
+	t.Run("changes are remembered once committed", func(t *testing.T) {
+		c := NewPrometheusConverter()
+		series := func(value float64, timestamp int64) RemoteWrite {
+			return RemoteWrite{Series: []RemoteWriteSeries{{
+				Labels:  map[string]string{"__name__": "jobs_total"},
+				Samples: []RemoteWriteSample{{Value: value, Timestamp: timestamp}},
+			}}}
+		}
+		convert(c, series(10, 1))
+		var got []model.Metrics
+		require.ErrorIs(t, c.Convert(series(15, 2), func(metrics []model.Metrics) error {
+			got = metrics
+			return errStore
+		}), errStore)
+		require.Equal(t, []model.Metrics{counter("jobs_total", 5)}, got)
+		require.Equal(t, []model.Metrics{counter("jobs_total", 5)}, convert(c, series(15, 2)), "a failed update is not remembered")
+		require.Empty(t, convert(c, series(15, 2)))
+
+		sample := c.series["jobs_total"]
+		sample.seen = sample.seen.Add(-staleSeriesAge)
+		c.series["jobs_total"] = sample
+		c.expired = c.expired.Add(-staleSeriesAge)
+		convert(c, RemoteWrite{})
+		require.Empty(t, c.series, "stale series are forgotten")
+	})
+
+	t.Run("stale metadata is forgotten", func(t *testing.T) {
+		c := NewPrometheusConverter()
+		convert(c, RemoteWrite{Metadata: map[string]string{"jobs": "counter"}})
+		family := c.types["jobs"]
+		family.seen = family.seen.Add(-staleSeriesAge)
+		c.types["jobs"] = family
+		c.expired = c.expired.Add(-staleSeriesAge)
+		convert(c, RemoteWrite{Metadata: map[string]string{"queue": "gauge"}})
+		require.NotContains(t, c.types, "jobs")
+		require.Contains(t, c.types, "queue")
+	})
+
+	t.Run("concurrent requests count a change once", func(t *testing.T) {
+		c := NewPrometheusConverter()
+		series := RemoteWrite{Series: []RemoteWriteSeries{{
+			Labels:  map[string]string{"__name__": "jobs_total"},
+			Samples: []RemoteWriteSample{{Value: 10, Timestamp: 1}},
+		}}}
+		convert(c, series)
+		series.Series[0].Samples[0] = RemoteWriteSample{Value: 15, Timestamp: 2}
+		var (
+			mu    sync.Mutex
+			total int64
+			wg    sync.WaitGroup
+		)
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				require.NoError(t, c.Convert(series, func(metrics []model.Metrics) error {
+					// Storing takes a while, other requests convert meanwhile unless they are held.
+					time.Sleep(10 * time.Millisecond)
+					mu.Lock()
+					defer mu.Unlock()
+					for _, m := range metrics {
+						total += *m.Delta
+					}
+					return nil
+				}))
+			}()
+		}
+		wg.Wait()
+		require.Equal(t, int64(5), total)
+	})
+}
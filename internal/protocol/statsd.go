@@ -0,0 +1,97 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+)
+
+// StatsDSample is a parsed StatsD line `name:value|type[|@rate][|#tag:value,...]`.
+type StatsDSample struct {
+	Name     string  // Metric name with the tags appended
+	Value    float64 // Value as sent
+	Kind     string  // c, g, ms or h
+	Rate     float64 // Sample rate in (0, 1]
+	Relative bool    // The gauge value is a change of the current value
+}
+
+// ParseStatsD parses a single StatsD line.
+func ParseStatsD(line string) (StatsDSample, error) {
+	s := StatsDSample{Rate: 1}
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok {
+		return s, errors.New("missing metric value")
+	}
+	if name == "" {
+		return s, errors.New("missing metric name")
+	}
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 {
+		return s, errors.New("missing metric type")
+	}
+	s.Kind = fields[1]
+	value := fields[0]
+	s.Relative = s.Kind == "g" && (strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-"))
+	var err error
+	if s.Value, err = strconv.ParseFloat(value, 64); err != nil || math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+		return s, fmt.Errorf("invalid value %q", value)
+	}
+	tags := make(map[string]string)
+	for _, field := range fields[2:] {
+		if rate, ok := strings.CutPrefix(field, "@"); ok {
+			if s.Rate, err = strconv.ParseFloat(rate, 64); err != nil || s.Rate <= 0 || s.Rate > 1 {
+				return s, fmt.Errorf("invalid sample rate %q", rate)
+			}
+		}
+		if list, ok := strings.CutPrefix(field, "#"); ok {
+			for _, tag := range strings.Split(list, ",") {
+				key, value, _ := strings.Cut(tag, ":")
+				if key != "" {
+					tags[key] = value
+				}
+			}
+		}
+	}
+	s.Name = Name(name, tags)
+	switch s.Kind {
+	case "c", "g", "ms", "h":
+		return s, nil
+	}
+	return s, fmt.Errorf("unsupported metric type %q", s.Kind)
+}
+
+// Metrics translates the sample into metrics:
+//
+// - c: a counter incremented by the value divided by the sample rate, rounded to an integer.
+// - g: a gauge set to the value, or to current changed by the value if it is prefixed with a sign.
+// - ms and h: a gauge set to the observed value and a counter name.count incremented by
+// the number of observations, scaled by the sample rate.
+func (s StatsDSample) Metrics(current float64) []model.Metrics {
+	switch s.Kind {
+	case "c":
+		delta := int64(math.Round(s.Value / s.Rate))
+		return []model.Metrics{{ID: s.Name, MType: model.MetricTypeCounter, Delta: &delta}}
+	case "g":
+		value := s.Value
+		if s.Relative {
+			value += current
+		}
+		return []model.Metrics{{ID: s.Name, MType: model.MetricTypeGauge, Value: &value}}
+	default:
+		value := s.Value
+		count := int64(math.Round(1 / s.Rate))
+		base, tags, _ := strings.Cut(s.Name, ";")
+		countName := base + ".count"
+		if tags != "" {
+			countName += ";" + tags
+		}
+		return []model.Metrics{
+			{ID: s.Name, MType: model.MetricTypeGauge, Value: &value},
+			{ID: countName, MType: model.MetricTypeCounter, Delta: &count},
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Package ratelimit provides token-bucket rate limiting keyed by an arbitrary string,
+// such as a client IP address or a client key.
+package ratelimit
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+// maxBuckets is the number of buckets kept, the least recently used one is evicted for a new key.
+const maxBuckets = 10000
+
+// bucket holds the token state of a single key.
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token-bucket rate limiter keeping a separate bucket per key.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64                  // Tokens added per second
+	burst   float64                  // Bucket capacity
+	buckets map[string]*list.Element // Elements of recent by key
+	recent  *list.List               // Buckets by last use, the most recent first
+	now     func() time.Time
+}
+
+// NewLimiter creates a new Limiter allowing rate requests per second per key
+// with bursts of up to burst requests. If burst is less than 1, it defaults to rate.
+func NewLimiter(rate float64, burst int) *Limiter {
+	b := float64(burst)
+	if b < 1 {
+		b = math.Max(rate, 1)
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   b,
+		buckets: make(map[string]*list.Element),
+		recent:  list.New(),
+		now:     time.Now,
+	}
+}
+
+// Allow reports whether a request for the given key may proceed.
+// If it may not, it also returns the time after which a retry is expected to succeed.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	var b *bucket
+	if e, ok := l.buckets[key]; ok {
+		l.recent.MoveToFront(e)
+		b = e.Value.(*bucket)
+	} else {
+		if l.recent.Len() >= maxBuckets {
+			// The least recently used client starts over with a full bucket.
+			delete(l.buckets, l.recent.Remove(l.recent.Back()).(*bucket).key)
+		}
+		b = &bucket{key: key, tokens: l.burst, last: now}
+		l.buckets[key] = l.recent.PushFront(b)
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
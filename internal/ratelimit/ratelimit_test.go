@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a")
+		require.True(t, ok, "request %d within burst", i)
+	}
+	ok, wait := l.Allow("a")
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, wait)
+
+	ok, _ = l.Allow("b")
+	require.True(t, ok, "keys have separate buckets")
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.Allow("a")
+	require.True(t, ok, "bucket refilled")
+	ok, _ = l.Allow("a")
+	require.False(t, ok)
+}
+
+func TestLimiter_maxBuckets(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(1, 1)
+	l.now = func() time.Time { return now }
+
+	ok, _ := l.Allow("first")
+	require.True(t, ok)
+	for i := 0; i < maxBuckets; i++ {
+		// None of the buckets refill, they are all in use.
+		l.Allow(strconv.Itoa(i))
+	}
+	require.Len(t, l.buckets, maxBuckets)
+	require.NotContains(t, l.buckets, "first", "the least recently used bucket is evicted")
+
+	ok, _ = l.Allow(strconv.Itoa(maxBuckets - 1))
+	require.False(t, ok, "recent buckets are kept")
+}
@@ -0,0 +1,117 @@
+// Package remote fetches the JSON listing of the metrics of other yametrics servers and agents,
+// as used by the federation, the scraping of agents and the snapshots of replication.
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/signature"
+)
+
+// MaxResponseSize is the size up to which a listing is read.
+const MaxResponseSize = 64 << 20
+
+// ErrSignature is returned by Fetch when the listing is not signed with the key.
+var ErrSignature = errors.New("the response signature does not match the key")
+
+type lister interface {
+	ListMetrics(ctx context.Context) ([]model.Metrics, error)
+}
+
+// Fetch gets the JSON listing of metrics at url, verifying its signature in the HashSHA256
+// header if key is not empty.
+func Fetch(ctx context.Context, client *http.Client, url, key string) ([]model.Metrics, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint:all
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if key != "" {
+		ok, err := signature.Verify(key, body, resp.Header.Get("HashSHA256"))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrSignature
+		}
+	}
+	var metrics []model.Metrics
+	if err := json.Unmarshal(body, &metrics); err != nil {
+		return nil, fmt.Errorf("decoding the metrics: %w", err)
+	}
+	return metrics, nil
+}
+
+// CounterTotals returns the totals of the local counters by ID, so that the totals of fetched
+// counters can be stored as the increments to them.
+func CounterTotals(ctx context.Context, local lister) (map[string]int64, error) {
+	list, err := local.ListMetrics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ListMetrics: %w", err)
+	}
+	totals := make(map[string]int64)
+	for _, m := range list {
+		if m.MType == model.MetricTypeCounter && m.Delta != nil {
+			totals[m.ID] = *m.Delta
+		}
+	}
+	return totals, nil
+}
+
+// Totals remembers the last fetched total of every counter of a remote end, so that the fetched
+// totals can be stored as increments even when the remote end resets its counters. It is not safe
+// for concurrent use.
+type Totals struct {
+	last map[string]int64 // Totals of the last fetch whose increments were stored, by ID
+}
+
+// Delta returns the increment of the local counter id for its fetched total. A counter fetched for
+// the first time is brought from its local total to the fetched one. A total below the previous
+// one, or below the local total on the first fetch, means that the remote end has reset the counter,
+// e.g. by restarting, and counts in full.
+func (t *Totals) Delta(id string, total int64, local map[string]int64) int64 {
+	prev, ok := t.last[id]
+	if !ok {
+		prev = local[id]
+	}
+	if total < prev {
+		return total
+	}
+	return total - prev
+}
+
+// Commit remembers the totals of a fetch once their increments are stored. Counters missing from
+// the fetch are forgotten.
+func (t *Totals) Commit(totals map[string]int64) {
+	t.last = totals
+}
+
+// AcceptsJSON reports whether the request asks for the JSON listing in its Accept header.
+func AcceptsJSON(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(accepted); err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
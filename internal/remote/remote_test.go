@@ -0,0 +1,81 @@
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/signature"
+)
+
+type fakeLister []model.Metrics
+
+func (l fakeLister) ListMetrics(context.Context) ([]model.Metrics, error) {
+	return l, nil
+}
+
+func TestFetch(t *testing.T) {
+	body := []byte(`[{"id":"Alloc","type":"gauge","value":1.5}]`)
+	sig, err := signature.NewSha256Sig("secret", body).Generate()
+	require.NoError(t, err)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !AcceptsJSON(r) {
+			http.Error(w, "not acceptable", http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Set("HashSHA256", sig)
+		w.Write(body) //nolint:all
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	metrics, err := Fetch(ctx, srv.Client(), srv.URL, "secret")
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	require.Equal(t, 1.5, *metrics[0].Value)
+	_, err = Fetch(ctx, srv.Client(), srv.URL, "other")
+	require.ErrorIs(t, err, ErrSignature)
+	_, err = Fetch(ctx, srv.Client(), srv.URL, "")
+	require.NoError(t, err, "the signature is not checked without a key")
+}
+
+func TestCounterTotals(t *testing.T) {
+	total := int64(7)
+	value := 2.0
+	totals, err := CounterTotals(context.Background(), fakeLister{
+		{ID: "PollCount", MType: model.MetricTypeCounter, Delta: &total},
+		{ID: "Alloc", MType: model.MetricTypeGauge, Value: &value},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"PollCount": 7}, totals)
+}
+
+func TestAcceptsJSON(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"application/json":                  true,
+		"text/html, application/json;q=0.9": true,
+		"text/html":                         false,
+		"":                                  false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", accept)
+		require.Equal(t, want, AcceptsJSON(r), accept)
+	}
+}
+
+func TestTotals_Delta(t *testing.T) {
+	var totals Totals
+	local := map[string]int64{"PollCount": 4, "Restarted": 9}
+	require.Equal(t, int64(6), totals.Delta("PollCount", 10, local), "a new counter is brought to the fetched total")
+	require.Equal(t, int64(3), totals.Delta("Restarted", 3, local), "a total below the local one is a reset")
+	require.Equal(t, int64(2), totals.Delta("New", 2, local))
+	totals.Commit(map[string]int64{"PollCount": 10})
+
+	require.Equal(t, int64(5), totals.Delta("PollCount", 15, local))
+	require.Equal(t, int64(3), totals.Delta("PollCount", 3, local), "a total below the previous one is a reset")
+	require.Equal(t, int64(0), totals.Delta("PollCount", 10, local))
+}
@@ -0,0 +1,253 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/signature"
+)
+
+const (
+	sendTimeout = 10 * time.Second
+	// maxQueue is the number of batches a replica may fall behind before it is sent a snapshot instead.
+	maxQueue = 10000
+	// maxEvents is the number of batches sent in one message.
+	maxEvents  = 100
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+type lister interface {
+	ListMetrics(ctx context.Context) ([]model.Metrics, error)
+}
+
+// Primary streams the applied updates to replicas.
+type Primary struct {
+	source   lister
+	id       string
+	key      func() string // Returns the signing key in use
+	client   *http.Client
+	replicas []*follower
+	logger   *zap.SugaredLogger
+
+	mu  sync.Mutex // Serializes the updates, so that they reach the replicas in the order they were applied
+	seq uint64     // Sequence number of the last applied update
+}
+
+// follower is the streaming state of a replica.
+type follower struct {
+	url   string
+	ready chan struct{} // Signals queued updates
+
+	mu       sync.Mutex
+	from     uint64 // Sequence number the replica is at, events follow it
+	events   [][]model.Metrics
+	sync     bool // Whether the replica needs a snapshot
+	failures int  // Sends failed in a row
+}
+
+// NewPrimary creates a Primary streaming to the replicas at the base URLs, signing the
+// messages with the key returned by key if it is not empty, so that a reloaded key is used
+// right away. source provides the snapshots.
+func NewPrimary(source lister, replicas []string, key func() string, logger *zap.SugaredLogger) *Primary {
+	id := make([]byte, 8)
+	rand.Read(id) //nolint:all
+	p := &Primary{
+		source: source,
+		id:     hex.EncodeToString(id),
+		key:    key,
+		client: &http.Client{Timeout: sendTimeout},
+		logger: logger,
+	}
+	for _, url := range replicas {
+		p.replicas = append(p.replicas, &follower{
+			url:   strings.TrimSuffix(url, "/") + Path,
+			ready: make(chan struct{}, 1),
+			sync:  true,
+		})
+	}
+	return p
+}
+
+// Apply applies an update and queues it for the replicas if it succeeds.
+func (p *Primary) Apply(batch []model.Metrics, apply func() error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := apply(); err != nil {
+		return err
+	}
+	p.seq++
+	for _, f := range p.replicas {
+		f.mu.Lock()
+		switch {
+		case f.sync:
+			// The snapshot will include the update.
+		case len(f.events) >= maxQueue:
+			f.events, f.sync = nil, true
+		default:
+			f.events = append(f.events, batch)
+		}
+		f.mu.Unlock()
+		select {
+		case f.ready <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Run streams the updates to every replica until ctx is done. Failed sends are retried with an
+// exponential backoff, up to 30 seconds.
+func (p *Primary) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, f := range p.replicas {
+		wg.Add(1)
+		go func(f *follower) {
+			defer wg.Done()
+			p.stream(ctx, f)
+		}(f)
+	}
+	wg.Wait()
+}
+
+func (p *Primary) stream(ctx context.Context, f *follower) {
+	for {
+		msg, ok, err := p.next(ctx, f)
+		if err == nil && ok {
+			err = p.send(ctx, f.url, msg)
+		}
+		if err == nil && ok {
+			p.sent(f, msg)
+			continue
+		}
+		var wait <-chan time.Time
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			wait = time.After(p.failed(f, msg, err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.ready:
+		case <-wait:
+		}
+	}
+}
+
+// next returns the message to send to the replica, false if it is up to date. The snapshot
+// of a replica needing one is taken between updates, so that it continues from p.seq.
+func (p *Primary) next(ctx context.Context, f *follower) (Message, bool, error) {
+	f.mu.Lock()
+	needSync := f.sync
+	f.mu.Unlock()
+	if needSync {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		metrics, err := p.source.ListMetrics(ctx)
+		if err != nil {
+			return Message{}, false, fmt.Errorf("ListMetrics: %w", err)
+		}
+		f.mu.Lock()
+		f.events, f.sync = nil, false
+		f.mu.Unlock()
+		return Message{Primary: p.id, Seq: p.seq, Full: true, Metrics: metrics}, true, nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.events) == 0 {
+		return Message{}, false, nil
+	}
+	events := f.events[:min(len(f.events), maxEvents)]
+	return Message{Primary: p.id, From: f.from, Seq: f.from + uint64(len(events)), Events: events}, true, nil
+}
+
+// sent drops the events acknowledged by the replica.
+func (p *Primary) sent(f *follower, msg Message) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		p.logger.Infof("replication: streaming to %v recovered after %d failures", f.url, f.failures)
+		f.failures = 0
+	}
+	if f.sync {
+		return
+	}
+	if msg.Full {
+		f.from = msg.Seq
+		return
+	}
+	f.events = f.events[len(msg.Events):]
+	f.from = msg.Seq
+}
+
+// failed records a failed send and returns the time to wait before the next one.
+func (p *Primary) failed(f *follower, msg Message, err error) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if errors.Is(err, ErrOutOfSync) {
+		// The replica has restarted or lost updates, it is sent a snapshot right away.
+		p.logger.Infof("replication: %v is out of sync, sending a snapshot", f.url)
+		f.events, f.sync = nil, true
+		return 0
+	}
+	if msg.Full {
+		// The snapshot is taken again, so that it includes the updates queued meanwhile.
+		f.events, f.sync = nil, true
+	}
+	f.failures++
+	backoff := minBackoff << min(f.failures-1, 16)
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	p.logger.Warnf("replication: streaming to %v failed %d times in a row, retrying in %v: %v", f.url, f.failures, backoff, err)
+	return backoff
+}
+
+// send posts the message to the replica, ErrOutOfSync is returned if the replica needs a snapshot.
+func (p *Primary) send(ctx context.Context, url string, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key := p.key(); key != "" {
+		sig, err := signature.NewSha256Sig(key, body).Generate()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("HashSHA256", sig)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:all
+	reply, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusConflict:
+		return ErrOutOfSync
+	default:
+		return fmt.Errorf("unexpected status %v: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+}
@@ -0,0 +1,141 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mrkovshik/yametrics/internal/apperrors"
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/remote"
+	"github.com/mrkovshik/yametrics/internal/signature"
+)
+
+var (
+	// ErrOutOfSync is returned by Replica.Receive when the replica needs a snapshot to follow the primary.
+	ErrOutOfSync = errors.New("replica is out of sync with the primary")
+	// ErrPromoted is returned by Replica.Receive once the replica has been promoted.
+	ErrPromoted = errors.New("replica has been promoted and no longer follows a primary")
+	// ErrInvalidPromotion is returned by Replica.AcceptPromotion for an expired or replayed request.
+	ErrInvalidPromotion = errors.New("promotion request is expired or has been used")
+)
+
+type target interface {
+	ApplyReplicated(ctx context.Context, batch []model.Metrics) error
+
+	ListMetrics(ctx context.Context) ([]model.Metrics, error)
+}
+
+// Replica applies the updates streamed from the primary and rejects all other updates until it is promoted.
+type Replica struct {
+	target target
+	logger *zap.SugaredLogger
+
+	mu       sync.Mutex
+	primary  string
+	seq      uint64
+	promoted bool
+	nonces   signature.Nonces // Nonces of the accepted promotion requests
+}
+
+// NewReplica creates a Replica applying the streamed updates to target.
+func NewReplica(target target, logger *zap.SugaredLogger) *Replica {
+	return &Replica{target: target, logger: logger}
+}
+
+// Apply applies an update from a client if the replica has been promoted, and returns
+// apperrors.ErrReadOnly otherwise.
+func (r *Replica) Apply(_ []model.Metrics, apply func() error) error {
+	r.mu.Lock()
+	promoted := r.promoted
+	r.mu.Unlock()
+	if !promoted {
+		return apperrors.ErrReadOnly
+	}
+	return apply()
+}
+
+// Receive applies a message from the primary. Events are only applied in order, a message that does
+// not continue from the last one received fails with ErrOutOfSync and a snapshot is needed.
+func (r *Replica) Receive(ctx context.Context, msg Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.promoted {
+		return ErrPromoted
+	}
+	if msg.Full {
+		if err := r.restore(ctx, msg.Metrics); err != nil {
+			return err
+		}
+		if r.primary != msg.Primary {
+			r.logger.Infof("replication: following primary %v from update %d", msg.Primary, msg.Seq)
+		}
+		r.primary, r.seq = msg.Primary, msg.Seq
+		return nil
+	}
+	if msg.Primary != r.primary || msg.From != r.seq {
+		return ErrOutOfSync
+	}
+	for _, batch := range msg.Events {
+		if err := r.target.ApplyReplicated(ctx, batch); err != nil {
+			return err
+		}
+		r.seq++
+	}
+	return nil
+}
+
+// restore brings the metrics to the values of the snapshot. Gauges take the snapshot values and
+// counters are changed to the snapshot totals; metrics missing from the snapshot are kept.
+func (r *Replica) restore(ctx context.Context, snapshot []model.Metrics) error {
+	totals, err := remote.CounterTotals(ctx, r.target)
+	if err != nil {
+		return err
+	}
+	batch := make([]model.Metrics, 0, len(snapshot))
+	for _, m := range snapshot {
+		if m.MType == model.MetricTypeCounter && m.Delta != nil {
+			delta := *m.Delta - totals[m.ID]
+			m.Delta = &delta
+		}
+		batch = append(batch, m)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	return r.target.ApplyReplicated(ctx, batch)
+}
+
+// AcceptPromotion checks that a promotion request was made within PromoteMaxAge of now and that its
+// nonce has not been used, and records the nonce. It returns ErrInvalidPromotion otherwise.
+func (r *Replica) AcceptPromotion(req PromoteRequest, now time.Time) error {
+	if err := r.nonces.Accept(req, now); err != nil {
+		return ErrInvalidPromotion
+	}
+	return nil
+}
+
+// Promote makes the replica accept updates from clients and stop following the primary.
+func (r *Replica) Promote() Status {
+	r.mu.Lock()
+	if !r.promoted {
+		r.promoted = true
+		r.logger.Infof("replication: promoted to primary at update %d of primary %v", r.seq, r.primary)
+	}
+	r.mu.Unlock()
+	return r.Status()
+}
+
+// Status returns the replication state of the replica.
+func (r *Replica) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := Status{Role: RoleReplica, Primary: r.primary, Seq: r.seq}
+	if r.promoted {
+		status.Role = RolePrimary
+	}
+	return status
+}
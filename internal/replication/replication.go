@@ -0,0 +1,53 @@
+// Package replication streams the updates applied by a primary server to its replicas over HTTP,
+// so that the metrics of an in-memory server survive its loss.
+//
+// The primary numbers every applied batch of updates and sends the batches to each replica in
+// order. A replica that has missed updates, because the primary restarted, the replica restarted
+// or fell too far behind, gets a snapshot of all metrics instead and continues from there.
+// Replicas serve reads and reject updates from clients until they are promoted.
+package replication
+
+import (
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/signature"
+)
+
+// Path is the path of the replication endpoint of a replica, relative to its base URL.
+const Path = "/replication/"
+
+// Message is a request streaming updates from the primary to a replica.
+type Message struct {
+	Primary string `json:"primary"` // ID of the primary, new on every start
+	From    uint64 `json:"from"`    // Sequence number the replica must be at to apply Events
+	Seq     uint64 `json:"seq"`     // Sequence number of the replica after applying the message
+
+	// Full marks a snapshot, whose Metrics replace the values of the replica regardless of From.
+	Full    bool              `json:"full,omitempty"`
+	Metrics []model.Metrics   `json:"metrics,omitempty"`
+	Events  [][]model.Metrics `json:"events,omitempty"`
+}
+
+// PromoteMaxAge is how long a promotion request is valid after it was made.
+const PromoteMaxAge = signature.StampMaxAge
+
+// PromoteRequest is the body of a request promoting a replica. It is signed with the key like every
+// replication request; the stamp makes a signed request valid only once.
+type PromoteRequest = signature.Stamp
+
+// NewPromoteRequest returns a PromoteRequest made now with a random nonce.
+func NewPromoteRequest() PromoteRequest {
+	return signature.NewStamp()
+}
+
+// Status reports the replication state of a replica.
+type Status struct {
+	Role    string `json:"role"`              // replica, or primary once promoted
+	Primary string `json:"primary,omitempty"` // ID of the primary the updates were received from
+	Seq     uint64 `json:"seq"`               // Sequence number of the last update received
+}
+
+// Roles reported in Status.
+const (
+	RolePrimary = "primary"
+	RoleReplica = "replica"
+)
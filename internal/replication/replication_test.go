@@ -0,0 +1,175 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/mrkovshik/yametrics/internal/apperrors"
+	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/model"
+	server "github.com/mrkovshik/yametrics/internal/service/server"
+	"github.com/mrkovshik/yametrics/internal/storage"
+)
+
+func newService() *server.MetricService {
+	cfg := config.ServerConfig{}
+	return server.NewMetricService(storage.NewInMemoryStorage(), &cfg, zap.NewNop().Sugar())
+}
+
+func gauge(id string, v float64) model.Metrics {
+	return model.Metrics{ID: id, MType: model.MetricTypeGauge, Value: &v}
+}
+
+func counter(id string, d int64) model.Metrics {
+	return model.Metrics{ID: id, MType: model.MetricTypeCounter, Delta: &d}
+}
+
+func list(t *testing.T, s *server.MetricService) []model.Metrics {
+	t.Helper()
+	metrics, err := s.ListMetrics(context.Background())
+	require.NoError(t, err)
+	return metrics
+}
+
+func TestReplica_Receive(t *testing.T) {
+	ctx := context.Background()
+	metricService := newService()
+	r := NewReplica(metricService, zap.NewNop().Sugar())
+	metricService.WithReplication(r)
+
+	require.ErrorIs(t, metricService.UpdateMetrics(ctx, []model.Metrics{gauge("Alloc", 1)}), apperrors.ErrReadOnly)
+	require.ErrorIs(t, r.Receive(ctx, Message{Primary: "p1", From: 0, Seq: 1, Events: [][]model.Metrics{{gauge("Alloc", 1)}}}), ErrOutOfSync,
+		"updates need a snapshot first")
+
+	require.NoError(t, metricService.ApplyReplicated(ctx, []model.Metrics{counter("PollCount", 7), gauge("Stale", 1)}))
+	require.NoError(t, r.Receive(ctx, Message{Primary: "p1", Seq: 5, Full: true, Metrics: []model.Metrics{counter("PollCount", 3), gauge("Alloc", 1)}}))
+	require.Equal(t, []model.Metrics{gauge("Alloc", 1), counter("PollCount", 3), gauge("Stale", 1)}, list(t, metricService),
+		"counters take the snapshot totals")
+
+	require.NoError(t, r.Receive(ctx, Message{Primary: "p1", From: 5, Seq: 7, Events: [][]model.Metrics{{counter("PollCount", 2)}, {gauge("Alloc", 4)}}}))
+	require.ErrorIs(t, r.Receive(ctx, Message{Primary: "p1", From: 5, Seq: 6, Events: [][]model.Metrics{{counter("PollCount", 2)}}}), ErrOutOfSync,
+		"updates are not applied twice")
+	require.ErrorIs(t, r.Receive(ctx, Message{Primary: "p2", From: 7, Seq: 8, Events: [][]model.Metrics{{counter("PollCount", 2)}}}), ErrOutOfSync,
+		"a restarted primary sends a snapshot")
+	require.Equal(t, Status{Role: RoleReplica, Primary: "p1", Seq: 7}, r.Status())
+	require.Equal(t, []model.Metrics{gauge("Alloc", 4), counter("PollCount", 5), gauge("Stale", 1)}, list(t, metricService))
+
+	require.Equal(t, Status{Role: RolePrimary, Primary: "p1", Seq: 7}, r.Promote())
+	require.NoError(t, metricService.UpdateMetrics(ctx, []model.Metrics{counter("PollCount", 1)}))
+	require.ErrorIs(t, r.Receive(ctx, Message{Primary: "p1", From: 7, Seq: 8, Events: [][]model.Metrics{{counter("PollCount", 2)}}}), ErrPromoted)
+}
+
+// replicaStub serves a Replica the way the replication endpoint does. It fails with 500 while failing is set.
+func replicaStub(t *testing.T, r *Replica) (*httptest.Server, *atomic.Bool) {
+	t.Helper()
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var msg Message
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&msg))
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		err := r.Receive(req.Context(), msg)
+		switch {
+		case errors.Is(err, ErrOutOfSync):
+			w.WriteHeader(http.StatusConflict)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &failing
+}
+
+func TestPrimary_Run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	primaryService, replicaService := newService(), newService()
+	require.NoError(t, primaryService.UpdateMetrics(ctx, []model.Metrics{counter("PollCount", 10)}))
+	replica := NewReplica(replicaService, zap.NewNop().Sugar())
+	srv, failing := replicaStub(t, replica)
+	p := NewPrimary(primaryService, []string{srv.URL + "/"}, func() string { return "" }, zap.NewNop().Sugar())
+	primaryService.WithReplication(p)
+	stopped := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(stopped)
+	}()
+
+	inSync := func() bool {
+		return assert.ObjectsAreEqual(list(t, primaryService), list(t, replicaService))
+	}
+	require.Eventually(t, inSync, 2*time.Second, 10*time.Millisecond, "replicas start from a snapshot")
+	for i := 0; i < 5; i++ {
+		require.NoError(t, primaryService.UpdateMetrics(ctx, []model.Metrics{counter("PollCount", 1), gauge("Alloc", float64(i))}))
+	}
+	require.Eventually(t, inSync, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, uint64(5), replica.Status().Seq)
+
+	// Updates are queued while the replica is down and sent once it is back.
+	failing.Store(true)
+	require.NoError(t, primaryService.UpdateMetrics(ctx, []model.Metrics{counter("PollCount", 5)}))
+	require.Eventually(t, func() bool {
+		p.replicas[0].mu.Lock()
+		defer p.replicas[0].mu.Unlock()
+		return p.replicas[0].failures > 0
+	}, 2*time.Second, 10*time.Millisecond)
+	failing.Store(false)
+	require.Eventually(t, inSync, 5*time.Second, 10*time.Millisecond)
+
+	// A restarted replica is brought up to date with a snapshot.
+	replica.mu.Lock()
+	replica.primary, replica.seq = "", 0
+	replica.mu.Unlock()
+	require.NoError(t, primaryService.UpdateMetrics(ctx, []model.Metrics{gauge("Alloc", 42)}))
+	require.Eventually(t, inSync, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, Status{Role: RoleReplica, Primary: p.id, Seq: 7}, replica.Status())
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after the context was done")
+	}
+}
+
+func TestPrimary_ApplyOverflow(t *testing.T) {
+	p := NewPrimary(newService(), []string{"http://replica:8080"}, func() string { return "" }, zap.NewNop().Sugar())
+	f := p.replicas[0]
+	f.sync = false
+	apply := func() error { return nil }
+	for i := 0; i < maxQueue; i++ {
+		require.NoError(t, p.Apply(nil, apply))
+	}
+	require.Len(t, f.events, maxQueue)
+	require.False(t, f.sync)
+
+	require.NoError(t, p.Apply(nil, apply))
+	require.Empty(t, f.events, "a replica too far behind gets a snapshot instead")
+	require.True(t, f.sync)
+
+	require.Error(t, p.Apply(nil, func() error { return errors.New("storage failed") }))
+	require.Equal(t, uint64(maxQueue+1), p.seq, "failed updates are not streamed")
+}
+
+func TestReplica_AcceptPromotion(t *testing.T) {
+	r := NewReplica(nil, zap.NewNop().Sugar())
+	now := time.Now()
+	req := PromoteRequest{Time: now, Nonce: "a"}
+	require.NoError(t, r.AcceptPromotion(req, now))
+	require.ErrorIs(t, r.AcceptPromotion(req, now.Add(time.Second)), ErrInvalidPromotion, "nonces are used once")
+	require.ErrorIs(t, r.AcceptPromotion(PromoteRequest{Time: now.Add(-2 * PromoteMaxAge), Nonce: "b"}, now), ErrInvalidPromotion)
+	require.ErrorIs(t, r.AcceptPromotion(PromoteRequest{Time: now.Add(2 * PromoteMaxAge), Nonce: "c"}, now), ErrInvalidPromotion)
+	require.ErrorIs(t, r.AcceptPromotion(PromoteRequest{Time: now}, now), ErrInvalidPromotion)
+}
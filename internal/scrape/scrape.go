@@ -0,0 +1,154 @@
+// Package scrape pulls metrics from agents running in the pull mode, for hosts that cannot send
+// metrics to the server. Scraped metrics are labeled with the name of the target, e.g.
+// Alloc;instance=web1, and every scrape records the up and scrape_duration_seconds gauges of the
+// target, e.g. up;instance=web1 is 1 if the last scrape succeeded and 0 otherwise.
+package scrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/protocol"
+	"github.com/mrkovshik/yametrics/internal/remote"
+)
+
+// Names of the labels and metrics recorded for every target.
+const (
+	InstanceTag    = "instance"
+	UpMetric       = "up"
+	DurationMetric = "scrape_duration_seconds"
+)
+
+type service interface {
+	UpdateMetrics(ctx context.Context, batch []model.Metrics) error
+
+	ListMetrics(ctx context.Context) ([]model.Metrics, error)
+}
+
+// Scraper scrapes metrics from agents.
+type Scraper struct {
+	service service
+	targets []config.ScrapeTarget
+	key     func() string             // Returns the signing key in use
+	totals  map[string]*remote.Totals // Counter totals of the last stored scrape by target name
+	client  *http.Client
+	logger  *zap.SugaredLogger
+}
+
+// NewScraper creates a Scraper storing the metrics of targets through service. Responses must be
+// signed with the key returned by key if it is not empty, so that a reloaded key is used right away.
+func NewScraper(service service, targets []config.ScrapeTarget, key func() string, logger *zap.SugaredLogger) *Scraper {
+	totals := make(map[string]*remote.Totals, len(targets))
+	for _, t := range targets {
+		totals[t.Name] = &remote.Totals{}
+	}
+	return &Scraper{
+		service: service,
+		targets: targets,
+		key:     key,
+		totals:  totals,
+		client:  &http.Client{},
+		logger:  logger,
+	}
+}
+
+// Run scrapes every target at its interval until ctx is done. Targets going down and coming back
+// up are logged.
+func (s *Scraper) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range s.targets {
+		wg.Add(1)
+		go func(t config.ScrapeTarget) {
+			defer wg.Done()
+			ticker := time.NewTicker(t.Interval)
+			defer ticker.Stop()
+			up := true
+			for {
+				err := s.Scrape(ctx, t)
+				switch {
+				case err != nil && up && ctx.Err() == nil:
+					s.logger.Warnf("scrape: target %v is down: %v", t.Name, err)
+				case err == nil && !up:
+					s.logger.Infof("scrape: target %v is up", t.Name)
+				}
+				up = err == nil
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(t)
+	}
+	wg.Wait()
+}
+
+// Scrape stores the metrics of the target along with its up and scrape_duration_seconds gauges,
+// and returns the error the scrape failed with. Gauges take the scraped values, counters are
+// incremented by the change of the scraped totals, see remote.Totals. Scrapes of a target must not
+// run concurrently, and the totals of targets the Scraper was not created with are not remembered.
+func (s *Scraper) Scrape(ctx context.Context, t config.ScrapeTarget) error {
+	start := time.Now()
+	scrapeCtx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+	metrics, err := remote.Fetch(scrapeCtx, s.client, t.URL, s.key())
+	totals, ok := s.totals[t.Name]
+	if !ok {
+		totals = &remote.Totals{}
+	}
+	var (
+		batch   []model.Metrics
+		scraped map[string]int64
+	)
+	if err == nil {
+		batch, scraped, err = s.convert(ctx, t, totals, metrics)
+	}
+	up := 1.0
+	if err != nil {
+		up, batch = 0, nil
+	}
+	duration := time.Since(start).Seconds()
+	batch = append(batch,
+		model.Metrics{ID: protocol.WithTag(UpMetric, InstanceTag, t.Name), MType: model.MetricTypeGauge, Value: &up},
+		model.Metrics{ID: protocol.WithTag(DurationMetric, InstanceTag, t.Name), MType: model.MetricTypeGauge, Value: &duration},
+	)
+	if updateErr := s.service.UpdateMetrics(ctx, batch); updateErr != nil {
+		return errors.Join(err, fmt.Errorf("UpdateMetrics: %w", updateErr))
+	}
+	if err == nil {
+		totals.Commit(scraped)
+	}
+	return err
+}
+
+// convert labels the scraped metrics with the target and turns the counter totals into increments.
+// It also returns the scraped counter totals to commit once the increments are stored.
+func (s *Scraper) convert(ctx context.Context, t config.ScrapeTarget, totals *remote.Totals, metrics []model.Metrics) ([]model.Metrics, map[string]int64, error) {
+	local, err := remote.CounterTotals(ctx, s.service)
+	if err != nil {
+		return nil, nil, err
+	}
+	batch := make([]model.Metrics, 0, len(metrics)+2)
+	scraped := make(map[string]int64)
+	for _, m := range metrics {
+		id := protocol.WithTag(m.ID, InstanceTag, t.Name)
+		switch {
+		case m.MType == model.MetricTypeGauge && m.Value != nil:
+			value := *m.Value
+			batch = append(batch, model.Metrics{ID: id, MType: m.MType, Value: &value})
+		case m.MType == model.MetricTypeCounter && m.Delta != nil:
+			delta := totals.Delta(id, *m.Delta, local)
+			scraped[id] = *m.Delta
+			batch = append(batch, model.Metrics{ID: id, MType: m.MType, Delta: &delta})
+		}
+	}
+	return batch, scraped, nil
+}
@@ -0,0 +1,140 @@
+package scrape
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	agentconfig "github.com/mrkovshik/yametrics/internal/config/agent"
+	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/model"
+	agent "github.com/mrkovshik/yametrics/internal/service/agent"
+	server "github.com/mrkovshik/yametrics/internal/service/server"
+	"github.com/mrkovshik/yametrics/internal/storage"
+)
+
+func newService() *server.MetricService {
+	cfg := config.ServerConfig{}
+	return server.NewMetricService(storage.NewInMemoryStorage(), &cfg, zap.NewNop().Sugar())
+}
+
+// newAgent serves the metrics endpoint of an agent holding the metrics in its storage.
+func newAgent(t *testing.T, key string, metrics ...model.Metrics) (*storage.InMemoryStorage, *httptest.Server) {
+	t.Helper()
+	strg := storage.NewInMemoryStorage()
+	require.NoError(t, strg.UpdateMetrics(context.Background(), metrics))
+	cfg := agentconfig.AgentConfig{Key: key}
+	srv := httptest.NewServer(agent.NewAgent(nil, &cfg, strg, zap.NewNop().Sugar()).ExposeHandler())
+	t.Cleanup(srv.Close)
+	return strg, srv
+}
+
+func gauge(id string, v float64) model.Metrics {
+	return model.Metrics{ID: id, MType: model.MetricTypeGauge, Value: &v}
+}
+
+func counter(id string, d int64) model.Metrics {
+	return model.Metrics{ID: id, MType: model.MetricTypeCounter, Delta: &d}
+}
+
+func value(t *testing.T, s *server.MetricService, id string) float64 {
+	t.Helper()
+	m, err := s.GetMetric(context.Background(), gauge(id, 0))
+	require.NoError(t, err)
+	return *m.Value
+}
+
+func TestScraper_Scrape(t *testing.T) {
+	ctx := context.Background()
+	metricService := newService()
+	agentStorage, srv := newAgent(t, "secret", counter("PollCount", 5), gauge("Alloc", 1.5))
+	target := config.ScrapeTarget{Name: "web1", URL: srv.URL + "/metrics", Interval: time.Second, Timeout: time.Second}
+	key := "secret"
+	s := NewScraper(metricService, []config.ScrapeTarget{target}, func() string { return key }, zap.NewNop().Sugar())
+
+	require.NoError(t, s.Scrape(ctx, target))
+	require.NoError(t, agentStorage.UpdateMetricValue(ctx, counter("PollCount", 3)))
+	require.NoError(t, s.Scrape(ctx, target))
+	list, err := metricService.ListMetrics(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 4)
+	require.Equal(t, gauge("Alloc;instance=web1", 1.5), list[0])
+	require.Equal(t, counter("PollCount;instance=web1", 8), list[1], "counters follow the agent totals")
+	require.Equal(t, "scrape_duration_seconds;instance=web1", list[2].ID)
+	require.Equal(t, gauge("up;instance=web1", 1), list[3])
+
+	// A restarted agent counts from zero again, its new total adds up instead of going backwards.
+	require.NoError(t, agentStorage.UpdateMetricValue(ctx, counter("PollCount", -6)))
+	require.NoError(t, s.Scrape(ctx, target))
+	m, err := metricService.GetMetric(ctx, counter("PollCount;instance=web1", 0))
+	require.NoError(t, err)
+	require.Equal(t, int64(10), *m.Delta)
+
+	// A reloaded key is used by the next scrape.
+	key = "other"
+	require.EqualError(t, s.Scrape(ctx, target), "the response signature does not match the key")
+	require.Equal(t, 0.0, value(t, metricService, "up;instance=web1"))
+}
+
+func TestScraper_ScrapeFailures(t *testing.T) {
+	ctx := context.Background()
+	metricService := newService()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	targets := []config.ScrapeTarget{
+		{Name: "slow", URL: slow.URL, Interval: time.Second, Timeout: 50 * time.Millisecond},
+		{Name: "down", URL: down.URL, Interval: time.Second, Timeout: time.Second},
+	}
+	s := NewScraper(metricService, targets, func() string { return "" }, zap.NewNop().Sugar())
+
+	start := time.Now()
+	require.ErrorIs(t, s.Scrape(ctx, targets[0]), context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 500*time.Millisecond, "scrapes are cut off at the timeout")
+	require.Equal(t, 0.0, value(t, metricService, "up;instance=slow"))
+	require.Error(t, s.Scrape(ctx, targets[1]))
+	require.Equal(t, 0.0, value(t, metricService, "up;instance=down"))
+}
+
+func TestScraper_Run(t *testing.T) {
+	metricService := newService()
+	_, fast := newAgent(t, "", gauge("Alloc", 1))
+	_, slow := newAgent(t, "", gauge("Alloc", 2))
+	targets := []config.ScrapeTarget{
+		{Name: "fast", URL: fast.URL + "/metrics", Interval: 20 * time.Millisecond, Timeout: 20 * time.Millisecond},
+		{Name: "slow", URL: slow.URL + "/metrics", Interval: time.Hour, Timeout: time.Second},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		NewScraper(metricService, targets, func() string { return "" }, zap.NewNop().Sugar()).Run(ctx)
+		close(stopped)
+	}()
+
+	require.Eventually(t, func() bool {
+		m, err := metricService.GetMetric(ctx, gauge("scrape_duration_seconds;instance=slow", 0))
+		return err == nil && m.Value != nil
+	}, 2*time.Second, 10*time.Millisecond, "targets are scraped right away")
+	fast.Close()
+	require.Eventually(t, func() bool { return value(t, metricService, "up;instance=fast") == 0 }, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1.0, value(t, metricService, "up;instance=slow"))
+	require.Equal(t, 2.0, value(t, metricService, "Alloc;instance=slow"))
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after the context was done")
+	}
+}
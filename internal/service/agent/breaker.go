@@ -0,0 +1,65 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// States of a circuit breaker, recorded as the value of the BreakerStateMetric gauge.
+const (
+	BreakerClosed   = 0 // Requests are sent
+	BreakerHalfOpen = 1 // A single request probes whether the destination is back
+	BreakerOpen     = 2 // Sending is paused until the cooldown is over
+)
+
+// breaker pauses sending to a destination that is consistently down. It opens after threshold
+// consecutive failed requests; once the cooldown is over a single request probes the destination
+// and closes the breaker if it succeeds. A zero threshold disables the breaker.
+type breaker struct {
+	mu       sync.Mutex
+	state    int
+	failures int       // Consecutive failed requests
+	openedAt time.Time // When the breaker opened
+	probing  bool      // Whether the probe request is in flight
+}
+
+// allow reports whether a request may be sent now and the state of the breaker.
+func (b *breaker) allow(now time.Time, cooldown time.Duration) (bool, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= cooldown {
+		b.state = BreakerHalfOpen
+	}
+	switch b.state {
+	case BreakerClosed:
+		return true, b.state
+	case BreakerHalfOpen:
+		if b.probing {
+			return false, b.state
+		}
+		b.probing = true
+		return true, b.state
+	default:
+		return false, b.state
+	}
+}
+
+// success records a request the destination answered and returns the state of the breaker.
+func (b *breaker) success() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state, b.failures, b.probing = BreakerClosed, 0, false
+	return b.state
+}
+
+// failure records a failed request and returns the state of the breaker.
+func (b *breaker) failure(now time.Time, threshold int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == BreakerHalfOpen || (threshold > 0 && b.failures >= threshold) {
+		b.state, b.openedAt = BreakerOpen, now
+	}
+	b.probing = false
+	return b.state
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/protocol"
+	"github.com/mrkovshik/yametrics/internal/remote"
+	"github.com/mrkovshik/yametrics/internal/signature"
+)
+
+// ExposeHandler returns the HTTP API the server scrapes the agent through in the pull mode:
+//
+// - GET /metrics: Lists the metrics in the storage in the Prometheus text format, or as a JSON
+// array if the client accepts application/json. Counters are the totals since the agent started.
+//
+// Responses are signed in the HashSHA256 header if a key is configured.
+func (a *Agent) ExposeHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", a.handleExpose)
+	return mux
+}
+
+// Expose serves the ExposeHandler on l until ctx is done.
+func (a *Agent) Expose(ctx context.Context, l net.Listener) error {
+	srv := &http.Server{Handler: a.ExposeHandler(), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background()) //nolint:all
+	}()
+	if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (a *Agent) handleExpose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	metricMap, err := a.storage.GetAllMetrics(r.Context())
+	if err != nil {
+		a.logger.Errorf("GetAllMetrics: %v", err)
+		http.Error(w, "GetAllMetrics", http.StatusInternalServerError)
+		return
+	}
+	list := make([]model.Metrics, 0, len(metricMap))
+	for _, m := range metricMap {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ID != list[j].ID {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].MType < list[j].MType
+	})
+
+	var body bytes.Buffer
+	if remote.AcceptsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(&body).Encode(list)
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		err = protocol.FormatPrometheusText(&body, list)
+	}
+	if err != nil {
+		a.logger.Errorf("encoding metrics: %v", err)
+		http.Error(w, "encoding metrics", http.StatusInternalServerError)
+		return
+	}
+	if key := a.Config().Key; key != "" {
+		sig, err := signature.NewSha256Sig(key, body.Bytes()).Generate()
+		if err != nil {
+			a.logger.Errorf("signing metrics: %v", err)
+			http.Error(w, "signing metrics", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("HashSHA256", sig)
+	}
+	body.WriteTo(w) //nolint:all
+}
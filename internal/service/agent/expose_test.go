@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	config "github.com/mrkovshik/yametrics/internal/config/agent"
+	"github.com/mrkovshik/yametrics/internal/metrics"
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/signature"
+	storage2 "github.com/mrkovshik/yametrics/internal/storage"
+)
+
+func TestAgent_ExposeHandler(t *testing.T) {
+	ctx := context.Background()
+	strg := storage2.NewInMemoryStorage()
+	delta, value := int64(4), 1.5
+	require.NoError(t, strg.UpdateMetrics(ctx, []model.Metrics{
+		{ID: "PollCount", MType: model.MetricTypeCounter, Delta: &delta},
+		{ID: "Alloc", MType: model.MetricTypeGauge, Value: &value},
+		{ID: "queue;env=prod", MType: model.MetricTypeGauge, Value: &value},
+	}))
+	cfg := config.AgentConfig{Key: "secret"}
+	h := NewAgent(metrics.NewMockMetrics(), &cfg, strg, zap.NewNop().Sugar()).ExposeHandler()
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "# TYPE Alloc gauge\nAlloc 1.5\n# TYPE PollCount counter\nPollCount 4\n# TYPE queue gauge\nqueue{env=\"prod\"} 1.5\n", rr.Body.String())
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/json")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	sig, err := signature.NewSha256Sig(cfg.Key, rr.Body.Bytes()).Generate()
+	require.NoError(t, err)
+	require.Equal(t, sig, rr.Header().Get("HashSHA256"))
+	var got []model.Metrics
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+	require.Equal(t, []model.Metrics{
+		{ID: "Alloc", MType: model.MetricTypeGauge, Value: &value},
+		{ID: "PollCount", MType: model.MetricTypeCounter, Delta: &delta},
+		{ID: "queue;env=prod", MType: model.MetricTypeGauge, Value: &value},
+	}, got)
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
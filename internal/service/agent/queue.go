@@ -0,0 +1,123 @@
+package service
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// jobQueue hands the jobs of a send to the workers, the earliest due first. Jobs put back for a
+// retry are only handed out once their backoff is over, so that a worker sends other metrics
+// meanwhile instead of waiting.
+type jobQueue struct {
+	mu      sync.Mutex
+	jobs    jobHeap
+	active  int           // Jobs handed out and not yet done
+	failed  []job         // Jobs that could not be sent
+	changed chan struct{} // Closed and replaced when jobs are put back or done
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{changed: make(chan struct{})}
+}
+
+// push adds a job to the queue.
+func (q *jobQueue) push(j job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	heap.Push(&q.jobs, j)
+}
+
+// next returns the next job once it is due. It returns false once every job is done, or when
+// ctx is done.
+func (q *jobQueue) next(ctx context.Context) (job, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.jobs) == 0 && q.active == 0 {
+			q.mu.Unlock()
+			return job{}, false
+		}
+		var timer *time.Timer
+		var wait <-chan time.Time
+		if len(q.jobs) > 0 {
+			due := time.Until(q.jobs[0].notBefore)
+			if due <= 0 {
+				j := heap.Pop(&q.jobs).(job)
+				q.active++
+				q.mu.Unlock()
+				return j, true
+			}
+			timer = time.NewTimer(due)
+			wait = timer.C
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+		case <-changed:
+		case <-wait:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return job{}, false
+		}
+	}
+}
+
+// done marks a job handed out by next as done.
+func (q *jobQueue) done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.finish()
+}
+
+// retry puts a job handed out by next back, to be handed out again once it is due.
+func (q *jobQueue) retry(j job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	heap.Push(&q.jobs, j)
+	q.finish()
+}
+
+// unsent marks a job handed out by next as one that could not be sent.
+func (q *jobQueue) unsent(j job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.failed = append(q.failed, j)
+	q.finish()
+}
+
+// finish ends a job handed out by next and wakes up the waiting workers, the caller holds q.mu.
+func (q *jobQueue) finish() {
+	q.active--
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// remaining returns the jobs that were not sent, once the workers have stopped.
+func (q *jobQueue) remaining() []job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append(q.failed, q.jobs...)
+}
+
+// jobHeap orders jobs by the time they are due, implementing heap.Interface.
+type jobHeap []job
+
+func (h jobHeap) Len() int           { return len(h) }
+func (h jobHeap) Less(i, j int) bool { return h[i].notBefore.Before(h[j].notBefore) }
+func (h jobHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *jobHeap) Push(x any) {
+	*h = append(*h, x.(job))
+}
+
+func (h *jobHeap) Pop() any {
+	old := *h
+	j := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return j
+}
@@ -2,26 +2,30 @@ package service
 
 import (
 	"bytes"
-	"compress/gzip"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 
+	"github.com/mrkovshik/yametrics/internal/compress"
 	rsa2 "github.com/mrkovshik/yametrics/internal/rsa"
 	"github.com/mrkovshik/yametrics/internal/signature"
+	"github.com/mrkovshik/yametrics/internal/tracing"
 )
 
 // RequestBuilder helps in constructing and modifying HTTP requests.
 type RequestBuilder struct {
-	R   http.Request // The HTTP request being built.
-	Err error        // Any error encountered during the building process.
+	R       http.Request         // The HTTP request being built.
+	Err     error                // Any error encountered during the building process.
+	Trace   tracing.TraceContext // Trace context propagated with the request, if any.
+	RawSize int64                // Size of the body before compression, set by Compress.
 }
 
 // NewRequestBuilder initializes a new RequestBuilder with a default GET request.
 func NewRequestBuilder() *RequestBuilder {
 	req, err := http.NewRequest(http.MethodGet, "", nil)
-	return &RequestBuilder{*req, err}
+	return &RequestBuilder{R: *req, Err: err}
 }
 
 // WithHeader adds a header to the HTTP request.
@@ -30,6 +34,15 @@ func (rb *RequestBuilder) WithHeader(key, value string) *RequestBuilder {
 	return rb
 }
 
+// WithTrace starts a new trace for the request and propagates it to the server
+// via the X-Request-ID and traceparent headers.
+func (rb *RequestBuilder) WithTrace() *RequestBuilder {
+	rb.Trace = tracing.NewTraceContext()
+	rb.WithHeader(tracing.RequestIDHeader, rb.Trace.RequestID)
+	rb.WithHeader(tracing.TraceparentHeader, rb.Trace.Traceparent())
+	return rb
+}
+
 // SetMethod sets the HTTP method for the request.
 func (rb *RequestBuilder) SetMethod(method string) *RequestBuilder {
 	if rb.Err == nil {
@@ -78,22 +91,38 @@ func (rb *RequestBuilder) Sign(key string) *RequestBuilder {
 	return rb
 }
 
-// Compress compresses the request body using gzip and sets the appropriate headers.
-func (rb *RequestBuilder) Compress() *RequestBuilder {
-	if rb.Err == nil {
+// Compress compresses the request body using the named codec and sets the appropriate headers.
+// The identity encoding leaves the body as is and only sets its length.
+func (rb *RequestBuilder) Compress(encoding string) *RequestBuilder {
+	if rb.Err == nil && encoding == compress.EncodingIdentity && rb.R.Body != nil {
+		var body []byte
+		body, rb.Err = io.ReadAll(rb.R.Body)
+		rb.R.Body = io.NopCloser(bytes.NewReader(body))
+		rb.R.ContentLength, rb.RawSize = int64(len(body)), int64(len(body))
+	}
+	if rb.Err == nil && encoding != compress.EncodingIdentity {
+		codec, ok := compress.Lookup(encoding)
+		if !ok {
+			rb.Err = fmt.Errorf("unsupported compression %q", encoding)
+			return rb
+		}
 		var compressedBody bytes.Buffer
-		gzipWriter := gzip.NewWriter(&compressedBody)
-		_, err := io.Copy(gzipWriter, rb.R.Body)
+		cw, err := codec.NewWriter(&compressedBody)
 		if err != nil {
 			return &RequestBuilder{Err: err}
 		}
-		err = gzipWriter.Close()
+		if rb.R.Body != nil {
+			if rb.RawSize, err = io.Copy(cw, rb.R.Body); err != nil {
+				return &RequestBuilder{Err: err}
+			}
+		}
+		err = cw.Close()
 		if err != nil {
 			return &RequestBuilder{Err: err}
 		}
 		rb.R.Body = io.NopCloser(&compressedBody)
 		rb.R.ContentLength = int64(compressedBody.Len())
-		rb.WithHeader("Content-Encoding", "gzip")
+		rb.WithHeader("Content-Encoding", codec.Name())
 	}
 	return rb
 }
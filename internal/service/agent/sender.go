@@ -0,0 +1,264 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	config "github.com/mrkovshik/yametrics/internal/config/agent"
+	"github.com/mrkovshik/yametrics/internal/model"
+)
+
+// sender sends reports to one destination with its own pool of workers, retries and circuit breaker,
+// so that a server that is down or slow does not hold up sending to the other destinations.
+// Reports arriving while the previous one is still being sent are merged and sent next; metrics
+// that could not be sent wait for the next report.
+type sender struct {
+	agent   *Agent
+	breaker breaker
+
+	mu         sync.Mutex
+	cfg        config.Destination       // Settings of the destination, replaced on reload
+	latest     map[string]model.Metrics // Metrics waiting to be sent by type and ID, replaced by newer values
+	increments map[string]model.Metrics // Pushed counters waiting to be sent by ID, adding up
+	queued     bool                     // Whether a report arrived since the pending metrics were last sent
+	done       chan struct{}            // Closed once the pending metrics are sent, nil if they are not being sent
+}
+
+// destinations returns the senders of the destinations in the current configuration. Senders keep
+// their pending metrics between reports; those of destinations removed on reload finish on their own.
+func (a *Agent) destinations(ctx context.Context) []*sender {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	list := a.cfg.Load().DestinationList()
+	senders := make(map[string]*sender, len(list))
+	current := make([]*sender, 0, len(list))
+	for _, cfg := range list {
+		s, ok := a.senders[cfg.Name]
+		if !ok {
+			s = &sender{agent: a}
+			a.gauge(ctx, BreakerStateMetric, BreakerClosed, DestinationTag, cfg.Name)
+		}
+		s.mu.Lock()
+		s.cfg = cfg
+		s.mu.Unlock()
+		senders[cfg.Name] = s
+		current = append(current, s)
+	}
+	a.senders = senders
+	return current
+}
+
+// wait waits for every destination to send its pending metrics or for ctx to be done.
+func (a *Agent) wait(ctx context.Context) {
+	a.mu.Lock()
+	var pending []chan struct{}
+	for _, s := range a.senders {
+		s.mu.Lock()
+		if s.done != nil {
+			pending = append(pending, s.done)
+		}
+		s.mu.Unlock()
+	}
+	a.mu.Unlock()
+	for _, done := range pending {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// enqueue adds a report to the pending metrics and starts sending them unless they are already being sent.
+func (s *sender) enqueue(ctx context.Context, collected, pushed []model.Metrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range collected {
+		s.add(m, false, true)
+	}
+	for _, m := range pushed {
+		s.add(m, m.MType == model.MetricTypeCounter, true)
+	}
+	s.queued = true
+	s.recordDepth(ctx)
+	if s.done == nil {
+		s.done = make(chan struct{})
+		go s.run(ctx)
+	}
+}
+
+// add adds a metric to the pending ones, the caller holds s.mu. Increments add up, other metrics
+// replace the pending ones if they are newer.
+func (s *sender) add(m model.Metrics, increment, newer bool) {
+	if increment {
+		if s.increments == nil {
+			s.increments = make(map[string]model.Metrics)
+		}
+		if prev, ok := s.increments[m.ID]; ok {
+			delta := *prev.Delta + *m.Delta
+			m.Delta = &delta
+		}
+		s.increments[m.ID] = m
+		return
+	}
+	if s.latest == nil {
+		s.latest = make(map[string]model.Metrics)
+	}
+	key := m.MType + "/" + m.ID
+	if _, ok := s.latest[key]; !ok || newer {
+		s.latest[key] = m
+	}
+}
+
+// run sends the pending metrics as long as reports arrive while they are sent.
+func (s *sender) run(ctx context.Context) {
+	for {
+		s.mu.Lock()
+		if !s.queued || len(s.latest)+len(s.increments) == 0 {
+			close(s.done)
+			s.done = nil
+			s.mu.Unlock()
+			return
+		}
+		cfg, latest, increments := s.cfg, s.latest, s.increments
+		s.latest, s.increments, s.queued = nil, nil, false
+		s.mu.Unlock()
+		s.send(ctx, cfg, latest, increments)
+	}
+}
+
+// job is a metric handed to a worker.
+type job struct {
+	metric    model.Metrics
+	increment bool      // Whether the metric is a pushed counter
+	attempt   int       // Attempts made to send the metric, counted from 0
+	notBefore time.Time // Time the metric may be sent again after a failed attempt
+}
+
+// send sends the metrics using a pool of workers and waits for the workers to finish.
+// Metrics the workers did not send are put back to wait for the next report.
+func (s *sender) send(ctx context.Context, cfg config.Destination, latest, increments map[string]model.Metrics) {
+	var wg sync.WaitGroup
+	q := newJobQueue()
+	for _, m := range latest {
+		q.push(job{metric: m})
+	}
+	for _, m := range increments {
+		q.push(job{metric: m, increment: true})
+	}
+	for w := 1; w <= cfg.RateLimit; w++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			s.worker(ctx, cfg, id, q)
+		}(w)
+	}
+	wg.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range q.remaining() {
+		s.add(j.metric, j.increment, false)
+	}
+	s.recordDepth(ctx)
+}
+
+// recordDepth records the number of metrics waiting to be sent, the caller holds s.mu.
+func (s *sender) recordDepth(ctx context.Context) {
+	s.agent.gauge(ctx, QueueDepthMetric, float64(len(s.latest)+len(s.increments)), DestinationTag, s.cfg.Name)
+}
+
+// worker sends metrics to the destination until there are none left, a request cannot be built or fails
+// for good, or the circuit breaker pauses sending. A request that may succeed if sent again is put back to be retried
+// after a backoff, meanwhile the worker sends other metrics. The metric of a request that failed for
+// good is handed back to the queue as unsent.
+func (s *sender) worker(ctx context.Context, dest config.Destination, id int, q *jobQueue) {
+	a := s.agent
+	for {
+		j, ok := q.next(ctx)
+		if !ok {
+			return
+		}
+		cfg := a.cfg.Load()
+		allowed, state := s.breaker.allow(time.Now(), cfg.BreakerCooldown)
+		if !allowed {
+			a.gauge(ctx, BreakerStateMetric, float64(state), DestinationTag, dest.Name)
+			q.unsent(j)
+			return
+		}
+		metricUpdateURL := fmt.Sprintf("http://%v/update/", dest.Address)
+
+		reqBuilder := NewRequestBuilder().WithTrace().SetURL(metricUpdateURL).AddJSONBody(j.metric).Sign(dest.Key).EncryptRSA(dest.CryptoKey).Compress(dest.Compression).SetMethod(http.MethodPost)
+		logger := a.logger.With("request_id", reqBuilder.Trace.RequestID, "destination", dest.Name)
+		logger.Debugf("worker #%v is sending %v", id, j.metric.ID)
+		if reqBuilder.Err != nil {
+			// Such as a crypto key that cannot be read: every request would fail alike, so the metrics
+			// wait for the next report, by when the configuration may have been fixed.
+			logger.Errorf("error building request: %v\n", reqBuilder.Err)
+			a.count(ctx, SendFailuresMetric, 1, DestinationTag, dest.Name, StatusTag, "request")
+			j.attempt, j.notBefore = 0, time.Time{}
+			q.unsent(j)
+			return
+		}
+		a.count(ctx, UncompressedBytesMetric, reqBuilder.RawSize, DestinationTag, dest.Name)
+		a.count(ctx, SentBytesMetric, reqBuilder.R.ContentLength, DestinationTag, dest.Name)
+		start := time.Now()
+		response, err := a.sendAttempt(reqBuilder.R.WithContext(ctx), dest.Name, j.attempt)
+		a.exportSpan(reqBuilder, start, response, err)
+		if retryable(response, err) && j.attempt < cfg.RetryAttempts && ctx.Err() == nil {
+			wait := retryDelay(response, j.attempt, cfg.RetryInitialInterval, cfg.RetryMaxInterval)
+			if err == nil {
+				response.Body.Close() //nolint:all
+				logger.Errorf("server answered %v\n retry in %v\n", response.StatusCode, wait)
+			} else {
+				logger.Errorf("failed connect to server: %v\n retry in %v\n", err, wait)
+			}
+			j.attempt++
+			j.notBefore = time.Now().Add(wait)
+			q.retry(j)
+			continue
+		}
+		if err != nil || retryable(response, nil) {
+			status := "error"
+			if err != nil {
+				logger.Errorf("error sending request: %v\n", err)
+			} else {
+				status = strconv.Itoa(response.StatusCode)
+				response.Body.Close() //nolint:all
+				logger.Errorf("status code is %v\n", response.StatusCode)
+			}
+			a.count(ctx, SendFailuresMetric, 1, DestinationTag, dest.Name, StatusTag, status)
+			// The metric is sent with the next report, counting its attempts afresh.
+			j.attempt, j.notBefore = 0, time.Time{}
+			q.unsent(j)
+			if ctx.Err() != nil {
+				// The agent is shutting down, the destination did not fail.
+				return
+			}
+			next := s.breaker.failure(time.Now(), cfg.BreakerThreshold)
+			if next == BreakerOpen && state != BreakerOpen {
+				logger.Warnf("destination is down, pausing sending for %v", cfg.BreakerCooldown)
+			}
+			a.gauge(ctx, BreakerStateMetric, float64(next), DestinationTag, dest.Name)
+			return
+		}
+		q.done()
+		if response.StatusCode != http.StatusOK {
+			// The server is up but rejects the metric, sending it again would not help.
+			logger.Errorf("status code is %v\n", response.StatusCode)
+			a.count(ctx, SendFailuresMetric, 1, DestinationTag, dest.Name, StatusTag, strconv.Itoa(response.StatusCode))
+		} else {
+			a.count(ctx, SendSuccessesMetric, 1, DestinationTag, dest.Name)
+		}
+		if err := response.Body.Close(); err != nil {
+			logger.Error("response.Body.Close()", err)
+		}
+		if state != BreakerClosed {
+			logger.Info("destination is back, resuming sending")
+		}
+		a.gauge(ctx, BreakerStateMetric, float64(s.breaker.success()), DestinationTag, dest.Name)
+	}
+}
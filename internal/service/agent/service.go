@@ -2,15 +2,21 @@
 package service
 
 import (
-	"bytes"
 	"context"
-	"fmt"
+	"errors"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	config "github.com/mrkovshik/yametrics/internal/config/agent"
 	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/tracing"
 	"go.uber.org/zap"
 
 	"github.com/mrkovshik/yametrics/internal/metrics"
@@ -26,25 +32,75 @@ type storage interface {
 	GetAllMetrics(ctx context.Context) (map[string]model.Metrics, error)
 }
 
+// receiver lists the metrics pushed to the agent by local applications.
+type receiver interface {
+	Received() []model.Metrics
+}
+
 // Agent represents a metric collection agent that polls and sends metrics.
 type Agent struct {
-	source  metrics.MetricSource // Source of the metrics
-	logger  *zap.SugaredLogger   // Logger for logging messages
-	cfg     *config.AgentConfig  // Configuration for the agent
-	storage storage              // Storage for metrics
+	source   metrics.MetricSource               // Source of the metrics
+	logger   *zap.SugaredLogger                 // Logger for logging messages
+	cfg      atomic.Pointer[config.AgentConfig] // Configuration for the agent, replaced on reload
+	storage  storage                            // Storage for metrics
+	tracer   *tracing.Exporter                  // Exporter for client spans, nil if disabled
+	receiver receiver                           // Metrics pushed by local applications, nil if disabled
+
+	collectors map[string]metrics.Collector // Collectors polled along with the source by name
+
+	mu      sync.Mutex               // Guards senders
+	senders map[string]*sender       // Senders of the destinations by name, kept between reports
+	ownMu   sync.Mutex               // Guards own
+	own     map[string]model.Metrics // Metrics the agent recorded about itself by type and ID
 }
 
 // NewAgent initializes a new Agent.
 func NewAgent(source metrics.MetricSource, cfg *config.AgentConfig, strg storage, logger *zap.SugaredLogger) *Agent {
-	return &Agent{
+	a := &Agent{
 		source:  source,
 		logger:  logger,
-		cfg:     cfg,
 		storage: strg,
 	}
+	a.cfg.Store(cfg)
+	return a
+}
+
+// Config returns the configuration currently in use.
+func (a *Agent) Config() *config.AgentConfig {
+	return a.cfg.Load()
+}
+
+// UpdateConfig atomically replaces the agent configuration.
+// The new settings, including the destinations and their numbers of workers, take effect from the next send cycle.
+func (a *Agent) UpdateConfig(cfg *config.AgentConfig) {
+	a.cfg.Store(cfg)
+}
+
+// WithTracer sets the exporter receiving a span for every request sent to the server.
+func (a *Agent) WithTracer(tracer *tracing.Exporter) *Agent {
+	a.tracer = tracer
+	return a
+}
+
+// WithReceiver sets the receiver of metrics pushed by local applications, which are sent with every report.
+// Pushed counters are increments: the amount sent is taken out of the storage.
+func (a *Agent) WithReceiver(r receiver) *Agent {
+	a.receiver = r
+	return a
+}
+
+// WithCollector adds a collector polled by PollCollectors, the metrics of its last poll are sent with every report.
+// Counters of collectors are increments like the pushed ones.
+func (a *Agent) WithCollector(name string, c metrics.Collector) *Agent {
+	if a.collectors == nil {
+		a.collectors = make(map[string]metrics.Collector)
+	}
+	a.collectors[name] = c
+	return a
 }
 
 // SendMetrics sends metrics at intervals specified by the channel.
+// Once the channel is closed it makes a final send so that metrics polled since the last tick are not lost.
 func (a *Agent) SendMetrics(ctx context.Context, ch <-chan time.Time, done chan struct{}) {
 	var metricNamesMap = map[string]struct{}{
 		"Alloc":           {},
@@ -82,43 +138,69 @@ func (a *Agent) SendMetrics(ctx context.Context, ch <-chan time.Time, done chan
 	}
 	for range ch {
 		a.logger.Debug("Starting to send metrics")
-		a.sendMetricsByPool(ctx, metricNamesMap)
-		a.logger.Debug("Metrics sent.\n")
+		a.report(ctx, metricNamesMap)
 	}
+	a.logger.Info("Flushing metrics before shutdown")
+	a.sendMetricsByPool(ctx, metricNamesMap)
 	done <- struct{}{}
 }
 
 // PollMetrics polls metrics at intervals specified by the channel.
 func (a *Agent) PollMetrics(ch <-chan time.Time, done chan struct{}) {
+	defer func() { done <- struct{}{} }()
 	for range ch {
 		a.logger.Debug("Starting to update metrics")
-		if err := a.source.PollMemStats(a.storage); err != nil {
+		if err := a.poll("memstats", func() error { return a.source.PollMemStats(a.storage) }); err != nil {
 			a.logger.Error("PollMemStats", err)
 			return
 		}
 		a.logger.Debug("Metrics updated.\n")
 	}
-	done <- struct{}{}
 }
 
 // PollUtilMetrics polls utilization metrics at intervals specified by the channel.
 func (a *Agent) PollUtilMetrics(ch <-chan time.Time, done chan struct{}) {
+	defer func() { done <- struct{}{} }()
 	for range ch {
-		if err := a.source.PollVirtMemStats(a.storage); err != nil {
+		if err := a.poll("virtmem", func() error { return a.source.PollVirtMemStats(a.storage) }); err != nil {
 			a.logger.Error("PollVirtMemStats", err)
 			return
 		}
 		a.logger.Debug("Metrics updated.\n")
 	}
-	done <- struct{}{}
 }
 
-// sendMetricsByPool sends metrics using a pool of workers.
+// PollCollectors polls the collectors at intervals specified by the channel.
+// A collector failing to poll is polled again on the next tick.
+func (a *Agent) PollCollectors(ch <-chan time.Time, done chan struct{}) {
+	defer func() { done <- struct{}{} }()
+	for range ch {
+		for name, c := range a.collectors {
+			if err := a.poll(name, func() error { return c.Poll(a.storage) }); err != nil {
+				a.logger.Errorf("poll %v: %v", name, err)
+			}
+		}
+	}
+}
+
+// sendMetricsByPool sends metrics to every destination and waits for the destinations to finish.
+// Waiting stops when ctx is done.
 func (a *Agent) sendMetricsByPool(ctx context.Context, names map[string]struct{}) {
-	jobs := make(chan model.Metrics, len(names))
-	for w := 1; w <= a.cfg.RateLimit; w++ {
-		go a.worker(w, jobs)
+	a.report(ctx, names)
+	a.wait(ctx)
+}
+
+// report hands the current metrics over to every destination without waiting for them to be sent.
+func (a *Agent) report(ctx context.Context, names map[string]struct{}) {
+	collected, pushed := a.collect(ctx, names)
+	for _, s := range a.destinations(ctx) {
+		s.enqueue(ctx, collected, pushed)
 	}
+}
+
+// collect returns the collected metrics, including the gauges of the collectors, and the metrics pushed by local applications,
+// recorded by the agent or counted by the collectors. Pushed counters are taken out of the storage, so they must be sent once.
+func (a *Agent) collect(ctx context.Context, names map[string]struct{}) (collected, pushed []model.Metrics) {
 	for name := range names {
 		currentMetric := model.Metrics{
 			ID: name,
@@ -131,72 +213,134 @@ func (a *Agent) sendMetricsByPool(ctx context.Context, names map[string]struct{}
 		}
 		foundMetric, err := a.storage.GetMetricByModel(ctx, currentMetric)
 		if err != nil {
-			a.logger.Error("GetMetricByModel", err)
-			return
+			a.logger.Errorf("GetMetricByModel %v: %v", name, err)
+			continue
 		}
-		jobs <- foundMetric
+		collected = append(collected, foundMetric)
 	}
-	close(jobs)
-}
-
-// retryableSend sends an HTTP request with retries.
-func (a *Agent) retryableSend(req *http.Request) (*http.Response, error) {
-	var (
-		bodyBytes      []byte
-		retryIntervals = []int{1, 3, 5} //TODO: move to config
-		client         = http.Client{Timeout: 5 * time.Second}
-		err            error
-	)
-	if req.Body != nil {
-		bodyBytes, err = io.ReadAll(req.Body)
-		if err != nil {
-			return nil, err
+	var asPushed []model.Metrics
+	for name, c := range a.collectors {
+		for _, m := range c.Collected() {
+			if m.MType == model.MetricTypeCounter {
+				asPushed = append(asPushed, m)
+				continue
+			}
+			foundMetric, err := a.storage.GetMetricByModel(ctx, m)
+			if err != nil {
+				a.logger.Errorf("GetMetricByModel %v of %v: %v", m.ID, name, err)
+				continue
+			}
+			collected = append(collected, foundMetric)
 		}
-		// Reset the request body for retries.
-		req.Body.Close() //nolint:all
-		req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
-	}
-	for i := 0; i <= len(retryIntervals); i++ {
-		response, err := client.Do(req)
-		if err == nil {
-			return response, nil
+	}
+	if a.receiver != nil {
+		asPushed = append(asPushed, a.receiver.Received()...)
+	}
+	// Metrics the agent recorded about itself are sent like the pushed ones.
+	for _, m := range append(asPushed, a.ownMetrics()...) {
+		if _, ok := names[m.ID]; ok {
+			// Collected metrics take precedence over pushed ones with the same name.
+			continue
 		}
-		if i == len(retryIntervals) {
-			return nil, err
+		foundMetric, err := a.storage.GetMetricByModel(ctx, m)
+		if err != nil {
+			a.logger.Errorf("GetMetricByModel %v: %v", m.ID, err)
+			continue
 		}
-		a.logger.Errorf("failed connect to server: %v\n retry in %v seconds\n", err, retryIntervals[i])
-		time.Sleep(time.Duration(retryIntervals[i]) * time.Second)
-		if req.Body != nil {
-			req.Body.Close() //nolint:all
-			req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		if foundMetric.MType == model.MetricTypeCounter {
+			if *foundMetric.Delta == 0 {
+				continue
+			}
+			// Increments pushed while the counter is being sent stay in the storage for the next report.
+			drained := -*foundMetric.Delta
+			if err := a.storage.UpdateMetricValue(ctx, model.Metrics{ID: m.ID, MType: m.MType, Delta: &drained}); err != nil {
+				a.logger.Errorf("UpdateMetricValue %v: %v", m.ID, err)
+				continue
+			}
 		}
+		pushed = append(pushed, foundMetric)
 	}
-	return nil, nil
+	return collected, pushed
 }
 
-// worker processes metrics and sends them to the server.
-func (a *Agent) worker(id int, jobs <-chan model.Metrics) {
-	for j := range jobs {
-		a.logger.Debugf("worker #%v is sending %v", id, j.ID)
-		metricUpdateURL := fmt.Sprintf("http://%v/update/", a.cfg.Address)
+// sendAttempt sends an HTTP request once. Attempts, and attempts after the first one as retries,
+// are counted for the named destination.
+func (a *Agent) sendAttempt(req *http.Request, destination string, attempt int) (*http.Response, error) {
+	if attempt > 0 {
+		a.count(req.Context(), SendRetriesMetric, 1, DestinationTag, destination)
+	}
+	a.count(req.Context(), SendAttemptsMetric, 1, DestinationTag, destination)
+	client := http.Client{Timeout: 5 * time.Second}
+	return client.Do(req)
+}
 
-		reqBuilder := NewRequestBuilder().SetURL(metricUpdateURL).AddJSONBody(j).Sign(a.cfg.Key).EncryptRSA(a.cfg.CryptoKey).Compress().SetMethod(http.MethodPost)
-		if reqBuilder.Err != nil {
-			a.logger.Errorf("error building request: %v\n", reqBuilder.Err)
-			return
-		}
-		response, err := a.retryableSend(&reqBuilder.R)
-		if err != nil {
-			a.logger.Errorf("error sending request: %v\n", err)
-			return
+// retryDelay returns the wait before retrying a request that failed on the given attempt, counted from 0.
+// Requests are retried with exponential backoff and full jitter: the wait is random, up to
+// retry_initial_interval doubled with every retry and capped at retry_max_interval. A 429 response
+// is retried no earlier than its Retry-After.
+func retryDelay(response *http.Response, attempt int, initial, maxInterval time.Duration) time.Duration {
+	wait := backoff(attempt, initial, maxInterval)
+	if response != nil && response.StatusCode == http.StatusTooManyRequests {
+		// The server is rate limiting us, so wait at least as long as it asks to.
+		if retryAfter := parseRetryAfter(response.Header.Get("Retry-After")); retryAfter > wait {
+			wait = retryAfter
 		}
-		if response.StatusCode != http.StatusOK {
-			a.logger.Errorf("status code is %v\n", response.StatusCode)
-			return
-		}
-		if err := response.Body.Close(); err != nil {
-			a.logger.Error("response.Body.Close()", err)
-			return
+	}
+	return wait
+}
+
+// retryable reports whether a request may succeed if sent again: the server could not be reached,
+// is failing or is rate limiting requests.
+func retryable(response *http.Response, err error) bool {
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			// A url.Error is a net.Error itself, whatever it wraps.
+			err = urlErr.Err
 		}
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= http.StatusInternalServerError
+}
+
+// backoff returns a random wait before the retry following the given attempt, counted from 0.
+func backoff(attempt int, initial, maxInterval time.Duration) time.Duration {
+	ceiling := maxInterval
+	if attempt < 32 && initial<<attempt > 0 && initial<<attempt < maxInterval {
+		ceiling = initial << attempt
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// parseRetryAfter parses the Retry-After header value given in seconds.
+// It returns 0 if the value is empty or malformed.
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// exportSpan exports a client span for the request sent to the server.
+func (a *Agent) exportSpan(rb *RequestBuilder, start time.Time, response *http.Response, err error) {
+	attrs := map[string]string{
+		"http.method": rb.R.Method,
+		"http.url":    rb.R.URL.String(),
+	}
+	failed := err != nil
+	if response != nil {
+		attrs["http.status_code"] = strconv.Itoa(response.StatusCode)
+		failed = failed || response.StatusCode >= http.StatusInternalServerError
 	}
+	a.tracer.Export(tracing.Span{
+		Trace:      rb.Trace,
+		Name:       rb.R.Method + " " + rb.R.URL.Path,
+		Kind:       tracing.SpanKindClient,
+		Start:      start,
+		End:        time.Now(),
+		Attributes: attrs,
+		Error:      failed,
+	})
 }
@@ -1,13 +1,33 @@
 package service
 
 import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/mrkovshik/yametrics/internal/compress"
 	config "github.com/mrkovshik/yametrics/internal/config/agent"
 	"github.com/mrkovshik/yametrics/internal/metrics"
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/signature"
 	storage2 "github.com/mrkovshik/yametrics/internal/storage"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -48,3 +68,442 @@ func TestAgent_Poll(t *testing.T) {
 		<-done
 	})
 }
+
+func TestAgent_retriesDoNotHoldUpOtherMetrics(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []string
+		limited  bool
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m model.Metrics
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&m))
+		mu.Lock()
+		defer mu.Unlock()
+		if m.ID == "slow" && !limited {
+			limited = true
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		received = append(received, m.ID)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	strg := storage2.NewInMemoryStorage()
+	for _, id := range []string{"slow", "fast"} {
+		value := 1.0
+		require.NoError(t, strg.UpdateMetricValue(ctx, model.Metrics{ID: id, MType: model.MetricTypeGauge, Value: &value}))
+	}
+	cfg := config.AgentConfig{
+		Address:              strings.TrimPrefix(srv.URL, "http://"),
+		RateLimit:            1,
+		Compression:          compress.EncodingIdentity,
+		RetryAttempts:        3,
+		RetryInitialInterval: time.Millisecond,
+		RetryMaxInterval:     time.Millisecond,
+	}
+	a := NewAgent(metrics.NewMockMetrics(), &cfg, strg, zap.NewNop().Sugar())
+
+	start := time.Now()
+	a.sendMetricsByPool(ctx, map[string]struct{}{"slow": {}, "fast": {}})
+	require.GreaterOrEqual(t, time.Since(start), time.Second, "the retry waits for Retry-After")
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"fast", "slow"}, received, "the worker sends other metrics while the retry waits")
+}
+
+func TestAgent_retriesStopOnContextDone(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	strg := storage2.NewInMemoryStorage()
+	value := 1.0
+	require.NoError(t, strg.UpdateMetricValue(context.Background(), model.Metrics{ID: "queue", MType: model.MetricTypeGauge, Value: &value}))
+	cfg := config.AgentConfig{
+		Address:              strings.TrimPrefix(srv.URL, "http://"),
+		RateLimit:            1,
+		RetryAttempts:        3,
+		RetryInitialInterval: time.Second,
+		RetryMaxInterval:     5 * time.Second,
+	}
+	a := NewAgent(metrics.NewMockMetrics(), &cfg, strg, zap.NewNop().Sugar())
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	a.sendMetricsByPool(ctx, map[string]struct{}{"queue": {}})
+	a.wait(context.Background())
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func Test_retryDelay(t *testing.T) {
+	limited := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"2"}}}
+	require.Equal(t, 2*time.Second, retryDelay(limited, 0, time.Millisecond, time.Millisecond))
+	require.LessOrEqual(t, retryDelay(nil, 0, time.Millisecond, time.Millisecond), time.Millisecond)
+}
+
+func TestAgent_SendMetricsFlushesOnShutdown(t *testing.T) {
+	var received atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	strg := storage2.NewInMemoryStorage()
+	cfg := config.AgentConfig{Address: strings.TrimPrefix(srv.URL, "http://"), RateLimit: 2, Compression: "gzip"}
+	a := NewAgent(metrics.NewMockMetrics(), &cfg, strg, zap.NewNop().Sugar())
+
+	pollCh := make(chan time.Time, 1)
+	pollCh <- time.Now()
+	close(pollCh)
+	done := make(chan struct{}, 1)
+	a.PollMetrics(pollCh, done)
+	<-done
+	a.PollUtilMetrics(pollCh, done)
+	<-done
+
+	// No tick is ever sent, so everything received comes from the final flush.
+	sendCh := make(chan time.Time)
+	close(sendCh)
+	a.SendMetrics(context.Background(), sendCh, done)
+	<-done
+	require.Positive(t, received.Load())
+}
+
+type fakeReceiver []model.Metrics
+
+func (r fakeReceiver) Received() []model.Metrics {
+	return r
+}
+
+func TestAgent_sendMetricsByPoolDrainsPushedCounters(t *testing.T) {
+	var mu sync.Mutex
+	var sent []model.Metrics
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		var m model.Metrics
+		require.NoError(t, json.NewDecoder(body).Decode(&m))
+		mu.Lock()
+		sent = append(sent, m)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	strg := storage2.NewInMemoryStorage()
+	push := func(m model.Metrics) {
+		require.NoError(t, strg.UpdateMetricValue(ctx, m))
+	}
+	delta := func(d int64) *int64 { return &d }
+	value := func(v float64) *float64 { return &v }
+	push(model.Metrics{ID: "orders", MType: model.MetricTypeCounter, Delta: delta(5)})
+	push(model.Metrics{ID: "queue", MType: model.MetricTypeGauge, Value: value(3)})
+
+	cfg := config.AgentConfig{Address: strings.TrimPrefix(srv.URL, "http://"), RateLimit: 1, Compression: "gzip"}
+	a := NewAgent(metrics.NewMockMetrics(), &cfg, strg, zap.NewNop().Sugar()).
+		WithReceiver(fakeReceiver{{ID: "orders", MType: model.MetricTypeCounter}, {ID: "queue", MType: model.MetricTypeGauge}})
+	send := func() map[string]model.Metrics {
+		mu.Lock()
+		sent = nil
+		mu.Unlock()
+		a.sendMetricsByPool(ctx, map[string]struct{}{})
+		mu.Lock()
+		defer mu.Unlock()
+		byID := make(map[string]model.Metrics)
+		for _, m := range sent {
+			byID[m.ID] = m
+		}
+		return byID
+	}
+
+	got := send()
+	require.Equal(t, int64(5), *got["orders"].Delta)
+	require.Equal(t, 3.0, *got["queue"].Value)
+
+	push(model.Metrics{ID: "orders", MType: model.MetricTypeCounter, Delta: delta(2)})
+	got = send()
+	require.Equal(t, int64(2), *got["orders"].Delta, "only increments since the last report are sent")
+
+	got = send()
+	require.NotContains(t, got, "orders", "counters without increments are not sent")
+	require.Equal(t, 3.0, *got["queue"].Value, "gauges are sent with every report")
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	require.Equal(t, 3*time.Second, parseRetryAfter("3"))
+	require.Equal(t, time.Duration(0), parseRetryAfter(""))
+	require.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+}
+
+func TestAgent_reportSendsToEveryDestination(t *testing.T) {
+	var mu sync.Mutex
+	sent := make(map[string]model.Metrics)
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		sig, err := signature.NewSha256Sig("next", body).Generate()
+		require.NoError(t, err)
+		require.Equal(t, sig, r.Header.Get("HashSHA256"), "requests are signed with the key of the destination")
+		var m model.Metrics
+		require.NoError(t, json.Unmarshal(body, &m))
+		mu.Lock()
+		sent[m.ID] = m
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer live.Close()
+	// The old server hangs until the request is abandoned.
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body) //nolint:all
+		<-r.Context().Done()
+	}))
+	defer stuck.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	strg := storage2.NewInMemoryStorage()
+	delta := func(d int64) *int64 { return &d }
+	require.NoError(t, strg.UpdateMetricValue(ctx, model.Metrics{ID: "orders", MType: model.MetricTypeCounter, Delta: delta(5)}))
+	cfg := config.AgentConfig{
+		Key:                     "key",
+		RateLimit:               1,
+		Compression:             "gzip",
+		Destinations:            "old=" + strings.TrimPrefix(stuck.URL, "http://") + ",new=" + strings.TrimPrefix(live.URL, "http://"),
+		DestinationKeys:         "new=next",
+		DestinationCompressions: "new=identity",
+		DestinationRateLimits:   "new=2",
+	}
+	a := NewAgent(metrics.NewMockMetrics(), &cfg, strg, zap.NewNop().Sugar()).
+		WithReceiver(fakeReceiver{{ID: "orders", MType: model.MetricTypeCounter}})
+	orders := func() int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		if m, ok := sent["orders"]; ok {
+			return *m.Delta
+		}
+		return 0
+	}
+
+	a.report(ctx, map[string]struct{}{})
+	require.Eventually(t, func() bool { return orders() == 5 }, 2*time.Second, 10*time.Millisecond,
+		"the destination that hangs does not hold up the others")
+
+	require.NoError(t, strg.UpdateMetricValue(ctx, model.Metrics{ID: "orders", MType: model.MetricTypeCounter, Delta: delta(2)}))
+	a.report(ctx, map[string]struct{}{})
+	require.Eventually(t, func() bool { return orders() == 2 }, 2*time.Second, 10*time.Millisecond)
+
+	old := a.senders["old"]
+	old.mu.Lock()
+	require.NotNil(t, old.done, "the destination that hangs is still being sent to")
+	require.Equal(t, int64(2), *old.increments["orders"].Delta, "reports wait for the destination that hangs")
+	old.mu.Unlock()
+
+	require.NoError(t, strg.UpdateMetricValue(ctx, model.Metrics{ID: "orders", MType: model.MetricTypeCounter, Delta: delta(3)}))
+	a.report(ctx, map[string]struct{}{})
+	old.mu.Lock()
+	require.Equal(t, int64(5), *old.increments["orders"].Delta, "pending increments add up")
+	old.mu.Unlock()
+
+	cancel()
+	a.wait(context.Background())
+}
+
+func TestAgent_unbuiltRequestsKeepIncrements(t *testing.T) {
+	var received atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m model.Metrics
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&m))
+		if m.ID == "orders" {
+			received.Add(*m.Delta)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	strg := storage2.NewInMemoryStorage()
+	delta := int64(5)
+	require.NoError(t, strg.UpdateMetricValue(ctx, model.Metrics{ID: "orders", MType: model.MetricTypeCounter, Delta: &delta}))
+	cfg := config.AgentConfig{
+		Address:     strings.TrimPrefix(srv.URL, "http://"),
+		RateLimit:   1,
+		Compression: compress.EncodingIdentity,
+		CryptoKey:   filepath.Join(t.TempDir(), "missing.pem"),
+	}
+	a := NewAgent(metrics.NewMockMetrics(), &cfg, strg, zap.NewNop().Sugar()).
+		WithReceiver(fakeReceiver{{ID: "orders", MType: model.MetricTypeCounter}})
+
+	a.sendMetricsByPool(ctx, map[string]struct{}{})
+	require.Zero(t, received.Load())
+	s := a.senders[cfg.Address]
+	s.mu.Lock()
+	require.Equal(t, int64(5), *s.increments["orders"].Delta, "the drained increments wait for the next report")
+	s.mu.Unlock()
+
+	fixed := cfg
+	fixed.CryptoKey = ""
+	a.UpdateConfig(&fixed)
+	a.sendMetricsByPool(ctx, map[string]struct{}{})
+	require.Equal(t, int64(5), received.Load())
+}
+
+func TestAgent_breakerPausesSending(t *testing.T) {
+	var (
+		down     atomic.Bool
+		requests atomic.Int32
+		received atomic.Int64
+	)
+	down.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var m model.Metrics
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&m))
+		if m.ID == "orders" {
+			received.Add(*m.Delta)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	strg := storage2.NewInMemoryStorage()
+	push := func(d int64) {
+		require.NoError(t, strg.UpdateMetricValue(ctx, model.Metrics{ID: "orders", MType: model.MetricTypeCounter, Delta: &d}))
+	}
+	cfg := config.AgentConfig{
+		Destinations:     "main=" + strings.TrimPrefix(srv.URL, "http://"),
+		RateLimit:        1,
+		Compression:      compress.EncodingIdentity,
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Hour,
+	}
+	a := NewAgent(metrics.NewMockMetrics(), &cfg, strg, zap.NewNop().Sugar()).
+		WithReceiver(fakeReceiver{{ID: "orders", MType: model.MetricTypeCounter}})
+	state := func() float64 {
+		m, err := strg.GetMetricByModel(ctx, model.Metrics{ID: "agent_breaker_state;destination=main", MType: model.MetricTypeGauge})
+		require.NoError(t, err)
+		return *m.Value
+	}
+
+	push(5)
+	a.sendMetricsByPool(ctx, map[string]struct{}{})
+	require.Equal(t, float64(BreakerClosed), state())
+	push(2)
+	a.sendMetricsByPool(ctx, map[string]struct{}{})
+	require.Equal(t, float64(BreakerOpen), state(), "the breaker opens after consecutive failures")
+	require.Equal(t, int32(2), requests.Load())
+
+	push(1)
+	a.sendMetricsByPool(ctx, map[string]struct{}{})
+	require.Equal(t, int32(2), requests.Load(), "nothing is sent while the breaker is open")
+
+	// Once the cooldown is over a probe closes the breaker and the increments held back are sent.
+	down.Store(false)
+	cfg.BreakerCooldown = time.Millisecond
+	a.UpdateConfig(&cfg)
+	time.Sleep(10 * time.Millisecond)
+	a.sendMetricsByPool(ctx, map[string]struct{}{})
+	require.Equal(t, int64(8), received.Load())
+	require.Equal(t, float64(BreakerClosed), state())
+}
+
+func Test_retryable(t *testing.T) {
+	status := func(code int) *http.Response { return &http.Response{StatusCode: code} }
+	require.True(t, retryable(status(http.StatusServiceUnavailable), nil))
+	require.True(t, retryable(status(http.StatusTooManyRequests), nil))
+	require.False(t, retryable(status(http.StatusBadRequest), nil))
+	require.False(t, retryable(status(http.StatusOK), nil))
+	require.True(t, retryable(nil, &url.Error{Op: "Post", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}))
+	require.True(t, retryable(nil, &url.Error{Op: "Post", Err: io.EOF}))
+	require.False(t, retryable(nil, &url.Error{Op: "Post", Err: errors.New(`unsupported protocol scheme "ftp"`)}))
+}
+
+func Test_backoff(t *testing.T) {
+	for attempt, ceiling := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		for i := 0; i < 100; i++ {
+			wait := backoff(attempt, time.Second, 5*time.Second)
+			require.GreaterOrEqual(t, wait, time.Duration(0))
+			require.LessOrEqual(t, wait, ceiling)
+		}
+	}
+	require.LessOrEqual(t, backoff(100, time.Second, 5*time.Second), 5*time.Second, "large attempts do not overflow")
+}
+
+func TestAgent_PollCollectors(t *testing.T) {
+	var mu sync.Mutex
+	sent := make(map[string]model.Metrics)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m model.Metrics
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&m))
+		mu.Lock()
+		sent[m.ID] = m
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	pidFile := filepath.Join(t.TempDir(), "agent.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0o600))
+	cfg := config.AgentConfig{Address: strings.TrimPrefix(srv.URL, "http://"), RateLimit: 1, Compression: compress.EncodingIdentity}
+	a := NewAgent(metrics.NewMockMetrics(), &cfg, storage2.NewInMemoryStorage(), zap.NewNop().Sugar()).
+		WithCollector("process", metrics.NewProcessCollector([]config.Process{{Name: "self", By: config.ProcessByPIDFile, Pattern: pidFile}}))
+
+	ch := make(chan time.Time, 1)
+	ch <- time.Now()
+	close(ch)
+	done := make(chan struct{}, 1)
+	a.PollCollectors(ch, done)
+	<-done
+	a.sendMetricsByPool(context.Background(), map[string]struct{}{})
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, 1.0, *sent["process_count;process=self"].Value)
+	require.Contains(t, sent, "process_threads;process=self")
+}
+
+func TestAgent_collectorCountersAreIncrements(t *testing.T) {
+	var mu sync.Mutex
+	deltas := make(map[string]int64)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m model.Metrics
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&m))
+		if m.MType == model.MetricTypeCounter {
+			mu.Lock()
+			deltas[m.ID] += *m.Delta
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cfg := config.AgentConfig{Address: strings.TrimPrefix(srv.URL, "http://"), RateLimit: 1, Compression: compress.EncodingIdentity}
+	commands := metrics.NewExecCollector([]config.Command{{Name: "jobs", Command: "echo 'jobs_done counter 3'", Interval: time.Hour, Timeout: 5 * time.Second}}, zap.NewNop().Sugar())
+	a := NewAgent(metrics.NewMockMetrics(), &cfg, storage2.NewInMemoryStorage(), zap.NewNop().Sugar()).WithCollector("exec", commands)
+
+	ch := make(chan time.Time, 1)
+	ch <- time.Now()
+	close(ch)
+	done := make(chan struct{}, 1)
+	a.PollCollectors(ch, done)
+	<-done
+	commands.Wait()
+	a.sendMetricsByPool(context.Background(), map[string]struct{}{})
+	a.sendMetricsByPool(context.Background(), map[string]struct{}{})
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, int64(3), deltas["jobs_done"], "the counter is sent once")
+}
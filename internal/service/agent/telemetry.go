@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/protocol"
+)
+
+// Metrics the agent records about itself, they are sent with every report like the metrics
+// pushed by local applications. Counters are sent as the increments since the last report.
+const (
+	// BreakerStateMetric is the state of the circuit breaker of a destination:
+	// BreakerClosed, BreakerHalfOpen or BreakerOpen.
+	BreakerStateMetric = "agent_breaker_state"
+	// SendAttemptsMetric counts the requests sent to a destination, retries included.
+	SendAttemptsMetric = "agent_send_attempts_total"
+	// SendRetriesMetric counts the retried requests to a destination.
+	SendRetriesMetric = "agent_send_retries_total"
+	// SendSuccessesMetric counts the metrics a destination accepted.
+	SendSuccessesMetric = "agent_send_successes_total"
+	// SendFailuresMetric counts the metrics that could not be sent to a destination by the status
+	// of the last response, error if the destination could not be reached or request if the request could not be built.
+	SendFailuresMetric = "agent_send_failures_total"
+	// QueueDepthMetric is the number of metrics waiting to be sent to a destination.
+	QueueDepthMetric = "agent_queue_depth"
+	// UncompressedBytesMetric counts the bytes of the request bodies to a destination before compression.
+	UncompressedBytesMetric = "agent_sent_uncompressed_bytes_total"
+	// SentBytesMetric counts the bytes of the request bodies to a destination as sent.
+	SentBytesMetric = "agent_sent_bytes_total"
+	// PollDurationMetric is the time the last poll of a collector took in seconds.
+	PollDurationMetric = "agent_poll_duration_seconds"
+	// BuildInfoMetric is always 1, the version, commit and date of the build are its tags.
+	BuildInfoMetric = "agent_build_info"
+
+	DestinationTag = "destination" // Name of the destination of the per-destination metrics
+	StatusTag      = "status"      // Status of the response of SendFailuresMetric
+	CollectorTag   = "collector"   // Name of the collector of PollDurationMetric
+)
+
+// tagged returns the name with the tags given as key and value pairs.
+func tagged(name string, tags ...string) string {
+	for i := 0; i+1 < len(tags); i += 2 {
+		name = protocol.WithTag(name, tags[i], tags[i+1])
+	}
+	return name
+}
+
+// record stores a metric about the agent itself in the storage and has it sent with every report.
+func (a *Agent) record(ctx context.Context, m model.Metrics) {
+	a.ownMu.Lock()
+	if a.own == nil {
+		a.own = make(map[string]model.Metrics)
+	}
+	a.own[m.MType+"/"+m.ID] = model.Metrics{ID: m.ID, MType: m.MType}
+	a.ownMu.Unlock()
+	if err := a.storage.UpdateMetricValue(ctx, m); err != nil {
+		a.logger.Errorf("UpdateMetricValue %v: %v", m.ID, err)
+	}
+}
+
+// count adds delta to a counter about the agent itself, tags are given as key and value pairs.
+func (a *Agent) count(ctx context.Context, name string, delta int64, tags ...string) {
+	a.record(ctx, model.Metrics{ID: tagged(name, tags...), MType: model.MetricTypeCounter, Delta: &delta})
+}
+
+// gauge sets a gauge about the agent itself, tags are given as key and value pairs.
+func (a *Agent) gauge(ctx context.Context, name string, value float64, tags ...string) {
+	a.record(ctx, model.Metrics{ID: tagged(name, tags...), MType: model.MetricTypeGauge, Value: &value})
+}
+
+// ownMetrics lists the metrics the agent recorded about itself, without their values.
+func (a *Agent) ownMetrics() []model.Metrics {
+	a.ownMu.Lock()
+	defer a.ownMu.Unlock()
+	list := make([]model.Metrics, 0, len(a.own))
+	for _, m := range a.own {
+		list = append(list, m)
+	}
+	return list
+}
+
+// WithBuildInfo records the BuildInfoMetric gauge of the build the agent runs.
+func (a *Agent) WithBuildInfo(version, commit, date string) *Agent {
+	a.gauge(context.Background(), BuildInfoMetric, 1, "version", version, "commit", commit, "date", date)
+	return a
+}
+
+// poll runs a collector and records the time it took.
+func (a *Agent) poll(collector string, poll func() error) error {
+	start := time.Now()
+	err := poll()
+	a.gauge(context.Background(), PollDurationMetric, time.Since(start).Seconds(), CollectorTag, collector)
+	return err
+}
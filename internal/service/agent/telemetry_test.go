@@ -0,0 +1,73 @@
+package service
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	config "github.com/mrkovshik/yametrics/internal/config/agent"
+	"github.com/mrkovshik/yametrics/internal/metrics"
+	"github.com/mrkovshik/yametrics/internal/model"
+	storage2 "github.com/mrkovshik/yametrics/internal/storage"
+)
+
+func TestAgent_telemetry(t *testing.T) {
+	var mu sync.Mutex
+	sent := make(map[string]model.Metrics)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		var m model.Metrics
+		require.NoError(t, json.NewDecoder(body).Decode(&m))
+		if m.ID == "Alloc" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		sent[m.ID] = m
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cfg := config.AgentConfig{Destinations: "main=" + strings.TrimPrefix(srv.URL, "http://"), RateLimit: 1, Compression: "gzip"}
+	a := NewAgent(metrics.NewMockMetrics(), &cfg, storage2.NewInMemoryStorage(), zap.NewNop().Sugar()).
+		WithBuildInfo("v1.2.0", "abc123", "2026-10-19")
+	pollCh := make(chan time.Time, 1)
+	pollCh <- time.Now()
+	close(pollCh)
+	done := make(chan struct{}, 1)
+	a.PollMetrics(pollCh, done)
+	<-done
+
+	// The metrics recorded while sending the first report are sent with the second one.
+	ctx := context.Background()
+	names := map[string]struct{}{"Alloc": {}}
+	a.sendMetricsByPool(ctx, names)
+	mu.Lock()
+	require.Equal(t, 1.0, *sent["agent_build_info;commit=abc123;date=2026-10-19;version=v1.2.0"].Value)
+	sent = make(map[string]model.Metrics)
+	mu.Unlock()
+	a.sendMetricsByPool(ctx, names)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, int64(3), *sent["agent_send_attempts_total;destination=main"].Delta)
+	require.Equal(t, int64(2), *sent["agent_send_successes_total;destination=main"].Delta, "the build info and the poll duration")
+	require.Equal(t, int64(1), *sent["agent_send_failures_total;destination=main;status=400"].Delta)
+	require.Positive(t, *sent["agent_sent_uncompressed_bytes_total;destination=main"].Delta)
+	require.Positive(t, *sent["agent_sent_bytes_total;destination=main"].Delta)
+	require.Equal(t, 0.0, *sent["agent_queue_depth;destination=main"].Value)
+	require.Equal(t, float64(BreakerClosed), *sent["agent_breaker_state;destination=main"].Value)
+	require.Contains(t, sent, "agent_poll_duration_seconds;collector=memstats")
+	require.NotContains(t, sent, "agent_send_retries_total;destination=main", "no request was retried")
+}
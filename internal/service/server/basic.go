@@ -6,9 +6,15 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	config "github.com/mrkovshik/yametrics/internal/config/server"
+	"github.com/mrkovshik/yametrics/internal/logger"
 	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/telemetry"
 	"github.com/mrkovshik/yametrics/internal/templates"
 	"go.uber.org/zap"
 )
@@ -29,11 +35,26 @@ type storage interface {
 	Ping(ctx context.Context) error
 }
 
+// replication applies updates as the primary or a replica, see package replication.
+type replication interface {
+	Apply(batch []model.Metrics, apply func() error) error
+}
+
 // MetricService represents the service for managing metrics.
 type MetricService struct {
 	storage storage
-	config  *config.ServerConfig
+	config  atomic.Pointer[config.ServerConfig]
 	logger  *zap.SugaredLogger
+
+	replication replication // Role of the server in replication, nil if it does not replicate
+
+	restored        atomic.Bool // Whether the restore from file has finished
+	snapshotMu      sync.Mutex
+	lastSnapshotErr error // Result of the last snapshot, nil if none was taken yet
+
+	updates          *telemetry.CounterVec
+	snapshotDuration *telemetry.HistogramVec
+	snapshotFailures *telemetry.CounterVec
 }
 
 // NewMetricService creates a new instance of MetricService.
@@ -42,11 +63,36 @@ type MetricService struct {
 // config: the server configuration settings.
 // logger: a logger for logging messages.
 func NewMetricService(storage storage, config *config.ServerConfig, logger *zap.SugaredLogger) *MetricService {
-	return &MetricService{
+	s := &MetricService{
 		storage: storage,
-		config:  config,
 		logger:  logger,
 	}
+	s.config.Store(config)
+	s.restored.Store(!config.RestoreEnable)
+	return s
+}
+
+// UpdateConfig atomically replaces the configuration used by the service.
+func (s *MetricService) UpdateConfig(cfg *config.ServerConfig) {
+	s.config.Store(cfg)
+}
+
+// WithTelemetry sets the registry the service records its metrics to.
+func (s *MetricService) WithTelemetry(reg *telemetry.Registry) *MetricService {
+	s.updates = reg.NewCounterVec("yametrics_metric_updates_total",
+		"Total number of applied metric updates.", "type")
+	s.snapshotDuration = reg.NewHistogramVec("yametrics_snapshot_duration_seconds",
+		"Duration of storing metrics snapshots to file.", telemetry.DefBuckets)
+	s.snapshotFailures = reg.NewCounterVec("yametrics_snapshot_failures_total",
+		"Total number of failed metrics snapshots.")
+	return s
+}
+
+// WithReplication sets the replication role of the service: a primary streams the updates to
+// its replicas, a replica rejects them until it is promoted.
+func (s *MetricService) WithReplication(r replication) *MetricService {
+	s.replication = r
+	return s
 }
 
 // UpdateMetrics updates the metrics in the storage. If SyncStoreEnable is true in the config,
@@ -55,17 +101,29 @@ func NewMetricService(storage storage, config *config.ServerConfig, logger *zap.
 // ctx: the context for managing request-scoped values and cancelation.
 // batch: a slice of metrics to be updated.
 //
-// Returns an error if the update or store operation fails.
+// Returns an error if the update or store operation fails, apperrors.ErrReadOnly on a replica.
 func (s *MetricService) UpdateMetrics(ctx context.Context, batch []model.Metrics) error {
+	if s.replication != nil {
+		return s.replication.Apply(batch, func() error { return s.ApplyReplicated(ctx, batch) })
+	}
+	return s.ApplyReplicated(ctx, batch)
+}
+
+// ApplyReplicated updates the metrics in the storage like UpdateMetrics, bypassing the replication
+// role. Replicas apply the updates streamed from the primary with it.
+func (s *MetricService) ApplyReplicated(ctx context.Context, batch []model.Metrics) error {
 	if err := s.storage.UpdateMetrics(ctx, batch); err != nil {
 		errMsg := fmt.Errorf("UpdateMetrics: %s", err.Error())
-		s.logger.Error(errMsg)
+		logger.FromContext(ctx, s.logger).Error(errMsg)
 		return errMsg
 	}
-	if s.config.SyncStoreEnable {
-		if err := s.storage.StoreMetrics(ctx, s.config.StoreFilePath); err != nil {
+	for _, metric := range batch {
+		s.updates.Inc(metric.MType)
+	}
+	if s.config.Load().SyncStoreEnable {
+		if err := s.StoreMetrics(ctx); err != nil {
 			errMsg := fmt.Errorf("StoreMetrics: %s", err.Error())
-			s.logger.Error(errMsg)
+			logger.FromContext(ctx, s.logger).Error(errMsg)
 			return errMsg
 		}
 	}
@@ -82,7 +140,7 @@ func (s *MetricService) GetMetric(ctx context.Context, metricModel model.Metrics
 	metric, err := s.storage.GetMetricByModel(ctx, metricModel)
 	if err != nil {
 		errMsg := fmt.Errorf("GetMetricByModel: %s", err.Error())
-		s.logger.Error(errMsg)
+		logger.FromContext(ctx, s.logger).Error(errMsg)
 		return model.Metrics{}, errMsg
 	}
 	return metric, nil
@@ -109,12 +167,50 @@ func (s *MetricService) GetAllMetrics(ctx context.Context) (string, error) {
 	return tpl.String(), nil
 }
 
+// ListMetrics retrieves all metrics from the storage.
+//
+// ctx: the context for managing request-scoped values and cancelation.
+//
+// Returns the metrics ordered by name and type and an error if the retrieval fails.
+func (s *MetricService) ListMetrics(ctx context.Context) ([]model.Metrics, error) {
+	metricMap, err := s.storage.GetAllMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]model.Metrics, 0, len(metricMap))
+	for _, m := range metricMap {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ID != list[j].ID {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].MType < list[j].MType
+	})
+	return list, nil
+}
+
+// StoreMetrics stores a snapshot of all metrics to the file specified in StoreFilePath.
 func (s *MetricService) StoreMetrics(ctx context.Context) error {
-	return s.storage.StoreMetrics(ctx, s.config.StoreFilePath)
+	start := time.Now()
+	err := s.storage.StoreMetrics(ctx, s.config.Load().StoreFilePath)
+	s.snapshotDuration.ObserveSince(start)
+	if err != nil {
+		s.snapshotFailures.Inc()
+	}
+	s.snapshotMu.Lock()
+	s.lastSnapshotErr = err
+	s.snapshotMu.Unlock()
+	return err
 }
 
+// RestoreMetrics restores metrics from the file specified in StoreFilePath.
 func (s *MetricService) RestoreMetrics(ctx context.Context) error {
-	return s.storage.RestoreMetrics(ctx, s.config.StoreFilePath)
+	if err := s.storage.RestoreMetrics(ctx, s.config.Load().StoreFilePath); err != nil {
+		return err
+	}
+	s.restored.Store(true)
+	return nil
 }
 
 // Ping checks the connectivity to the storage.
@@ -125,3 +221,46 @@ func (s *MetricService) RestoreMetrics(ctx context.Context) error {
 func (s *MetricService) Ping(ctx context.Context) error {
 	return s.storage.Ping(ctx)
 }
+
+// Health checks the storage connectivity, whether the restore from file has finished
+// and, if snapshots are enabled, whether the last snapshot succeeded.
+//
+// ctx: the context for managing request-scoped values and cancelation.
+//
+// Returns the overall and per-component health.
+func (s *MetricService) Health(ctx context.Context) model.Health {
+	start := time.Now()
+	storageHealth := model.ComponentHealth{Name: "storage", Status: model.HealthStatusOK}
+	if err := s.storage.Ping(ctx); err != nil {
+		storageHealth.Status = model.HealthStatusFail
+		storageHealth.Error = err.Error()
+	}
+	storageHealth.Latency = time.Since(start).String()
+	components := []model.ComponentHealth{storageHealth}
+
+	restoreHealth := model.ComponentHealth{Name: "restore", Status: model.HealthStatusOK, Latency: "0s"}
+	if !s.restored.Load() {
+		restoreHealth.Status = model.HealthStatusFail
+		restoreHealth.Error = "restore has not finished"
+	}
+	components = append(components, restoreHealth)
+
+	if s.config.Load().StoreEnable {
+		snapshotHealth := model.ComponentHealth{Name: "snapshot", Status: model.HealthStatusOK, Latency: "0s"}
+		s.snapshotMu.Lock()
+		if s.lastSnapshotErr != nil {
+			snapshotHealth.Status = model.HealthStatusFail
+			snapshotHealth.Error = s.lastSnapshotErr.Error()
+		}
+		s.snapshotMu.Unlock()
+		components = append(components, snapshotHealth)
+	}
+
+	health := model.Health{Status: model.HealthStatusOK, Components: components}
+	for _, c := range components {
+		if c.Status != model.HealthStatusOK {
+			health.Status = model.HealthStatusFail
+		}
+	}
+	return health
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -84,6 +85,12 @@ func TestMetricService(t *testing.T) {
 		assert.NotEqual(t, "", s)
 	})
 
+	t.Run("list", func(t *testing.T) {
+		list, err := basicSvs.ListMetrics(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []model.Metrics{testCounter1, testGauge1}, list)
+	})
+
 	t.Run("store", func(t *testing.T) {
 		err := basicSvs.StoreMetrics(ctx)
 		assert.NoError(t, err)
@@ -104,3 +111,57 @@ func defineStorage(ctx context.Context, ctrl *gomock.Controller) *mock_storage.M
 	strg.EXPECT().RestoreMetrics(ctx, "./tmp/metrics-test.json").Return(nil).AnyTimes()
 	return strg
 }
+
+func TestMetricService_Health(t *testing.T) {
+	logger, errBuild := loggerConfig.Build()
+	assert.NoError(t, errBuild)
+	defer logger.Sync() //nolint:all
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		restore     bool
+		restored    bool
+		pingErr     error
+		snapshotErr error
+		want        map[string]string
+		wantStatus  string
+	}{
+		{"healthy", false, false, nil, nil,
+			map[string]string{"storage": model.HealthStatusOK, "restore": model.HealthStatusOK, "snapshot": model.HealthStatusOK}, model.HealthStatusOK},
+		{"restore pending", true, false, nil, nil,
+			map[string]string{"storage": model.HealthStatusOK, "restore": model.HealthStatusFail, "snapshot": model.HealthStatusOK}, model.HealthStatusFail},
+		{"restore finished", true, true, nil, nil,
+			map[string]string{"storage": model.HealthStatusOK, "restore": model.HealthStatusOK, "snapshot": model.HealthStatusOK}, model.HealthStatusOK},
+		{"storage down", false, false, errors.New("connection refused"), nil,
+			map[string]string{"storage": model.HealthStatusFail, "restore": model.HealthStatusOK, "snapshot": model.HealthStatusOK}, model.HealthStatusFail},
+		{"snapshot failed", false, false, nil, errors.New("disk full"),
+			map[string]string{"storage": model.HealthStatusOK, "restore": model.HealthStatusOK, "snapshot": model.HealthStatusFail}, model.HealthStatusFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := config.GetTestConfig()
+			assert.NoError(t, err)
+			cfg.RestoreEnable = tt.restore
+			ctrl := gomock.NewController(t)
+			strg := mock_storage.NewMockStorage(ctrl)
+			strg.EXPECT().Ping(gomock.Any()).Return(tt.pingErr).AnyTimes()
+			strg.EXPECT().RestoreMetrics(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+			strg.EXPECT().StoreMetrics(gomock.Any(), gomock.Any()).Return(tt.snapshotErr).AnyTimes()
+
+			svc := NewMetricService(strg, &cfg, logger.Sugar())
+			if tt.restored {
+				assert.NoError(t, svc.RestoreMetrics(ctx))
+			}
+			assert.Equal(t, tt.snapshotErr, svc.StoreMetrics(ctx))
+
+			health := svc.Health(ctx)
+			assert.Equal(t, tt.wantStatus, health.Status)
+			got := make(map[string]string, len(health.Components))
+			for _, c := range health.Components {
+				got[c.Name] = c.Status
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -65,6 +65,35 @@ func (mr *MockServiceMockRecorder) GetMetric(arg0, arg1 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetric", reflect.TypeOf((*MockService)(nil).GetMetric), arg0, arg1)
 }
 
+// Health mocks base method.
+func (m *MockService) Health(arg0 context.Context) model.Health {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Health", arg0)
+	ret0, _ := ret[0].(model.Health)
+	return ret0
+}
+
+// Health indicates an expected call of Health.
+func (mr *MockServiceMockRecorder) Health(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Health", reflect.TypeOf((*MockService)(nil).Health), arg0)
+}
+
+// ListMetrics mocks base method.
+func (m *MockService) ListMetrics(arg0 context.Context) ([]model.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListMetrics", arg0)
+	ret0, _ := ret[0].([]model.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListMetrics indicates an expected call of ListMetrics.
+func (mr *MockServiceMockRecorder) ListMetrics(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListMetrics", reflect.TypeOf((*MockService)(nil).ListMetrics), arg0)
+}
+
 // Ping mocks base method.
 func (m *MockService) Ping(arg0 context.Context) error {
 	m.ctrl.T.Helper()
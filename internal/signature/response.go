@@ -14,9 +14,11 @@ type CapturingResponseWriter struct {
 }
 
 // NewCapturingResponseWriter creates a new CapturingResponseWriter instance.
+// The status code is 200 unless the handler sets another, as with net/http.
 func NewCapturingResponseWriter(w http.ResponseWriter) *CapturingResponseWriter {
 	return &CapturingResponseWriter{
 		ResponseWriter: w,
+		statCode:       http.StatusOK,
 	}
 }
 
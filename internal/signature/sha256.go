@@ -32,3 +32,12 @@ func (s *sha256Sig) Generate() (string, error) {
 	dst := h.Sum(nil)
 	return hex.EncodeToString(dst), nil
 }
+
+// Verify reports whether sig is the SHA-256 HMAC signature of body with key, comparing in constant time.
+func Verify(key string, body []byte, sig string) (bool, error) {
+	want, err := NewSha256Sig(key, body).Generate()
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal([]byte(sig), []byte(want)), nil
+}
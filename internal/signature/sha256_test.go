@@ -2,6 +2,7 @@ package signature
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -16,3 +17,25 @@ func Test_sha256Sig_Generate(t *testing.T) {
 
 	})
 }
+
+func TestVerify(t *testing.T) {
+	ok, err := Verify("secret auth key", []byte{123}, "bb409314cf250f4c447cbd10e3611b189b2af6ce8aa62ca68a60917fadc8eb5e")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = Verify("other key", []byte{123}, "bb409314cf250f4c447cbd10e3611b189b2af6ce8aa62ca68a60917fadc8eb5e")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestNonces_Accept(t *testing.T) {
+	var n Nonces
+	now := time.Now()
+	stamp := Stamp{Time: now, Nonce: "a"}
+	require.NoError(t, n.Accept(stamp, now))
+	require.ErrorIs(t, n.Accept(stamp, now.Add(time.Second)), ErrInvalidStamp, "nonces are used once")
+	require.ErrorIs(t, n.Accept(Stamp{Time: now.Add(-2 * StampMaxAge), Nonce: "b"}, now), ErrInvalidStamp)
+	require.ErrorIs(t, n.Accept(Stamp{Time: now.Add(2 * StampMaxAge), Nonce: "c"}, now), ErrInvalidStamp)
+	require.ErrorIs(t, n.Accept(Stamp{Time: now}, now), ErrInvalidStamp)
+	require.NoError(t, n.Accept(Stamp{Time: now.Add(2 * StampMaxAge), Nonce: "d"}, now.Add(2*StampMaxAge)))
+	require.NotContains(t, n.seen, "a", "expired nonces are forgotten")
+}
@@ -0,0 +1,61 @@
+package signature
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// StampMaxAge is how long a stamped request is valid after it was made. It also bounds the
+// difference between the clocks of the server and the client.
+const StampMaxAge = time.Minute
+
+// ErrInvalidStamp is returned by Nonces.Accept for an expired or replayed stamp.
+var ErrInvalidStamp = errors.New("request is expired or has been used")
+
+// Stamp is the body of a signed request that carries no other data, such as a read or a command.
+// The time and the nonce make the signature of the request valid only once.
+type Stamp struct {
+	Time  time.Time `json:"time"`  // When the request was made
+	Nonce string    `json:"nonce"` // Random value that is never reused
+}
+
+// NewStamp returns a Stamp made now with a random nonce.
+func NewStamp() Stamp {
+	nonce := make([]byte, 16)
+	rand.Read(nonce) //nolint:all
+	return Stamp{Time: time.Now().UTC(), Nonce: hex.EncodeToString(nonce)}
+}
+
+// Nonces remembers the nonces of the stamps accepted within StampMaxAge. The zero value is ready to use.
+type Nonces struct {
+	mu   sync.Mutex
+	seen map[string]time.Time // Times of the accepted stamps by nonce
+}
+
+// Accept checks that a stamp was made within StampMaxAge of now and that its nonce has not been
+// used, and records the nonce. It returns ErrInvalidStamp otherwise.
+func (n *Nonces) Accept(s Stamp, now time.Time) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for nonce, made := range n.seen {
+		if now.Sub(made) > StampMaxAge {
+			delete(n.seen, nonce)
+		}
+	}
+	age := now.Sub(s.Time)
+	if s.Nonce == "" || age > StampMaxAge || age < -StampMaxAge {
+		return ErrInvalidStamp
+	}
+	if _, ok := n.seen[s.Nonce]; ok {
+		return ErrInvalidStamp
+	}
+	if n.seen == nil {
+		n.seen = make(map[string]time.Time)
+	}
+	// Stamps made ahead of the clock of the server are remembered until they expire.
+	n.seen[s.Nonce] = s.Time
+	return nil
+}
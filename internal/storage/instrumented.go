@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/mrkovshik/yametrics/internal/model"
+	"github.com/mrkovshik/yametrics/internal/telemetry"
+)
+
+// metricStorage is the set of operations shared by the storage backends.
+type metricStorage interface {
+	UpdateMetricValue(ctx context.Context, newMetrics model.Metrics) error
+	UpdateMetrics(ctx context.Context, newMetrics []model.Metrics) error
+	GetMetricByModel(ctx context.Context, newMetrics model.Metrics) (model.Metrics, error)
+	GetAllMetrics(ctx context.Context) (map[string]model.Metrics, error)
+	StoreMetrics(ctx context.Context, path string) error
+	RestoreMetrics(ctx context.Context, path string) error
+	Ping(ctx context.Context) error
+}
+
+// InstrumentedStorage wraps a storage backend and records the latency and errors of every operation.
+type InstrumentedStorage struct {
+	next     metricStorage
+	backend  string
+	duration *telemetry.HistogramVec
+	errors   *telemetry.CounterVec
+}
+
+// NewInstrumentedStorage wraps next, labeling its metrics with the backend name.
+func NewInstrumentedStorage(next metricStorage, backend string, reg *telemetry.Registry) *InstrumentedStorage {
+	return &InstrumentedStorage{
+		next:    next,
+		backend: backend,
+		duration: reg.NewHistogramVec("yametrics_storage_operation_duration_seconds",
+			"Latency of storage operations.", telemetry.DefBuckets, "backend", "operation"),
+		errors: reg.NewCounterVec("yametrics_storage_operation_errors_total",
+			"Total number of failed storage operations.", "backend", "operation"),
+	}
+}
+
+// UpdateMetricValue updates a single metric in the wrapped storage.
+func (s *InstrumentedStorage) UpdateMetricValue(ctx context.Context, newMetrics model.Metrics) error {
+	defer s.observe("update_metric_value", time.Now())
+	return s.countErr("update_metric_value", s.next.UpdateMetricValue(ctx, newMetrics))
+}
+
+// UpdateMetrics updates a batch of metrics in the wrapped storage.
+func (s *InstrumentedStorage) UpdateMetrics(ctx context.Context, newMetrics []model.Metrics) error {
+	defer s.observe("update_metrics", time.Now())
+	return s.countErr("update_metrics", s.next.UpdateMetrics(ctx, newMetrics))
+}
+
+// GetMetricByModel retrieves a metric from the wrapped storage.
+func (s *InstrumentedStorage) GetMetricByModel(ctx context.Context, newMetrics model.Metrics) (model.Metrics, error) {
+	defer s.observe("get_metric", time.Now())
+	metric, err := s.next.GetMetricByModel(ctx, newMetrics)
+	return metric, s.countErr("get_metric", err)
+}
+
+// GetAllMetrics retrieves all metrics from the wrapped storage.
+func (s *InstrumentedStorage) GetAllMetrics(ctx context.Context) (map[string]model.Metrics, error) {
+	defer s.observe("get_all_metrics", time.Now())
+	metrics, err := s.next.GetAllMetrics(ctx)
+	return metrics, s.countErr("get_all_metrics", err)
+}
+
+// StoreMetrics stores all metrics of the wrapped storage into a file.
+func (s *InstrumentedStorage) StoreMetrics(ctx context.Context, path string) error {
+	defer s.observe("store_metrics", time.Now())
+	return s.countErr("store_metrics", s.next.StoreMetrics(ctx, path))
+}
+
+// RestoreMetrics restores metrics of the wrapped storage from a file.
+func (s *InstrumentedStorage) RestoreMetrics(ctx context.Context, path string) error {
+	defer s.observe("restore_metrics", time.Now())
+	return s.countErr("restore_metrics", s.next.RestoreMetrics(ctx, path))
+}
+
+// Ping checks the availability of the wrapped storage.
+func (s *InstrumentedStorage) Ping(ctx context.Context) error {
+	defer s.observe("ping", time.Now())
+	return s.countErr("ping", s.next.Ping(ctx))
+}
+
+func (s *InstrumentedStorage) observe(operation string, start time.Time) {
+	s.duration.ObserveSince(start, s.backend, operation)
+}
+
+func (s *InstrumentedStorage) countErr(operation string, err error) error {
+	if err != nil {
+		s.errors.Inc(s.backend, operation)
+	}
+	return err
+}
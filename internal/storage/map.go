@@ -87,14 +87,16 @@ func (s *InMemoryStorage) GetMetricByModel(_ context.Context, newMetrics model.M
 // Parameters:
 // - ctx: the context to control the retrieval operation.
 // Returns:
-// - a map of metric names to Metrics models representing all stored metrics.
+// - a copy of the map of metric names to Metrics models representing all stored metrics.
 // - an error if the retrieval operation fails.
 func (s *InMemoryStorage) GetAllMetrics(_ context.Context) (map[string]model.Metrics, error) {
-	//newMap := make(map[string]model.Metrics, len(s.metrics))
-	//for _,v:=range s.metrics{
-	//	newMap[id]
-	//}
-	return s.metrics, nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	newMap := make(map[string]model.Metrics, len(s.metrics))
+	for key, m := range s.metrics {
+		newMap[key] = m
+	}
+	return newMap, nil
 }
 
 // StoreMetrics stores all metrics from the metrics map into a JSON file at the specified path.
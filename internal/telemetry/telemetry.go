@@ -0,0 +1,351 @@
+// Package telemetry provides a minimal registry of labeled counters, gauges and histograms
+// used for the self-observability of yametrics, exposed in the Prometheus text format.
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefBuckets are the default histogram buckets in seconds, suited for request latencies.
+var DefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// collector is a single metric family in the registry.
+type collector interface {
+	write(w io.Writer) error
+}
+
+// Registry holds metric families and renders them in the Prometheus text format.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]collector
+}
+
+// NewRegistry creates a new empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]collector),
+	}
+}
+
+// NewCounterVec registers a counter family with the given label names.
+// If a counter with the same name is already registered, it is returned instead.
+// A nil registry returns a nil CounterVec, which discards all updates.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.families[name].(*CounterVec); ok {
+		return existing
+	}
+	c := &CounterVec{vec: newVec(name, help, typeCounter, labels)}
+	r.families[name] = c
+	return c
+}
+
+// NewGaugeVec registers a gauge family with the given label names.
+// If a gauge with the same name is already registered, it is returned instead.
+// A nil registry returns a nil GaugeVec, which discards all updates.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.families[name].(*GaugeVec); ok {
+		return existing
+	}
+	g := &GaugeVec{vec: newVec(name, help, typeGauge, labels)}
+	r.families[name] = g
+	return g
+}
+
+// NewHistogramVec registers a histogram family with the given buckets and label names.
+// If a histogram with the same name is already registered, it is returned instead.
+// A nil registry returns a nil HistogramVec, which discards all observations.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.families[name].(*HistogramVec); ok {
+		return existing
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	h := &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: sorted,
+		series:  make(map[string]*histogram),
+	}
+	r.families[name] = h
+	return h
+}
+
+// WriteText writes all registered metric families to w in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	families := make([]collector, 0, len(names))
+	for _, name := range names {
+		families = append(families, r.families[name])
+	}
+	r.mu.Unlock()
+	for _, f := range families {
+		if err := f.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler returns an http.Handler serving the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := r.WriteText(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// vec is a family of float values keyed by label values.
+type vec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	typ    string
+	labels []string
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func newVec(name, help, typ string, labels []string) vec {
+	return vec{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		values: make(map[string]float64),
+		keys:   make(map[string][]string),
+	}
+}
+
+func (v *vec) update(f func(float64) float64, labelValues []string) {
+	key := seriesKey(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.keys[key]; !ok {
+		v.keys[key] = append([]string(nil), labelValues...)
+	}
+	v.values[key] = f(v.values[key])
+}
+
+func (v *vec) write(w io.Writer) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.typ); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(v.keys) {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, v.keys[key], "", ""), formatValue(v.values[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CounterVec is a family of monotonically increasing counters.
+type CounterVec struct {
+	vec
+}
+
+// Inc increments the counter with the given label values by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter with the given label values by delta, which must not be negative.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if c == nil || delta < 0 {
+		return
+	}
+	c.update(func(v float64) float64 { return v + delta }, labelValues)
+}
+
+// GaugeVec is a family of values which can go up and down.
+type GaugeVec struct {
+	vec
+}
+
+// Set sets the gauge with the given label values.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+	g.update(func(float64) float64 { return value }, labelValues)
+}
+
+// Add adds delta to the gauge with the given label values.
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+	g.update(func(v float64) float64 { return v + delta }, labelValues)
+}
+
+// histogram holds the state of a single histogram series.
+type histogram struct {
+	labelValues []string
+	counts      []uint64 // Cumulative counts per bucket
+	count       uint64
+	sum         float64
+}
+
+// HistogramVec is a family of histograms sharing the same buckets.
+type HistogramVec struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*histogram
+}
+
+// Observe adds a single observation to the histogram with the given label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+	key := seriesKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// ObserveSince observes the time elapsed since start in seconds.
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", h.name, escapeHelp(h.help), h.name, typeHistogram); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "le", formatValue(bound)), s.counts[i]); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "le", "+Inf"), s.count); err != nil {
+			return err
+		}
+		labels := formatLabels(h.labels, s.labelValues, "", "")
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", h.name, labels, formatValue(s.sum), h.name, labels, s.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatLabels renders the label set, optionally followed by an extra label such as le.
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(value)))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
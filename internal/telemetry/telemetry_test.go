@@ -0,0 +1,46 @@
+package telemetry
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Total requests.", "route", "status")
+	requests.Inc("/update/", "200")
+	requests.Add(2, "/update/", "200")
+	requests.Inc(`/value/"x"`, "404")
+	r.NewGaugeVec("test_queue_depth", "Queue depth.").Set(5)
+	latency := r.NewHistogramVec("test_duration_seconds", "Duration.", []float64{0.1, 1}, "op")
+	latency.Observe(0.05, "store")
+	latency.Observe(0.5, "store")
+	require.Same(t, requests, r.NewCounterVec("test_requests_total", "Total requests.", "route", "status"))
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteText(&buf))
+	require.Equal(t, `# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{op="store",le="0.1"} 1
+test_duration_seconds_bucket{op="store",le="1"} 2
+test_duration_seconds_bucket{op="store",le="+Inf"} 2
+test_duration_seconds_sum{op="store"} 0.55
+test_duration_seconds_count{op="store"} 2
+# HELP test_queue_depth Queue depth.
+# TYPE test_queue_depth gauge
+test_queue_depth 5
+# HELP test_requests_total Total requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/update/",status="200"} 3
+test_requests_total{route="/value/\"x\"",status="404"} 1
+`, buf.String())
+}
+
+func TestNilRegistry(t *testing.T) {
+	var r *Registry
+	r.NewCounterVec("c", "").Inc()
+	r.NewGaugeVec("g", "").Set(1)
+	r.NewHistogramVec("h", "", DefBuckets).Observe(1)
+}
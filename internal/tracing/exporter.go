@@ -0,0 +1,229 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Span kinds as defined by OTLP.
+const (
+	SpanKindServer = 2
+	SpanKindClient = 3
+)
+
+const (
+	exportBatchSize     = 100
+	exportQueueSize     = 1000
+	exportFlushInterval = 5 * time.Second
+)
+
+// Span is a finished unit of work to be exported.
+type Span struct {
+	Trace      TraceContext
+	Name       string
+	Kind       int
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Error      bool
+}
+
+// Exporter sends finished spans in batches to an OTLP/HTTP collector using the JSON encoding.
+// A nil *Exporter is valid and discards all spans.
+type Exporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+	logger      *zap.SugaredLogger
+	done        chan struct{}
+
+	mu     sync.RWMutex // Guards spans against being closed while a span is queued
+	spans  chan Span
+	closed bool // Whether Shutdown has closed spans
+}
+
+// NewExporter creates an Exporter posting spans to the OTLP/HTTP traces endpoint
+// (for example http://localhost:4318/v1/traces) and starts its background sender.
+func NewExporter(endpoint, serviceName string, logger *zap.SugaredLogger) *Exporter {
+	e := &Exporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 5 * time.Second},
+		logger:      logger,
+		spans:       make(chan Span, exportQueueSize),
+		done:        make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Export queues the span for sending. Spans are dropped if the queue is full or the exporter
+// has been shut down, as requests outliving the shutdown may still finish spans.
+func (e *Exporter) Export(span Span) {
+	if e == nil {
+		return
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.spans <- span:
+	default:
+		e.logger.Warn("span export queue is full, dropping span")
+	}
+}
+
+// Shutdown flushes the queued spans and stops the background sender.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.spans)
+	}
+	e.mu.Unlock()
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(exportFlushInterval)
+	defer ticker.Stop()
+	batch := make([]Span, 0, exportBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			e.logger.Errorf("span export: %v", err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case span, ok := <-e.spans:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= exportBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (e *Exporter) send(batch []Span) error {
+	body, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:all
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector responded with status %v", resp.StatusCode)
+	}
+	return nil
+}
+
+// OTLP JSON encoding of the trace export request.
+type (
+	otlpExportRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpKeyValue struct {
+		Key   string        `json:"key"`
+		Value otlpAnyString `json:"value"`
+	}
+	otlpAnyString struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpStatus struct {
+		Code int `json:"code"`
+	}
+)
+
+func (e *Exporter) encode(batch []Span) otlpExportRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		status := otlpStatus{Code: 1}
+		if s.Error {
+			status.Code = 2
+		}
+		attrs := make([]otlpKeyValue, 0, len(s.Attributes))
+		for k, v := range s.Attributes {
+			attrs = append(attrs, otlpKeyValue{Key: k, Value: otlpAnyString{StringValue: v}})
+		}
+		spans = append(spans, otlpSpan{
+			TraceID:           s.Trace.TraceID,
+			SpanID:            s.Trace.SpanID,
+			ParentSpanID:      s.Trace.ParentSpanID,
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        attrs,
+			Status:            status,
+		})
+	}
+	return otlpExportRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: []otlpKeyValue{{
+				Key:   "service.name",
+				Value: otlpAnyString{StringValue: e.serviceName},
+			}}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/mrkovshik/yametrics"},
+				Spans: spans,
+			}},
+		}},
+	}
+}
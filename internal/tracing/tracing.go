@@ -0,0 +1,104 @@
+// Package tracing provides W3C trace context propagation and request correlation IDs
+// shared by the agent and the server, along with an optional OTLP/HTTP span exporter.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Header names used to propagate the trace context.
+const (
+	RequestIDHeader   = "X-Request-ID"
+	TraceparentHeader = "traceparent"
+)
+
+// TraceContext identifies a span within a trace.
+type TraceContext struct {
+	TraceID      string // 32 hex characters identifying the whole trace
+	SpanID       string // 16 hex characters identifying the current span
+	ParentSpanID string // Span ID of the remote parent, empty for root spans
+	RequestID    string // Correlation ID logged on both sides of a request
+}
+
+type ctxKey struct{}
+
+// NewTraceContext starts a new trace with a random trace ID and span ID.
+// The request ID equals the trace ID.
+func NewTraceContext() TraceContext {
+	traceID := randomHex(16)
+	return TraceContext{
+		TraceID:   traceID,
+		SpanID:    randomHex(8),
+		RequestID: traceID,
+	}
+}
+
+// ChildOf continues the trace of the remote parent with a new span.
+func ChildOf(parent TraceContext) TraceContext {
+	return TraceContext{
+		TraceID:      parent.TraceID,
+		SpanID:       randomHex(8),
+		ParentSpanID: parent.SpanID,
+		RequestID:    parent.RequestID,
+	}
+}
+
+// Traceparent formats the trace context as a W3C traceparent header value.
+func (tc TraceContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", tc.TraceID, tc.SpanID)
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(value string) (TraceContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return TraceContext{}, errors.New("invalid traceparent format")
+	}
+	version, traceID, spanID := parts[0], parts[1], parts[2]
+	if version == "ff" || !isHex(version, 2) {
+		return TraceContext{}, errors.New("invalid traceparent version")
+	}
+	if !isHex(traceID, 32) || traceID == strings.Repeat("0", 32) {
+		return TraceContext{}, errors.New("invalid trace id")
+	}
+	if !isHex(spanID, 16) || spanID == strings.Repeat("0", 16) {
+		return TraceContext{}, errors.New("invalid span id")
+	}
+	return TraceContext{
+		TraceID:   traceID,
+		SpanID:    spanID,
+		RequestID: traceID,
+	}, nil
+}
+
+// WithTraceContext returns a copy of ctx carrying the trace context.
+func WithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, ctxKey{}, tc)
+}
+
+// FromContext returns the trace context stored in ctx, if any.
+func FromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(ctxKey{}).(TraceContext)
+	return tc, ok
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"valid", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"future version with extra fields", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"empty", "", true},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", true},
+		{"short span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01", true},
+		{"upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := ParseTraceparent(tt.value)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tc.TraceID)
+			require.Equal(t, "00f067aa0ba902b7", tc.SpanID)
+		})
+	}
+}
+
+func TestTraceContext_RoundTrip(t *testing.T) {
+	tc := NewTraceContext()
+	parsed, err := ParseTraceparent(tc.Traceparent())
+	require.NoError(t, err)
+	require.Equal(t, tc.TraceID, parsed.TraceID)
+	require.Equal(t, tc.SpanID, parsed.SpanID)
+
+	child := ChildOf(parsed)
+	require.Equal(t, tc.TraceID, child.TraceID)
+	require.Equal(t, tc.SpanID, child.ParentSpanID)
+	require.NotEqual(t, tc.SpanID, child.SpanID)
+}
+
+func TestExporter(t *testing.T) {
+	received := make(chan otlpExportRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpExportRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		received <- req
+	}))
+	defer collector.Close()
+
+	e := NewExporter(collector.URL+"/v1/traces", "test-service", zap.NewNop().Sugar())
+	tc := NewTraceContext()
+	start := time.Now()
+	e.Export(Span{
+		Trace:      tc,
+		Name:       "POST /update/",
+		Kind:       SpanKindServer,
+		Start:      start,
+		End:        start.Add(time.Millisecond),
+		Attributes: map[string]string{"http.status_code": "200"},
+	})
+	require.NoError(t, e.Shutdown(context.Background()))
+
+	req := <-received
+	require.Len(t, req.ResourceSpans, 1)
+	require.Equal(t, "test-service", req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 1)
+	require.Equal(t, tc.TraceID, spans[0].TraceID)
+	require.Equal(t, "POST /update/", spans[0].Name)
+	require.Equal(t, SpanKindServer, spans[0].Kind)
+
+	require.NotPanics(t, func() { e.Export(Span{Trace: tc, Name: "late"}) }, "spans finished after the shutdown are dropped")
+	require.NoError(t, e.Shutdown(context.Background()))
+}
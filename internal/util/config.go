@@ -1,21 +1,20 @@
 package util
 
 import (
-	"errors"
+	"fmt"
 	"strconv"
-	"strings"
+	"time"
 )
 
-// CutSeconds converts time configurations in format like "2s" to it int representation
-// only format with seconds like "xxs" is valid
-func CutSeconds(s string) (int, error) {
-	reportInterval, found := strings.CutSuffix(s, "s")
-	if !found {
-		return 0, errors.New("invalid report interval format")
+// ParseDuration converts time configurations like "500ms", "10s" or "1m30s" to time.Duration.
+// A plain integer is interpreted as a number of seconds for backward compatibility.
+func ParseDuration(s string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(s); err == nil {
+		return time.Duration(seconds) * time.Second, nil
 	}
-	intReportInterval, err := strconv.Atoi(reportInterval)
+	d, err := time.ParseDuration(s)
 	if err != nil {
-		return 0, errors.New("invalid report interval format")
+		return 0, fmt.Errorf("invalid duration %q, use a number of seconds or a value like 500ms or 1m30s", s)
 	}
-	return intReportInterval, nil
+	return d, nil
 }
@@ -1,30 +1,38 @@
 package util
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
-func TestCutSeconds(t *testing.T) {
+func TestParseDuration(t *testing.T) {
 	type args struct {
 		s string
 	}
 	tests := []struct {
 		name    string
 		args    args
-		want    int
+		want    time.Duration
 		wantErr bool
 	}{
-		{"1", args{"1s"}, 1, false},
-		{"2", args{"2"}, 0, true},
-		{"3", args{"3m"}, 0, true},
+		{"1", args{"1s"}, time.Second, false},
+		{"2", args{"2"}, 2 * time.Second, false},
+		{"3", args{"3m"}, 3 * time.Minute, false},
+		{"4", args{"500ms"}, 500 * time.Millisecond, false},
+		{"5", args{"1m30s"}, 90 * time.Second, false},
+		{"6", args{"0"}, 0, false},
+		{"7", args{"often"}, 0, true},
+		{"8", args{""}, 0, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := CutSeconds(tt.args.s)
+			got, err := ParseDuration(tt.args.s)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("CutSeconds() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("ParseDuration() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if got != tt.want {
-				t.Errorf("CutSeconds() got = %v, want %v", got, tt.want)
+				t.Errorf("ParseDuration() got = %v, want %v", got, tt.want)
 			}
 		})
 	}